@@ -0,0 +1,50 @@
+package pay
+
+import "net/url"
+
+// AgreementStatus 代扣协议状态
+type AgreementStatus int
+
+const (
+	// AgreementStatusSigned 已签约
+	AgreementStatusSigned AgreementStatus = iota
+	// AgreementStatusUnsigned 已解约
+	AgreementStatusUnsigned
+)
+
+// Agreement 代扣协议，用于会员续费等周期扣款
+type Agreement interface {
+	// SignAgreement 签约用到的数据
+	// form -> 签约跳转地址
+	// wap, jsapi -> 签约跳转地址
+	// app -> 调起app签约用到的数据
+	SignAgreement(Way, AgreementOrder) (string, error)
+
+	// VerifyAgreement 签约、解约回调验证签名,成功返回协议信息
+	VerifyAgreement(url.Values) (*AgreementNotice, error)
+
+	// Unsign 解约
+	Unsign(agreementID string) error
+
+	// Charge 按协议扣款，同一Order.ID重复调用不会重复扣款，扣款结果以支付回调或查询结果为准
+	Charge(agreementID string, in Order) (*NoticeParams, error)
+}
+
+// AgreementOrder 签约信息
+type AgreementOrder struct {
+	ExternalID   string // 商户签约号，商户侧唯一
+	Title        string // 签约展示名称，微信 contract_display_account
+	PeriodType   string // 扣款周期类型 DAY MONTH，仅支付宝
+	Period       int    // 扣款周期，仅支付宝
+	ExecuteTime  string // 首次扣款日期 yyyy-MM-dd，仅支付宝
+	SingleAmount int32  // 单次扣款最大金额 单位分，仅支付宝
+}
+
+// AgreementNotice 签约、解约回调参数
+type AgreementNotice struct {
+	AgreementID string          // 支付平台协议号，支付宝 agreement_no，微信 contract_id
+	ExternalID  string          // 商户签约号
+	Status      AgreementStatus // 协议状态
+	UserID      string          // 签约用户，支付宝 alipay_user_id，微信 openid
+	Time        string          // 签约或解约时间
+}
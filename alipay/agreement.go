@@ -0,0 +1,249 @@
+package alipay
+
+import (
+	"net/url"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/alipay"
+)
+
+var _ pay.Agreement = &Alipay{}
+
+// 周期扣款 https://docs.open.alipay.com/api_2/alipay.user.agreement.page.sign
+const (
+	kCyclePayAuth         = "CYCLE_PAY_AUTH"
+	kCyclePayAuthPersonal = "CYCLE_PAY_AUTH_P"
+
+	kAppSignURL = "alipays://platformapi/startapp?appId=60000157&appClearTop=false&startMultApp=YES&sign_params="
+)
+
+// SignAgreement 支付宝周期扣款签约
+// form -> 扫码签约跳转地址
+// wap -> 钱包h5签约跳转地址
+// app -> 调起支付宝app签约的地址
+func (p *Alipay) SignAgreement(way pay.Way, in pay.AgreementOrder) (string, error) {
+	param := agreementPageSign{
+		NotifyURL:           p.opt.NotifyURL,
+		ReturnURL:           p.opt.ReturnURL,
+		PersonalProductCode: kCyclePayAuthPersonal,
+		ProductCode:         kCyclePayAuth,
+		SignScene:           "INDUSTRY|DIGITAL_MEDIA",
+		ExternalAgreementNo: in.ExternalID,
+		PeriodRuleParams: periodRuleParams{
+			PeriodType:   in.PeriodType,
+			Period:       in.Period,
+			ExecuteTime:  in.ExecuteTime,
+			SingleAmount: amount(in.SingleAmount),
+		},
+	}
+
+	switch way {
+	case pay.WayForm:
+		param.AccessParams.Channel = "QRCODEORSMS"
+		return p.gatewayURL(param)
+	case pay.WayWap:
+		param.AccessParams.Channel = "ALIPAYAPP"
+		return p.gatewayURL(param)
+	case pay.WayApp:
+		param.AccessParams.Channel = "ALIPAYAPP"
+		v, err := p.client.URLValues(param)
+		if err != nil {
+			return "", err
+		}
+		return kAppSignURL + url.QueryEscape(v.Encode()), nil
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+// VerifyAgreement 签约、解约回调验证签名 notify_type 为 dut_user_sign dut_user_unsign
+func (p *Alipay) VerifyAgreement(in url.Values) (*pay.AgreementNotice, error) {
+	if !p.verifySign(in) {
+		return nil, pay.ErrVerify
+	}
+
+	return AgreementNoticeParams(in), nil
+}
+
+// Unsign 解约 https://docs.open.alipay.com/api_2/alipay.user.agreement.unsign
+func (p *Alipay) Unsign(agreementID string) error {
+	var rsp struct {
+		Content struct {
+			Code    string `json:"code"`
+			Msg     string `json:"msg"`
+			SubCode string `json:"sub_code"`
+			SubMsg  string `json:"sub_msg"`
+		} `json:"alipay_user_agreement_unsign_response"`
+	}
+
+	err := p.call(false, func() (string, string, error) {
+		err := p.client.DoRequest("POST", agreementUnsign{
+			AgreementNo:         agreementID,
+			PersonalProductCode: kCyclePayAuthPersonal,
+		}, &rsp)
+		return rsp.Content.Code, rsp.Content.SubCode, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		return newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	return nil
+}
+
+// Charge 按协议扣款 alipay.trade.pay，product_code 为 CYCLE_PAY_AUTH
+func (p *Alipay) Charge(agreementID string, in pay.Order) (*pay.NoticeParams, error) {
+	param := agreementPay{
+		TradePay: alipay.TradePay{
+			Trade: alipay.Trade{
+				NotifyURL:   p.opt.NotifyURL,
+				Subject:     in.Title,
+				OutTradeNo:  in.ID,
+				TotalAmount: amount(in.Amount),
+				ProductCode: kCyclePayAuth,
+			},
+		},
+		AgreementParams: agreementParams{
+			AgreementNo: agreementID,
+		},
+	}
+
+	var rsp *alipay.TradePayRsp
+	err := p.call(false, func() (string, string, error) {
+		if err := p.client.DoRequest("POST", param, &rsp); err != nil {
+			return "", "", err
+		}
+		return rsp.AliPayTradePay.Code, rsp.AliPayTradePay.SubCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var status pay.TradeStatus
+	switch rsp.AliPayTradePay.Code {
+	case alipay.K_SUCCESS_CODE:
+		status = pay.TradeStatusSuccess
+	case "10003":
+		status = pay.TradeStatusWait
+	default:
+		return nil, newError(rsp.AliPayTradePay.Code, rsp.AliPayTradePay.Msg, rsp.AliPayTradePay.SubCode, rsp.AliPayTradePay.SubMsg)
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     in.ID,
+		PaymentID:   rsp.AliPayTradePay.TradeNo,
+		TradeStatus: status,
+		Amount:      in.Amount,
+	}, nil
+}
+
+// AgreementNoticeParams 签约、解约回调参数
+func AgreementNoticeParams(val url.Values) *pay.AgreementNotice {
+	var (
+		status = pay.AgreementStatusSigned
+		t      = val.Get("sign_time")
+	)
+
+	if val.Get("status") == "UNSIGN" || val.Get("notify_type") == "dut_user_unsign" {
+		status = pay.AgreementStatusUnsigned
+		t = val.Get("unsign_time")
+	}
+
+	return &pay.AgreementNotice{
+		AgreementID: val.Get("agreement_no"),
+		ExternalID:  val.Get("external_agreement_no"),
+		Status:      status,
+		UserID:      val.Get("alipay_user_id"),
+		Time:        t,
+	}
+}
+
+// agreementPageSign 支付宝个人协议页面签约
+type agreementPageSign struct {
+	NotifyURL           string           `json:"-"`
+	ReturnURL           string           `json:"-"`
+	PersonalProductCode string           `json:"personal_product_code"`
+	ProductCode         string           `json:"product_code"`
+	SignScene           string           `json:"sign_scene"`
+	ExternalAgreementNo string           `json:"external_agreement_no"`
+	AccessParams        accessParams     `json:"access_params"`
+	PeriodRuleParams    periodRuleParams `json:"period_rule_params"`
+}
+
+type accessParams struct {
+	Channel string `json:"channel"`
+}
+
+type periodRuleParams struct {
+	PeriodType   string `json:"period_type"`
+	Period       int    `json:"period"`
+	ExecuteTime  string `json:"execute_time"`
+	SingleAmount string `json:"single_amount"`
+}
+
+// APIName APIName
+func (p agreementPageSign) APIName() string {
+	return "alipay.user.agreement.page.sign"
+}
+
+// Params Params
+func (p agreementPageSign) Params() map[string]string {
+	var m = make(map[string]string)
+	m["notify_url"] = p.NotifyURL
+	m["return_url"] = p.ReturnURL
+	return m
+}
+
+// ExtJSONParamName ExtJSONParamName
+func (p agreementPageSign) ExtJSONParamName() string {
+	return "biz_content"
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p agreementPageSign) ExtJSONParamValue() string {
+	return marshal(p)
+}
+
+// agreementUnsign 支付宝个人代扣协议解约
+type agreementUnsign struct {
+	AgreementNo         string `json:"agreement_no"`
+	PersonalProductCode string `json:"personal_product_code"`
+}
+
+// APIName APIName
+func (p agreementUnsign) APIName() string {
+	return "alipay.user.agreement.unsign"
+}
+
+// Params Params
+func (p agreementUnsign) Params() map[string]string {
+	return nil
+}
+
+// ExtJSONParamName ExtJSONParamName
+func (p agreementUnsign) ExtJSONParamName() string {
+	return "biz_content"
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p agreementUnsign) ExtJSONParamValue() string {
+	return marshal(p)
+}
+
+// agreementPay 协议扣款，在 alipay.trade.pay 中加入 agreement_params
+type agreementPay struct {
+	alipay.TradePay
+	AgreementParams agreementParams `json:"agreement_params"`
+}
+
+type agreementParams struct {
+	AgreementNo string `json:"agreement_no"`
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p agreementPay) ExtJSONParamValue() string {
+	return marshal(p)
+}
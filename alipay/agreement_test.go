@@ -0,0 +1,161 @@
+package alipay
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/alipay/alipaytest"
+)
+
+// verifyRequestSign 使用桩网关生成的应用私钥验证请求签名，请求签名包括 sign_type
+func verifyRequestSign(t *testing.T, s *alipaytest.Server, v url.Values) error {
+	t.Helper()
+
+	b, err := base64.StdEncoding.DecodeString(s.AppPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pList []string
+	for k := range v {
+		if k != "sign" && len(v.Get(k)) > 0 {
+			pList = append(pList, k+"="+v.Get(k))
+		}
+	}
+	sort.Strings(pList)
+
+	sig, err := base64.StdEncoding.DecodeString(v.Get("sign"))
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(strings.Join(pList, "&")))
+
+	return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, h[:], sig)
+}
+
+func TestSignAgreement(t *testing.T) {
+	p, s := newTestAlipay(t)
+	in := pay.AgreementOrder{ExternalID: "A1", PeriodType: "MONTH", Period: 1, ExecuteTime: "2020-01-01", SingleAmount: 1999}
+
+	for way, channel := range map[pay.Way]string{
+		pay.WayForm: "QRCODEORSMS",
+		pay.WayWap:  "ALIPAYAPP",
+		pay.WayApp:  "ALIPAYAPP",
+	} {
+		u, err := p.SignAgreement(way, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var raw string
+		if way == pay.WayApp {
+			if !strings.HasPrefix(u, kAppSignURL) {
+				t.Fatalf("%s: url %s", way, u)
+			}
+			raw, _ = url.QueryUnescape(strings.TrimPrefix(u, kAppSignURL))
+		} else {
+			if !strings.HasPrefix(u, s.URL+"/gateway.do?") {
+				t.Fatalf("%s: url %s", way, u)
+			}
+			raw = u[strings.Index(u, "?")+1:]
+		}
+
+		v, err := url.ParseQuery(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyRequestSign(t, s, v); err != nil {
+			t.Errorf("%s: verify sign %v", way, err)
+		}
+
+		var biz agreementPageSign
+		json.Unmarshal([]byte(v.Get("biz_content")), &biz)
+		if v.Get("method") != "alipay.user.agreement.page.sign" || biz.AccessParams.Channel != channel ||
+			biz.ExternalAgreementNo != "A1" || biz.PeriodRuleParams.SingleAmount != "19.99" {
+			t.Errorf("%s: params %v", way, v)
+		}
+	}
+
+	if _, err := p.SignAgreement(pay.WayQrcode, in); err != pay.ErrWayNotDefine {
+		t.Errorf("got %v, want %v", err, pay.ErrWayNotDefine)
+	}
+}
+
+func TestVerifyAgreement(t *testing.T) {
+	p, s := newTestAlipay(t)
+
+	in := s.SignAgreement("A1", "2088000000000001")
+	n, err := p.VerifyAgreement(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.AgreementID != in.Get("agreement_no") || n.ExternalID != "A1" || n.UserID != "2088000000000001" ||
+		n.Status != pay.AgreementStatusSigned {
+		t.Errorf("notice %+v", n)
+	}
+
+	in.Set("external_agreement_no", "A2")
+	if _, err := p.VerifyAgreement(in); err != pay.ErrVerify {
+		t.Errorf("got %v, want %v", err, pay.ErrVerify)
+	}
+}
+
+func TestUnsign(t *testing.T) {
+	p, s := newTestAlipay(t)
+	id := s.SignAgreement("A1", "2088000000000001").Get("agreement_no")
+
+	if err := p.Unsign(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Agreement(id); got != "UNSIGN" {
+		t.Errorf("agreement %s, want UNSIGN", got)
+	}
+
+	err := p.Unsign(id)
+	if e, ok := err.(*pay.Error); !ok || e.SubCode != "USER_AGREEMENT_NOT_EXIST" {
+		t.Errorf("got %v, want USER_AGREEMENT_NOT_EXIST", err)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	p, s := newTestAlipay(t)
+	id := s.SignAgreement("A1", "2088000000000001").Get("agreement_no")
+
+	r, err := p.Charge(id, pay.Order{ID: "O1", Title: "test", Amount: 1999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" || len(r.PaymentID) == 0 || r.TradeStatus != pay.TradeStatusSuccess || r.Amount != 1999 {
+		t.Errorf("result %+v", r)
+	}
+
+	q, err := p.Query("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Amount != 1999 || q.TradeStatus != pay.TradeStatusSuccess {
+		t.Errorf("query %+v", q)
+	}
+
+	// 解约后扣款失败
+	if err := p.Unsign(id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Charge(id, pay.Order{ID: "O2", Title: "test", Amount: 1999})
+	if e, ok := err.(*pay.Error); !ok || e.SubCode != "ACQ.AGREEMENT_NOT_EXIST" {
+		t.Errorf("got %v, want ACQ.AGREEMENT_NOT_EXIST", err)
+	}
+}
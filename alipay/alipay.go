@@ -1,16 +1,19 @@
 package alipay
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
-	"strconv"
 
 	"github.com/gocommon/pay"
 	"github.com/smartwalle/alipay"
 )
 
-var _ pay.Payer = &Alipay{}
+var (
+	_ pay.Payer        = &Alipay{}
+	_ pay.ContextPayer = &Alipay{}
+)
 
 // Options Options
 type Options struct {
@@ -20,12 +23,21 @@ type Options struct {
 	IsProduction  bool
 	NotifyURL     string // 异步回调地址
 	ReturnURL     string // 同步回调地址
+	APIDomain     string // 网关域名，用于接口桩，为空时按 IsProduction 使用支付宝网关
+	Client        *http.Client
+
+	// WrapTransport 包装请求网关的 Transport，用于链路追踪
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+
+	Retry   *pay.RetryPolicy    // 幂等接口的重试策略，为空时不重试
+	Breaker *pay.BreakerOptions // 熔断，为空时不熔断
 }
 
 // Alipay Alipay
 type Alipay struct {
-	opt    Options
-	client *alipay.Client
+	opt     Options
+	client  *alipay.Client
+	breaker *pay.Breaker
 }
 
 // New New
@@ -35,28 +47,74 @@ func New(opt Options) (*Alipay, error) {
 		return nil, err
 	}
 
+	if opt.Client != nil {
+		cli.Client = opt.Client
+	}
+
+	var t = &transport{base: http.DefaultTransport}
+	if cli.Client.Transport != nil {
+		t.base = cli.Client.Transport
+	}
+	if opt.WrapTransport != nil {
+		t.base = opt.WrapTransport(t.base)
+	}
+	if len(opt.APIDomain) > 0 {
+		if t.domain, err = url.Parse(opt.APIDomain); err != nil {
+			return nil, err
+		}
+	}
+
+	c := *cli.Client
+	c.Transport = t
+	cli.Client = &c
+
 	p := &Alipay{
 		client: cli,
 		opt:    opt,
 	}
 
+	if opt.Breaker != nil {
+		p.breaker = pay.NewBreaker(*opt.Breaker)
+	}
+
 	return p, nil
 }
 
-// Verify 支付回调验证签名,成功返回回调参数
-func (p *Alipay) Verify(in url.Values) (*pay.NoticeParams, error) {
-	ok, err := p.client.VerifySign(in)
-	if err != nil {
-		return nil, err
+// WithContext 返回使用 ctx 请求网关的副本，共用熔断器
+func (p *Alipay) WithContext(ctx context.Context) pay.Payer {
+	if ctx == nil {
+		panic("nil context")
 	}
 
-	if !ok {
+	t := *p.client.Client.Transport.(*transport)
+	t.ctx = ctx
+
+	c := *p.client.Client
+	c.Transport = &t
+
+	cli := *p.client
+	cli.Client = &c
+
+	a := *p
+	a.client = &cli
+	return &a
+}
+
+// Verify 支付回调验证签名,成功返回回调参数
+func (p *Alipay) Verify(in url.Values) (*pay.NoticeParams, error) {
+	if !p.verifySign(in) {
 		return nil, pay.ErrVerify
 	}
 
 	return NoticeParams(in), nil
 }
 
+// verifySign 回调验签，签名不一致时 VerifySign 返回 rsa 的错误而不是 false，都按验签失败处理
+func (p *Alipay) verifySign(in url.Values) bool {
+	ok, err := p.client.VerifySign(in)
+	return ok && err == nil
+}
+
 // 支付状态
 
 // Success 回调成功返回数据
@@ -87,7 +145,7 @@ func (p *Alipay) Call(way pay.Way, in pay.Order) (string, error) {
 
 // wapCall 返回跳转的url地址
 func (p *Alipay) wapCall(in pay.Order) (string, error) {
-	u, err := p.client.TradeWapPay(alipay.TradeWapPay{
+	param := alipay.TradeWapPay{
 		Trade: alipay.Trade{
 			Subject:     in.Title,
 			OutTradeNo:  in.ID,
@@ -95,8 +153,13 @@ func (p *Alipay) wapCall(in pay.Order) (string, error) {
 			NotifyURL:   p.opt.NotifyURL,
 			ReturnURL:   p.opt.ReturnURL,
 		},
-	})
+	}
 
+	if in.ProfitSharing {
+		return p.gatewayURL(royaltyFreeze{param})
+	}
+
+	u, err := p.client.TradeWapPay(param)
 	if err != nil {
 		return "", err
 	}
@@ -106,8 +169,7 @@ func (p *Alipay) wapCall(in pay.Order) (string, error) {
 
 // formCall 返回跳转的url地址
 func (p *Alipay) formCall(in pay.Order) (string, error) {
-
-	u, err := p.client.TradePagePay(alipay.TradePagePay{
+	param := alipay.TradePagePay{
 		Trade: alipay.Trade{
 			Subject:     in.Title,
 			OutTradeNo:  in.ID,
@@ -115,8 +177,13 @@ func (p *Alipay) formCall(in pay.Order) (string, error) {
 			NotifyURL:   p.opt.NotifyURL,
 			ReturnURL:   p.opt.ReturnURL,
 		},
-	})
+	}
 
+	if in.ProfitSharing {
+		return p.gatewayURL(royaltyFreeze{param})
+	}
+
+	u, err := p.client.TradePagePay(param)
 	if err != nil {
 		return "", err
 	}
@@ -126,32 +193,56 @@ func (p *Alipay) formCall(in pay.Order) (string, error) {
 
 // appCall 返回app调起支付的参数
 func (p *Alipay) appCall(in pay.Order) (string, error) {
-
-	return p.client.TradeAppPay(alipay.TradeAppPay{
+	param := alipay.TradeAppPay{
 		Trade: alipay.Trade{
 			Subject:     in.Title,
 			OutTradeNo:  in.ID,
 			TotalAmount: fmt.Sprintf("%.2f", float32(in.Amount)/100),
 		},
 		// TimeExpire: "1d", // 该笔订单允许的最晚付款时间，逾期将关闭交易 取值范围：1m～15d 不接受小数点
-	})
+	}
+
+	if in.ProfitSharing {
+		v, err := p.client.URLValues(royaltyFreeze{param})
+		if err != nil {
+			return "", err
+		}
+		return v.Encode(), nil
+	}
+
+	return p.client.TradeAppPay(param)
 }
 
 // qrcodeCall 返回二维码地址
 func (p *Alipay) qrcodeCall(in pay.Order) (string, error) {
-	resp, err := p.client.TradePreCreate(alipay.TradePreCreate{
+	param := alipay.TradePreCreate{
 		Trade: alipay.Trade{
 			Subject:     in.Title,
 			OutTradeNo:  in.ID,
 			TotalAmount: fmt.Sprintf("%.2f", float32(in.Amount)/100),
 		},
+	}
+
+	// 同一 out_trade_no 重复预下单返回同一二维码
+	var resp *alipay.TradePreCreateRsp
+	err := p.call(true, func() (string, string, error) {
+		var err error
+		if in.ProfitSharing {
+			err = p.client.DoRequest("POST", royaltyFreeze{param}, &resp)
+		} else {
+			resp, err = p.client.TradePreCreate(param)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return resp.Content.Code, resp.Content.SubCode, nil
 	})
 	if err != nil {
 		return "", err
 	}
 
 	if !resp.IsSuccess() {
-		return "", errors.New("sign failed")
+		return "", newError(resp.Content.Code, resp.Content.Msg, resp.Content.SubCode, resp.Content.SubMsg)
 	}
 
 	return resp.Content.QRCode, nil
@@ -166,11 +257,6 @@ func NoticeParams(val url.Values) *pay.NoticeParams {
 		payStatus pay.TradeStatus
 	)
 
-	amountf, _ := strconv.ParseFloat(amount, 10)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
 	switch status {
 	case "WAIT_BUYER_PAY":
 		payStatus = pay.TradeStatusWait
@@ -186,6 +272,6 @@ func NoticeParams(val url.Values) *pay.NoticeParams {
 		OrderID:     val.Get("out_trade_no"),
 		PaymentID:   val.Get("trade_no"), // 支付单号
 		TradeStatus: payStatus,           //支付状态
-		Amount:      int32(amountf * 100),
+		Amount:      fen(amount),
 	}
 }
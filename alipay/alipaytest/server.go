@@ -0,0 +1,593 @@
+// Package alipaytest 本地支付宝网关桩，可以注入故障，用于测试重试和熔断
+//
+// alipay.Options.APIDomain 使用 Server.URL，AliPublicKey AppPrivateKey 使用 Server 生成的密钥。
+// 桩网关不验证商户签名，支持预下单、查询、关闭、退款、单笔转账、分账关系绑定解绑和周期扣款的解约、协议扣款，
+// 订单需要调用 Pay 模拟用户支付，协议需要调用 SignAgreement 模拟用户签约
+package alipaytest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 接口名，Inject Requests 使用
+const (
+	TradePreCreate  = "alipay.trade.precreate"
+	TradeQuery      = "alipay.trade.query"
+	TradeClose      = "alipay.trade.close"
+	TradeRefund     = "alipay.trade.refund"
+	FundTransfer    = "alipay.fund.trans.toaccount.transfer"
+	FundQuery       = "alipay.fund.trans.order.query"
+	RoyaltyBind     = "alipay.trade.royalty.relation.bind"
+	RoyaltyUnbind   = "alipay.trade.royalty.relation.unbind"
+	TradePay        = "alipay.trade.pay"
+	AgreementUnsign = "alipay.user.agreement.unsign"
+)
+
+// PayeeNotExist 转账到该收款账号时返回 PAYEE_NOT_EXIST
+const PayeeNotExist = "notexist@example.com"
+
+// RoyaltyResultFail 绑定解绑该收款方账号时返回 code 10000、result_code FAIL
+const RoyaltyResultFail = "2088000000000000"
+
+// Fault 注入的故障
+type Fault int
+
+const (
+	// FaultTimeout 等待 Server.Delay 后正常处理，客户端超时时间小于 Delay 时为超时
+	FaultTimeout Fault = iota + 1
+	// FaultServerError 返回 502
+	FaultServerError
+	// FaultSystemError 返回 40004 ACQ.SYSTEM_ERROR，请求不处理
+	FaultSystemError
+	// FaultUnknown 正常处理请求后返回 20000 服务不可用，模拟处理成功但结果未知
+	FaultUnknown
+)
+
+// Server 本地支付宝网关桩
+type Server struct {
+	*httptest.Server
+
+	AliPublicKey  string        // 桩网关公钥，用于 alipay.Options.AliPublicKey
+	AppPrivateKey string        // 应用私钥，用于 alipay.Options.AppPrivateKey
+	Delay         time.Duration // FaultTimeout 的等待时间，默认 1s
+
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	seq        int
+	faults     map[string][]Fault
+	requests   map[string]int
+	orders     map[string]*order
+	refunds    map[string]string // out_request_no 对应的订单号
+	payouts    map[string]*payout
+	royalty    map[string]bool   // 已绑定分账关系的收款方账号
+	agreements map[string]string // agreement_no 对应的协议状态 NORMAL UNSIGN
+}
+
+type payout struct {
+	OutBizNo string
+	OrderID  string
+	Amount   string
+}
+
+type order struct {
+	OutTradeNo  string
+	TradeNo     string
+	TotalAmount string
+	Status      string // WAIT_BUYER_PAY TRADE_SUCCESS TRADE_CLOSED
+}
+
+// NewServer 生成密钥并启动网关桩
+func NewServer() (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		AliPublicKey:  base64.StdEncoding.EncodeToString(pub),
+		AppPrivateKey: base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(app)),
+		Delay:         time.Second,
+		key:           key,
+		faults:        make(map[string][]Fault),
+		requests:      make(map[string]int),
+		orders:        make(map[string]*order),
+		refunds:       make(map[string]string),
+		payouts:       make(map[string]*payout),
+		royalty:       make(map[string]bool),
+		agreements:    make(map[string]string),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s, nil
+}
+
+// Inject 接口 method 接下来的 n 次请求返回 fault，多次调用按顺序排队
+func (s *Server) Inject(method string, fault Fault, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.faults[method] = append(s.faults[method], fault)
+	}
+}
+
+// Requests 接口 method 收到的请求数，包括注入故障的请求
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[method]
+}
+
+// Refunds 订单的退款数，重复的退款请求不重复退款
+func (s *Server) Refunds(outTradeNo string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, id := range s.refunds {
+		if id == outTradeNo {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Payout 转账单号对应的转账金额，没有转账时返回空，重复的转账请求只转账一次
+func (s *Server) Payout(outBizNo string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.payouts[outBizNo]; ok {
+		return p.Amount
+	}
+	return ""
+}
+
+// Royalty 收款方账号是否已绑定分账关系
+func (s *Server) Royalty(account string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.royalty[account]
+}
+
+// Agreement 协议状态，NORMAL 或 UNSIGN，协议不存在时返回空
+func (s *Server) Agreement(agreementNo string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.agreements[agreementNo]
+}
+
+// SignAgreement 模拟用户签约，返回签名的 dut_user_sign 签约回调参数
+func (s *Server) SignAgreement(externalAgreementNo, userID string) url.Values {
+	s.mu.Lock()
+	s.seq++
+	agreementNo := "2020" + strconv.Itoa(s.seq)
+	s.agreements[agreementNo] = "NORMAL"
+	s.mu.Unlock()
+
+	var v = url.Values{}
+	v.Set("notify_type", "dut_user_sign")
+	v.Set("notify_id", strconv.FormatInt(time.Now().UnixNano(), 36))
+	v.Set("agreement_no", agreementNo)
+	v.Set("external_agreement_no", externalAgreementNo)
+	v.Set("alipay_user_id", userID)
+	v.Set("status", "NORMAL")
+	v.Set("sign_time", "2020-01-01 00:00:00")
+	v.Set("sign_type", "RSA2")
+	v.Set("sign", s.signValues(v))
+
+	return v
+}
+
+// Pay 模拟用户支付，返回签名的异步通知参数，订单不存在或不是待支付时返回 nil
+func (s *Server) Pay(outTradeNo string) url.Values {
+	s.mu.Lock()
+	o, ok := s.orders[outTradeNo]
+	if ok && o.Status == "WAIT_BUYER_PAY" {
+		o.Status = "TRADE_SUCCESS"
+	} else {
+		ok = false
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	var v = url.Values{}
+	v.Set("notify_type", "trade_status_sync")
+	v.Set("notify_id", strconv.FormatInt(time.Now().UnixNano(), 36))
+	v.Set("out_trade_no", o.OutTradeNo)
+	v.Set("trade_no", o.TradeNo)
+	v.Set("trade_status", o.Status)
+	v.Set("total_amount", o.TotalAmount)
+	v.Set("sign_type", "RSA2")
+	v.Set("sign", s.signValues(v))
+
+	return v
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	method := r.Form.Get("method")
+
+	var biz map[string]string
+	json.Unmarshal([]byte(r.Form.Get("biz_content")), &biz)
+
+	var receivers struct {
+		ReceiverList []struct {
+			Account string `json:"account"`
+		} `json:"receiver_list"`
+	}
+	json.Unmarshal([]byte(r.Form.Get("biz_content")), &receivers)
+
+	var agreement struct {
+		AgreementParams struct {
+			AgreementNo string `json:"agreement_no"`
+		} `json:"agreement_params"`
+	}
+	json.Unmarshal([]byte(r.Form.Get("biz_content")), &agreement)
+
+	fault := s.next(method)
+
+	switch fault {
+	case FaultTimeout:
+		time.Sleep(s.Delay)
+	case FaultServerError:
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	case FaultSystemError:
+		s.write(w, method, bizError("40004", "Business Failed", "ACQ.SYSTEM_ERROR", "系统错误"))
+		return
+	}
+
+	var rsp map[string]string
+	switch method {
+	case TradePreCreate:
+		rsp = s.preCreate(biz)
+	case TradeQuery:
+		rsp = s.query(biz)
+	case TradeClose:
+		rsp = s.close(biz)
+	case TradeRefund:
+		rsp = s.refund(biz)
+	case FundTransfer:
+		rsp = s.transfer(biz)
+	case FundQuery:
+		rsp = s.queryTransfer(biz)
+	case RoyaltyBind, RoyaltyUnbind:
+		var accounts []string
+		for _, r := range receivers.ReceiverList {
+			accounts = append(accounts, r.Account)
+		}
+		rsp = s.royaltyRelation(accounts, method == RoyaltyBind)
+	case AgreementUnsign:
+		rsp = s.unsign(biz)
+	case TradePay:
+		rsp = s.tradePay(biz, agreement.AgreementParams.AgreementNo)
+	default:
+		rsp = bizError("40004", "Business Failed", "isv.invalid-method", "不存在的方法名")
+	}
+
+	if fault == FaultUnknown {
+		rsp = bizError("20000", "Service Currently Unavailable", "isp.unknow-error", "系统繁忙")
+	}
+
+	s.write(w, method, rsp)
+}
+
+// next 记录请求数并取出注入的故障
+func (s *Server) next(method string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[method]++
+
+	faults := s.faults[method]
+	if len(faults) == 0 {
+		return 0
+	}
+
+	s.faults[method] = faults[1:]
+
+	return faults[0]
+}
+
+func (s *Server) preCreate(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := biz["out_trade_no"]
+	o, ok := s.orders[id]
+	if ok && o.Status != "WAIT_BUYER_PAY" {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_HAS_SUCCESS", "交易已被支付")
+	}
+
+	// 同一订单号重复预下单返回同一二维码
+	if !ok {
+		s.seq++
+		o = &order{
+			OutTradeNo:  id,
+			TradeNo:     "2019" + strconv.Itoa(s.seq),
+			TotalAmount: biz["total_amount"],
+			Status:      "WAIT_BUYER_PAY",
+		}
+		s.orders[id] = o
+	}
+
+	rsp := success()
+	rsp["out_trade_no"] = id
+	rsp["qr_code"] = "https://qr.alipay.com/" + o.TradeNo
+
+	return rsp
+}
+
+func (s *Server) query(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[biz["out_trade_no"]]
+	if !ok {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_NOT_EXIST", "交易不存在")
+	}
+
+	rsp := success()
+	rsp["out_trade_no"] = o.OutTradeNo
+	rsp["trade_no"] = o.TradeNo
+	rsp["trade_status"] = o.Status
+	rsp["total_amount"] = o.TotalAmount
+
+	return rsp
+}
+
+func (s *Server) close(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[biz["out_trade_no"]]
+	if !ok {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_NOT_EXIST", "交易不存在")
+	}
+
+	if o.Status != "WAIT_BUYER_PAY" && o.Status != "TRADE_CLOSED" {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_STATUS_ERROR", "交易状态不合法")
+	}
+
+	o.Status = "TRADE_CLOSED"
+
+	rsp := success()
+	rsp["out_trade_no"] = o.OutTradeNo
+	rsp["trade_no"] = o.TradeNo
+
+	return rsp
+}
+
+func (s *Server) refund(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[biz["out_trade_no"]]
+	if !ok || o.Status != "TRADE_SUCCESS" {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_STATUS_ERROR", "交易状态不合法")
+	}
+
+	// 同一 out_request_no 重复请求只退款一次
+	var change = "N"
+	if _, ok := s.refunds[biz["out_request_no"]]; !ok {
+		s.refunds[biz["out_request_no"]] = o.OutTradeNo
+		change = "Y"
+	}
+
+	rsp := success()
+	rsp["out_trade_no"] = o.OutTradeNo
+	rsp["trade_no"] = o.TradeNo
+	rsp["refund_fee"] = biz["refund_amount"]
+	rsp["fund_change"] = change
+
+	return rsp
+}
+
+func (s *Server) transfer(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if biz["payee_account"] == PayeeNotExist {
+		return bizError("40004", "Business Failed", "PAYEE_NOT_EXIST", "收款账号不存在")
+	}
+
+	// 同一 out_biz_no 重复请求只转账一次
+	p, ok := s.payouts[biz["out_biz_no"]]
+	if !ok {
+		s.seq++
+		p = &payout{
+			OutBizNo: biz["out_biz_no"],
+			OrderID:  "2020" + strconv.Itoa(s.seq),
+			Amount:   biz["amount"],
+		}
+		s.payouts[p.OutBizNo] = p
+	}
+
+	rsp := success()
+	rsp["out_biz_no"] = p.OutBizNo
+	rsp["order_id"] = p.OrderID
+	rsp["pay_date"] = "2020-01-01 00:00:00"
+
+	return rsp
+}
+
+func (s *Server) queryTransfer(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payouts[biz["out_biz_no"]]
+	if !ok {
+		return bizError("40004", "Business Failed", "ORDER_NOT_EXIST", "转账订单不存在")
+	}
+
+	rsp := success()
+	rsp["out_biz_no"] = p.OutBizNo
+	rsp["order_id"] = p.OrderID
+	rsp["status"] = "SUCCESS"
+	rsp["pay_date"] = "2020-01-01 00:00:00"
+
+	return rsp
+}
+
+func (s *Server) royaltyRelation(accounts []string, bind bool) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(accounts) == 0 {
+		return bizError("40004", "Business Failed", "ACQ.INVALID_PARAMETER", "参数无效")
+	}
+
+	for _, a := range accounts {
+		if a == RoyaltyResultFail {
+			rsp := success()
+			rsp["result_code"] = "FAIL"
+			return rsp
+		}
+	}
+
+	for _, a := range accounts {
+		if bind {
+			s.royalty[a] = true
+		} else {
+			delete(s.royalty, a)
+		}
+	}
+
+	rsp := success()
+	rsp["result_code"] = "SUCCESS"
+
+	return rsp
+}
+
+func (s *Server) unsign(biz map[string]string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.agreements[biz["agreement_no"]] != "NORMAL" {
+		return bizError("40004", "Business Failed", "USER_AGREEMENT_NOT_EXIST", "用户协议不存在")
+	}
+
+	s.agreements[biz["agreement_no"]] = "UNSIGN"
+
+	return success()
+}
+
+// tradePay 只支持按协议扣款，协议有效时直接支付成功
+func (s *Server) tradePay(biz map[string]string, agreementNo string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.agreements[agreementNo] != "NORMAL" {
+		return bizError("40004", "Business Failed", "ACQ.AGREEMENT_NOT_EXIST", "用户协议不存在")
+	}
+
+	id := biz["out_trade_no"]
+	o, ok := s.orders[id]
+	if ok {
+		return bizError("40004", "Business Failed", "ACQ.TRADE_HAS_SUCCESS", "交易已被支付")
+	}
+
+	s.seq++
+	o = &order{
+		OutTradeNo:  id,
+		TradeNo:     "2019" + strconv.Itoa(s.seq),
+		TotalAmount: biz["total_amount"],
+		Status:      "TRADE_SUCCESS",
+	}
+	s.orders[id] = o
+
+	rsp := success()
+	rsp["out_trade_no"] = o.OutTradeNo
+	rsp["trade_no"] = o.TradeNo
+	rsp["total_amount"] = o.TotalAmount
+
+	return rsp
+}
+
+// write 返回签名的响应，sign 为响应节点 json 的 RSA2 签名
+func (s *Server) write(w http.ResponseWriter, method string, rsp map[string]string) {
+	content, _ := json.Marshal(rsp)
+
+	h := sha256.Sum256(content)
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+
+	name := strings.Replace(method, ".", "_", -1) + "_response"
+	if len(method) == 0 {
+		name = "error_response"
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	io.WriteString(w, `{"`+name+`":`+string(content)+`,"sign":"`+base64.StdEncoding.EncodeToString(sig)+`"}`)
+}
+
+// signValues 异步通知签名，除 sign sign_type 外的参数按 key 排序
+func (s *Server) signValues(v url.Values) string {
+	var pList = make([]string, 0, len(v))
+	for k := range v {
+		if k == "sign" || k == "sign_type" || len(v.Get(k)) == 0 {
+			continue
+		}
+		pList = append(pList, k+"="+v.Get(k))
+	}
+	sort.Strings(pList)
+
+	h := sha256.Sum256([]byte(strings.Join(pList, "&")))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func success() map[string]string {
+	return map[string]string{
+		"code": "10000",
+		"msg":  "Success",
+	}
+}
+
+func bizError(code, msg, subCode, subMsg string) map[string]string {
+	return map[string]string{
+		"code":     code,
+		"msg":      msg,
+		"sub_code": subCode,
+		"sub_msg":  subMsg,
+	}
+}
@@ -0,0 +1,66 @@
+package alipay
+
+import "github.com/gocommon/pay"
+
+// kProvider pay.Error.Provider
+const kProvider = "alipay"
+
+// kCodeCategory 网关返回码的分类 https://opendocs.alipay.com/common/02km9f
+var kCodeCategory = map[string]pay.Category{
+	"20000": pay.CategorySystem, // 服务不可用
+	"20001": pay.CategoryAuth,   // 授权权限不足
+	"40001": pay.CategoryParam,  // 缺少必选参数
+	"40002": pay.CategoryParam,  // 非法的参数
+	"40006": pay.CategoryAuth,   // 权限不足
+}
+
+// kSubCodeCategory 常见 sub_code 的分类，优先于 code
+var kSubCodeCategory = map[string]pay.Category{
+	"isv.invalid-signature":                 pay.CategoryAuth,
+	"isv.invalid-app-id":                    pay.CategoryAuth,
+	"isv.insufficient-isv-permissions":      pay.CategoryAuth,
+	"isv.insufficient-user-permissions":     pay.CategoryAuth,
+	"aop.invalid-auth-token":                pay.CategoryAuth,
+	"aop.invalid-app-auth-token":            pay.CategoryAuth,
+	"ACQ.ACCESS_FORBIDDEN":                  pay.CategoryAuth,
+	"ACQ.PARTNER_ERROR":                     pay.CategoryAuth,
+	"ACQ.INVALID_PARAMETER":                 pay.CategoryParam,
+	"ACQ.TOTAL_FEE_EXCEED":                  pay.CategoryParam,
+	"ACQ.REFUND_AMT_NOT_EQUAL_TOTAL":        pay.CategoryParam,
+	"ACQ.TRADE_NOT_EXIST":                   pay.CategoryParam,
+	"ACQ.BUYER_BALANCE_NOT_ENOUGH":          pay.CategoryBalance,
+	"ACQ.BUYER_BANKCARD_BALANCE_NOT_ENOUGH": pay.CategoryBalance,
+	"ACQ.SELLER_BALANCE_NOT_ENOUGH":         pay.CategoryBalance,
+	"PAYER_BALANCE_NOT_ENOUGH":              pay.CategoryBalance,
+	"BALANCE_IS_NOT_ENOUGH":                 pay.CategoryBalance,
+	"ACQ.TRADE_HAS_SUCCESS":                 pay.CategoryDuplicate,
+	"ACQ.TRADE_HAS_CLOSE":                   pay.CategoryDuplicate,
+	"ACQ.TRADE_HAS_FINISHED":                pay.CategoryDuplicate,
+	"ACQ.CONTEXT_INCONSISTENT":              pay.CategoryDuplicate,
+	"ACQ.DISCORDANT_REPEAT_REQUEST":         pay.CategoryDuplicate,
+	"ACQ.SYSTEM_ERROR":                      pay.CategorySystem,
+	"aop.ACQ.SYSTEM_ERROR":                  pay.CategorySystem,
+	"SYSTEM_ERROR":                          pay.CategorySystem,
+	"isp.unknow-error":                      pay.CategorySystem,
+}
+
+// newError 网关返回的错误，sub_msg 为空时使用 msg
+func newError(code, msg, subCode, subMsg string) *pay.Error {
+	category, ok := kSubCodeCategory[subCode]
+	if !ok {
+		category = kCodeCategory[code]
+	}
+
+	if len(subMsg) > 0 {
+		msg = subMsg
+	}
+
+	return &pay.Error{
+		Provider:  kProvider,
+		Code:      code,
+		SubCode:   subCode,
+		Message:   msg,
+		Category:  category,
+		Retryable: isRetryCode(code, subCode),
+	}
+}
@@ -0,0 +1,152 @@
+package alipay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/alipay"
+)
+
+var _ pay.Payout = &Alipay{}
+
+// payoutRetryTimes 转账结果不确定时的最大请求次数
+const payoutRetryTimes = 3
+
+// Transfer 单笔转账到支付宝账户，结果不确定时按 Options.Retry 的间隔使用相同的out_biz_no重试
+// 多次请求结果仍不确定时以查询结果为准，查询不到时返回最后一次请求的错误，确定没有发出的请求直接返回错误
+func (p *Alipay) Transfer(in pay.PayoutOrder) (*pay.PayoutResult, error) {
+	if in.PayeeType == pay.PayeeBankCard {
+		return nil, pay.ErrPayeeTypeNotDefine
+	}
+
+	param := alipay.FundTransToAccountTransfer{
+		OutBizNo:      in.OutBizNo,
+		PayeeType:     payeeType(in.PayeeType),
+		PayeeAccount:  in.Payee,
+		Amount:        amount(in.Amount),
+		PayeeRealName: in.PayeeName,
+		Remark:        in.Remark,
+	}
+
+	var lastErr error
+	for i := 0; i < payoutRetryTimes; i++ {
+		if i > 0 {
+			time.Sleep(p.opt.Retry.Delay(i - 1))
+		}
+
+		var rsp *alipay.FundTransToAccountTransferRsp
+		err := p.call(false, func() (string, string, error) {
+			var err error
+			if rsp, err = p.client.FundTransToAccountTransfer(param); err != nil {
+				return "", "", err
+			}
+			return rsp.Content.Code, rsp.Content.SubCode, nil
+		})
+		if err != nil {
+			lastErr = err
+			if !isPayoutUnknown(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		if !rsp.IsSuccess() {
+			return &pay.PayoutResult{
+				OutBizNo:   in.OutBizNo,
+				PaymentID:  rsp.Content.OrderId,
+				Status:     pay.PayoutStatusFailed,
+				FailReason: rsp.Content.SubMsg,
+			}, nil
+		}
+
+		return &pay.PayoutResult{
+			OutBizNo:  in.OutBizNo,
+			PaymentID: rsp.Content.OrderId,
+			Status:    pay.PayoutStatusSuccess,
+			PayDate:   rsp.Content.PayDate,
+		}, nil
+	}
+
+	r, err := p.QueryTransfer(in.OutBizNo)
+	if err != nil {
+		return nil, lastErr
+	}
+
+	return r, nil
+}
+
+// isPayoutUnknown 转账请求可能已被处理，结果不确定：网关返回需要重试的返回码、5xx，或请求已发出后超时
+// 连接失败等请求没有发出的错误、业务错误和熔断返回 false
+func isPayoutUnknown(err error) bool {
+	var e *pay.Error
+	if !errors.As(err, &e) || !e.Retryable {
+		return false
+	}
+
+	if isRetryCode(e.Code, e.SubCode) {
+		return true
+	}
+
+	var se *statusError
+	if errors.As(e.Err, &se) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(e.Err, &ne) && ne.Timeout()
+}
+
+// QueryTransfer 查询转账订单
+func (p *Alipay) QueryTransfer(outBizNo string) (*pay.PayoutResult, error) {
+	var rsp *alipay.FundTransOrderQueryRsp
+	err := p.call(true, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.FundTransOrderQuery(alipay.FundTransOrderQuery{OutBizNo: outBizNo}); err != nil {
+			return "", "", err
+		}
+		return rsp.Content.Code, rsp.Content.SubCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !rsp.IsSuccess() {
+		return nil, newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	var status pay.PayoutStatus
+	switch rsp.Content.Status {
+	case "SUCCESS":
+		status = pay.PayoutStatusSuccess
+	case "FAIL", "REFUND":
+		status = pay.PayoutStatusFailed
+	default:
+		// INIT DEALING UNKNOWN
+		status = pay.PayoutStatusProcessing
+	}
+
+	return &pay.PayoutResult{
+		OutBizNo:   rsp.Content.OutBizNo,
+		PaymentID:  rsp.Content.OrderId,
+		Status:     status,
+		PayDate:    rsp.Content.PayDate,
+		FailReason: rsp.Content.FailReason,
+	}, nil
+}
+
+// payeeType 收款方账户类型
+func payeeType(t pay.PayeeType) string {
+	if t == pay.PayeeUserID {
+		return "ALIPAY_USERID"
+	}
+
+	return "ALIPAY_LOGONID"
+}
+
+// amount 分转为元，按整数格式化，不经过浮点数
+func amount(cents int32) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,127 @@
+package alipay
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/alipay/alipaytest"
+)
+
+func newTestAlipay(t *testing.T) (*Alipay, *alipaytest.Server) {
+	s, err := alipaytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	p, err := New(Options{
+		AppID:         "2016000000000000",
+		AliPublicKey:  s.AliPublicKey,
+		AppPrivateKey: s.AppPrivateKey,
+		APIDomain:     s.URL,
+		Retry:         &pay.RetryPolicy{Backoff: []time.Duration{time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, s
+}
+
+func TestAmount(t *testing.T) {
+	for cents, want := range map[int32]string{
+		0:        "0.00",
+		5:        "0.05",
+		100:      "1.00",
+		16777217: "167772.17",
+	} {
+		if got := amount(cents); got != want {
+			t.Errorf("amount(%d) = %s, want %s", cents, got, want)
+		}
+	}
+}
+
+func TestNoticeParamsAmount(t *testing.T) {
+	for s, want := range map[string]int32{
+		"19.99":     1999,
+		"0.29":      29,
+		"167772.17": 16777217,
+		"":          0,
+	} {
+		if got := NoticeParams(url.Values{"total_amount": {s}}).Amount; got != want {
+			t.Errorf("total_amount %q = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestTransferRetryUnknown(t *testing.T) {
+	p, s := newTestAlipay(t)
+	s.Inject(alipaytest.FundTransfer, alipaytest.FaultUnknown, 2)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T1", Payee: "a@example.com", Amount: 16777217})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusSuccess {
+		t.Errorf("status %v", r.Status)
+	}
+	if n := s.Requests(alipaytest.FundTransfer); n != 3 {
+		t.Errorf("%d transfer requests, want 3", n)
+	}
+	if n := s.Requests(alipaytest.FundQuery); n != 0 {
+		t.Errorf("%d query requests, want 0", n)
+	}
+	if a := s.Payout("T1"); a != "167772.17" {
+		t.Errorf("amount %s", a)
+	}
+}
+
+func TestTransferQueryAfterUnknown(t *testing.T) {
+	p, s := newTestAlipay(t)
+	s.Inject(alipaytest.FundTransfer, alipaytest.FaultUnknown, payoutRetryTimes)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T2", Payee: "a@example.com", Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusSuccess || r.OutBizNo != "T2" {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(alipaytest.FundQuery); n != 1 {
+		t.Errorf("%d query requests, want 1", n)
+	}
+}
+
+func TestTransferNotSent(t *testing.T) {
+	p, s := newTestAlipay(t)
+	s.Close()
+
+	_, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T3", Payee: "a@example.com", Amount: 100})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if isPayoutUnknown(err) {
+		t.Errorf("connection error %v treated as unknown", err)
+	}
+}
+
+func TestTransferFailed(t *testing.T) {
+	p, s := newTestAlipay(t)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T4", Payee: alipaytest.PayeeNotExist, Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusFailed || len(r.FailReason) == 0 {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(alipaytest.FundTransfer); n != 1 {
+		t.Errorf("%d transfer requests, want 1", n)
+	}
+}
@@ -0,0 +1,289 @@
+package alipay
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/alipay"
+)
+
+var _ pay.PreAuth = &Alipay{}
+
+// 资金预授权 https://docs.open.alipay.com/20180417160701241302/intro
+const (
+	kPreAuthOnline = "PRE_AUTH_ONLINE" // 线上资金预授权
+	kPreAuth       = "PRE_AUTH"        // 当面资金授权
+
+	kAuthConfirmComplete    = "COMPLETE"     // 转支付后剩余冻结资金自动解冻
+	kAuthConfirmNotComplete = "NOT_COMPLETE" // 转支付后剩余冻结资金保持冻结
+)
+
+// Freeze 冻结资金
+// app -> 调起支付宝app授权的参数
+// qrcode -> 授权二维码地址
+func (p *Alipay) Freeze(way pay.Way, in pay.FreezeOrder) (string, error) {
+	switch way {
+	case pay.WayApp:
+		return p.client.FundAuthOrderAppFreeze(alipay.FundAuthOrderAppFreeze{
+			NotifyURL:    p.opt.NotifyURL,
+			OutOrderNo:   in.OutOrderNo,
+			OutRequestNo: in.OutRequestNo,
+			OrderTitle:   in.Title,
+			Amount:       amount(in.Amount),
+			ProductCode:  productCode(way),
+		})
+	case pay.WayQrcode:
+		param := alipay.FundAuthOrderVoucherCreate{
+			NotifyURL:    p.opt.NotifyURL,
+			OutOrderNo:   in.OutOrderNo,
+			OutRequestNo: in.OutRequestNo,
+			OrderTitle:   in.Title,
+			Amount:       amount(in.Amount),
+			ProductCode:  productCode(way),
+		}
+
+		var rsp *alipay.FundAuthOrderVoucherCreateRsp
+		err := p.call(false, func() (string, string, error) {
+			var err error
+			if rsp, err = p.client.FundAuthOrderVoucherCreate(param); err != nil {
+				return "", "", err
+			}
+			return rsp.Content.Code, rsp.Content.SubCode, nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+			return "", newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+		}
+
+		return rsp.Content.CodeValue, nil
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+// VerifyFreeze 授权回调验证签名,成功返回授权状态
+func (p *Alipay) VerifyFreeze(in url.Values) (*pay.FreezeResult, error) {
+	if !p.verifySign(in) {
+		return nil, pay.ErrVerify
+	}
+
+	return FreezeNoticeParams(in), nil
+}
+
+// Capture 冻结资金转支付，Complete 为 true 时剩余冻结资金自动解冻，产品码按 in.Way 与冻结时一致
+func (p *Alipay) Capture(in pay.CaptureOrder) (*pay.NoticeParams, error) {
+	mode := kAuthConfirmNotComplete
+	if in.Complete {
+		mode = kAuthConfirmComplete
+	}
+
+	param := alipay.TradePay{
+		Trade: alipay.Trade{
+			NotifyURL:   p.opt.NotifyURL,
+			Subject:     in.Title,
+			OutTradeNo:  in.OutTradeNo,
+			TotalAmount: amount(in.Amount),
+			ProductCode: productCode(in.Way),
+		},
+		AuthNo:          in.AuthNo,
+		BuyerId:         in.PayerID,
+		AuthConfirmMode: mode,
+	}
+
+	var rsp *alipay.TradePayRsp
+	err := p.call(false, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.TradePay(param); err != nil {
+			return "", "", err
+		}
+		return rsp.AliPayTradePay.Code, rsp.AliPayTradePay.SubCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var status pay.TradeStatus
+	switch rsp.AliPayTradePay.Code {
+	case alipay.K_SUCCESS_CODE:
+		status = pay.TradeStatusSuccess
+	case "10003":
+		// 等待用户付款，以支付回调或查询结果为准
+		status = pay.TradeStatusWait
+	default:
+		return nil, newError(rsp.AliPayTradePay.Code, rsp.AliPayTradePay.Msg, rsp.AliPayTradePay.SubCode, rsp.AliPayTradePay.SubMsg)
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     rsp.AliPayTradePay.OutTradeNo,
+		PaymentID:   rsp.AliPayTradePay.TradeNo,
+		TradeStatus: status,
+		Amount:      fen(rsp.AliPayTradePay.TotalAmount),
+	}, nil
+}
+
+// Unfreeze 解冻剩余资金
+func (p *Alipay) Unfreeze(in pay.UnfreezeOrder) error {
+	param := alipay.FundAuthOrderUnfreeze{
+		NotifyURL:    p.opt.NotifyURL,
+		AuthNo:       in.AuthNo,
+		OutRequestNo: in.OutRequestNo,
+		Amount:       amount(in.Amount),
+		Remark:       in.Remark,
+	}
+
+	var rsp *alipay.FundAuthOrderUnfreezeRsp
+	err := p.call(false, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.FundAuthOrderUnfreeze(param); err != nil {
+			return "", "", err
+		}
+		return rsp.Content.Code, rsp.Content.SubCode, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		return newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	return nil
+}
+
+// CancelFreeze 撤销授权，只能撤销冻结操作，用于冻结结果不确定时
+func (p *Alipay) CancelFreeze(outOrderNo, outRequestNo, remark string) error {
+	param := alipay.FundAuthOperationCancel{
+		NotifyURL:    p.opt.NotifyURL,
+		OutOrderNo:   outOrderNo,
+		OutRequestNo: outRequestNo,
+		Remark:       remark,
+	}
+
+	var rsp *alipay.FundAuthOperationCancelRsp
+	err := p.call(false, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.FundAuthOperationCancel(param); err != nil {
+			return "", "", err
+		}
+		return rsp.Content.Code, rsp.Content.SubCode, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		return newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	return nil
+}
+
+// QueryFreeze 查询授权单
+func (p *Alipay) QueryFreeze(outOrderNo, outRequestNo string) (*pay.FreezeResult, error) {
+	var rsp struct {
+		Content struct {
+			Code              string `json:"code"`
+			Msg               string `json:"msg"`
+			SubCode           string `json:"sub_code"`
+			SubMsg            string `json:"sub_msg"`
+			AuthNo            string `json:"auth_no"`
+			OutOrderNo        string `json:"out_order_no"`
+			OrderStatus       string `json:"order_status"`
+			OperationID       string `json:"operation_id"`
+			TotalFreezeAmount string `json:"total_freeze_amount"`
+			RestAmount        string `json:"rest_amount"`
+			TotalPayAmount    string `json:"total_pay_amount"`
+			PayerUserID       string `json:"payer_user_id"`
+		} `json:"alipay_fund_auth_operation_detail_query_response"`
+	}
+
+	err := p.call(true, func() (string, string, error) {
+		err := p.client.DoRequest("POST", alipay.FundAuthOperationDetailQuery{
+			OutOrderNo:   outOrderNo,
+			OutRequestNo: outRequestNo,
+		}, &rsp)
+		return rsp.Content.Code, rsp.Content.SubCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		return nil, newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	var status pay.FreezeStatus
+	switch rsp.Content.OrderStatus {
+	case "INIT":
+		status = pay.FreezeStatusInit
+	case "AUTHORIZED":
+		status = pay.FreezeStatusFrozen
+	case "FINISH":
+		status = pay.FreezeStatusFinished
+	case "CLOSED":
+		status = pay.FreezeStatusClosed
+	}
+
+	return &pay.FreezeResult{
+		AuthNo:            rsp.Content.AuthNo,
+		OutOrderNo:        rsp.Content.OutOrderNo,
+		OperationID:       rsp.Content.OperationID,
+		Status:            status,
+		TotalFreezeAmount: fen(rsp.Content.TotalFreezeAmount),
+		RestAmount:        fen(rsp.Content.RestAmount),
+		TotalPayAmount:    fen(rsp.Content.TotalPayAmount),
+		PayerID:           rsp.Content.PayerUserID,
+	}, nil
+}
+
+// productCode 冻结方式对应的产品码，二维码为当面资金授权，其他为线上资金预授权
+func productCode(way pay.Way) string {
+	if way == pay.WayQrcode {
+		return kPreAuth
+	}
+
+	return kPreAuthOnline
+}
+
+// FreezeNoticeParams 资金授权回调参数
+func FreezeNoticeParams(val url.Values) *pay.FreezeResult {
+	var (
+		restAmount = fen(val.Get("rest_amount"))
+
+		status pay.FreezeStatus
+	)
+
+	switch val.Get("status") {
+	case "INIT":
+		status = pay.FreezeStatusInit
+	case "CLOSED":
+		status = pay.FreezeStatusClosed
+	case "SUCCESS":
+		status = pay.FreezeStatusFrozen
+		if restAmount == 0 && val.Get("operation_type") != "FREEZE" {
+			status = pay.FreezeStatusFinished
+		}
+	}
+
+	return &pay.FreezeResult{
+		AuthNo:            val.Get("auth_no"),
+		OutOrderNo:        val.Get("out_order_no"),
+		OperationID:       val.Get("operation_id"),
+		Status:            status,
+		TotalFreezeAmount: fen(val.Get("total_freeze_amount")),
+		RestAmount:        restAmount,
+		TotalPayAmount:    fen(val.Get("total_pay_amount")),
+		PayerID:           val.Get("payer_user_id"),
+	}
+}
+
+// fen 金额 元转分
+func fen(amount string) int32 {
+	f, _ := strconv.ParseFloat(amount, 64)
+	return int32(math.Round(f * 100))
+}
@@ -0,0 +1,19 @@
+package alipay
+
+import (
+	"testing"
+
+	"github.com/gocommon/pay"
+)
+
+func TestProductCode(t *testing.T) {
+	for way, want := range map[pay.Way]string{
+		pay.WayApp:    kPreAuthOnline,
+		pay.WayQrcode: kPreAuth,
+		"":            kPreAuthOnline,
+	} {
+		if got := productCode(way); got != want {
+			t.Errorf("productCode(%q) = %s, want %s", way, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package alipay
+
+import (
+	"net/url"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/alipay"
+)
+
+var _ pay.Querier = &Alipay{}
+
+const kTradeNotExist = "ACQ.TRADE_NOT_EXIST"
+
+// Query 统一收单线下交易查询 alipay.trade.query
+func (p *Alipay) Query(orderID string) (*pay.NoticeParams, error) {
+	var rsp *alipay.TradeQueryRsp
+	err := p.call(true, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.TradeQuery(alipay.TradeQuery{OutTradeNo: orderID}); err != nil {
+			return "", "", err
+		}
+		return rsp.Content.Code, rsp.Content.SubCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		if rsp.Content.SubCode == kTradeNotExist {
+			return nil, pay.ErrOrderNotExist
+		}
+		return nil, newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	var v = url.Values{}
+	v.Set("out_trade_no", rsp.Content.OutTradeNo)
+	v.Set("trade_no", rsp.Content.TradeNo)
+	v.Set("trade_status", rsp.Content.TradeStatus)
+	v.Set("total_amount", rsp.Content.TotalAmount)
+
+	return NoticeParams(v), nil
+}
+
+// Close 统一收单交易关闭 alipay.trade.close，用户未扫码时交易不存在，返回 nil
+func (p *Alipay) Close(orderID string) error {
+	var rsp *alipay.TradeCloseRsp
+	err := p.call(true, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.TradeClose(alipay.TradeClose{OutTradeNo: orderID}); err != nil {
+			return "", "", err
+		}
+		return rsp.AliPayTradeClose.Code, rsp.AliPayTradeClose.SubCode, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rsp.AliPayTradeClose.Code == alipay.K_SUCCESS_CODE || rsp.AliPayTradeClose.SubCode == kTradeNotExist {
+		return nil
+	}
+
+	return newError(rsp.AliPayTradeClose.Code, rsp.AliPayTradeClose.Msg, rsp.AliPayTradeClose.SubCode, rsp.AliPayTradeClose.SubMsg)
+}
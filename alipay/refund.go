@@ -0,0 +1,43 @@
+package alipay
+
+import (
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/alipay"
+)
+
+var _ pay.Refunder = &Alipay{}
+
+// Refund 申请退款，同步返回退款结果，out_request_no 为 RefundOrder.ID
+func (p *Alipay) Refund(in pay.RefundOrder) (*pay.RefundResult, error) {
+	param := alipay.TradeRefund{
+		OutTradeNo:   in.OrderID,
+		TradeNo:      in.PaymentID,
+		RefundAmount: amount(in.Amount),
+		RefundReason: in.Reason,
+		OutRequestNo: in.ID,
+	}
+
+	// 同一 out_request_no 重复请求只退款一次
+	var rsp *alipay.TradeRefundRsp
+	err := p.call(true, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.TradeRefund(param); err != nil {
+			return "", "", err
+		}
+		return rsp.AliPayTradeRefund.Code, rsp.AliPayTradeRefund.SubCode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.AliPayTradeRefund.Code != alipay.K_SUCCESS_CODE {
+		return nil, newError(rsp.AliPayTradeRefund.Code, rsp.AliPayTradeRefund.Msg, rsp.AliPayTradeRefund.SubCode, rsp.AliPayTradeRefund.SubMsg)
+	}
+
+	return &pay.RefundResult{
+		ID:       in.ID,
+		RefundID: rsp.AliPayTradeRefund.TradeNo,
+		Status:   pay.RefundStatusSuccess,
+		Amount:   in.Amount,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package alipay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gocommon/pay"
+)
+
+// kUnknownCode 网关返回的服务不可用，结果未知
+const kUnknownCode = "20000"
+
+// kRetrySubCode 需要用原参数重试的 sub_code
+var kRetrySubCode = map[string]bool{
+	"ACQ.SYSTEM_ERROR":     true,
+	"aop.ACQ.SYSTEM_ERROR": true,
+	"SYSTEM_ERROR":         true,
+	"isp.unknow-error":     true,
+}
+
+// isRetryCode 是否为需要重试的返回码
+func isRetryCode(code, subCode string) bool {
+	return code == kUnknownCode || kRetrySubCode[subCode]
+}
+
+// call 请求网关，fn 返回网关的 code 和 sub_code
+// retry 为 true 时按 Options.Retry 重试网络错误和 isRetryCode，只用于按商户单号幂等的接口
+// 所有请求经过熔断器
+func (p *Alipay) call(retry bool, fn func() (code, subCode string, err error)) error {
+	var policy *pay.RetryPolicy
+	if retry {
+		policy = p.opt.Retry
+	}
+
+	return pay.Retry(policy, p.breaker, func() error {
+		code, subCode, err := fn()
+		if err != nil {
+			return pay.NetError(kProvider, err)
+		}
+
+		if isRetryCode(code, subCode) {
+			return newError(code, "", subCode, "")
+		}
+
+		return nil
+	})
+}
+
+// transport 网关返回 5xx 时返回错误，作为网络错误重试，domain 不为空时请求发到 Options.APIDomain
+// ctx 不为空时请求使用 ctx，见 Alipay.WithContext
+type transport struct {
+	base   http.RoundTripper
+	domain *url.URL
+	ctx    context.Context
+}
+
+// RoundTrip RoundTrip
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.ctx != nil {
+		req = req.WithContext(t.ctx)
+	}
+
+	if t.domain != nil {
+		var u = *req.URL
+		u.Scheme = t.domain.Scheme
+		u.Host = t.domain.Host
+
+		var r = new(http.Request)
+		*r = *req
+		r.URL = &u
+		r.Host = ""
+		req = r
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		resp.Body.Close()
+		return nil, &statusError{status: resp.Status}
+	}
+
+	return resp, nil
+}
+
+// statusError 网关返回 5xx，请求可能已被处理
+type statusError struct {
+	status string
+}
+
+// Error Error
+func (e *statusError) Error() string {
+	return fmt.Sprintf("alipay: %s", e.status)
+}
@@ -0,0 +1,72 @@
+package alipay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/alipay/alipaytest"
+)
+
+func TestRetryIdempotent(t *testing.T) {
+	p, s := newTestAlipay(t)
+	p.opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Inject(alipaytest.TradeQuery, alipaytest.FaultServerError, 1)
+	s.Inject(alipaytest.TradeQuery, alipaytest.FaultSystemError, 1)
+
+	r, err := p.Query("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(alipaytest.TradeQuery); n != 3 {
+		t.Errorf("%d query requests, want 3", n)
+	}
+}
+
+func TestNoRetryNotIdempotent(t *testing.T) {
+	p, s := newTestAlipay(t)
+	p.opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+	s.Inject(alipaytest.RoyaltyBind, alipaytest.FaultSystemError, 1)
+
+	err := p.BindRoyaltyRelation("B1", RoyaltyReceiver{Type: RoyaltyTypeLoginName, Account: "a@example.com"})
+	if !pay.IsRetryable(err) {
+		t.Errorf("got %v, want retryable error", err)
+	}
+	if n := s.Requests(alipaytest.RoyaltyBind); n != 1 {
+		t.Errorf("%d bind requests, want 1", n)
+	}
+}
+
+func TestBreakerOpen(t *testing.T) {
+	p, s := newTestAlipay(t)
+	p.opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+	p.breaker = pay.NewBreaker(pay.BreakerOptions{Failures: 2, Cooldown: time.Minute})
+	s.Inject(alipaytest.TradeQuery, alipaytest.FaultSystemError, 10)
+
+	// 第二次失败后熔断，第三次请求没有发出
+	if _, err := p.Query("O1"); err != pay.ErrCircuitOpen {
+		t.Errorf("got %v, want %v", err, pay.ErrCircuitOpen)
+	}
+	if !p.breaker.Open() {
+		t.Error("breaker not open")
+	}
+
+	// 熔断中其他接口也不发出请求
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != pay.ErrCircuitOpen {
+		t.Errorf("got %v, want %v", err, pay.ErrCircuitOpen)
+	}
+	if n := s.Requests(alipaytest.TradeQuery); n != 2 {
+		t.Errorf("%d query requests, want 2", n)
+	}
+	if n := s.Requests(alipaytest.TradePreCreate); n != 0 {
+		t.Errorf("%d precreate requests, want 0", n)
+	}
+}
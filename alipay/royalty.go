@@ -0,0 +1,321 @@
+package alipay
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/smartwalle/alipay"
+)
+
+// 分账 https://docs.open.alipay.com/api_1/alipay.trade.order.settle
+// 下单时 pay.Order.ProfitSharing 为 true 的订单冻结资金，之后调用Settle分账
+const (
+	kSandboxGateway    = "https://openapi.alipaydev.com/gateway.do"
+	kProductionGateway = "https://openapi.alipay.com/gateway.do"
+)
+
+const (
+	kRoyaltyRelationBind   = "alipay.trade.royalty.relation.bind"
+	kRoyaltyRelationUnbind = "alipay.trade.royalty.relation.unbind"
+)
+
+const (
+	// RoyaltyTypeUserID 分账收款方类型 支付宝用户号
+	RoyaltyTypeUserID = "userId"
+	// RoyaltyTypeLoginName 分账收款方类型 支付宝登录号
+	RoyaltyTypeLoginName = "loginName"
+)
+
+// RoyaltyReceiver 分账收款方
+type RoyaltyReceiver struct {
+	Type    string `json:"type"`           // 收款方类型 userId loginName
+	Account string `json:"account"`        // 收款方账号
+	Name    string `json:"name,omitempty"` // 收款方姓名，loginName时校验
+	Memo    string `json:"memo,omitempty"` // 分账关系描述
+}
+
+// Royalty 分账明细
+type Royalty struct {
+	TransOut string // 分账支出方用户号，为空时为卖家
+	TransIn  string // 分账收入方用户号
+	Amount   int32  // 分账金额 单位分
+	Desc     string // 分账描述
+}
+
+// SettleDetail 分账明细结果
+type SettleDetail struct {
+	OperationType string `json:"operation_type"` // 分账操作类型 replenish replenish_refund transfer transfer_refund
+	ExecuteDt     string `json:"execute_dt"`     // 分账执行时间
+	TransOut      string `json:"trans_out"`      // 分账转出账号
+	TransIn       string `json:"trans_in"`       // 分账转入账号
+	Amount        string `json:"amount"`         // 分账金额 单位元
+	State         string `json:"state"`          // 分账状态 SUCCESS FAIL PROCESSING
+	DetailID      string `json:"detail_id"`      // 分账明细单号
+	ErrorCode     string `json:"error_code"`     // 分账失败错误码
+	ErrorDesc     string `json:"error_desc"`     // 分账失败描述
+}
+
+// SettleResult 分账查询结果
+type SettleResult struct {
+	OutRequestNo string          // 结算请求流水号
+	OperationDt  string          // 分账受理时间
+	Details      []*SettleDetail // 分账明细
+}
+
+// BindRoyaltyRelation 绑定分账关系 https://docs.open.alipay.com/api_1/alipay.trade.royalty.relation.bind
+func (p *Alipay) BindRoyaltyRelation(outRequestNo string, receivers ...RoyaltyReceiver) error {
+	return p.royaltyRelation(royaltyRelation{
+		method:       kRoyaltyRelationBind,
+		OutRequestNo: outRequestNo,
+		ReceiverList: receivers,
+	})
+}
+
+// UnbindRoyaltyRelation 解绑分账关系 https://docs.open.alipay.com/api_1/alipay.trade.royalty.relation.unbind
+func (p *Alipay) UnbindRoyaltyRelation(outRequestNo string, receivers ...RoyaltyReceiver) error {
+	return p.royaltyRelation(royaltyRelation{
+		method:       kRoyaltyRelationUnbind,
+		OutRequestNo: outRequestNo,
+		ReceiverList: receivers,
+	})
+}
+
+func (p *Alipay) royaltyRelation(param royaltyRelation) error {
+	var content *royaltyRelationContent
+	err := p.call(false, func() (string, string, error) {
+		var rsp royaltyRelationRsp
+		if err := p.client.DoRequest("POST", param, &rsp); err != nil {
+			return "", "", err
+		}
+
+		if content = rsp.content(param.method); content == nil {
+			return "", "", nil
+		}
+		return content.Code, content.SubCode, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if content == nil {
+		return newError("", "empty response", "", "")
+	}
+
+	if content.Code != alipay.K_SUCCESS_CODE {
+		return newError(content.Code, content.Msg, content.SubCode, content.SubMsg)
+	}
+
+	// code 为 10000 时 result_code 仍可能为 FAIL
+	if content.ResultCode != "SUCCESS" {
+		return newError(content.Code, "result_code "+content.ResultCode, content.ResultCode, "")
+	}
+
+	return nil
+}
+
+// Settle 统一收单交易结算，一次请求可以分给多个收款方
+func (p *Alipay) Settle(tradeNo, outRequestNo string, royalties ...Royalty) error {
+	var params = make([]*alipay.RoyaltyParameter, 0, len(royalties))
+	for _, r := range royalties {
+		params = append(params, &alipay.RoyaltyParameter{
+			TransOut: r.TransOut,
+			TransIn:  r.TransIn,
+			Amount:   float64(r.Amount) / 100,
+			Desc:     r.Desc,
+		})
+	}
+
+	param := alipay.TradeOrderSettle{
+		OutRequestNo:      outRequestNo,
+		TradeNo:           tradeNo,
+		RoyaltyParameters: params,
+	}
+
+	var rsp *alipay.TradeOrderSettleRsp
+	err := p.call(false, func() (string, string, error) {
+		var err error
+		if rsp, err = p.client.TradeOrderSettle(param); err != nil {
+			return "", "", err
+		}
+		return rsp.Body.Code, rsp.Body.SubCode, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rsp.Body.Code != alipay.K_SUCCESS_CODE {
+		return newError(rsp.Body.Code, rsp.Body.Msg, rsp.Body.SubCode, rsp.Body.SubMsg)
+	}
+
+	return nil
+}
+
+// QuerySettle 查询分账结果 https://docs.open.alipay.com/api_1/alipay.trade.order.settle.query
+func (p *Alipay) QuerySettle(tradeNo, outRequestNo string) (*SettleResult, error) {
+	var rsp struct {
+		Content struct {
+			Code              string          `json:"code"`
+			Msg               string          `json:"msg"`
+			SubCode           string          `json:"sub_code"`
+			SubMsg            string          `json:"sub_msg"`
+			OutRequestNo      string          `json:"out_request_no"`
+			OperationDt       string          `json:"operation_dt"`
+			RoyaltyDetailList []*SettleDetail `json:"royalty_detail_list"`
+		} `json:"alipay_trade_order_settle_query_response"`
+	}
+
+	err := p.call(true, func() (string, string, error) {
+		err := p.client.DoRequest("POST", settleQuery{
+			TradeNo:      tradeNo,
+			OutRequestNo: outRequestNo,
+		}, &rsp)
+		return rsp.Content.Code, rsp.Content.SubCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Content.Code != alipay.K_SUCCESS_CODE {
+		return nil, newError(rsp.Content.Code, rsp.Content.Msg, rsp.Content.SubCode, rsp.Content.SubMsg)
+	}
+
+	return &SettleResult{
+		OutRequestNo: rsp.Content.OutRequestNo,
+		OperationDt:  rsp.Content.OperationDt,
+		Details:      rsp.Content.RoyaltyDetailList,
+	}, nil
+}
+
+// gatewayURL 返回带签名参数的网关跳转地址
+func (p *Alipay) gatewayURL(param alipay.Param) (string, error) {
+	v, err := p.client.URLValues(param)
+	if err != nil {
+		return "", err
+	}
+
+	gateway := kSandboxGateway
+	if p.opt.IsProduction {
+		gateway = kProductionGateway
+	}
+	if len(p.opt.APIDomain) > 0 {
+		gateway = strings.TrimSuffix(p.opt.APIDomain, "/") + "/gateway.do"
+	}
+
+	return gateway + "?" + v.Encode(), nil
+}
+
+// royaltyFreeze 下单时冻结资金用于分账，在 biz_content 中加入 extend_params.royalty_freeze
+type royaltyFreeze struct {
+	alipay.Param
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p royaltyFreeze) ExtJSONParamValue() string {
+	var biz map[string]interface{}
+	if err := json.Unmarshal([]byte(p.Param.ExtJSONParamValue()), &biz); err != nil {
+		return p.Param.ExtJSONParamValue()
+	}
+
+	extend, _ := biz["extend_params"].(map[string]interface{})
+	if extend == nil {
+		extend = map[string]interface{}{}
+	}
+	extend["royalty_freeze"] = "true"
+	biz["extend_params"] = extend
+
+	d, err := json.Marshal(biz)
+	if err != nil {
+		return p.Param.ExtJSONParamValue()
+	}
+
+	return string(d)
+}
+
+// royaltyRelation 分账关系绑定与解绑
+type royaltyRelation struct {
+	method       string
+	OutRequestNo string            `json:"out_request_no"`
+	ReceiverList []RoyaltyReceiver `json:"receiver_list"`
+}
+
+// royaltyRelationRsp 绑定与解绑的响应，与响应节点同级的还有 sign
+type royaltyRelationRsp struct {
+	Bind   *royaltyRelationContent `json:"alipay_trade_royalty_relation_bind_response"`
+	Unbind *royaltyRelationContent `json:"alipay_trade_royalty_relation_unbind_response"`
+	Error  *royaltyRelationContent `json:"error_response"`
+	Sign   string                  `json:"sign"`
+}
+
+// content 接口 method 的响应节点，网关返回 error_response 时使用 error_response
+func (r royaltyRelationRsp) content(method string) *royaltyRelationContent {
+	var c = r.Bind
+	if method == kRoyaltyRelationUnbind {
+		c = r.Unbind
+	}
+	if c == nil {
+		c = r.Error
+	}
+	return c
+}
+
+type royaltyRelationContent struct {
+	Code       string `json:"code"`
+	Msg        string `json:"msg"`
+	SubCode    string `json:"sub_code"`
+	SubMsg     string `json:"sub_msg"`
+	ResultCode string `json:"result_code"`
+}
+
+// APIName APIName
+func (p royaltyRelation) APIName() string {
+	return p.method
+}
+
+// Params Params
+func (p royaltyRelation) Params() map[string]string {
+	return nil
+}
+
+// ExtJSONParamName ExtJSONParamName
+func (p royaltyRelation) ExtJSONParamName() string {
+	return "biz_content"
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p royaltyRelation) ExtJSONParamValue() string {
+	return marshal(p)
+}
+
+// settleQuery 交易分账查询
+type settleQuery struct {
+	TradeNo      string `json:"trade_no"`
+	OutRequestNo string `json:"out_request_no"`
+}
+
+// APIName APIName
+func (p settleQuery) APIName() string {
+	return "alipay.trade.order.settle.query"
+}
+
+// Params Params
+func (p settleQuery) Params() map[string]string {
+	return nil
+}
+
+// ExtJSONParamName ExtJSONParamName
+func (p settleQuery) ExtJSONParamName() string {
+	return "biz_content"
+}
+
+// ExtJSONParamValue ExtJSONParamValue
+func (p settleQuery) ExtJSONParamValue() string {
+	return marshal(p)
+}
+
+func marshal(obj interface{}) string {
+	d, err := json.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	return string(d)
+}
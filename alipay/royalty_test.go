@@ -0,0 +1,56 @@
+package alipay
+
+import (
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/alipay/alipaytest"
+)
+
+func TestRoyaltyRelation(t *testing.T) {
+	p, s := newTestAlipay(t)
+
+	var r = RoyaltyReceiver{Type: RoyaltyTypeUserID, Account: "2088101126708402", Memo: "分账给员工"}
+
+	if err := p.BindRoyaltyRelation("R1", r); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Royalty(r.Account) {
+		t.Error("relation not bound")
+	}
+
+	if err := p.UnbindRoyaltyRelation("R2", r); err != nil {
+		t.Fatal(err)
+	}
+	if s.Royalty(r.Account) {
+		t.Error("relation not unbound")
+	}
+	if n := s.Requests(alipaytest.RoyaltyUnbind); n != 1 {
+		t.Errorf("%d unbind requests, want 1", n)
+	}
+}
+
+func TestRoyaltyRelationError(t *testing.T) {
+	p, _ := newTestAlipay(t)
+
+	err := p.BindRoyaltyRelation("R1")
+
+	e, ok := err.(*pay.Error)
+	if !ok || e.SubCode != "ACQ.INVALID_PARAMETER" || e.Category != pay.CategoryParam {
+		t.Errorf("got %v, want ACQ.INVALID_PARAMETER", err)
+	}
+}
+
+func TestRoyaltyRelationResultFail(t *testing.T) {
+	p, s := newTestAlipay(t)
+
+	err := p.BindRoyaltyRelation("R1", RoyaltyReceiver{Type: RoyaltyTypeUserID, Account: alipaytest.RoyaltyResultFail})
+
+	e, ok := err.(*pay.Error)
+	if !ok || e.Provider != kProvider || e.Code != "10000" || e.SubCode != "FAIL" {
+		t.Errorf("got %#v, want result_code FAIL", err)
+	}
+	if s.Royalty(alipaytest.RoyaltyResultFail) {
+		t.Error("relation bound")
+	}
+}
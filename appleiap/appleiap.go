@@ -0,0 +1,264 @@
+// Package appleiap Apple 应用内购买，验证 StoreKit 2 JWS 交易和 App Store Server Notifications V2
+// https://developer.apple.com/documentation/appstoreservernotifications
+//
+// 验证只使用本地根证书，不请求 Apple 服务器
+package appleiap
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payer = &AppleIAP{}
+
+// 通知类型 https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+const (
+	NotificationTypeSubscribed       = "SUBSCRIBED"
+	NotificationTypeDidRenew         = "DID_RENEW"
+	NotificationTypeOneTimeCharge    = "ONE_TIME_CHARGE"
+	NotificationTypeExpired          = "EXPIRED"
+	NotificationTypeDidFailToRenew   = "DID_FAIL_TO_RENEW"
+	NotificationTypeRefund           = "REFUND"
+	NotificationTypeRefundDeclined   = "REFUND_DECLINED"
+	NotificationTypeRefundReversed   = "REFUND_REVERSED"
+	NotificationTypeRevoke           = "REVOKE"
+	NotificationTypeConsumptionReq   = "CONSUMPTION_REQUEST"
+	NotificationTypeTest             = "TEST"
+	NotificationTypeDidChangeRenewal = "DID_CHANGE_RENEWAL_STATUS"
+)
+
+var (
+	// ErrInvalidRootCert Options.RootCert 无法解析
+	ErrInvalidRootCert = errors.New("appleiap: invalid root certificate")
+	// ErrBundleIDMismatch bundleId 与 Options.BundleID 不一致
+	ErrBundleIDMismatch = errors.New("appleiap: bundle id mismatch")
+	// ErrEnvironmentMismatch environment 与 Options.Environment 不一致
+	ErrEnvironmentMismatch = errors.New("appleiap: environment mismatch")
+	// ErrNoTransaction 通知中没有交易信息
+	ErrNoTransaction = errors.New("appleiap: notification has no transaction")
+	// ErrInvalidAppAccountToken 订单号不是 UUID，不能作为 appAccountToken
+	ErrInvalidAppAccountToken = errors.New("appleiap: order id must be uuid")
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Options Options
+type Options struct {
+	BundleID    string // 不为空时验证 bundleId
+	Environment string // Production Sandbox，不为空时验证 environment
+	RootCert    string // 根证书 PEM，为空时使用内置的 Apple Root CA - G3
+}
+
+// AppleIAP AppleIAP
+type AppleIAP struct {
+	opt   Options
+	roots *x509.CertPool
+}
+
+// New New
+func New(opt Options) (*AppleIAP, error) {
+	root := opt.RootCert
+	if len(root) == 0 {
+		root = appleRootCAG3
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(root)) {
+		return nil, ErrInvalidRootCert
+	}
+
+	p := &AppleIAP{
+		opt:   opt,
+		roots: roots,
+	}
+
+	return p, nil
+}
+
+// Verify 通知验证签名,成功返回回调参数，in 为 WebhookValues 的返回值
+// 退款、撤销家庭共享时 TradeStatus 为 TradeStatusClosed
+func (p *AppleIAP) Verify(in url.Values) (*pay.NoticeParams, error) {
+	n, err := p.Notification(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Transaction == nil {
+		return nil, ErrNoTransaction
+	}
+
+	params := n.Transaction.NoticeParams()
+	switch n.NotificationType {
+	case NotificationTypeRefund, NotificationTypeRevoke:
+		params.TradeStatus = pay.TradeStatusClosed
+	}
+
+	return params, nil
+}
+
+// Success 回调成功返回数据，返回 http 200 即可
+func (p *AppleIAP) Success() string {
+	return ""
+}
+
+// Call 应用内购买由 app 发起，app 返回商户订单号，作为 StoreKit 的 appAccountToken
+// 回调时 NoticeParams.OrderID 为 appAccountToken，订单号必须是 UUID
+func (p *AppleIAP) Call(way pay.Way, in pay.Order) (string, error) {
+	if way != pay.WayApp {
+		return "", pay.ErrWayNotDefine
+	}
+
+	if !uuidRegexp.MatchString(in.ID) {
+		return "", ErrInvalidAppAccountToken
+	}
+
+	return in.ID, nil
+}
+
+// VerifyTransaction 验证 app 上报的 StoreKit 2 JWS 交易
+func (p *AppleIAP) VerifyTransaction(signedTransaction string) (*Transaction, error) {
+	var t Transaction
+	if err := p.verifyJWS(signedTransaction, &t); err != nil {
+		return nil, err
+	}
+
+	if err := p.check(t.BundleID, t.Environment); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Notification 验证并解析通知，TEST 等不含交易的通知 Transaction 为空
+func (p *AppleIAP) Notification(in url.Values) (*Notification, error) {
+	signedPayload := in.Get("signedPayload")
+	if len(signedPayload) == 0 {
+		var body struct {
+			SignedPayload string `json:"signedPayload"`
+		}
+		if err := json.Unmarshal([]byte(in.Get("body")), &body); err != nil {
+			return nil, err
+		}
+		signedPayload = body.SignedPayload
+	}
+
+	var n Notification
+	if err := p.verifyJWS(signedPayload, &n); err != nil {
+		return nil, err
+	}
+
+	if err := p.check(n.Data.BundleID, n.Data.Environment); err != nil {
+		return nil, err
+	}
+
+	if len(n.Data.SignedTransactionInfo) > 0 {
+		t, err := p.VerifyTransaction(n.Data.SignedTransactionInfo)
+		if err != nil {
+			return nil, err
+		}
+		n.Transaction = t
+	}
+
+	return &n, nil
+}
+
+func (p *AppleIAP) check(bundleID, environment string) error {
+	if len(p.opt.BundleID) > 0 && bundleID != p.opt.BundleID {
+		return ErrBundleIDMismatch
+	}
+
+	if len(p.opt.Environment) > 0 && environment != p.opt.Environment {
+		return ErrEnvironmentMismatch
+	}
+
+	return nil
+}
+
+// Notification App Store Server Notification V2
+type Notification struct {
+	NotificationType string `json:"notificationType"`
+	Subtype          string `json:"subtype"`
+	NotificationUUID string `json:"notificationUUID"`
+	Version          string `json:"version"`
+	SignedDate       int64  `json:"signedDate"`
+	Data             struct {
+		AppAppleID            int64  `json:"appAppleId"`
+		BundleID              string `json:"bundleId"`
+		BundleVersion         string `json:"bundleVersion"`
+		Environment           string `json:"environment"`
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+		SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	} `json:"data"`
+
+	Transaction *Transaction `json:"-"` // 验证后的交易信息
+}
+
+// Transaction JWS 交易信息
+type Transaction struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	BundleID              string `json:"bundleId"`
+	ProductID             string `json:"productId"`
+	PurchaseDate          int64  `json:"purchaseDate"`
+	ExpiresDate           int64  `json:"expiresDate"`
+	Quantity              int32  `json:"quantity"`
+	Type                  string `json:"type"`
+	AppAccountToken       string `json:"appAccountToken"`
+	Environment           string `json:"environment"`
+	SignedDate            int64  `json:"signedDate"`
+	RevocationDate        int64  `json:"revocationDate"`
+	RevocationReason      *int   `json:"revocationReason"`
+	Price                 int64  `json:"price"` // 价格 千分之一货币单位
+	Currency              string `json:"currency"`
+}
+
+// kCurrencyExponent 小数位数不是2的币种，ISO 4217
+var kCurrencyExponent = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Amount 价格转为币种的最小单位，如美元为分，日元为元
+func (t *Transaction) Amount() int32 {
+	exp, ok := kCurrencyExponent[strings.ToUpper(t.Currency)]
+	if !ok {
+		exp = 2
+	}
+
+	var price = t.Price
+	for i := exp; i < 3; i++ {
+		price /= 10
+	}
+
+	return int32(price)
+}
+
+// NoticeParams 交易转回调参数，PaymentID 为 originalTransactionId，已撤销的交易为 TradeStatusClosed
+func (t *Transaction) NoticeParams() *pay.NoticeParams {
+	var status = pay.TradeStatusSuccess
+	if t.RevocationDate > 0 {
+		status = pay.TradeStatusClosed
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     t.AppAccountToken,
+		PaymentID:   t.OriginalTransactionID,
+		TradeStatus: status,
+		Amount:      t.Amount(),
+	}
+}
+
+// WebhookValues 通知请求头和body转为 Verify 的参数
+func WebhookValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	v.Set("body", string(body))
+	return v
+}
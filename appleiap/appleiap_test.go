@@ -0,0 +1,306 @@
+package appleiap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+// testCA 测试用的根证书、中级证书和叶子证书
+type testCA struct {
+	rootPEM string
+	x5c     []string
+	key     *ecdsa.PrivateKey // 叶子证书私钥
+}
+
+// newTestCA 生成证书链，leafOID 为 false 时叶子证书不含 Apple 扩展
+func newTestCA(t *testing.T, leafOID bool) *testCA {
+	t.Helper()
+
+	var (
+		notBefore = time.Now().Add(-time.Hour)
+		notAfter  = time.Now().Add(time.Hour)
+	)
+
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	create := func(serial int64, cn string, ca bool, oid asn1.ObjectIdentifier, pub, priv interface{}, parent *x509.Certificate) (*x509.Certificate, []byte) {
+		tpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			BasicConstraintsValid: true,
+			IsCA:                  ca,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		if oid != nil {
+			tpl.ExtraExtensions = []pkix.Extension{{Id: oid, Value: []byte{0x05, 0x00}}}
+		}
+		if parent == nil {
+			parent = tpl
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, der
+	}
+
+	var (
+		rootKey         = newKey()
+		intermediateKey = newKey()
+		leafKey         = newKey()
+		leafMarker      = oidLeafMarker
+	)
+	if !leafOID {
+		leafMarker = nil
+	}
+
+	root, rootDER := create(1, "Test Root", true, nil, &rootKey.PublicKey, rootKey, nil)
+	intermediate, intermediateDER := create(2, "Test Intermediate", true, oidIntermediateMarker, &intermediateKey.PublicKey, rootKey, root)
+	_, leafDER := create(3, "Test Leaf", false, leafMarker, &leafKey.PublicKey, intermediateKey, intermediate)
+
+	return &testCA{
+		rootPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})),
+		x5c: []string{
+			base64.StdEncoding.EncodeToString(leafDER),
+			base64.StdEncoding.EncodeToString(intermediateDER),
+			base64.StdEncoding.EncodeToString(rootDER),
+		},
+		key: leafKey,
+	}
+}
+
+// sign ES256 签名的 JWS，key 为空时使用叶子证书私钥
+func (ca *testCA) sign(t *testing.T, payload interface{}, key *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	if key == nil {
+		key = ca.key
+	}
+
+	header, _ := json.Marshal(map[string]interface{}{"alg": "ES256", "x5c": ca.x5c})
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hashed := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+const testOrderID = "6f5b2c1e-8a3d-4f7b-9c2e-1d4a5b6c7d8e"
+
+func testTransaction() map[string]interface{} {
+	return map[string]interface{}{
+		"transactionId":         "2000000000000001",
+		"originalTransactionId": "2000000000000000",
+		"bundleId":              "com.example.app",
+		"productId":             "coins_100",
+		"appAccountToken":       testOrderID,
+		"environment":           "Sandbox",
+		"signedDate":            time.Now().UnixNano() / int64(time.Millisecond),
+		"price":                 1990,
+		"currency":              "USD",
+	}
+}
+
+func newTestAppleIAP(t *testing.T, root string) *AppleIAP {
+	t.Helper()
+
+	p, err := New(Options{BundleID: "com.example.app", Environment: "Sandbox", RootCert: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	ca := newTestCA(t, true)
+	p := newTestAppleIAP(t, ca.rootPEM)
+
+	tx, err := p.VerifyTransaction(ca.sign(t, testTransaction(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.AppAccountToken != testOrderID || tx.OriginalTransactionID != "2000000000000000" {
+		t.Errorf("transaction %+v", tx)
+	}
+}
+
+func TestVerifyTransactionInvalid(t *testing.T) {
+	var (
+		ca    = newTestCA(t, true)
+		other = newTestCA(t, true)
+		noOID = newTestCA(t, false)
+	)
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// 签名后修改 payload
+	tampered := ca.sign(t, testTransaction(), nil)
+	{
+		tx := testTransaction()
+		tx["price"] = 1
+		body, _ := json.Marshal(tx)
+		parts := strings.Split(tampered, ".")
+		tampered = parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2]
+	}
+
+	// want 为空时只要求返回错误，证书链校验失败时为 x509 的错误
+	var cases = []struct {
+		name  string
+		root  string
+		token string
+		want  error
+	}{
+		{"wrong root", other.rootPEM, ca.sign(t, testTransaction(), nil), nil},
+		{"missing leaf oid", noOID.rootPEM, noOID.sign(t, testTransaction(), nil), ErrInvalidCertChain},
+		{"bad signature", ca.rootPEM, ca.sign(t, testTransaction(), otherKey), pay.ErrVerify},
+		{"tampered payload", ca.rootPEM, tampered, pay.ErrVerify},
+		{"not jws", ca.rootPEM, "a.b", ErrInvalidJWS},
+	}
+
+	for _, c := range cases {
+		p := newTestAppleIAP(t, c.root)
+
+		_, err := p.VerifyTransaction(c.token)
+		if err == nil {
+			t.Errorf("%s: verified", c.name)
+			continue
+		}
+		if c.want != nil && err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// 默认使用 Apple 根证书，测试证书链不通过
+	p, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyTransaction(ca.sign(t, testTransaction(), nil)); err == nil {
+		t.Error("test chain verified against the Apple root")
+	}
+}
+
+func TestVerifyTransactionMismatch(t *testing.T) {
+	ca := newTestCA(t, true)
+	p := newTestAppleIAP(t, ca.rootPEM)
+
+	tx := testTransaction()
+	tx["bundleId"] = "com.example.other"
+	if _, err := p.VerifyTransaction(ca.sign(t, tx, nil)); err != ErrBundleIDMismatch {
+		t.Errorf("got %v, want %v", err, ErrBundleIDMismatch)
+	}
+
+	tx = testTransaction()
+	tx["environment"] = "Production"
+	if _, err := p.VerifyTransaction(ca.sign(t, tx, nil)); err != ErrEnvironmentMismatch {
+		t.Errorf("got %v, want %v", err, ErrEnvironmentMismatch)
+	}
+}
+
+func TestVerifyNotification(t *testing.T) {
+	ca := newTestCA(t, true)
+	p := newTestAppleIAP(t, ca.rootPEM)
+
+	var cases = []struct {
+		typ  string
+		want pay.TradeStatus
+	}{
+		{NotificationTypeOneTimeCharge, pay.TradeStatusSuccess},
+		{NotificationTypeDidRenew, pay.TradeStatusSuccess},
+		{NotificationTypeRefund, pay.TradeStatusClosed},
+		{NotificationTypeRevoke, pay.TradeStatusClosed},
+	}
+
+	for _, c := range cases {
+		payload := ca.sign(t, map[string]interface{}{
+			"notificationType": c.typ,
+			"notificationUUID": "uuid",
+			"signedDate":       time.Now().UnixNano() / int64(time.Millisecond),
+			"data": map[string]interface{}{
+				"bundleId":              "com.example.app",
+				"environment":           "Sandbox",
+				"signedTransactionInfo": ca.sign(t, testTransaction(), nil),
+			},
+		}, nil)
+
+		body, _ := json.Marshal(map[string]string{"signedPayload": payload})
+		r, err := p.Verify(WebhookValues(nil, body))
+		if err != nil {
+			t.Fatalf("%s: %v", c.typ, err)
+		}
+
+		want := pay.NoticeParams{OrderID: testOrderID, PaymentID: "2000000000000000", TradeStatus: c.want, Amount: 199}
+		if *r != want {
+			t.Errorf("%s: got %+v, want %+v", c.typ, r, want)
+		}
+	}
+
+	// TEST 通知没有交易
+	payload := ca.sign(t, map[string]interface{}{
+		"notificationType": NotificationTypeTest,
+		"data":             map[string]interface{}{"bundleId": "com.example.app", "environment": "Sandbox"},
+	}, nil)
+	if _, err := p.Verify(url.Values{"signedPayload": {payload}}); err != ErrNoTransaction {
+		t.Errorf("got %v, want %v", err, ErrNoTransaction)
+	}
+}
+
+func TestTransactionAmount(t *testing.T) {
+	for _, c := range []struct {
+		price    int64
+		currency string
+		want     int32
+	}{
+		{1990, "USD", 199},
+		{6000, "CNY", 600},
+		{120000, "JPY", 120},
+		{1100000, "KRW", 1100},
+		{1500, "KWD", 1500},
+		{1990, "", 199},
+	} {
+		tx := &Transaction{Price: c.price, Currency: c.currency}
+		if got := tx.Amount(); got != c.want {
+			t.Errorf("%d %s: got %d, want %d", c.price, c.currency, got, c.want)
+		}
+	}
+}
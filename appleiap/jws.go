@@ -0,0 +1,145 @@
+package appleiap
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	// ErrInvalidJWS JWS 格式错误
+	ErrInvalidJWS = errors.New("appleiap: invalid jws")
+	// ErrInvalidCertChain x5c 证书链无效
+	ErrInvalidCertChain = errors.New("appleiap: invalid certificate chain")
+)
+
+// Apple 证书扩展 OID，叶子证书和中级证书必须包含
+var (
+	oidLeafMarker         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidIntermediateMarker = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// verifyJWS 验证 ES256 签名和 x5c 证书链，成功后解析 payload 到 v
+// 证书有效期按 payload 的 signedDate 验证，历史通知重放时证书过期也能验证
+func (p *AppleIAP) verifyJWS(token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidJWS
+	}
+
+	var header struct {
+		Alg string   `json:"alg"`
+		X5c []string `json:"x5c"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+
+	if header.Alg != "ES256" || len(header.X5c) != 3 {
+		return ErrInvalidJWS
+	}
+
+	var payload struct {
+		SignedDate int64 `json:"signedDate"`
+	}
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return err
+	}
+
+	leaf, err := p.verifyChain(header.X5c, millis(payload.SignedDate))
+	if err != nil {
+		return err
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrInvalidCertChain
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return ErrInvalidJWS
+	}
+
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(pub, hashed[:], r, s) {
+		return pay.ErrVerify
+	}
+
+	return decodeSegment(parts[1], v)
+}
+
+// verifyChain 验证 x5c 叶子证书 -> 中级证书 -> 根证书，根证书使用 Options 或内置的 Apple Root CA - G3
+func (p *AppleIAP) verifyChain(x5c []string, at time.Time) (*x509.Certificate, error) {
+	var certs = make([]*x509.Certificate, 0, len(x5c))
+	for _, c := range x5c {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, ErrInvalidCertChain
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	leaf, intermediate := certs[0], certs[1]
+	if !hasExtension(leaf, oidLeafMarker) || !hasExtension(intermediate, oidIntermediateMarker) {
+		return nil, ErrInvalidCertChain
+	}
+
+	var intermediates = x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return leaf, nil
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrInvalidJWS
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// millis 毫秒时间戳转时间，为0时返回当前时间
+func millis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Now()
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,242 @@
+// Package audit 支付平台请求、响应和回调的审计日志
+//
+// Transport 记录每次签名后发出的请求和原始响应，通过 alipay.Options.WrapTransport wxpay.Options.WrapTransport payscore.Options.WrapTransport 使用，
+// Middleware 记录每次回调验证。记录前按字段名脱敏密钥、银行卡和身份信息。
+// 每条记录包含上一条的哈希，删除或修改中间的记录后 Verify 失败，
+// 末尾的删除需要与另外保存的最后一条记录的哈希比较，见 cmd/auditverify
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+// Kind 记录类型
+type Kind string
+
+// 记录类型
+const (
+	KindRequest  Kind = "request"
+	KindResponse Kind = "response"
+	KindCallback Kind = "callback"
+)
+
+var (
+	// ErrSinkRequired 未配置 Sink
+	ErrSinkRequired = errors.New("audit: sink required")
+)
+
+// Entry 一条审计记录，Body 为脱敏后的内容
+type Entry struct {
+	Seq      int64     `json:"seq"` // 从 1 开始连续递增
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Kind     Kind      `json:"kind"`
+	API      string    `json:"api,omitempty"`    // 支付宝为 method，其他为接口路径，回调为操作名
+	Method   string    `json:"method,omitempty"` // http 方法
+	URL      string    `json:"url,omitempty"`    // 查询参数已脱敏
+	Ref      int64     `json:"ref,omitempty"`    // 响应对应的请求 Seq
+	Status   int       `json:"status,omitempty"` // 响应的 http 状态码
+	Body     string    `json:"body,omitempty"`
+	Size     int       `json:"size,omitempty"` // 原始内容长度，超过 kMaxBody 时不记录 Body
+	Error    string    `json:"error,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"` // hex(sha256(不含 Hash 的 json))，json 中包含 PrevHash
+}
+
+// Sum 计算 Hash，不修改 e
+func (e *Entry) Sum() (string, error) {
+	c := *e
+	c.Hash = ""
+
+	data, err := c.marshal()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// marshal 不转义 html 字符的 json，不含换行
+func (e *Entry) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sink 审计日志存储，Write 按 Seq 顺序调用，返回前应已持久化
+type Sink interface {
+	// Write 写入一条记录，返回错误时不应留下该记录，Auditor 会以同一 Seq 写入下一条
+	Write(e *Entry) error
+	// Last 最后一条记录，没有记录时返回 nil
+	Last() (*Entry, error)
+}
+
+// Options Options
+type Options struct {
+	Sink         Sink
+	RedactFields []string // 另外需要脱敏的字段名，忽略大小写和 _ -
+	Strict       bool     // 为 true 时请求或回调写入失败返回错误，请求不发出；响应写入失败只调用 OnError
+	OnError      func(e *Entry, err error)
+}
+
+// Auditor 为记录编号、计算哈希链后写入 Sink，并发安全
+type Auditor struct {
+	opt    Options
+	fields map[string]bool
+
+	mu   sync.Mutex
+	seq  int64
+	prev string
+}
+
+// New 从 Sink 的最后一条记录继续编号
+func New(opt Options) (*Auditor, error) {
+	if opt.Sink == nil {
+		return nil, ErrSinkRequired
+	}
+
+	a := &Auditor{
+		opt:    opt,
+		fields: redactFields(opt.RedactFields),
+	}
+
+	last, err := opt.Sink.Last()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		a.seq = last.Seq
+		a.prev = last.Hash
+	}
+
+	return a, nil
+}
+
+// Wrap 用 Middleware 和 mws 包装 Payer，Middleware 在最外层
+func (a *Auditor) Wrap(provider string, p pay.Payer, mws ...pay.Middleware) *pay.Wrapped {
+	return pay.Wrap(provider, p, append([]pay.Middleware{a.Middleware()}, mws...)...)
+}
+
+// Record 编号、计算哈希后写入 Sink，e.Body 应已脱敏，见 Redact
+// 写入失败时不占用编号，下一条记录使用同一 Seq
+func (a *Auditor) Record(e *Entry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e.Seq = a.seq + 1
+	e.PrevHash = a.prev
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	hash, err := e.Sum()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	if err := a.opt.Sink.Write(e); err != nil {
+		return err
+	}
+
+	a.seq = e.Seq
+	a.prev = e.Hash
+
+	return nil
+}
+
+// record Record 失败时调用 OnError，Strict 且 strict 为 true 时返回错误
+func (a *Auditor) record(e *Entry, strict bool) error {
+	err := a.Record(e)
+	if err == nil {
+		return nil
+	}
+
+	if a.opt.OnError != nil {
+		a.opt.OnError(e, err)
+	}
+
+	if a.opt.Strict && strict {
+		return err
+	}
+
+	return nil
+}
+
+// ChainError 哈希链校验失败
+type ChainError struct {
+	Seq    int64 // 校验失败的记录应有的 Seq，即第几条记录
+	Reason string
+}
+
+// Error Error
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit: seq %d: %s", e.Seq, e.Reason)
+}
+
+// Verify 校验 json lines 格式的审计日志，返回最后一条校验通过的记录和记录数
+// 第一条记录 Seq 为 1、PrevHash 为空，之后 Seq 连续且 PrevHash 为上一条的 Hash，校验失败返回 *ChainError
+// fn 不为空时对每条校验通过的记录调用，返回错误时停止校验并返回该错误
+func Verify(r io.Reader, fn func(e *Entry) error) (last *Entry, n int, err error) {
+	var dec = json.NewDecoder(r)
+
+	for {
+		var (
+			e    Entry
+			seq  = int64(n + 1)
+			prev string
+		)
+
+		if err := dec.Decode(&e); err == io.EOF {
+			return last, n, nil
+		} else if err != nil {
+			return last, n, &ChainError{Seq: seq, Reason: err.Error()}
+		}
+
+		if last != nil {
+			prev = last.Hash
+		}
+
+		if e.Seq != seq {
+			return last, n, &ChainError{Seq: seq, Reason: fmt.Sprintf("got seq %d, entries deleted or reordered", e.Seq)}
+		}
+
+		if e.PrevHash != prev {
+			return last, n, &ChainError{Seq: seq, Reason: "prev_hash mismatch, previous entry modified"}
+		}
+
+		hash, err := e.Sum()
+		if err != nil {
+			return last, n, &ChainError{Seq: seq, Reason: err.Error()}
+		}
+		if e.Hash != hash {
+			return last, n, &ChainError{Seq: seq, Reason: "hash mismatch, entry modified"}
+		}
+
+		last = &e
+		n++
+
+		if fn != nil {
+			if err := fn(last); err != nil {
+				return last, n, err
+			}
+		}
+	}
+}
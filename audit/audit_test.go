@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memSink 内存 Sink，err 不为空时写入失败
+type memSink struct {
+	entries []*Entry
+	err     error
+}
+
+func (s *memSink) Write(e *Entry) error {
+	if s.err != nil {
+		return s.err
+	}
+	c := *e
+	s.entries = append(s.entries, &c)
+	return nil
+}
+
+func (s *memSink) Last() (*Entry, error) {
+	if len(s.entries) == 0 {
+		return nil, nil
+	}
+	c := *s.entries[len(s.entries)-1]
+	return &c, nil
+}
+
+// testLog 写入 n 条记录，返回 json lines
+func testLog(t *testing.T, n int) []string {
+	t.Helper()
+
+	s := &memSink{}
+	a, err := New(Options{Sink: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for i := 0; i < n; i++ {
+		e := &Entry{Provider: "wxpay", Kind: KindRequest, Body: "body" + string(rune('a'+i))}
+		if err := a.Record(e); err != nil {
+			t.Fatal(err)
+		}
+		data, err := e.marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+
+	return lines
+}
+
+func TestVerify(t *testing.T) {
+	lines := testLog(t, 4)
+
+	last, n, err := Verify(strings.NewReader(strings.Join(lines, "\n")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 || last.Seq != 4 || last.Body != "bodyd" {
+		t.Errorf("%d entries, last %+v", n, last)
+	}
+
+	var cases = []struct {
+		name  string
+		lines []string
+		seq   int64 // 校验失败的 Seq
+		n     int   // 校验通过的记录数
+	}{
+		{"delete first", lines[1:], 1, 0},
+		{"delete middle", []string{lines[0], lines[1], lines[3]}, 3, 2},
+		{"reorder", []string{lines[0], lines[2], lines[1], lines[3]}, 2, 1},
+		{"edit body", []string{lines[0], strings.Replace(lines[1], "bodyb", "bodyx", 1), lines[2], lines[3]}, 2, 1},
+		{"edit seq", []string{lines[0], strings.Replace(lines[1], `"seq":2`, `"seq":3`, 1), lines[2], lines[3]}, 2, 1},
+		{"not json", []string{lines[0], "{", lines[2]}, 2, 1},
+	}
+
+	for _, c := range cases {
+		_, n, err := Verify(strings.NewReader(strings.Join(c.lines, "\n")), nil)
+
+		var ce *ChainError
+		if !errors.As(err, &ce) || ce.Seq != c.seq || n != c.n {
+			t.Errorf("%s: got %v after %d entries, want seq %d after %d", c.name, err, n, c.seq, c.n)
+		}
+	}
+
+	// 重新计算哈希的修改在下一条记录的 prev_hash 处失败
+	var e Entry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.Body = "bodyx"
+	e.Hash, _ = e.Sum()
+	data, _ := e.marshal()
+
+	_, n, err = Verify(strings.NewReader(strings.Join([]string{lines[0], string(data), lines[2]}, "\n")), nil)
+	if ce, ok := err.(*ChainError); !ok || ce.Seq != 3 || n != 2 {
+		t.Errorf("rehashed entry: got %v after %d entries, want seq 3", err, n)
+	}
+
+	// 末尾的删除只能通过另外保存的最后一条记录发现
+	last, n, err = Verify(strings.NewReader(strings.Join(lines[:3], "\n")), nil)
+	if err != nil || n != 3 || last.Seq != 3 {
+		t.Errorf("truncated log: %v, %d entries", err, n)
+	}
+}
+
+func TestVerifyCallback(t *testing.T) {
+	lines := testLog(t, 3)
+
+	stop := errors.New("stop")
+	var seqs []int64
+	_, n, err := Verify(strings.NewReader(strings.Join(lines, "\n")), func(e *Entry) error {
+		seqs = append(seqs, e.Seq)
+		if e.Seq == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 || len(seqs) != 2 {
+		t.Errorf("got %v after %d entries, seqs %v", err, n, seqs)
+	}
+}
+
+func TestNewContinuesChain(t *testing.T) {
+	s := &memSink{}
+	a, _ := New(Options{Sink: s})
+	a.Record(&Entry{Provider: "alipay", Kind: KindRequest})
+
+	// 写入失败不占用编号
+	s.err = errors.New("disk full")
+	if err := a.Record(&Entry{Provider: "alipay", Kind: KindRequest}); err != s.err {
+		t.Fatalf("got %v, want %v", err, s.err)
+	}
+	s.err = nil
+
+	b, err := New(Options{Sink: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Record(&Entry{Provider: "alipay", Kind: KindResponse})
+
+	var buf bytes.Buffer
+	for _, e := range s.entries {
+		data, _ := e.marshal()
+		buf.Write(append(data, '\n'))
+	}
+	if _, n, err := Verify(&buf, nil); err != nil || n != 2 {
+		t.Errorf("got %v, %d entries", err, n)
+	}
+
+	if _, err := New(Options{}); err != ErrSinkRequired {
+		t.Errorf("got %v, want %v", err, ErrSinkRequired)
+	}
+}
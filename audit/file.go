@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var _ Sink = &File{}
+
+// file *os.File 用到的方法
+type file interface {
+	io.WriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// File json lines 文件，每行一条记录，只追加写入，每次写入后 fsync
+// 写入或 fsync 失败时截断到写入前的长度，不留下不完整的行，截断失败后不再写入
+type File struct {
+	mu   sync.Mutex
+	f    file
+	last *Entry
+	err  error
+}
+
+// OpenFile 打开或创建文件，读取最后一条记录用于继续哈希链
+// 最后一行不完整时返回错误，需要先用 Verify 检查
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := lastEntry(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &File{
+		f:    f,
+		last: last,
+	}, nil
+}
+
+// lastEntry 读取最后一个不为空的行
+func lastEntry(r io.Reader) (*Entry, error) {
+	var (
+		br   = bufio.NewReader(r)
+		line []byte
+	)
+
+	for {
+		data, err := br.ReadBytes('\n')
+		if d := bytes.TrimSpace(data); len(d) > 0 {
+			line = d
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if line == nil {
+		return nil, nil
+	}
+
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Write Write
+func (f *File) Write(e *Entry) error {
+	data, err := e.marshal()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	off, err := f.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if err := f.write(append(data, '\n')); err != nil {
+		if terr := f.f.Truncate(off); terr != nil {
+			f.err = fmt.Errorf("audit: truncate after failed write: %v", terr)
+		}
+		return err
+	}
+
+	c := *e
+	f.last = &c
+
+	return nil
+}
+
+func (f *File) write(data []byte) error {
+	if _, err := f.f.Write(data); err != nil {
+		return err
+	}
+	return f.f.Sync()
+}
+
+// Last Last
+func (f *File) Last() (*Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.last == nil {
+		return nil, nil
+	}
+
+	c := *f.last
+	return &c, nil
+}
+
+// Close Close
+func (f *File) Close() error {
+	return f.f.Close()
+}
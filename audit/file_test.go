@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// syncErrFile Sync 返回错误，模拟 fsync 失败
+type syncErrFile struct {
+	file
+}
+
+func (f *syncErrFile) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestFileWriteFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(Options{Sink: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Record(&Entry{Provider: "wxpay", Kind: KindRequest, Body: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.f = &syncErrFile{f.f}
+	if err := a.Record(&Entry{Provider: "wxpay", Kind: KindRequest, Body: "failed"}); err == nil {
+		t.Fatal("record succeeded with sync error")
+	}
+	f.f = f.f.(*syncErrFile).file
+
+	if err := a.Record(&Entry{Provider: "wxpay", Kind: KindRequest, Body: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	last, n, err := Verify(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || last.Body != "second" {
+		t.Errorf("%d entries, last %+v", n, last)
+	}
+
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if e, _ := f.Last(); e == nil || e.Seq != 2 {
+		t.Errorf("last entry %+v, want seq 2", e)
+	}
+}
@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"strings"
+)
+
+const kMask = "***"
+
+// kRedactFields 默认脱敏的字段名，已去掉 _ - 并转为小写
+var kRedactFields = []string{
+	// 密钥
+	"key", "apikey", "signkey", "sandboxsignkey", "privatekey", "appprivatekey",
+	"secret", "clientsecret", "password", "accesstoken", "refreshtoken",
+	// 银行卡
+	"cardno", "cardnumber", "bankcardno", "encbankno", "bankno", "accno", "bankaccountno",
+	"cvv", "cvv2", "cvn2", "cvc", "expired", "expmonth", "expyear", "expirydate", "customerinfo",
+	// 身份信息
+	"truename", "enctruename", "realname", "payeerealname", "reusername", "certno", "idcard", "idno", "mobile", "phone",
+	// 收款账户
+	"payeeaccount",
+}
+
+// redactFields 默认字段和 extra
+func redactFields(extra []string) map[string]bool {
+	var fields = make(map[string]bool)
+	for _, k := range kRedactFields {
+		fields[k] = true
+	}
+	for _, k := range extra {
+		fields[normalize(k)] = true
+	}
+	return fields
+}
+
+// normalize 去掉 _ - 并转为小写，card_no cardNo card-no 为同一字段
+func normalize(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+// Redact 按字段名脱敏，fields 为另外需要脱敏的字段名
+// 支持 xml、json 和表单，字段值为 json 时递归处理，其他格式原样返回
+func Redact(data []byte, fields ...string) string {
+	return redactor(redactFields(fields)).body(data)
+}
+
+type redactor map[string]bool
+
+func (r redactor) body(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return ""
+	}
+
+	switch trimmed[0] {
+	case '<':
+		if s, ok := r.xml(trimmed); ok {
+			return s
+		}
+	case '{', '[':
+		if s, ok := r.json(trimmed); ok {
+			return s
+		}
+	default:
+		if form, err := url.ParseQuery(string(trimmed)); err == nil && strings.Contains(string(trimmed), "=") {
+			return r.values(form).Encode()
+		}
+	}
+
+	return string(data)
+}
+
+// values 表单或查询参数
+func (r redactor) values(in url.Values) url.Values {
+	var out = make(url.Values, len(in))
+	for k, vs := range in {
+		for _, v := range vs {
+			out.Add(k, r.value(k, v))
+		}
+	}
+	return out
+}
+
+// value 脱敏字段返回 mask，值为 json 时递归处理
+func (r redactor) value(key, v string) string {
+	if r[normalize(key)] {
+		return mask(v)
+	}
+
+	if t := strings.TrimSpace(v); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+		if s, ok := r.json([]byte(t)); ok {
+			return s
+		}
+	}
+
+	return v
+}
+
+func (r redactor) json(data []byte) (string, bool) {
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", false
+	}
+
+	out, err := json.Marshal(r.walk("", v))
+	if err != nil {
+		return "", false
+	}
+
+	return string(out), true
+}
+
+func (r redactor) walk(key string, v interface{}) interface{} {
+	if r[normalize(key)] {
+		switch t := v.(type) {
+		case nil:
+			return nil
+		case string:
+			return mask(t)
+		case json.Number:
+			return mask(t.String())
+		}
+		return kMask
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, c := range t {
+			t[k] = r.walk(k, c)
+		}
+		return t
+	case []interface{}:
+		for i, c := range t {
+			t[i] = r.walk(key, c)
+		}
+		return t
+	case string:
+		return r.value(key, t)
+	}
+
+	return v
+}
+
+// xml 只处理根节点下一级字段的格式，字段保持原来的顺序
+func (r redactor) xml(data []byte) (string, bool) {
+	var (
+		dec    = xml.NewDecoder(bytes.NewReader(data))
+		root   string
+		names  []string
+		fields = make(map[string]string)
+		depth  int
+		name   string
+	)
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = t.Name.Local
+			} else if depth == 2 {
+				name = t.Name.Local
+				if _, ok := fields[name]; !ok {
+					names = append(names, name)
+					fields[name] = ""
+				}
+			} else {
+				return "", false
+			}
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 2 {
+				fields[name] += string(t)
+			}
+		}
+	}
+
+	if len(root) == 0 {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<" + root + ">")
+	for _, k := range names {
+		buf.WriteString("<" + k + ">")
+		xml.EscapeText(&buf, []byte(r.value(k, fields[k])))
+		buf.WriteString("</" + k + ">")
+	}
+	buf.WriteString("</" + root + ">")
+
+	return buf.String(), true
+}
+
+// urlString 查询参数脱敏后的地址，参数按名称排序
+func (r redactor) urlString(u *url.URL) string {
+	c := *u
+	c.User = nil
+	if len(c.RawQuery) > 0 {
+		c.RawQuery = r.values(c.Query()).Encode()
+	}
+	return c.String()
+}
+
+// mask 全部为数字且不少于 8 位时保留后 4 位，其他返回 ***
+func mask(v string) string {
+	if len(v) == 0 {
+		return v
+	}
+
+	if len(v) >= 8 && strings.Trim(v, "0123456789") == "" {
+		return strings.Repeat("*", len(v)-4) + v[len(v)-4:]
+	}
+
+	return kMask
+}
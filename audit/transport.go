@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/gocommon/pay"
+)
+
+// kMaxBody 记录内容的上限，超过时只记录长度
+const kMaxBody = 1 << 20
+
+// Transport 返回包装 Transport 的函数，用于 alipay.Options.WrapTransport wxpay.Options.WrapTransport payscore.Options.WrapTransport
+// 与 tracing 一起使用时按需要的顺序组合，例如 func(rt) { return tr.Transport(p)(a.Transport(p)(rt)) }
+// 请求内容只在 req.GetBody 可用时记录，请求头不记录
+func (a *Auditor) Transport(provider string) func(http.RoundTripper) http.RoundTripper {
+	return func(base http.RoundTripper) http.RoundTripper {
+		if base == nil {
+			base = http.DefaultTransport
+		}
+
+		return &transport{
+			base:     base,
+			auditor:  a,
+			provider: provider,
+		}
+	}
+}
+
+type transport struct {
+	base     http.RoundTripper
+	auditor  *Auditor
+	provider string
+}
+
+// RoundTrip 发出请求前记录请求，Strict 时记录失败不发出请求，收到响应后记录响应并恢复 resp.Body
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := redactor(t.auditor.fields)
+
+	var in = &Entry{
+		Provider: t.provider,
+		Kind:     KindRequest,
+		API:      req.URL.Path,
+		Method:   req.Method,
+		URL:      r.urlString(req.URL),
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, err := ioutil.ReadAll(io.LimitReader(body, kMaxBody+1))
+			body.Close()
+			if err == nil {
+				t.setBody(in, data)
+			}
+		}
+	}
+
+	if err := t.auditor.record(in, true); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	var out = &Entry{
+		Provider: t.provider,
+		Kind:     KindResponse,
+		API:      in.API,
+		Ref:      in.Seq,
+	}
+
+	if err != nil {
+		out.Error = err.Error()
+		t.auditor.record(out, false)
+		return nil, err
+	}
+
+	out.Status = resp.StatusCode
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, kMaxBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	t.setBody(out, data)
+
+	t.auditor.record(out, false)
+
+	return resp, nil
+}
+
+// setBody 脱敏后记录内容，支付宝请求的接口名为表单中的 method
+func (t *transport) setBody(e *Entry, data []byte) {
+	e.Size = len(data)
+	if len(data) > kMaxBody {
+		return
+	}
+
+	if e.Kind == KindRequest {
+		if form, err := url.ParseQuery(string(data)); err == nil {
+			if method := form.Get("method"); len(method) > 0 {
+				e.API = method
+			}
+		}
+	}
+
+	e.Body = redactor(t.auditor.fields).body(data)
+}
+
+// Middleware 记录回调验证，包括验签失败的回调，Strict 时记录失败返回错误，回调应返回失败由支付平台重新通知
+func (a *Auditor) Middleware() pay.Middleware {
+	return func(next pay.Handler) pay.Handler {
+		return func(inv *pay.Invocation) (interface{}, error) {
+			switch inv.Operation {
+			case pay.OpVerify, pay.OpVerifyAgreement, pay.OpVerifyFreeze:
+			default:
+				return next(inv)
+			}
+
+			res, err := next(inv)
+
+			var e = &Entry{
+				Provider: inv.Provider,
+				Kind:     KindCallback,
+				API:      string(inv.Operation),
+			}
+			if in, ok := inv.Request.(url.Values); ok {
+				e.Body = redactor(a.fields).values(in).Encode()
+				e.Size = len(in.Encode())
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+
+			if rerr := a.record(e, true); rerr != nil {
+				return nil, rerr
+			}
+
+			return res, err
+		}
+	}
+}
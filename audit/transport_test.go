@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gocommon/pay"
+)
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<xml><return_code>SUCCESS</return_code><payment_no>1000</payment_no></xml>`))
+	}))
+	defer srv.Close()
+
+	s := &memSink{}
+	a, _ := New(Options{Sink: s})
+	cli := &http.Client{Transport: a.Transport("wxpay")(nil)}
+
+	body := `<xml><partner_trade_no>P1</partner_trade_no><enc_bank_no>Ym5r</enc_bank_no></xml>`
+	resp, err := cli.Post(srv.URL+"/mmpaysptrans/pay_bank", "text/xml", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// 记录响应后调用方仍能读取完整的响应
+	if !strings.Contains(string(data), "<payment_no>1000</payment_no>") {
+		t.Errorf("response body %s", data)
+	}
+
+	if len(s.entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(s.entries))
+	}
+	in, out := s.entries[0], s.entries[1]
+	if in.Kind != KindRequest || in.API != "/mmpaysptrans/pay_bank" || in.Size != len(body) ||
+		strings.Contains(in.Body, "Ym5r") || !strings.Contains(in.Body, "P1") {
+		t.Errorf("request %+v", in)
+	}
+	if out.Kind != KindResponse || out.Ref != in.Seq || out.Status != http.StatusOK || out.Body != string(data) {
+		t.Errorf("response %+v", out)
+	}
+}
+
+func TestTransportStrict(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	var (
+		s      = &memSink{err: errors.New("disk full")}
+		onErrs int
+	)
+	a, _ := New(Options{Sink: s, Strict: true, OnError: func(e *Entry, err error) { onErrs++ }})
+	cli := &http.Client{Transport: a.Transport("alipay")(nil)}
+
+	// 请求记录失败时不发出请求
+	if _, err := cli.PostForm(srv.URL+"/gateway.do", url.Values{"method": {"alipay.trade.refund"}}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("got %v, want the sink error", err)
+	}
+	if hits != 0 || onErrs != 1 {
+		t.Errorf("%d requests sent, %d OnError calls", hits, onErrs)
+	}
+
+	// 非 Strict 时只调用 OnError
+	a.opt.Strict = false
+	resp, err := cli.PostForm(srv.URL+"/gateway.do", url.Values{"method": {"alipay.trade.refund"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if hits != 1 || onErrs != 3 {
+		t.Errorf("%d requests sent, %d OnError calls", hits, onErrs)
+	}
+}
+
+// verifyPayer 只实现 pay.Payer，Verify 按 sign 返回结果
+type verifyPayer struct{}
+
+func (verifyPayer) Verify(in url.Values) (*pay.NoticeParams, error) {
+	if in.Get("sign") != "ok" {
+		return nil, pay.ErrVerify
+	}
+	return &pay.NoticeParams{OrderID: in.Get("out_trade_no"), TradeStatus: pay.TradeStatusSuccess}, nil
+}
+func (verifyPayer) Success() string                         { return "success" }
+func (verifyPayer) Call(pay.Way, pay.Order) (string, error) { return "", nil }
+
+func TestMiddleware(t *testing.T) {
+	s := &memSink{}
+	a, _ := New(Options{Sink: s, Strict: true})
+	p := a.Wrap("alipay", verifyPayer{})
+
+	in := url.Values{"out_trade_no": {"O1"}, "sign": {"ok"}, "mobile": {"13800000000"}}
+	if r, err := p.Verify(in); err != nil || r.OrderID != "O1" {
+		t.Fatalf("got %+v %v", r, err)
+	}
+
+	// 验签失败的回调也记录
+	in.Set("sign", "bad")
+	if _, err := p.Verify(in); err != pay.ErrVerify {
+		t.Fatalf("got %v, want %v", err, pay.ErrVerify)
+	}
+
+	if len(s.entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(s.entries))
+	}
+	if e := s.entries[0]; e.Kind != KindCallback || e.API != string(pay.OpVerify) || strings.Contains(e.Body, "13800000000") || len(e.Error) > 0 {
+		t.Errorf("entry %+v", e)
+	}
+	if e := s.entries[1]; e.Error != pay.ErrVerify.Error() {
+		t.Errorf("entry %+v", e)
+	}
+
+	// 其他操作不记录
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.entries) != 2 {
+		t.Errorf("%d entries after Call", len(s.entries))
+	}
+}
+
+func TestMiddlewareStrict(t *testing.T) {
+	s := &memSink{err: errors.New("disk full")}
+	a, _ := New(Options{Sink: s, Strict: true})
+	p := a.Wrap("alipay", verifyPayer{})
+
+	in := url.Values{"out_trade_no": {"O1"}, "sign": {"ok"}}
+
+	// 记录失败时回调返回错误，由支付平台重新通知
+	if r, err := p.Verify(in); err != s.err || r != nil {
+		t.Errorf("got %+v %v, want %v", r, err, s.err)
+	}
+
+	a.opt.Strict = false
+	if r, err := p.Verify(in); err != nil || r.OrderID != "O1" {
+		t.Errorf("got %+v %v", r, err)
+	}
+}
@@ -0,0 +1,88 @@
+// auditverify 校验 audit.File 写入的审计日志的哈希链
+//
+//	auditverify [-hash hash [-seq seq]] audit.log
+//
+// 文件为 - 时读取标准输入。校验通过时输出记录数和最后一条记录的 seq hash，
+// 另外保存这两个值，之后用 -seq -hash 校验可以发现该记录及之前的记录被删除或修改
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gocommon/pay/audit"
+)
+
+func main() {
+	hash := flag.String("hash", "", "expected hash of the entry at -seq")
+	seq := flag.Int64("seq", 0, "seq of the entry to check -hash against, 0 for the last entry")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: auditverify [-hash hash [-seq seq]] file\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if name := flag.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	last, n, err := verify(r, *seq, *hash)
+	if err != nil {
+		fail(err)
+	}
+
+	if last == nil {
+		fmt.Println("ok: 0 entries")
+		return
+	}
+
+	fmt.Printf("ok: %d entries, last seq %d hash %s\n", n, last.Seq, last.Hash)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// verify 校验哈希链，hash 不为空时 seq 对应的记录的哈希应为 hash，seq 为 0 时为最后一条记录
+func verify(r io.Reader, seq int64, hash string) (*audit.Entry, int, error) {
+	var found bool
+	last, n, err := audit.Verify(r, func(e *audit.Entry) error {
+		if seq == 0 || e.Seq != seq {
+			return nil
+		}
+
+		found = true
+		if len(hash) > 0 && e.Hash != hash {
+			return fmt.Errorf("audit: seq %d: hash %s, expected %s", e.Seq, e.Hash, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return last, n, err
+	}
+
+	if len(hash) > 0 {
+		switch {
+		case seq > 0 && !found:
+			return last, n, fmt.Errorf("audit: seq %d not found, %d entries", seq, n)
+		case seq == 0 && (last == nil || last.Hash != hash):
+			return last, n, fmt.Errorf("audit: last entry does not match %s, entries deleted or appended", hash)
+		}
+	}
+
+	return last, n, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gocommon/pay/audit"
+)
+
+// testLog 写入 n 条记录，返回文件内容和每条记录的哈希
+func testLog(t *testing.T, n int) ([]byte, []string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := audit.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a, err := audit.New(audit.Options{Sink: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []string
+	for i := 0; i < n; i++ {
+		e := &audit.Entry{Provider: "wxpay", Kind: audit.KindRequest}
+		if err := a.Record(e); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, e.Hash)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data, hashes
+}
+
+func TestVerifyAnchor(t *testing.T) {
+	data, hashes := testLog(t, 3)
+	lines := strings.SplitAfter(string(data), "\n")
+	head := []byte(strings.Join(lines[:2], ""))
+
+	var cases = []struct {
+		name string
+		data []byte
+		seq  int64
+		hash string
+		ok   bool
+	}{
+		{"no anchor", data, 0, "", true},
+		{"last", data, 0, hashes[2], true},
+		{"seq", data, 2, hashes[1], true},
+		{"appended after anchor", data, 0, hashes[1], false},
+		{"anchor entry deleted", head, 3, hashes[2], false},
+		{"tail deleted", head, 0, hashes[2], false},
+		{"wrong hash", data, 2, hashes[2], false},
+		{"seq beyond log", data, 4, hashes[2], false},
+	}
+
+	for _, c := range cases {
+		last, _, err := verify(bytes.NewReader(c.data), c.seq, c.hash)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: got %v, want ok %v", c.name, err, c.ok)
+		}
+		if c.ok && last.Hash != hashes[2] {
+			t.Errorf("%s: last %+v", c.name, last)
+		}
+	}
+}
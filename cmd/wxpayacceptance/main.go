@@ -0,0 +1,52 @@
+// wxpayacceptance 在微信支付仿真测试系统执行验收用例
+//
+//	wxpayacceptance -mchid mchid -apikey apikey -appid appid
+//
+// 按顺序执行 wxpay.AcceptanceCases，输出每个用例的结果，有用例失败时退出码为1
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gocommon/pay/wxpay"
+)
+
+func main() {
+	mchID := flag.String("mchid", "", "merchant id")
+	apiKey := flag.String("apikey", "", "merchant API key, the sandbox key is fetched with it")
+	appID := flag.String("appid", "", "official account appid")
+	domain := flag.String("domain", "", "API domain, empty for https://api.mch.weixin.qq.com")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: wxpayacceptance -mchid mchid -apikey apikey -appid appid [-domain domain]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(*mchID) == 0 || len(*apiKey) == 0 || len(*appID) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p := wxpay.New(wxpay.Options{
+		MchID:     *mchID,
+		APIKey:    *apiKey,
+		PublicID:  *appID,
+		APIDomain: *domain,
+	})
+
+	results, err := p.Acceptance()
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = r.Err.Error()
+		}
+		fmt.Printf("%s\t%s\t%s\n", r.Case.Name, r.OrderID, status)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package pay
+
+import (
+	"net"
+	"strings"
+)
+
+// Category 错误分类
+type Category int
+
+// 错误分类
+const (
+	CategoryUnknown   Category = iota // 未归类的错误
+	CategoryAuth                      // 签名、证书、appid 或权限配置错误
+	CategoryParam                     // 参数错误，订单不存在
+	CategoryBalance                   // 买家或商户余额不足
+	CategoryDuplicate                 // 订单号重复，订单已支付或已关闭
+	CategorySystem                    // 支付平台系统错误、网络错误或超时，结果未知
+)
+
+// String String
+func (c Category) String() string {
+	switch c {
+	case CategoryAuth:
+		return "auth"
+	case CategoryParam:
+		return "param"
+	case CategoryBalance:
+		return "balance"
+	case CategoryDuplicate:
+		return "duplicate"
+	case CategorySystem:
+		return "system"
+	}
+	return "unknown"
+}
+
+// Error 支付平台返回的错误，调用方按 Category 或 SubCode 处理
+type Error struct {
+	Provider  string   // 支付平台 alipay wxpay
+	Code      string   // 网关返回码，支付宝 code，微信 return_code 或 result_code
+	SubCode   string   // 业务错误码，支付宝 sub_code，微信 err_code
+	Message   string   // 错误描述
+	Category  Category // 错误分类
+	Retryable bool     // 是否可以用原参数重试
+	Err       error    // 网络错误等原始错误
+}
+
+// Error Error
+func (e *Error) Error() string {
+	var parts = []string{e.Provider + ":"}
+	if len(e.SubCode) > 0 {
+		parts = append(parts, e.SubCode)
+	} else if len(e.Code) > 0 {
+		parts = append(parts, e.Code)
+	}
+	if len(e.Message) > 0 {
+		parts = append(parts, e.Message)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Unwrap 原始错误
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NetError 网络错误和超时转为可重试的 CategorySystem 错误，其他错误原样返回
+func NetError(provider string, err error) error {
+	if _, ok := err.(net.Error); !ok {
+		return err
+	}
+
+	return &Error{
+		Provider:  provider,
+		Message:   err.Error(),
+		Category:  CategorySystem,
+		Retryable: true,
+		Err:       err,
+	}
+}
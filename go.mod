@@ -1,10 +1,16 @@
 module github.com/gocommon/pay
 
-go 1.12
+go 1.15
 
 require (
+	github.com/prometheus/client_golang v1.7.0
 	github.com/smartwalle/alipay v0.0.0-20190612023432-b02a8bdaa2d5
 	github.com/smartwalle/wxpay v0.0.0-20190701015148-b4ed80efbc45
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	modernc.org/sqlite v1.10.6
 )
 
 replace github.com/smartwalle/wxpay => github.com/gocommon/wxpay v0.0.0-20190701065221-011a3aef50aa
@@ -0,0 +1,125 @@
+package googleplay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	kTokenURL = "https://oauth2.googleapis.com/token"
+	kScope    = "https://www.googleapis.com/auth/androidpublisher"
+)
+
+var (
+	// ErrInvalidServiceAccount 服务账号密钥无法解析
+	ErrInvalidServiceAccount = errors.New("googleplay: invalid service account")
+)
+
+// serviceAccount 服务账号密钥 json 中用到的字段
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+func parseServiceAccount(data []byte) (*serviceAccount, error) {
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, ErrInvalidServiceAccount
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if sa.key, ok = key.(*rsa.PrivateKey); !ok {
+		return nil, ErrInvalidServiceAccount
+	}
+
+	if len(sa.TokenURI) == 0 {
+		sa.TokenURI = kTokenURL
+	}
+
+	return &sa, nil
+}
+
+// accessToken 服务账号 JWT 换取 access token 并缓存，过期前1分钟刷新
+// https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+func (p *GooglePlay) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.token) > 0 && time.Now().Before(p.expires) {
+		return p.token, nil
+	}
+
+	assertion, err := p.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	var v = url.Values{}
+	v.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	v.Set("assertion", assertion)
+
+	req, err := http.NewRequest("POST", p.account.TokenURI, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var rsp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := p.send(req, &rsp); err != nil {
+		return "", err
+	}
+
+	p.token = rsp.AccessToken
+	p.expires = time.Now().Add(time.Duration(rsp.ExpiresIn)*time.Second - time.Minute)
+
+	return p.token, nil
+}
+
+// assertion 服务账号签名的 JWT RS256
+func (p *GooglePlay) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.account.ClientEmail,
+		"scope": kScope,
+		"aud":   p.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(signing))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.account.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
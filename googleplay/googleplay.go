@@ -0,0 +1,495 @@
+// Package googleplay Google Play 结算，通过 Android Publisher API 验证购买凭证，解析实时开发者通知
+// https://developer.android.com/google/play/billing/rtdn-reference
+//
+// 实时开发者通知本身没有签名，Verify 收到通知后以 Android Publisher API 查询结果为准，
+// 作废通知以 voidedpurchases 接口查到的记录为准
+package googleplay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payer = &GooglePlay{}
+
+const (
+	kAPIDomain = "https://androidpublisher.googleapis.com"
+
+	kPurchasesPath = "/androidpublisher/v3/applications/%s/purchases"
+
+	// kMaxAccountIDLength obfuscatedAccountId 最大长度
+	kMaxAccountIDLength = 64
+
+	// kVoidedWindow 查询作废记录时从通知时间往前查的时长
+	kVoidedWindow = 24 * time.Hour
+
+	kTimeout = 10 * time.Second
+)
+
+// 作废通知退款类型 refundType
+const (
+	RefundTypeFull    = 1 // 全额退款
+	RefundTypePartial = 2 // 部分退款，按数量部分退款的购买仍有效
+)
+
+// 一次性商品购买状态 purchaseState
+const (
+	PurchaseStatePurchased = 0
+	PurchaseStateCanceled  = 1
+	PurchaseStatePending   = 2
+)
+
+// 作废通知商品类型 productType
+const (
+	ProductTypeSubscription = 1
+	ProductTypeOneTime      = 2
+)
+
+// 订阅状态 subscriptionState
+const (
+	SubscriptionStatePending                 = "SUBSCRIPTION_STATE_PENDING"
+	SubscriptionStateActive                  = "SUBSCRIPTION_STATE_ACTIVE"
+	SubscriptionStatePaused                  = "SUBSCRIPTION_STATE_PAUSED"
+	SubscriptionStateInGracePeriod           = "SUBSCRIPTION_STATE_IN_GRACE_PERIOD"
+	SubscriptionStateOnHold                  = "SUBSCRIPTION_STATE_ON_HOLD"
+	SubscriptionStateCanceled                = "SUBSCRIPTION_STATE_CANCELED"
+	SubscriptionStateExpired                 = "SUBSCRIPTION_STATE_EXPIRED"
+	SubscriptionStatePendingPurchaseCanceled = "SUBSCRIPTION_STATE_PENDING_PURCHASE_CANCELED"
+)
+
+var (
+	// ErrPackageNameMismatch 通知的 packageName 与 Options.PackageName 不一致
+	ErrPackageNameMismatch = errors.New("googleplay: package name mismatch")
+	// ErrNotificationNotSupport 测试通知等不含购买信息的通知
+	ErrNotificationNotSupport = errors.New("googleplay: notification not support")
+	// ErrInvalidAccountID 订单号超过64个字符，不能作为 obfuscatedAccountId
+	ErrInvalidAccountID = errors.New("googleplay: order id too long")
+	// ErrVoidedNotFound voidedpurchases 接口查不到作废通知中的购买，通知不可信或接口还未更新
+	ErrVoidedNotFound = errors.New("googleplay: voided purchase not found")
+)
+
+// Options Options
+type Options struct {
+	PackageName        string       // 应用包名
+	ServiceAccountJSON []byte       // 服务账号密钥 json
+	APIDomain          string       // 接口域名，为空时使用 https://androidpublisher.googleapis.com
+	TokenURL           string       // 获取 access token 地址，为空时使用服务账号的 token_uri
+	Client             *http.Client // 为空时使用超时 10s 的 http.Client
+}
+
+// GooglePlay GooglePlay
+type GooglePlay struct {
+	opt     Options
+	account *serviceAccount
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New New
+func New(opt Options) (*GooglePlay, error) {
+	sa, err := parseServiceAccount(opt.ServiceAccountJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opt.TokenURL) > 0 {
+		sa.TokenURI = opt.TokenURL
+	}
+
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	p := &GooglePlay{
+		opt:     opt,
+		account: sa,
+	}
+
+	return p, nil
+}
+
+// Verify 解析实时开发者通知并查询购买状态,成功返回回调参数，in 为 WebhookValues 的返回值
+// PaymentID 为 Google Play 订单号 GPA.xxxx，OrderID 为 obfuscatedAccountId
+// Google Play 不返回支付金额，Amount 为 0
+// 作废通知 OrderID 为空，按 PaymentID 关联订单，全额退款为交易关闭，部分退款仍为支付成功
+func (p *GooglePlay) Verify(in url.Values) (*pay.NoticeParams, error) {
+	n, err := ParseNotification([]byte(in.Get("body")))
+	if err != nil {
+		return nil, err
+	}
+
+	if n.PackageName != p.opt.PackageName {
+		return nil, ErrPackageNameMismatch
+	}
+
+	switch {
+	case n.OneTimeProductNotification != nil:
+		purchase, err := p.VerifyProduct(n.OneTimeProductNotification.SKU, n.OneTimeProductNotification.PurchaseToken)
+		if err != nil {
+			return nil, err
+		}
+		return purchase.NoticeParams(), nil
+	case n.SubscriptionNotification != nil:
+		purchase, err := p.VerifySubscription(n.SubscriptionNotification.PurchaseToken)
+		if err != nil {
+			return nil, err
+		}
+		return purchase.NoticeParams(), nil
+	case n.VoidedPurchaseNotification != nil:
+		return p.verifyVoided(n)
+	}
+
+	return nil, ErrNotificationNotSupport
+}
+
+// Success 回调成功返回数据，返回 http 200 即可
+func (p *GooglePlay) Success() string {
+	return ""
+}
+
+// Call 购买由 app 发起，app 返回商户订单号，作为 BillingFlowParams 的 obfuscatedAccountId
+func (p *GooglePlay) Call(way pay.Way, in pay.Order) (string, error) {
+	if way != pay.WayApp {
+		return "", pay.ErrWayNotDefine
+	}
+
+	if len(in.ID) > kMaxAccountIDLength {
+		return "", ErrInvalidAccountID
+	}
+
+	return in.ID, nil
+}
+
+// VerifyProduct 查询一次性商品购买
+func (p *GooglePlay) VerifyProduct(productID, purchaseToken string) (*ProductPurchase, error) {
+	var rsp ProductPurchase
+	err := p.do("GET", p.purchasesPath("/products/%s/tokens/%s", productID, purchaseToken), &rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// VerifySubscription 查询订阅购买
+func (p *GooglePlay) VerifySubscription(purchaseToken string) (*SubscriptionPurchase, error) {
+	var rsp SubscriptionPurchase
+	err := p.do("GET", p.purchasesPath("/subscriptionsv2/tokens/%s", purchaseToken), &rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// verifyVoided 在 voidedpurchases 接口中查到通知中的购买才返回，不信任通知中的内容
+func (p *GooglePlay) verifyVoided(n *Notification) (*pay.NoticeParams, error) {
+	var (
+		v         = n.VoidedPurchaseNotification
+		startTime time.Time
+	)
+	if ms, err := strconv.ParseInt(n.EventTimeMillis, 10, 64); err == nil {
+		startTime = time.Unix(0, ms*int64(time.Millisecond)).Add(-kVoidedWindow)
+	}
+
+	list, err := p.VoidedPurchases(startTime, v.ProductType == ProductTypeSubscription)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vp := range list {
+		if vp.PurchaseToken != v.PurchaseToken || vp.OrderID != v.OrderID {
+			continue
+		}
+
+		var status = pay.TradeStatusClosed
+		if v.RefundType == RefundTypePartial {
+			status = pay.TradeStatusSuccess
+		}
+
+		return &pay.NoticeParams{
+			PaymentID:   vp.OrderID,
+			TradeStatus: status,
+		}, nil
+	}
+
+	return nil, ErrVoidedNotFound
+}
+
+// VoidedPurchases 查询 startTime 之后作废的购买，startTime 为零值时查询最近30天，subscriptions 为 true 时包括订阅
+// https://developers.google.com/android-publisher/api-ref/rest/v3/purchases.voidedpurchases/list
+func (p *GooglePlay) VoidedPurchases(startTime time.Time, subscriptions bool) ([]*VoidedPurchase, error) {
+	var (
+		list []*VoidedPurchase
+		page string
+	)
+
+	for {
+		var q = url.Values{}
+		if !startTime.IsZero() {
+			q.Set("startTime", strconv.FormatInt(startTime.UnixNano()/int64(time.Millisecond), 10))
+		}
+		if subscriptions {
+			q.Set("type", "1")
+		}
+		if len(page) > 0 {
+			q.Set("token", page)
+		}
+
+		var rsp struct {
+			VoidedPurchases []*VoidedPurchase `json:"voidedPurchases"`
+			TokenPagination struct {
+				NextPageToken string `json:"nextPageToken"`
+			} `json:"tokenPagination"`
+		}
+		path := p.purchasesPath("/voidedpurchases")
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+		if err := p.do("GET", path, &rsp); err != nil {
+			return nil, err
+		}
+
+		list = append(list, rsp.VoidedPurchases...)
+
+		if page = rsp.TokenPagination.NextPageToken; len(page) == 0 {
+			return list, nil
+		}
+	}
+}
+
+// AcknowledgeProduct 确认一次性商品购买，3天内未确认 Google Play 会自动退款
+func (p *GooglePlay) AcknowledgeProduct(productID, purchaseToken string) error {
+	return p.do("POST", p.purchasesPath("/products/%s/tokens/%s:acknowledge", productID, purchaseToken), nil)
+}
+
+// AcknowledgeSubscription 确认订阅购买
+func (p *GooglePlay) AcknowledgeSubscription(subscriptionID, purchaseToken string) error {
+	return p.do("POST", p.purchasesPath("/subscriptions/%s/tokens/%s:acknowledge", subscriptionID, purchaseToken), nil)
+}
+
+func (p *GooglePlay) purchasesPath(format string, args ...string) string {
+	var a = make([]interface{}, 0, len(args))
+	for _, s := range args {
+		a = append(a, url.PathEscape(s))
+	}
+
+	return fmt.Sprintf(kPurchasesPath, url.PathEscape(p.opt.PackageName)) + fmt.Sprintf(format, a...)
+}
+
+// do 请求 Android Publisher API
+func (p *GooglePlay) do(method, path string, result interface{}) error {
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+
+	domain := kAPIDomain
+	if len(p.opt.APIDomain) > 0 {
+		domain = strings.TrimSuffix(p.opt.APIDomain, "/")
+	}
+
+	req, err := http.NewRequest(method, domain+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	return p.send(req, result)
+}
+
+func (p *GooglePlay) send(req *http.Request, result interface{}) error {
+	resp, err := p.opt.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error json.RawMessage `json:"error"`
+		}
+		json.Unmarshal(data, &e)
+
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(e.Error, &detail) != nil || len(detail.Message) == 0 {
+			// oauth 错误 error 为字符串
+			json.Unmarshal(e.Error, &detail.Message)
+		}
+
+		return fmt.Errorf("googleplay: http status %d %s", resp.StatusCode, detail.Message)
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// ProductPurchase 一次性商品购买
+type ProductPurchase struct {
+	OrderID                     string `json:"orderId"`
+	PurchaseState               int    `json:"purchaseState"`
+	ConsumptionState            int    `json:"consumptionState"`
+	AcknowledgementState        int    `json:"acknowledgementState"`
+	PurchaseTimeMillis          string `json:"purchaseTimeMillis"`
+	ProductID                   string `json:"productId"`
+	Quantity                    int32  `json:"quantity"`
+	PurchaseType                *int   `json:"purchaseType"` // 0 测试购买 1 促销代码
+	ObfuscatedExternalAccountID string `json:"obfuscatedExternalAccountId"`
+	RegionCode                  string `json:"regionCode"`
+}
+
+// NoticeParams 购买转回调参数
+func (p *ProductPurchase) NoticeParams() *pay.NoticeParams {
+	var status = pay.TradeStatusWait
+	switch p.PurchaseState {
+	case PurchaseStatePurchased:
+		status = pay.TradeStatusSuccess
+	case PurchaseStateCanceled:
+		status = pay.TradeStatusClosed
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     p.ObfuscatedExternalAccountID,
+		PaymentID:   p.OrderID,
+		TradeStatus: status,
+	}
+}
+
+// VoidedPurchase 已作废的购买
+type VoidedPurchase struct {
+	PurchaseToken      string `json:"purchaseToken"`
+	OrderID            string `json:"orderId"`
+	PurchaseTimeMillis string `json:"purchaseTimeMillis"`
+	VoidedTimeMillis   string `json:"voidedTimeMillis"`
+	VoidedSource       int    `json:"voidedSource"`   // 0 用户 1 开发者 2 Google
+	VoidedReason       int    `json:"voidedReason"`   // 0 其他 1 悔单 2 未收到商品 3 商品缺陷 4 误购 5 欺诈 6 友好欺诈 7 拒付 8 未确认
+	VoidedQuantity     int    `json:"voidedQuantity"` // 按数量部分退款时退款的数量
+}
+
+// SubscriptionPurchase 订阅购买 subscriptionsv2
+type SubscriptionPurchase struct {
+	SubscriptionState          string `json:"subscriptionState"`
+	LatestOrderID              string `json:"latestOrderId"`
+	StartTime                  string `json:"startTime"`
+	AcknowledgementState       string `json:"acknowledgementState"`
+	RegionCode                 string `json:"regionCode"`
+	LinkedPurchaseToken        string `json:"linkedPurchaseToken"`
+	ExternalAccountIdentifiers struct {
+		ObfuscatedExternalAccountID string `json:"obfuscatedExternalAccountId"`
+	} `json:"externalAccountIdentifiers"`
+	LineItems []struct {
+		ProductID  string `json:"productId"`
+		ExpiryTime string `json:"expiryTime"`
+	} `json:"lineItems"`
+}
+
+// NoticeParams 订阅转回调参数
+// 已取消自动续费的订阅在到期前仍为支付成功，到期后为交易结束
+func (s *SubscriptionPurchase) NoticeParams() *pay.NoticeParams {
+	return &pay.NoticeParams{
+		OrderID:     s.ExternalAccountIdentifiers.ObfuscatedExternalAccountID,
+		PaymentID:   s.LatestOrderID,
+		TradeStatus: SubscriptionTradeStatus(s.SubscriptionState),
+	}
+}
+
+// SubscriptionTradeStatus 订阅状态转支付状态
+func SubscriptionTradeStatus(state string) pay.TradeStatus {
+	switch state {
+	case SubscriptionStateActive, SubscriptionStateInGracePeriod, SubscriptionStateCanceled:
+		return pay.TradeStatusSuccess
+	case SubscriptionStateExpired:
+		return pay.TradeStatusFinished
+	case SubscriptionStatePendingPurchaseCanceled:
+		return pay.TradeStatusClosed
+	}
+
+	return pay.TradeStatusWait
+}
+
+// Notification 实时开发者通知
+type Notification struct {
+	Version                    string `json:"version"`
+	PackageName                string `json:"packageName"`
+	EventTimeMillis            string `json:"eventTimeMillis"`
+	OneTimeProductNotification *struct {
+		Version          string `json:"version"`
+		NotificationType int    `json:"notificationType"` // 1 购买成功 2 取消待处理的购买
+		PurchaseToken    string `json:"purchaseToken"`
+		SKU              string `json:"sku"`
+	} `json:"oneTimeProductNotification"`
+	SubscriptionNotification *struct {
+		Version          string `json:"version"`
+		NotificationType int    `json:"notificationType"`
+		PurchaseToken    string `json:"purchaseToken"`
+		SubscriptionID   string `json:"subscriptionId"`
+	} `json:"subscriptionNotification"`
+	VoidedPurchaseNotification *struct {
+		PurchaseToken string `json:"purchaseToken"`
+		OrderID       string `json:"orderId"`
+		ProductType   int    `json:"productType"` // ProductTypeSubscription ProductTypeOneTime
+		RefundType    int    `json:"refundType"`  // RefundTypeFull RefundTypePartial
+	} `json:"voidedPurchaseNotification"`
+	TestNotification *struct {
+		Version string `json:"version"`
+	} `json:"testNotification"`
+}
+
+// ParseNotification 解析 Pub/Sub 推送的 body，message.data 为 base64 编码的通知
+func ParseNotification(body []byte) (*Notification, error) {
+	var push struct {
+		Message struct {
+			Data      string `json:"data"`
+			MessageID string `json:"messageId"`
+		} `json:"message"`
+		Subscription string `json:"subscription"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(push.Message.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var n Notification
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+// WebhookValues Pub/Sub 推送请求头和body转为 Verify 的参数
+func WebhookValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	v.Set("body", string(body))
+	return v
+}
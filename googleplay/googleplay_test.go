@@ -0,0 +1,149 @@
+package googleplay
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+const kTestPackage = "com.example.app"
+
+// newTestGooglePlay 本地 token 接口和 voidedpurchases 接口，voided 为接口返回的作废记录
+func newTestGooglePlay(t *testing.T, voided ...*VoidedPurchase) *GooglePlay {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	mux.HandleFunc(fmt.Sprintf(kPurchasesPath, kTestPackage)+"/voidedpurchases", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error":{"code":401,"message":"unauthorized"}}`, http.StatusUnauthorized)
+			return
+		}
+
+		// 每页一条，验证分页
+		var i int
+		if tok := r.URL.Query().Get("token"); len(tok) > 0 {
+			i, _ = strconv.Atoi(tok)
+		}
+
+		var rsp = map[string]interface{}{}
+		if i < len(voided) {
+			rsp["voidedPurchases"] = voided[i : i+1]
+		}
+		if i+1 < len(voided) {
+			rsp["tokenPagination"] = map[string]string{"nextPageToken": strconv.Itoa(i + 1)}
+		}
+		json.NewEncoder(w).Encode(rsp)
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	sa, _ := json.Marshal(map[string]string{
+		"client_email": "test@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+
+	p, err := New(Options{
+		PackageName:        kTestPackage,
+		ServiceAccountJSON: sa,
+		APIDomain:          s.URL,
+		TokenURL:           s.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// voidedNotice 作废通知的 Pub/Sub 推送
+func voidedNotice(packageName, purchaseToken, orderID string, refundType int) url.Values {
+	data, _ := json.Marshal(map[string]interface{}{
+		"version":         "1.0",
+		"packageName":     packageName,
+		"eventTimeMillis": strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+		"voidedPurchaseNotification": map[string]interface{}{
+			"purchaseToken": purchaseToken,
+			"orderId":       orderID,
+			"productType":   ProductTypeOneTime,
+			"refundType":    refundType,
+		},
+	})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"message":      map[string]string{"data": base64.StdEncoding.EncodeToString(data), "messageId": "1"},
+		"subscription": "projects/example/subscriptions/play",
+	})
+
+	return WebhookValues(nil, body)
+}
+
+func TestVerifyVoided(t *testing.T) {
+	p := newTestGooglePlay(t,
+		&VoidedPurchase{PurchaseToken: "t1", OrderID: "GPA.1"},
+		&VoidedPurchase{PurchaseToken: "t2", OrderID: "GPA.2", VoidedQuantity: 1},
+	)
+
+	for _, c := range []struct {
+		token, orderID string
+		refundType     int
+		status         pay.TradeStatus
+	}{
+		{"t1", "GPA.1", RefundTypeFull, pay.TradeStatusClosed},
+		{"t2", "GPA.2", RefundTypePartial, pay.TradeStatusSuccess},
+	} {
+		r, err := p.Verify(voidedNotice(kTestPackage, c.token, c.orderID, c.refundType))
+		if err != nil {
+			t.Fatalf("%s: %v", c.orderID, err)
+		}
+		if r.PaymentID != c.orderID || r.TradeStatus != c.status {
+			t.Errorf("%s: got %+v, want status %v", c.orderID, r, c.status)
+		}
+	}
+}
+
+func TestVerifyVoidedNotFound(t *testing.T) {
+	p := newTestGooglePlay(t, &VoidedPurchase{PurchaseToken: "t1", OrderID: "GPA.1"})
+
+	for _, in := range []url.Values{
+		voidedNotice(kTestPackage, "t1", "GPA.2", RefundTypeFull),
+		voidedNotice(kTestPackage, "t2", "GPA.1", RefundTypeFull),
+	} {
+		if _, err := p.Verify(in); err != ErrVoidedNotFound {
+			t.Errorf("got %v, want %v", err, ErrVoidedNotFound)
+		}
+	}
+
+	if _, err := p.Verify(voidedNotice("com.example.other", "t1", "GPA.1", RefundTypeFull)); err != ErrPackageNameMismatch {
+		t.Errorf("got %v, want %v", err, ErrPackageNameMismatch)
+	}
+}
+
+func TestClient(t *testing.T) {
+	if p := newTestGooglePlay(t); p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+}
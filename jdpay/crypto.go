@@ -0,0 +1,170 @@
+package jdpay
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	// ErrInvalidDesKey DesKey 不是 base64 编码的24字节密钥
+	ErrInvalidDesKey = errors.New("jdpay: invalid des key")
+	// ErrInvalidPrivateKey 商户私钥无法解析
+	ErrInvalidPrivateKey = errors.New("jdpay: invalid private key")
+	// ErrInvalidPublicKey 京东公钥无法解析
+	ErrInvalidPublicKey = errors.New("jdpay: invalid public key")
+	// ErrInvalidCiphertext 密文格式错误
+	ErrInvalidCiphertext = errors.New("jdpay: invalid ciphertext")
+)
+
+var signRegexp = regexp.MustCompile(`<sign>[^<]*</sign>`)
+
+func newDesCipher(desKey string) (cipher.Block, error) {
+	key, err := base64.StdEncoding.DecodeString(desKey)
+	if err != nil || len(key) != 24 {
+		return nil, ErrInvalidDesKey
+	}
+
+	return des.NewTripleDESCipher(key)
+}
+
+// encrypt 3DES ECB 加密，明文前加4字节长度，补0到8字节整数倍，返回16进制
+func encrypt(block cipher.Block, data []byte) string {
+	var size = 4 + len(data)
+	if size%block.BlockSize() != 0 {
+		size += block.BlockSize() - size%block.BlockSize()
+	}
+
+	src := make([]byte, size)
+	binary.BigEndian.PutUint32(src, uint32(len(data)))
+	copy(src[4:], data)
+
+	dst := make([]byte, size)
+	for i := 0; i < size; i += block.BlockSize() {
+		block.Encrypt(dst[i:], src[i:])
+	}
+
+	return hex.EncodeToString(dst)
+}
+
+// decrypt encrypt 的逆过程
+func decrypt(block cipher.Block, ciphertext string) ([]byte, error) {
+	src, err := hex.DecodeString(ciphertext)
+	if err != nil || len(src) < block.BlockSize() || len(src)%block.BlockSize() != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	dst := make([]byte, len(src))
+	for i := 0; i < len(src); i += block.BlockSize() {
+		block.Decrypt(dst[i:], src[i:])
+	}
+
+	n := int(binary.BigEndian.Uint32(dst))
+	if n > len(dst)-4 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return dst[4 : 4+n], nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	return pk, nil
+}
+
+func parsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return pub, nil
+}
+
+// sign sha256 摘要的16进制字符串用私钥加密，返回 base64
+func (p *Jdpay) sign(src string) (string, error) {
+	digest := sha256.Sum256([]byte(src))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.privateKey, crypto.Hash(0), []byte(hex.EncodeToString(digest[:])))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// verifyXML 去掉 <sign> 节点后验证签名
+func (p *Jdpay) verifyXML(data []byte) error {
+	m := signRegexp.Find(data)
+	if m == nil {
+		return pay.ErrVerify
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(string(m), "<sign>"), "</sign>"))
+	if err != nil {
+		return pay.ErrVerify
+	}
+
+	digest := sha256.Sum256(signRegexp.ReplaceAll(data, nil))
+	if rsa.VerifyPKCS1v15(p.publicKey, crypto.Hash(0), []byte(hex.EncodeToString(digest[:])), sig) != nil {
+		return pay.ErrVerify
+	}
+
+	return nil
+}
+
+// signValues 除 sign 外的参数按 key 排序拼接后签名
+func (p *Jdpay) signValues(vals url.Values) (string, error) {
+	var pList = make([]string, 0, len(vals))
+	for k := range vals {
+		if k == "sign" {
+			continue
+		}
+		pList = append(pList, k+"="+vals.Get(k))
+	}
+	sort.Strings(pList)
+
+	return p.sign(strings.Join(pList, "&"))
+}
@@ -0,0 +1,417 @@
+// Package jdpay 京东支付 V2.0，报文 RSA 签名后 3DES 加密
+// https://payapi.jd.com/
+package jdpay
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payer = &Jdpay{}
+
+const (
+	kAPIDomain = "https://paygate.jd.com"
+	kH5PayURL  = "https://h5pay.jd.com/jdpay/saveOrder"
+
+	kUniorder = "/service/uniorder"
+
+	kVersion      = "V2.0"
+	kCurrencyCNY  = "CNY"
+	kOrderType    = "1" // 虚拟商品
+	kSuccessCode  = "000000"
+	kTradeTypeGEN = "GEN" // 普通支付，APP
+	kTradeTypeQR  = "QR"  // 二维码支付
+
+	kXMLHeader = `<?xml version="1.0" encoding="UTF-8"?>`
+
+	kProvider = "jdpay" // pay.Error.Provider
+
+	kTimeout = 10 * time.Second
+)
+
+// 交易状态
+const (
+	StatusCreated    = "0"
+	StatusProcessing = "1"
+	StatusSuccess    = "2"
+	StatusFailed     = "3"
+	StatusCanceled   = "4"
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+// Options Options
+type Options struct {
+	Merchant    string       // 商户号
+	DesKey      string       // 3DES 密钥 base64
+	PrivateKey  string       // 商户 RSA 私钥 PEM
+	JDPublicKey string       // 京东 RSA 公钥 PEM
+	NotifyURL   string       // 异步回调地址
+	ReturnURL   string       // H5 支付完成跳转地址
+	APIDomain   string       // 接口域名，为空时使用 https://paygate.jd.com
+	H5PayURL    string       // H5 收银台地址，为空时使用 https://h5pay.jd.com/jdpay/saveOrder
+	Client      *http.Client // 为空时使用超时 10s 的 http.Client
+}
+
+// Jdpay Jdpay
+type Jdpay struct {
+	opt Options
+
+	des        cipher.Block
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+}
+
+// New New
+func New(opt Options) (*Jdpay, error) {
+	block, err := newDesCipher(opt.DesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := parsePrivateKey(opt.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := parsePublicKey(opt.JDPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	p := &Jdpay{
+		opt:        opt,
+		des:        block,
+		privateKey: pk,
+		publicKey:  pub,
+	}
+
+	return p, nil
+}
+
+// Verify 支付回调解密并验证签名,成功返回回调参数，in 为 WebhookValues 的返回值
+func (p *Jdpay) Verify(in url.Values) (*pay.NoticeParams, error) {
+	data, err := p.decode([]byte(in.Get("body")))
+	if err != nil {
+		return nil, err
+	}
+
+	var notice struct {
+		TradeNum string `xml:"tradeNum"`
+		Amount   int32  `xml:"amount"`
+		Status   string `xml:"status"`
+		OrderID  string `xml:"orderId"`
+	}
+	if err := xml.Unmarshal(data, &notice); err != nil {
+		return nil, err
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     notice.TradeNum,
+		PaymentID:   notice.OrderID,
+		TradeStatus: TradeStatus(notice.Status),
+		Amount:      notice.Amount,
+	}, nil
+}
+
+// Success 回调成功返回数据
+func (p *Jdpay) Success() string {
+	return "success"
+}
+
+// Call 调起支付用到的数据
+// qrcode -> 二维码地址
+// app -> 调起京东支付SDK用到的参数json
+// wap -> 自动提交form表单 html
+func (p *Jdpay) Call(way pay.Way, in pay.Order) (string, error) {
+	switch way {
+	case pay.WayQrcode:
+		rsp, err := p.uniorder(in, kTradeTypeQR)
+		if err != nil {
+			return "", err
+		}
+		return rsp.QrCode, nil
+	case pay.WayApp:
+		rsp, err := p.uniorder(in, kTradeTypeGEN)
+		if err != nil {
+			return "", err
+		}
+		return p.appParams(rsp.OrderID)
+	case pay.WayWap:
+		return p.wapCall(in)
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+// uniorderReq 统一下单请求报文
+type uniorderReq struct {
+	XMLName   xml.Name `xml:"jdpay"`
+	Version   string   `xml:"version"`
+	Merchant  string   `xml:"merchant"`
+	TradeNum  string   `xml:"tradeNum"`
+	TradeName string   `xml:"tradeName"`
+	TradeTime string   `xml:"tradeTime"`
+	Amount    int32    `xml:"amount"`
+	OrderType string   `xml:"orderType"`
+	Currency  string   `xml:"currency"`
+	NotifyURL string   `xml:"notifyUrl"`
+	TradeType string   `xml:"tradeType"`
+	IP        string   `xml:"ip,omitempty"`
+	Sign      string   `xml:"sign,omitempty"`
+}
+
+// uniorderRsp 统一下单返回报文
+type uniorderRsp struct {
+	Result struct {
+		Code string `xml:"code"`
+		Desc string `xml:"desc"`
+	} `xml:"result"`
+	OrderID  string `xml:"orderId"`
+	TradeNum string `xml:"tradeNum"`
+	QrCode   string `xml:"qrCode"`
+}
+
+// uniorder 统一下单
+func (p *Jdpay) uniorder(in pay.Order, tradeType string) (*uniorderRsp, error) {
+	req := uniorderReq{
+		Version:   kVersion,
+		Merchant:  p.opt.Merchant,
+		TradeNum:  in.ID,
+		TradeName: in.Title,
+		TradeTime: time.Now().In(cst).Format("20060102150405"),
+		Amount:    in.Amount,
+		OrderType: kOrderType,
+		Currency:  kCurrencyCNY,
+		NotifyURL: p.opt.NotifyURL,
+		TradeType: tradeType,
+		IP:        in.IP,
+	}
+
+	body, err := p.encode(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := kAPIDomain
+	if len(p.opt.APIDomain) > 0 {
+		domain = strings.TrimSuffix(p.opt.APIDomain, "/")
+	}
+
+	resp, err := p.opt.Client.Post(domain+kUniorder, "application/xml;charset=utf-8", bytes.NewReader(body))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	plain, err := p.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rsp uniorderRsp
+	if err := xml.Unmarshal(plain, &rsp); err != nil {
+		return nil, err
+	}
+
+	if rsp.Result.Code != kSuccessCode {
+		return nil, newError(rsp.Result.Code, rsp.Result.Desc)
+	}
+
+	return &rsp, nil
+}
+
+// appParams 京东支付SDK参数，signData 为 merchant orderId 的签名
+func (p *Jdpay) appParams(orderID string) (string, error) {
+	var v = url.Values{}
+	v.Set("merchant", p.opt.Merchant)
+	v.Set("orderId", orderID)
+
+	sign, err := p.signValues(v)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := json.Marshal(map[string]string{
+		"merchant": p.opt.Merchant,
+		"orderId":  orderID,
+		"signData": sign,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
+// wapCall H5 收银台，除 version merchant sign 外的参数 3DES 加密
+func (p *Jdpay) wapCall(in pay.Order) (string, error) {
+	var v = url.Values{}
+	v.Set("version", kVersion)
+	v.Set("merchant", p.opt.Merchant)
+	v.Set("tradeNum", in.ID)
+	v.Set("tradeName", in.Title)
+	v.Set("tradeTime", time.Now().In(cst).Format("20060102150405"))
+	v.Set("amount", strconv.Itoa(int(in.Amount)))
+	v.Set("orderType", kOrderType)
+	v.Set("currency", kCurrencyCNY)
+	v.Set("callbackUrl", p.opt.ReturnURL)
+	v.Set("notifyUrl", p.opt.NotifyURL)
+
+	sign, err := p.signValues(v)
+	if err != nil {
+		return "", err
+	}
+
+	var keys = make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+		if k != "version" && k != "merchant" {
+			v.Set(k, encrypt(p.des, []byte(v.Get(k))))
+		}
+	}
+	v.Set("sign", sign)
+	keys = append(keys, "sign")
+	sort.Strings(keys)
+
+	action := kH5PayURL
+	if len(p.opt.H5PayURL) > 0 {
+		action = p.opt.H5PayURL
+	}
+
+	var b strings.Builder
+	b.WriteString(`<form id="jdpaysubmit" name="jdpaysubmit" action="`)
+	b.WriteString(html.EscapeString(action))
+	b.WriteString(`" method="post">`)
+	for _, k := range keys {
+		fmt.Fprintf(&b, `<input type="hidden" name="%s" value="%s"/>`, html.EscapeString(k), html.EscapeString(v.Get(k)))
+	}
+	b.WriteString(`</form><script>document.forms['jdpaysubmit'].submit();</script>`)
+
+	return b.String(), nil
+}
+
+// envelope 加密报文
+type envelope struct {
+	XMLName  xml.Name `xml:"jdpay"`
+	Version  string   `xml:"version"`
+	Merchant string   `xml:"merchant"`
+	Result   *struct {
+		Code string `xml:"code"`
+		Desc string `xml:"desc"`
+	} `xml:"result,omitempty"`
+	Encrypt string `xml:"encrypt"`
+}
+
+// encode 报文签名后加密，sign 为不含 sign 节点的报文签名
+func (p *Jdpay) encode(req *uniorderReq) ([]byte, error) {
+	req.Sign = ""
+	plain, err := xml.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Sign, err = p.sign(kXMLHeader + string(plain)); err != nil {
+		return nil, err
+	}
+
+	if plain, err = xml.Marshal(req); err != nil {
+		return nil, err
+	}
+
+	d, err := xml.Marshal(envelope{
+		Version:  kVersion,
+		Merchant: p.opt.Merchant,
+		Encrypt:  base64.StdEncoding.EncodeToString([]byte(encrypt(p.des, append([]byte(kXMLHeader), plain...)))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(kXMLHeader), d...), nil
+}
+
+// decode 解密报文并验证签名，返回明文
+func (p *Jdpay) decode(data []byte) ([]byte, error) {
+	var env envelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+
+	if len(env.Encrypt) == 0 {
+		if env.Result != nil && env.Result.Code != kSuccessCode {
+			return nil, newError(env.Result.Code, env.Result.Desc)
+		}
+		return nil, ErrInvalidCiphertext
+	}
+
+	hexText, err := base64.StdEncoding.DecodeString(env.Encrypt)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plain, err := decrypt(p.des, string(hexText))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.verifyXML(plain); err != nil {
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+// newError 返回码不为 000000 的错误
+func newError(code, desc string) *pay.Error {
+	return &pay.Error{
+		Provider: kProvider,
+		Code:     code,
+		Message:  desc,
+	}
+}
+
+// TradeStatus 交易状态转支付状态
+func TradeStatus(status string) pay.TradeStatus {
+	switch status {
+	case StatusSuccess:
+		return pay.TradeStatusSuccess
+	case StatusFailed, StatusCanceled:
+		return pay.TradeStatusClosed
+	}
+
+	return pay.TradeStatusWait
+}
+
+// WebhookValues 回调请求头和body转为 Verify 的参数
+func WebhookValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	v.Set("body", string(body))
+	return v
+}
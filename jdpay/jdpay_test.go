@@ -0,0 +1,174 @@
+package jdpay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+const (
+	kTestMerchant = "110000000001"
+	kTestDesKey   = "mUH4aT/A5G0drQcu65YjP4fMt93+Et8F"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	d, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// newTestJdpay 商户侧使用商户私钥和京东公钥，jd 为 true 时返回模拟京东侧的实例，用于解密验签商户请求
+func newTestJdpay(t *testing.T, domain string, jd bool) *Jdpay {
+	t.Helper()
+
+	privateKey, publicKey := "merchant_private_key.pem", "jd_public_key.pem"
+	if jd {
+		privateKey, publicKey = "jd_private_key.pem", "merchant_public_key.pem"
+	}
+
+	p, err := New(Options{
+		Merchant:    kTestMerchant,
+		DesKey:      kTestDesKey,
+		PrivateKey:  string(readFixture(t, privateKey)),
+		JDPublicKey: string(readFixture(t, publicKey)),
+		APIDomain:   domain,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	p := newTestJdpay(t, "", false)
+
+	r, err := p.Verify(WebhookValues(nil, readFixture(t, "notify.xml")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.OrderID != "20190701000001" || r.Amount != 101 || r.TradeStatus != pay.TradeStatusSuccess || len(r.PaymentID) == 0 {
+		t.Errorf("notice %+v", r)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	p := newTestJdpay(t, "", false)
+
+	var env envelope
+	if err := xml.Unmarshal(readFixture(t, "notify.xml"), &env); err != nil {
+		t.Fatal(err)
+	}
+	hexText, _ := base64.StdEncoding.DecodeString(env.Encrypt)
+	plain, err := decrypt(p.des, string(hexText))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 改金额后重新加密，签名不变
+	plain = bytes.Replace(plain, []byte("<amount>101</amount>"), []byte("<amount>1</amount>"), 1)
+	env.Encrypt = base64.StdEncoding.EncodeToString([]byte(encrypt(p.des, plain)))
+	body, _ := xml.Marshal(env)
+
+	if _, err := p.Verify(WebhookValues(nil, body)); err != pay.ErrVerify {
+		t.Errorf("got %v, want %v", err, pay.ErrVerify)
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	jd := newTestJdpay(t, "", true)
+
+	plain, err := jd.decode(readFixture(t, "uniorder_request.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req uniorderReq
+	if err := xml.Unmarshal(plain, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Merchant != kTestMerchant || req.TradeType != kTradeTypeQR || len(req.TradeNum) == 0 {
+		t.Errorf("request %+v", req)
+	}
+}
+
+func TestCallQrcode(t *testing.T) {
+	var (
+		jd   = newTestJdpay(t, "", true)
+		rsp  = readFixture(t, "uniorder_response.xml")
+		req  uniorderReq
+		rerr error
+	)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var plain []byte
+		if plain, rerr = jd.decode(body); rerr == nil {
+			rerr = xml.Unmarshal(plain, &req)
+		}
+		w.Write(rsp)
+	}))
+	defer s.Close()
+
+	p := newTestJdpay(t, s.URL, false)
+
+	qrCode, err := p.Call(pay.WayQrcode, pay.Order{ID: "20190701000001", Title: "test", Amount: 101, IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qrCode) == 0 {
+		t.Error("empty qrCode")
+	}
+
+	if rerr != nil {
+		t.Fatalf("request: %v", rerr)
+	}
+	if req.TradeNum != "20190701000001" || req.Amount != 101 || req.TradeType != kTradeTypeQR {
+		t.Errorf("request %+v", req)
+	}
+}
+
+func TestError(t *testing.T) {
+	// 报文无法解密或验签时京东返回不加密的 result
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(kXMLHeader + `<jdpay><version>V2.0</version><merchant>110000000001</merchant><result><code>TDP404</code><desc>验签失败</desc></result></jdpay>`))
+	}))
+	defer s.Close()
+
+	_, err := newTestJdpay(t, s.URL, false).Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	if e, ok := err.(*pay.Error); !ok || e.Provider != kProvider || e.Code != "TDP404" || e.Message != "验签失败" {
+		t.Errorf("got %T %v, want *pay.Error", err, err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	if p := newTestJdpay(t, "", false); p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	p := newTestJdpay(t, s.URL, false)
+	p.opt.Client = &http.Client{Timeout: 10 * time.Millisecond}
+
+	_, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	if e, ok := err.(*pay.Error); !ok || !e.Retryable || e.Category != pay.CategorySystem {
+		t.Errorf("got %v, want a retryable network error", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package metrics 支付操作的 Prometheus 指标
+//
+// Middleware 统计经过 pay.Wrap 的每次操作，按支付平台、操作名、支付方式、结果和错误分类打标签，
+// 支付回调验证成功后按交易状态统计回调数
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const kNamespace = "pay"
+
+// 结果标签
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Options Options
+type Options struct {
+	Namespace  string                // 指标前缀，默认 pay
+	Registerer prometheus.Registerer // 默认 prometheus.DefaultRegisterer
+	Buckets    []float64             // 耗时分布，单位秒，默认 prometheus.DefBuckets
+}
+
+// Metrics 支付指标
+//
+//	pay_operations_total{provider,operation,way,outcome,category}
+//	pay_operation_duration_seconds{provider,operation,way,outcome}
+//	pay_notifications_total{provider,trade_status,outcome,category}
+type Metrics struct {
+	operations    *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	notifications *prometheus.CounterVec
+}
+
+// New 创建并注册指标
+func New(opt Options) (*Metrics, error) {
+	if len(opt.Namespace) == 0 {
+		opt.Namespace = kNamespace
+	}
+	if opt.Registerer == nil {
+		opt.Registerer = prometheus.DefaultRegisterer
+	}
+	if len(opt.Buckets) == 0 {
+		opt.Buckets = prometheus.DefBuckets
+	}
+
+	m := &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opt.Namespace,
+			Name:      "operations_total",
+			Help:      "Payment operations by provider, operation, way, outcome and error category.",
+		}, []string{"provider", "operation", "way", "outcome", "category"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opt.Namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Payment operation latency in seconds.",
+			Buckets:   opt.Buckets,
+		}, []string{"provider", "operation", "way", "outcome"}),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opt.Namespace,
+			Name:      "notifications_total",
+			Help:      "Payment notifications by provider, trade status, outcome and error category.",
+		}, []string{"provider", "trade_status", "outcome", "category"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.operations, m.duration, m.notifications} {
+		if err := opt.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Wrap 用 Middleware 和 mws 包装 Payer，Middleware 在最外层
+func (m *Metrics) Wrap(provider string, p pay.Payer, mws ...pay.Middleware) *pay.Wrapped {
+	return pay.Wrap(provider, p, append([]pay.Middleware{m.Middleware()}, mws...)...)
+}
+
+// Middleware 统计操作次数和耗时，回调验证另外经 ObserveNotice 统计
+func (m *Metrics) Middleware() pay.Middleware {
+	return func(next pay.Handler) pay.Handler {
+		return func(inv *pay.Invocation) (interface{}, error) {
+			start := time.Now()
+			res, err := next(inv)
+
+			m.Observe(inv, time.Since(start), err)
+
+			if inv.Operation == pay.OpVerify {
+				params, _ := res.(*pay.NoticeParams)
+				m.ObserveNotice(inv.Provider, params, err)
+			}
+
+			return res, err
+		}
+	}
+}
+
+// Observe 记录一次操作
+func (m *Metrics) Observe(inv *pay.Invocation, d time.Duration, err error) {
+	outcome := Outcome(err)
+
+	m.operations.WithLabelValues(inv.Provider, string(inv.Operation), string(inv.Way), outcome, Category(err)).Inc()
+	m.duration.WithLabelValues(inv.Provider, string(inv.Operation), string(inv.Way), outcome).Observe(d.Seconds())
+}
+
+// ObserveNotice 记录一次回调处理，params 为验证后的回调参数，err 为验证或业务处理的错误
+func (m *Metrics) ObserveNotice(provider string, params *pay.NoticeParams, err error) {
+	var status string
+	if params != nil {
+		status = params.TradeStatus.String()
+	}
+
+	m.notifications.WithLabelValues(provider, status, Outcome(err), Category(err)).Inc()
+}
+
+// Outcome 结果标签
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// Category 错误分类标签，成功时为空
+// *pay.Error 为 Category.String()，熔断、未实现和验签失败等已知错误有单独的值，包装过的错误按 errors.Is errors.As 判断
+func Category(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch {
+	case errors.Is(err, pay.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, pay.ErrNotSupported):
+		return "not_supported"
+	case errors.Is(err, pay.ErrVerify):
+		return pay.CategoryAuth.String()
+	case errors.Is(err, pay.ErrOrderNotExist):
+		return pay.CategoryParam.String()
+	}
+
+	var pe *pay.PanicError
+	if errors.As(err, &pe) {
+		return "panic"
+	}
+
+	var e *pay.Error
+	if errors.As(err, &e) {
+		return e.Category.String()
+	}
+
+	return pay.CategoryUnknown.String()
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCategory(t *testing.T) {
+	var cases = []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{pay.ErrCircuitOpen, "circuit_open"},
+		{fmt.Errorf("refund: %w", pay.ErrNotSupported), "not_supported"},
+		{fmt.Errorf("notify: %w", pay.ErrVerify), pay.CategoryAuth.String()},
+		{fmt.Errorf("call: %w", &pay.Error{Category: pay.CategorySystem}), pay.CategorySystem.String()},
+		{fmt.Errorf("call: %w", &pay.PanicError{Value: "boom"}), "panic"},
+		{fmt.Errorf("unknown"), pay.CategoryUnknown.String()},
+	}
+
+	for _, c := range cases {
+		if got := Category(c.err); got != c.want {
+			t.Errorf("Category(%v) = %q, want %q", c.err, got, c.want)
+		}
+	}
+}
+
+// fakePayer Call 订单号为 FAIL 时返回余额不足，Verify sign 不为 ok 时验签失败
+type fakePayer struct{}
+
+func (fakePayer) Verify(in url.Values) (*pay.NoticeParams, error) {
+	if in.Get("sign") != "ok" {
+		return nil, pay.ErrVerify
+	}
+	return &pay.NoticeParams{OrderID: in.Get("out_trade_no"), TradeStatus: pay.TradeStatusSuccess}, nil
+}
+
+func (fakePayer) Success() string { return "success" }
+
+func (fakePayer) Call(way pay.Way, in pay.Order) (string, error) {
+	if in.ID == "FAIL" {
+		return "", &pay.Error{Code: "FAIL", SubCode: "NOTENOUGH", Category: pay.CategoryBalance}
+	}
+	return "code_url", nil
+}
+
+func TestMiddleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(Options{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := m.Wrap("fake", fakePayer{})
+
+	for _, id := range []string{"O1", "O2", "FAIL"} {
+		p.Call(pay.WayQrcode, pay.Order{ID: id})
+	}
+	p.Verify(url.Values{"out_trade_no": {"O1"}, "sign": {"ok"}})
+	p.Verify(url.Values{"sign": {"bad"}})
+
+	var cases = []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{m.operations.WithLabelValues("fake", "call", "qrcode", OutcomeSuccess, ""), 2},
+		{m.operations.WithLabelValues("fake", "call", "qrcode", OutcomeError, pay.CategoryBalance.String()), 1},
+		{m.operations.WithLabelValues("fake", "verify", "", OutcomeSuccess, ""), 1},
+		{m.operations.WithLabelValues("fake", "verify", "", OutcomeError, pay.CategoryAuth.String()), 1},
+		{m.notifications.WithLabelValues("fake", pay.TradeStatusSuccess.String(), OutcomeSuccess, ""), 1},
+		{m.notifications.WithLabelValues("fake", "", OutcomeError, pay.CategoryAuth.String()), 1},
+	}
+	for i, c := range cases {
+		if got := testutil.ToFloat64(c.c); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+
+	// 每个 provider operation way outcome 一个耗时分布
+	if n := testutil.CollectAndCount(m.duration); n != 4 {
+		t.Errorf("%d duration series, want 4", n)
+	}
+	if n := testutil.CollectAndCount(m.operations); n != 4 {
+		t.Errorf("%d operation series, want 4", n)
+	}
+}
+
+func TestObserveNotice(t *testing.T) {
+	m, err := New(Options{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 业务处理失败时按回调的交易状态和错误分类统计
+	params := &pay.NoticeParams{OrderID: "O1", TradeStatus: pay.TradeStatusClosed}
+	m.ObserveNotice("alipay", params, &pay.Error{Category: pay.CategorySystem})
+	m.ObserveNotice("alipay", params, nil)
+
+	if got := testutil.ToFloat64(m.notifications.WithLabelValues("alipay", pay.TradeStatusClosed.String(), OutcomeError, pay.CategorySystem.String())); got != 1 {
+		t.Errorf("error notices %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.notifications.WithLabelValues("alipay", pay.TradeStatusClosed.String(), OutcomeSuccess, "")); got != 1 {
+		t.Errorf("success notices %v, want 1", got)
+	}
+}
+
+func TestNewRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(Options{Namespace: "shop", Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ObserveNotice("alipay", nil, nil)
+	m.Observe(&pay.Invocation{Provider: "alipay", Operation: pay.OpRefund}, time.Millisecond, nil)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	sort.Strings(names)
+	want := []string{"shop_notifications_total", "shop_operation_duration_seconds", "shop_operations_total"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("metrics %v, want %v", names, want)
+	}
+
+	// 同一 Registerer 重复注册返回错误
+	if _, err := New(Options{Namespace: "shop", Registerer: reg}); err == nil {
+		t.Error("registered twice")
+	}
+}
@@ -0,0 +1,440 @@
+package pay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"runtime/debug"
+	"time"
+)
+
+// Operation 操作名
+type Operation string
+
+// 操作名
+const (
+	OpCall            Operation = "call"
+	OpVerify          Operation = "verify"
+	OpRefund          Operation = "refund"
+	OpQuery           Operation = "query"
+	OpClose           Operation = "close"
+	OpSignAgreement   Operation = "sign_agreement"
+	OpVerifyAgreement Operation = "verify_agreement"
+	OpUnsign          Operation = "unsign"
+	OpCharge          Operation = "charge"
+	OpFreeze          Operation = "freeze"
+	OpVerifyFreeze    Operation = "verify_freeze"
+	OpCapture         Operation = "capture"
+	OpUnfreeze        Operation = "unfreeze"
+	OpCancelFreeze    Operation = "cancel_freeze"
+	OpQueryFreeze     Operation = "query_freeze"
+	OpTransfer        Operation = "transfer"
+	OpQueryTransfer   Operation = "query_transfer"
+)
+
+var (
+	// ErrNotSupported 支付平台未实现该操作
+	ErrNotSupported = errors.New("operation not supported")
+)
+
+// Invocation 一次操作，除 Context 外中间件只读
+type Invocation struct {
+	Context   context.Context // 中间件可以替换后传给下一层，实现了 ContextPayer 的 Payer 用它发出请求
+	Operation Operation
+	Provider  string
+	Way       Way         // Call SignAgreement Freeze 的支付方式
+	OrderID   string      // 商户单号：订单号、退款单号、签约号、授权单号或付款单号，回调验证为空
+	Request   interface{} // 请求参数 Order RefundOrder PayoutOrder url.Values 等
+}
+
+// Handler 执行操作，返回操作的结果
+type Handler func(inv *Invocation) (interface{}, error)
+
+// Middleware 包装 Handler，可以在操作前后记录日志、统计、限流，或者直接返回错误不执行操作
+type Middleware func(next Handler) Handler
+
+// Chain 组合中间件，第一个在最外层
+func Chain(mws ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// ContextPayer 可以绑定 context 的 Payer，WithContext 返回的 Payer 请求支付平台时使用 ctx，用于链路追踪和取消
+type ContextPayer interface {
+	WithContext(ctx context.Context) Payer
+}
+
+// Supports p 是否支持操作 op，Wrapped 等实现了 Payer() Payer 的包装按被包装的 Payer 判断
+// Verify Call 总是支持
+func Supports(p Payer, op Operation) bool {
+	for {
+		w, ok := p.(interface{ Payer() Payer })
+		if !ok {
+			break
+		}
+		p = w.Payer()
+	}
+
+	var ok bool
+	switch op {
+	case OpRefund:
+		_, ok = p.(Refunder)
+	case OpQuery, OpClose:
+		_, ok = p.(Querier)
+	case OpSignAgreement, OpVerifyAgreement, OpUnsign, OpCharge:
+		_, ok = p.(Agreement)
+	case OpFreeze, OpVerifyFreeze, OpCapture, OpUnfreeze, OpCancelFreeze, OpQueryFreeze:
+		_, ok = p.(PreAuth)
+	case OpTransfer, OpQueryTransfer:
+		_, ok = p.(Payout)
+	default:
+		ok = true
+	}
+
+	return ok
+}
+
+var (
+	_ Payer        = &Wrapped{}
+	_ ContextPayer = &Wrapped{}
+	_ Refunder     = &Wrapped{}
+	_ Querier      = &Wrapped{}
+	_ Agreement    = &Wrapped{}
+	_ PreAuth      = &Wrapped{}
+	_ Payout       = &Wrapped{}
+)
+
+// Wrapped 经过中间件调用的 Payer，同时实现 Refunder Querier Agreement PreAuth Payout
+// 底层 Payer 未实现的操作返回 ErrNotSupported，也经过中间件，调用前用 Supports 判断是否支持
+type Wrapped struct {
+	Provider string
+
+	payer Payer
+	chain Middleware
+	ctx   context.Context
+}
+
+// Wrap 用中间件包装 Payer，provider 为 Invocation.Provider
+func Wrap(provider string, p Payer, mws ...Middleware) *Wrapped {
+	return &Wrapped{
+		Provider: provider,
+		payer:    p,
+		chain:    Chain(mws...),
+	}
+}
+
+// Payer 被包装的 Payer
+func (w *Wrapped) Payer() Payer {
+	return w.payer
+}
+
+// WithContext 实现 ContextPayer，返回 BindContext(ctx)，Wrapped 再被包装时外层的 context 传到内层
+func (w *Wrapped) WithContext(ctx context.Context) Payer {
+	return w.BindContext(ctx)
+}
+
+// BindContext 返回绑定 ctx 的副本，之后的操作以 ctx 作为 Invocation.Context
+// 在回调或接口的 handler 中使用请求的 context，链路追踪可以从 handler 延续到支付平台请求
+func (w *Wrapped) BindContext(ctx context.Context) *Wrapped {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	c := *w
+	c.ctx = ctx
+	return &c
+}
+
+// Context 绑定的 context，默认 context.Background()
+func (w *Wrapped) Context() context.Context {
+	if w.ctx != nil {
+		return w.ctx
+	}
+	return context.Background()
+}
+
+// invoke 经过中间件执行 fn，p 为绑定了中间件处理后的 Invocation.Context 的 Payer
+func (w *Wrapped) invoke(inv *Invocation, fn func(p Payer) (interface{}, error)) (interface{}, error) {
+	inv.Provider = w.Provider
+	inv.Context = w.Context()
+
+	return w.chain(func(inv *Invocation) (interface{}, error) {
+		var p = w.payer
+		if c, ok := p.(ContextPayer); ok && inv.Context != nil {
+			p = c.WithContext(inv.Context)
+		}
+		return fn(p)
+	})(inv)
+}
+
+// Verify Verify
+func (w *Wrapped) Verify(in url.Values) (*NoticeParams, error) {
+	res, err := w.invoke(&Invocation{Operation: OpVerify, Request: in}, func(p Payer) (interface{}, error) {
+		return p.Verify(in)
+	})
+	r, _ := res.(*NoticeParams)
+	return r, err
+}
+
+// Success 不经过中间件
+func (w *Wrapped) Success() string {
+	return w.payer.Success()
+}
+
+// Call Call
+func (w *Wrapped) Call(way Way, in Order) (string, error) {
+	res, err := w.invoke(&Invocation{Operation: OpCall, Way: way, OrderID: in.ID, Request: in}, func(p Payer) (interface{}, error) {
+		return p.Call(way, in)
+	})
+	r, _ := res.(string)
+	return r, err
+}
+
+// Refund Refund
+func (w *Wrapped) Refund(in RefundOrder) (*RefundResult, error) {
+	res, err := w.invoke(&Invocation{Operation: OpRefund, OrderID: in.ID, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Refunder)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Refund(in)
+	})
+	r, _ := res.(*RefundResult)
+	return r, err
+}
+
+// Query Query
+func (w *Wrapped) Query(orderID string) (*NoticeParams, error) {
+	res, err := w.invoke(&Invocation{Operation: OpQuery, OrderID: orderID, Request: orderID}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Querier)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Query(orderID)
+	})
+	r, _ := res.(*NoticeParams)
+	return r, err
+}
+
+// Close Close
+func (w *Wrapped) Close(orderID string) error {
+	_, err := w.invoke(&Invocation{Operation: OpClose, OrderID: orderID, Request: orderID}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Querier)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return nil, q.Close(orderID)
+	})
+	return err
+}
+
+// SignAgreement SignAgreement
+func (w *Wrapped) SignAgreement(way Way, in AgreementOrder) (string, error) {
+	res, err := w.invoke(&Invocation{Operation: OpSignAgreement, Way: way, OrderID: in.ExternalID, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Agreement)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.SignAgreement(way, in)
+	})
+	r, _ := res.(string)
+	return r, err
+}
+
+// VerifyAgreement VerifyAgreement
+func (w *Wrapped) VerifyAgreement(in url.Values) (*AgreementNotice, error) {
+	res, err := w.invoke(&Invocation{Operation: OpVerifyAgreement, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Agreement)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.VerifyAgreement(in)
+	})
+	r, _ := res.(*AgreementNotice)
+	return r, err
+}
+
+// Unsign Unsign
+func (w *Wrapped) Unsign(agreementID string) error {
+	_, err := w.invoke(&Invocation{Operation: OpUnsign, Request: agreementID}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Agreement)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return nil, q.Unsign(agreementID)
+	})
+	return err
+}
+
+// Charge Charge
+func (w *Wrapped) Charge(agreementID string, in Order) (*NoticeParams, error) {
+	res, err := w.invoke(&Invocation{Operation: OpCharge, OrderID: in.ID, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Agreement)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Charge(agreementID, in)
+	})
+	r, _ := res.(*NoticeParams)
+	return r, err
+}
+
+// Freeze Freeze
+func (w *Wrapped) Freeze(way Way, in FreezeOrder) (string, error) {
+	res, err := w.invoke(&Invocation{Operation: OpFreeze, Way: way, OrderID: in.OutOrderNo, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Freeze(way, in)
+	})
+	r, _ := res.(string)
+	return r, err
+}
+
+// VerifyFreeze VerifyFreeze
+func (w *Wrapped) VerifyFreeze(in url.Values) (*FreezeResult, error) {
+	res, err := w.invoke(&Invocation{Operation: OpVerifyFreeze, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.VerifyFreeze(in)
+	})
+	r, _ := res.(*FreezeResult)
+	return r, err
+}
+
+// Capture Capture
+func (w *Wrapped) Capture(in CaptureOrder) (*NoticeParams, error) {
+	res, err := w.invoke(&Invocation{Operation: OpCapture, OrderID: in.OutTradeNo, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Capture(in)
+	})
+	r, _ := res.(*NoticeParams)
+	return r, err
+}
+
+// Unfreeze Unfreeze
+func (w *Wrapped) Unfreeze(in UnfreezeOrder) error {
+	_, err := w.invoke(&Invocation{Operation: OpUnfreeze, OrderID: in.OutOrderNo, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return nil, q.Unfreeze(in)
+	})
+	return err
+}
+
+// CancelFreeze CancelFreeze
+func (w *Wrapped) CancelFreeze(outOrderNo, outRequestNo, remark string) error {
+	_, err := w.invoke(&Invocation{Operation: OpCancelFreeze, OrderID: outOrderNo, Request: outRequestNo}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return nil, q.CancelFreeze(outOrderNo, outRequestNo, remark)
+	})
+	return err
+}
+
+// QueryFreeze QueryFreeze
+func (w *Wrapped) QueryFreeze(outOrderNo, outRequestNo string) (*FreezeResult, error) {
+	res, err := w.invoke(&Invocation{Operation: OpQueryFreeze, OrderID: outOrderNo, Request: outRequestNo}, func(p Payer) (interface{}, error) {
+		q, ok := p.(PreAuth)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.QueryFreeze(outOrderNo, outRequestNo)
+	})
+	r, _ := res.(*FreezeResult)
+	return r, err
+}
+
+// Transfer Transfer
+func (w *Wrapped) Transfer(in PayoutOrder) (*PayoutResult, error) {
+	res, err := w.invoke(&Invocation{Operation: OpTransfer, OrderID: in.OutBizNo, Request: in}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Payout)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.Transfer(in)
+	})
+	r, _ := res.(*PayoutResult)
+	return r, err
+}
+
+// QueryTransfer QueryTransfer
+func (w *Wrapped) QueryTransfer(outBizNo string) (*PayoutResult, error) {
+	res, err := w.invoke(&Invocation{Operation: OpQueryTransfer, OrderID: outBizNo, Request: outBizNo}, func(p Payer) (interface{}, error) {
+		q, ok := p.(Payout)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return q.QueryTransfer(outBizNo)
+	})
+	r, _ := res.(*PayoutResult)
+	return r, err
+}
+
+// Logging 记录每次操作的支付平台、操作名、支付方式、商户单号、耗时和错误，logf 为空时使用 log.Printf
+func Logging(logf func(format string, v ...interface{})) Middleware {
+	if logf == nil {
+		logf = log.Printf
+	}
+
+	return func(next Handler) Handler {
+		return func(inv *Invocation) (interface{}, error) {
+			start := time.Now()
+			res, err := next(inv)
+
+			logf("pay: provider=%s op=%s way=%s order=%s duration=%s err=%v",
+				inv.Provider, inv.Operation, inv.Way, inv.OrderID, time.Since(start), err)
+
+			return res, err
+		}
+	}
+}
+
+// PanicError 操作中 panic，由 Recovery 返回
+type PanicError struct {
+	Operation Operation
+	Provider  string
+	Value     interface{}
+	Stack     []byte
+}
+
+// Error Error
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s %s panic: %v", e.Provider, e.Operation, e.Value)
+}
+
+// Recovery 操作中 panic 时返回 *PanicError，不影响调用方进程
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(inv *Invocation) (res interface{}, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					res, err = nil, &PanicError{
+						Operation: inv.Operation,
+						Provider:  inv.Provider,
+						Value:     v,
+						Stack:     debug.Stack(),
+					}
+				}
+			}()
+
+			return next(inv)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package pay
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// basicPayer 只实现 Payer
+type basicPayer struct{}
+
+func (basicPayer) Verify(url.Values) (*NoticeParams, error) { return &NoticeParams{}, nil }
+func (basicPayer) Success() string                          { return "success" }
+func (basicPayer) Call(Way, Order) (string, error)          { return "", nil }
+
+// ctxPayer 实现 Querier 和 ContextPayer，Query 返回绑定的 context
+type ctxPayer struct {
+	basicPayer
+	ctx context.Context
+}
+
+func (p *ctxPayer) WithContext(ctx context.Context) Payer { return &ctxPayer{ctx: ctx} }
+func (p *ctxPayer) Query(string) (*NoticeParams, error) {
+	v, _ := p.ctx.Value(ctxKey{}).(string)
+	return &NoticeParams{OrderID: v}, nil
+}
+func (p *ctxPayer) Close(string) error { return nil }
+
+type ctxKey struct{}
+
+func TestSupports(t *testing.T) {
+	w := Wrap("basic", Wrap("inner", basicPayer{}))
+
+	if _, ok := Payer(w).(Refunder); !ok {
+		t.Fatal("Wrapped does not implement Refunder")
+	}
+	for _, op := range []Operation{OpRefund, OpQuery, OpClose, OpCharge, OpFreeze, OpTransfer} {
+		if Supports(w, op) {
+			t.Errorf("%s supported", op)
+		}
+	}
+	if !Supports(w, OpCall) || !Supports(w, OpVerify) {
+		t.Error("call or verify not supported")
+	}
+	if _, err := w.Refund(RefundOrder{ID: "R1"}); err != ErrNotSupported {
+		t.Errorf("got %v, want %v", err, ErrNotSupported)
+	}
+
+	if q := Wrap("ctx", &ctxPayer{}); !Supports(q, OpQuery) || Supports(q, OpRefund) {
+		t.Error("ctxPayer capabilities")
+	}
+}
+
+func TestWithContextNested(t *testing.T) {
+	var inner = Wrap("inner", &ctxPayer{})
+	if _, ok := Payer(inner).(ContextPayer); !ok {
+		t.Fatal("Wrapped does not implement ContextPayer")
+	}
+
+	outer := Wrap("outer", inner)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "bound")
+
+	r, err := outer.BindContext(ctx).Query("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "bound" {
+		t.Errorf("inner payer context value %q, want bound", r.OrderID)
+	}
+}
+
+// panicPayer Call 时 panic
+type panicPayer struct{ basicPayer }
+
+func (panicPayer) Call(Way, Order) (string, error) { panic("boom") }
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(inv *Invocation) (interface{}, error) {
+				calls = append(calls, name+" before")
+				res, err := next(inv)
+				calls = append(calls, name+" after")
+				return res, err
+			}
+		}
+	}
+
+	w := Wrap("basic", basicPayer{}, mw("first"), mw("second"))
+	if _, err := w.Call(WayQrcode, Order{ID: "O1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个在最外层
+	want := []string{"first before", "second before", "second after", "first after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls %v, want %v", calls, want)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	w := Wrap("panic", panicPayer{}, Recovery())
+
+	r, err := w.Call(WayQrcode, Order{ID: "O1"})
+
+	e, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("got %v, want *PanicError", err)
+	}
+	if len(r) > 0 || e.Provider != "panic" || e.Operation != OpCall || e.Value != "boom" || len(e.Stack) == 0 {
+		t.Errorf("result %q, error %+v", r, e)
+	}
+	if e.Error() != "panic call panic: boom" {
+		t.Errorf("message %q", e.Error())
+	}
+
+	// 未 panic 时原样返回
+	if _, err := Wrap("basic", basicPayer{}, Recovery()).Call(WayQrcode, Order{ID: "O1"}); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var lines []string
+	logf := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	// Logging 在 Recovery 外层时记录 panic 转成的错误
+	w := Wrap("panic", panicPayer{}, Logging(logf), Recovery())
+	w.Call(WayQrcode, Order{ID: "O1"})
+
+	if len(lines) != 1 {
+		t.Fatalf("lines %v", lines)
+	}
+	for _, s := range []string{"provider=panic", "op=call", "way=qrcode", "order=O1", "err=panic call panic: boom"} {
+		if !strings.Contains(lines[0], s) {
+			t.Errorf("line %q does not contain %q", lines[0], s)
+		}
+	}
+
+	Wrap("basic", basicPayer{}, Logging(logf)).Verify(url.Values{})
+	if len(lines) != 2 || !strings.Contains(lines[1], "op=verify") || !strings.HasSuffix(lines[1], "err=<nil>") {
+		t.Errorf("lines %v", lines)
+	}
+}
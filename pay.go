@@ -59,9 +59,13 @@ type NoticeParams struct {
 type Order struct {
 	ID     string // 订单ID
 	Title  string // 订单详情
-	Amount int32  // 支付金额 单位分
+	Amount int32  // 支付金额 单位为币种最小单位，人民币为分
 	IP     string // APP和网页支付提交用户端ip，Native支付填调用微信支付API的机器IP。
 	OpenID string // 用于jsapi支付
+
+	Currency string // 币种 ISO 4217，为空时使用各平台默认币种，仅境外支付
+
+	ProfitSharing bool // 是否需要分账，微信 profit_sharing=Y，支付宝冻结资金延迟结算 royalty_freeze
 }
 
 // TradeStatus 交易状态
@@ -77,3 +81,18 @@ const (
 	// TradeStatusFinished 交易结束，不可退款
 	TradeStatusFinished
 )
+
+var tradeStatusNames = map[TradeStatus]string{
+	TradeStatusWait:     "wait",
+	TradeStatusSuccess:  "success",
+	TradeStatusClosed:   "closed",
+	TradeStatusFinished: "finished",
+}
+
+// String String
+func (s TradeStatus) String() string {
+	if name, ok := tradeStatusNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,60 @@
+package pay
+
+import "errors"
+
+// ErrPayeeTypeNotDefine 不支持的收款方类型
+var ErrPayeeTypeNotDefine = errors.New("payee type not define")
+
+// PayeeType 收款方类型
+type PayeeType string
+
+const (
+	// PayeeAccount 收款方登录账号，支付宝为邮箱或手机号，微信为openid
+	PayeeAccount PayeeType = "account"
+	// PayeeUserID 收款方用户号，支付宝为2088开头的16位数字
+	PayeeUserID PayeeType = "userid"
+	// PayeeBankCard 收款方银行卡号，仅微信
+	PayeeBankCard PayeeType = "bankcard"
+)
+
+// PayoutStatus 付款状态
+type PayoutStatus int
+
+const (
+	// PayoutStatusProcessing 处理中，需要稍后查询最终状态
+	PayoutStatusProcessing PayoutStatus = iota
+	// PayoutStatusSuccess 付款成功
+	PayoutStatusSuccess
+	// PayoutStatusFailed 付款失败
+	PayoutStatusFailed
+)
+
+// Payout 付款到用户账户
+type Payout interface {
+	// Transfer 发起付款，结果不确定时使用相同的OutBizNo重试，不会重复付款
+	Transfer(PayoutOrder) (*PayoutResult, error)
+
+	// QueryTransfer 按商户付款单号查询付款状态
+	QueryTransfer(outBizNo string) (*PayoutResult, error)
+}
+
+// PayoutOrder 付款单信息
+type PayoutOrder struct {
+	OutBizNo  string    // 商户付款单号，同一笔付款重试时保持不变
+	PayeeType PayeeType // 收款方类型，为空时为PayeeAccount
+	Payee     string    // 收款方标识
+	PayeeName string    // 收款方真实姓名，不为空时校验实名，银行卡付款必填
+	BankCode  string    // 收款方开户行编号，仅银行卡付款
+	Amount    int32     // 付款金额 单位分
+	Remark    string    // 付款备注
+	IP        string    // 调用接口的机器IP，仅微信
+}
+
+// PayoutResult 付款结果
+type PayoutResult struct {
+	OutBizNo   string       // 商户付款单号
+	PaymentID  string       // 支付平台付款单号
+	Status     PayoutStatus // 付款状态
+	PayDate    string       // 付款时间，仅成功时返回
+	FailReason string       // 失败原因
+}
@@ -0,0 +1,32 @@
+package paypal
+
+import (
+	"math"
+	"strconv"
+)
+
+// 无小数位的币种 https://developer.paypal.com/docs/api/reference/currency-codes/
+var zeroDecimal = map[string]bool{
+	"HUF": true,
+	"JPY": true,
+	"TWD": true,
+}
+
+// value 最小单位金额转 PayPal 金额
+func value(amount int32, currency string) string {
+	if zeroDecimal[currency] {
+		return strconv.Itoa(int(amount))
+	}
+
+	return strconv.FormatFloat(float64(amount)/100, 'f', 2, 64)
+}
+
+// cents PayPal 金额转最小单位金额
+func cents(a Amount) int32 {
+	f, _ := strconv.ParseFloat(a.Value, 64)
+	if zeroDecimal[a.CurrencyCode] {
+		return int32(math.Round(f))
+	}
+
+	return int32(math.Round(f * 100))
+}
@@ -0,0 +1,482 @@
+// Package paypal PayPal Orders v2 REST API
+// https://developer.paypal.com/docs/api/orders/v2/
+package paypal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payer = &Paypal{}
+
+const (
+	kSandboxAPIDomain    = "https://api.sandbox.paypal.com"
+	kProductionAPIDomain = "https://api.paypal.com"
+
+	kToken         = "/v1/oauth2/token"
+	kOrders        = "/v2/checkout/orders"
+	kVerifyWebhook = "/v1/notifications/verify-webhook-signature"
+
+	kDefaultCurrency = "USD"
+
+	kTimeout = 10 * time.Second
+)
+
+// 订单状态
+const (
+	OrderStatusCreated             = "CREATED"
+	OrderStatusSaved               = "SAVED"
+	OrderStatusApproved            = "APPROVED"
+	OrderStatusVoided              = "VOIDED"
+	OrderStatusCompleted           = "COMPLETED"
+	OrderStatusPayerActionRequired = "PAYER_ACTION_REQUIRED"
+)
+
+// 扣款状态
+const (
+	CaptureStatusCompleted         = "COMPLETED"
+	CaptureStatusPending           = "PENDING"
+	CaptureStatusDeclined          = "DECLINED"
+	CaptureStatusFailed            = "FAILED"
+	CaptureStatusRefunded          = "REFUNDED"
+	CaptureStatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+)
+
+var (
+	// ErrNotFoundApproveURL 创建订单返回结果中没有用户授权地址
+	ErrNotFoundApproveURL = errors.New("paypal: not found approve url")
+)
+
+// Options Options
+type Options struct {
+	ClientID     string
+	Secret       string
+	IsProduction bool
+	WebhookID    string       // 验证webhook签名用的webhook id
+	ReturnURL    string       // 用户授权后跳转地址，带 token=PayPal订单号
+	CancelURL    string       // 用户取消后跳转地址
+	BrandName    string       // 授权页面显示的商户名称
+	APIDomain    string       // 接口域名，为空时按 IsProduction 选择
+	Client       *http.Client // 为空时使用超时 10s 的 http.Client
+}
+
+// Paypal Paypal
+type Paypal struct {
+	opt Options
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New New
+func New(opt Options) *Paypal {
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	return &Paypal{
+		opt: opt,
+	}
+}
+
+// Verify webhook 验证签名,成功返回回调参数，in 为 WebhookValues 的返回值
+func (p *Paypal) Verify(in url.Values) (*pay.NoticeParams, error) {
+	var req = struct {
+		AuthAlgo         string          `json:"auth_algo"`
+		CertURL          string          `json:"cert_url"`
+		TransmissionID   string          `json:"transmission_id"`
+		TransmissionSig  string          `json:"transmission_sig"`
+		TransmissionTime string          `json:"transmission_time"`
+		WebhookID        string          `json:"webhook_id"`
+		WebhookEvent     json.RawMessage `json:"webhook_event"`
+	}{
+		AuthAlgo:         in.Get("Paypal-Auth-Algo"),
+		CertURL:          in.Get("Paypal-Cert-Url"),
+		TransmissionID:   in.Get("Paypal-Transmission-Id"),
+		TransmissionSig:  in.Get("Paypal-Transmission-Sig"),
+		TransmissionTime: in.Get("Paypal-Transmission-Time"),
+		WebhookID:        p.opt.WebhookID,
+		WebhookEvent:     json.RawMessage(in.Get("body")),
+	}
+
+	if !json.Valid(req.WebhookEvent) {
+		return nil, pay.ErrVerify
+	}
+
+	var rsp struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := p.do("POST", kVerifyWebhook, "", req, &rsp); err != nil {
+		return nil, err
+	}
+
+	if rsp.VerificationStatus != "SUCCESS" {
+		return nil, pay.ErrVerify
+	}
+
+	return NoticeParams([]byte(in.Get("body")))
+}
+
+// Success 回调成功返回数据
+func (p *Paypal) Success() string {
+	return "ok"
+}
+
+// Call 调起支付用到的数据
+// form -> 用户授权跳转地址
+// wap -> 用户授权跳转地址
+// 用户授权后跳转到 ReturnURL，需要调用 CaptureOrder 完成扣款
+func (p *Paypal) Call(way pay.Way, in pay.Order) (string, error) {
+	switch way {
+	case pay.WayForm, pay.WayWap:
+		return p.createOrder(in)
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+func (p *Paypal) createOrder(in pay.Order) (string, error) {
+	currency := in.Currency
+	if len(currency) == 0 {
+		currency = kDefaultCurrency
+	}
+
+	var req = map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{{
+			"reference_id": in.ID,
+			"custom_id":    in.ID,
+			"invoice_id":   in.ID,
+			"description":  in.Title,
+			"amount": Amount{
+				CurrencyCode: currency,
+				Value:        value(in.Amount, currency),
+			},
+		}},
+		"application_context": map[string]string{
+			"brand_name":          p.opt.BrandName,
+			"return_url":          p.opt.ReturnURL,
+			"cancel_url":          p.opt.CancelURL,
+			"user_action":         "PAY_NOW",
+			"shipping_preference": "NO_SHIPPING",
+		},
+	}
+
+	var rsp Order
+	if err := p.do("POST", kOrders, in.ID, req, &rsp); err != nil {
+		return "", err
+	}
+
+	for _, l := range rsp.Links {
+		if l.Rel == "approve" || l.Rel == "payer-action" {
+			return l.Href, nil
+		}
+	}
+
+	return "", ErrNotFoundApproveURL
+}
+
+// CaptureOrder 用户授权后扣款，orderID 为 PayPal 订单号，即 ReturnURL 上的 token
+// 重复调用返回 ORDER_ALREADY_CAPTURED 错误，以 QueryOrder 结果为准
+func (p *Paypal) CaptureOrder(orderID string) (*pay.NoticeParams, error) {
+	var rsp Order
+	if err := p.do("POST", kOrders+"/"+url.PathEscape(orderID)+"/capture", "capture-"+orderID, struct{}{}, &rsp); err != nil {
+		return nil, err
+	}
+
+	return rsp.NoticeParams(), nil
+}
+
+// QueryOrder 查询订单
+func (p *Paypal) QueryOrder(orderID string) (*Order, error) {
+	var rsp Order
+	if err := p.do("GET", kOrders+"/"+url.PathEscape(orderID), "", nil, &rsp); err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// accessToken 获取并缓存 access token，过期前1分钟刷新
+func (p *Paypal) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.token) > 0 && time.Now().Before(p.expires) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequest("POST", p.api(kToken), strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(p.opt.ClientID, p.opt.Secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var rsp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := p.send(req, &rsp); err != nil {
+		return "", err
+	}
+
+	p.token = rsp.AccessToken
+	p.expires = time.Now().Add(time.Duration(rsp.ExpiresIn)*time.Second - time.Minute)
+
+	return p.token, nil
+}
+
+// do 请求接口，requestID 不为空时作为 PayPal-Request-Id 保证幂等
+func (p *Paypal) do(method, path, requestID string, body interface{}, result interface{}) error {
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if body != nil {
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, p.api(path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+	if len(requestID) > 0 {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
+
+	err = p.send(req, result)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusUnauthorized {
+		// token 被提前吊销，下次请求重新获取
+		p.mu.Lock()
+		p.token = ""
+		p.mu.Unlock()
+	}
+
+	return err
+}
+
+func (p *Paypal) send(req *http.Request, result interface{}) error {
+	resp, err := p.opt.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		e := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, e)
+		return e
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// api 接口地址，Options.APIDomain 不为空时替换默认域名
+func (p *Paypal) api(path string) string {
+	if len(p.opt.APIDomain) > 0 {
+		return strings.TrimSuffix(p.opt.APIDomain, "/") + path
+	}
+
+	if p.opt.IsProduction {
+		return kProductionAPIDomain + path
+	}
+
+	return kSandboxAPIDomain + path
+}
+
+// Error 接口错误
+type Error struct {
+	StatusCode       int    `json:"-"`
+	Name             string `json:"name"`
+	Message          string `json:"message"`
+	Code             string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Details          []struct {
+		Issue       string `json:"issue"`
+		Description string `json:"description"`
+	} `json:"details"`
+}
+
+func (e *Error) Error() string {
+	name, msg := e.Name, e.Message
+	if len(name) == 0 {
+		name, msg = e.Code, e.ErrorDescription
+	}
+
+	if len(e.Details) > 0 {
+		name = e.Details[0].Issue
+	}
+
+	return fmt.Sprintf("paypal: %d %s %s", e.StatusCode, name, msg)
+}
+
+// Amount 金额
+type Amount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// Link 相关地址
+type Link struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method"`
+}
+
+// Capture 扣款信息
+type Capture struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Amount    Amount `json:"amount"`
+	CustomID  string `json:"custom_id"`
+	InvoiceID string `json:"invoice_id"`
+}
+
+// Order PayPal订单
+type Order struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	Links         []Link `json:"links"`
+	PurchaseUnits []struct {
+		ReferenceID string `json:"reference_id"`
+		CustomID    string `json:"custom_id"`
+		Amount      Amount `json:"amount"`
+		Payments    struct {
+			Captures []Capture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+// NoticeParams 订单支付结果，扣款后 PaymentID 为扣款号，否则为 PayPal 订单号
+func (o *Order) NoticeParams() *pay.NoticeParams {
+	var n = &pay.NoticeParams{
+		PaymentID:   o.ID,
+		TradeStatus: OrderTradeStatus(o.Status),
+	}
+
+	if len(o.PurchaseUnits) == 0 {
+		return n
+	}
+
+	u := o.PurchaseUnits[0]
+	n.OrderID = u.CustomID
+	n.Amount = cents(u.Amount)
+
+	if len(u.Payments.Captures) > 0 {
+		c := u.Payments.Captures[0]
+		n.OrderID = c.CustomID
+		n.PaymentID = c.ID
+		n.TradeStatus = CaptureTradeStatus(c.Status)
+		n.Amount = cents(c.Amount)
+	}
+
+	if len(n.OrderID) == 0 {
+		n.OrderID = u.ReferenceID
+	}
+
+	return n
+}
+
+// OrderTradeStatus 订单状态转支付状态
+func OrderTradeStatus(status string) pay.TradeStatus {
+	switch status {
+	case OrderStatusCompleted:
+		return pay.TradeStatusSuccess
+	case OrderStatusVoided:
+		return pay.TradeStatusClosed
+	}
+
+	return pay.TradeStatusWait
+}
+
+// CaptureTradeStatus 扣款状态转支付状态，部分退款仍为支付成功
+func CaptureTradeStatus(status string) pay.TradeStatus {
+	switch status {
+	case CaptureStatusCompleted, CaptureStatusPartiallyRefunded:
+		return pay.TradeStatusSuccess
+	case CaptureStatusDeclined, CaptureStatusFailed, CaptureStatusRefunded:
+		return pay.TradeStatusClosed
+	}
+
+	return pay.TradeStatusWait
+}
+
+// WebhookValues webhook 请求头和body转为 Verify 的参数
+func WebhookValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	for _, k := range []string{"Paypal-Auth-Algo", "Paypal-Cert-Url", "Paypal-Transmission-Id", "Paypal-Transmission-Sig", "Paypal-Transmission-Time"} {
+		v.Set(k, header.Get(k))
+	}
+	v.Set("body", string(body))
+	return v
+}
+
+// NoticeParams webhook 事件转回调参数
+// CHECKOUT.ORDER.* 事件 resource 为订单，PAYMENT.CAPTURE.* 事件 resource 为扣款
+func NoticeParams(body []byte) (*pay.NoticeParams, error) {
+	var event struct {
+		EventType string          `json:"event_type"`
+		Resource  json.RawMessage `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(event.EventType, "PAYMENT.CAPTURE.") {
+		var c Capture
+		if err := json.Unmarshal(event.Resource, &c); err != nil {
+			return nil, err
+		}
+
+		orderID := c.CustomID
+		if len(orderID) == 0 {
+			orderID = c.InvoiceID
+		}
+
+		return &pay.NoticeParams{
+			OrderID:     orderID,
+			PaymentID:   c.ID,
+			TradeStatus: CaptureTradeStatus(c.Status),
+			Amount:      cents(c.Amount),
+		}, nil
+	}
+
+	var o Order
+	if err := json.Unmarshal(event.Resource, &o); err != nil {
+		return nil, err
+	}
+
+	return o.NoticeParams(), nil
+}
@@ -0,0 +1,182 @@
+package paypal
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/paypal/paypaltest"
+)
+
+func newTestPaypal(t *testing.T) (*Paypal, *paypaltest.Server) {
+	s := paypaltest.NewServer()
+	t.Cleanup(s.Close)
+
+	p := New(Options{
+		ClientID:  s.ClientID,
+		Secret:    s.Secret,
+		WebhookID: "WH-TEST",
+		ReturnURL: "https://example.com/return",
+		CancelURL: "https://example.com/cancel",
+		APIDomain: s.URL,
+	})
+
+	return p, s
+}
+
+// createOrder 下单并从授权地址取出 PayPal 订单号
+func createOrder(t *testing.T, p *Paypal, in pay.Order) string {
+	t.Helper()
+
+	href, err := p.Call(pay.WayForm, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u.Query().Get("token")
+}
+
+func TestCreateOrderIdempotent(t *testing.T) {
+	p, s := newTestPaypal(t)
+
+	id := createOrder(t, p, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	if again := createOrder(t, p, pay.Order{ID: "O1", Title: "test", Amount: 101}); again != id {
+		t.Errorf("retry created order %s, want %s", again, id)
+	}
+	if n := s.Orders(); n != 1 {
+		t.Errorf("%d orders, want 1", n)
+	}
+
+	if other := createOrder(t, p, pay.Order{ID: "O2", Title: "test", Amount: 101}); other == id {
+		t.Error("different order ids share one PayPal order")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	p, s := newTestPaypal(t)
+	id := createOrder(t, p, pay.Order{ID: "O1", Title: "test", Amount: 101})
+
+	if _, err := p.CaptureOrder(id); !isIssue(err, "ORDER_NOT_APPROVED") {
+		t.Errorf("capture before approval: got %v", err)
+	}
+
+	s.Approve(id)
+
+	r, err := p.CaptureOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" || r.Amount != 101 || r.TradeStatus != pay.TradeStatusSuccess || len(r.PaymentID) == 0 || r.PaymentID == id {
+		t.Errorf("capture %+v", r)
+	}
+
+	if _, err := p.CaptureOrder(id); !isIssue(err, "ORDER_ALREADY_CAPTURED") {
+		t.Errorf("second capture: got %v", err)
+	}
+
+	o, err := p.QueryOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := o.NoticeParams(); q.PaymentID != r.PaymentID || q.TradeStatus != pay.TradeStatusSuccess {
+		t.Errorf("query %+v", q)
+	}
+}
+
+func TestZeroDecimal(t *testing.T) {
+	for _, c := range []struct {
+		amount   int32
+		currency string
+		value    string
+	}{
+		{101, "USD", "1.01"},
+		{5, "EUR", "0.05"},
+		{500, "JPY", "500"},
+		{1234, "HUF", "1234"},
+		{99, "TWD", "99"},
+	} {
+		if v := value(c.amount, c.currency); v != c.value {
+			t.Errorf("value(%d, %s) = %s, want %s", c.amount, c.currency, v, c.value)
+		}
+		if a := cents(Amount{CurrencyCode: c.currency, Value: c.value}); a != c.amount {
+			t.Errorf("cents(%s %s) = %d, want %d", c.value, c.currency, a, c.amount)
+		}
+	}
+
+	p, s := newTestPaypal(t)
+	id := createOrder(t, p, pay.Order{ID: "O1", Title: "test", Amount: 500, Currency: "JPY"})
+
+	if currency, v := s.Amount(id); currency != "JPY" || v != "500" {
+		t.Errorf("sent %s %s, want JPY 500", currency, v)
+	}
+
+	s.Approve(id)
+
+	r, err := p.CaptureOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Amount != 500 {
+		t.Errorf("captured %d, want 500", r.Amount)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	p, s := newTestPaypal(t)
+	id := createOrder(t, p, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	s.Approve(id)
+
+	c, err := p.CaptureOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, body := s.Webhook(id)
+
+	r, err := p.Verify(WebhookValues(h, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" || r.PaymentID != c.PaymentID || r.Amount != 101 || r.TradeStatus != pay.TradeStatusSuccess {
+		t.Errorf("notice %+v", r)
+	}
+
+	tampered := bytes.Replace(body, []byte(`"custom_id":"O1"`), []byte(`"custom_id":"O2"`), 1)
+	if bytes.Equal(tampered, body) {
+		t.Fatalf("custom_id not found in %s", body)
+	}
+	if _, err := p.Verify(WebhookValues(h, tampered)); err != pay.ErrVerify {
+		t.Errorf("tampered body: got %v, want ErrVerify", err)
+	}
+
+	if _, err := p.Verify(WebhookValues(h, []byte("not json"))); err != pay.ErrVerify {
+		t.Errorf("invalid body: got %v, want ErrVerify", err)
+	}
+}
+
+// isIssue err 是否为 details 中包含 issue 的 *Error
+func isIssue(err error, issue string) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	for _, d := range e.Details {
+		if d.Issue == issue {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClient(t *testing.T) {
+	if p := New(Options{}); p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+}
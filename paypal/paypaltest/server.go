@@ -0,0 +1,294 @@
+// Package paypaltest 本地 PayPal 接口桩，用于不连 PayPal 沙箱的联调测试
+//
+// paypal.Options.APIDomain 使用 Server.URL。订单需要调用 Approve 模拟用户授权后才能扣款，
+// Webhook 生成的请求头由桩接口的验签接口验证
+package paypaltest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 本地 PayPal 接口桩
+type Server struct {
+	*httptest.Server
+
+	ClientID string
+	Secret   string
+
+	mu     sync.Mutex
+	seq    int
+	orders map[string]*order
+	// requestIDs PayPal-Request-Id 对应的订单号，重复请求返回同一订单
+	requestIDs map[string]string
+}
+
+type order struct {
+	ID       string
+	Status   string
+	CustomID string
+	Currency string
+	Value    string
+	Capture  string
+}
+
+// NewServer 启动接口桩
+func NewServer() *Server {
+	s := &Server{
+		ClientID:   "paypaltest",
+		Secret:     "paypaltest",
+		orders:     make(map[string]*order),
+		requestIDs: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/oauth2/token", s.handleToken)
+	mux.HandleFunc("/v2/checkout/orders", s.auth(s.handleCreate))
+	mux.HandleFunc("/v2/checkout/orders/", s.auth(s.handleOrder))
+	mux.HandleFunc("/v1/notifications/verify-webhook-signature", s.auth(s.handleVerify))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Approve 模拟用户授权订单
+func (s *Server) Approve(orderID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok || o.Status != "CREATED" {
+		return false
+	}
+
+	o.Status = "APPROVED"
+	return true
+}
+
+// Orders 已创建的订单数，相同 PayPal-Request-Id 的重复请求只创建一个订单
+func (s *Server) Orders() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.orders)
+}
+
+// Amount 订单的币种和金额，订单不存在时返回空
+func (s *Server) Amount(orderID string) (currency, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.orders[orderID]; ok {
+		return o.Currency, o.Value
+	}
+	return "", ""
+}
+
+// Webhook 生成 PAYMENT.CAPTURE.COMPLETED 事件的请求头和 body
+func (s *Server) Webhook(orderID string) (http.Header, []byte) {
+	s.mu.Lock()
+	o := s.orders[orderID]
+	s.mu.Unlock()
+
+	var resource = map[string]interface{}{}
+	if o != nil {
+		resource = map[string]interface{}{
+			"id":        o.Capture,
+			"status":    "COMPLETED",
+			"custom_id": o.CustomID,
+			"amount":    map[string]string{"currency_code": o.Currency, "value": o.Value},
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":         "WH-" + orderID,
+		"event_type": "PAYMENT.CAPTURE.COMPLETED",
+		"resource":   resource,
+	})
+
+	var h = http.Header{}
+	h.Set("Paypal-Auth-Algo", "SHA256withRSA")
+	h.Set("Paypal-Cert-Url", s.URL+"/cert")
+	h.Set("Paypal-Transmission-Id", "WH-"+orderID)
+	h.Set("Paypal-Transmission-Time", time.Now().UTC().Format(time.RFC3339))
+	h.Set("Paypal-Transmission-Sig", s.sign(body))
+
+	return h, body
+}
+
+func (s *Server) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.token() {
+			writeError(w, http.StatusUnauthorized, "AUTHENTICATION_FAILURE")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) token() string {
+	return "A21AA" + s.sign([]byte(s.ClientID))
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != s.ClientID || secret != s.Secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_client","error_description":"Client Authentication failed"}`))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": s.token(),
+		"token_type":   "Bearer",
+		"expires_in":   32400,
+	})
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PurchaseUnits []struct {
+			CustomID string `json:"custom_id"`
+			Amount   struct {
+				CurrencyCode string `json:"currency_code"`
+				Value        string `json:"value"`
+			} `json:"amount"`
+		} `json:"purchase_units"`
+	}
+	if r.Method != "POST" || json.NewDecoder(r.Body).Decode(&req) != nil || len(req.PurchaseUnits) == 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.orders[s.requestIDs[r.Header.Get("PayPal-Request-Id")]]
+	if !ok {
+		s.seq++
+		u := req.PurchaseUnits[0]
+		o = &order{
+			ID:       "PP" + strconv.Itoa(s.seq),
+			Status:   "CREATED",
+			CustomID: u.CustomID,
+			Currency: u.Amount.CurrencyCode,
+			Value:    u.Amount.Value,
+		}
+		s.orders[o.ID] = o
+		if id := r.Header.Get("PayPal-Request-Id"); len(id) > 0 {
+			s.requestIDs[id] = o.ID
+		}
+	}
+	rsp := s.render(o)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, rsp)
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/checkout/orders/")
+	id := strings.TrimSuffix(path, "/capture")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND")
+		return
+	}
+
+	if path == id {
+		writeJSON(w, http.StatusOK, s.render(o))
+		return
+	}
+
+	switch o.Status {
+	case "COMPLETED":
+		writeError(w, http.StatusUnprocessableEntity, "ORDER_ALREADY_CAPTURED")
+		return
+	case "APPROVED":
+	default:
+		writeError(w, http.StatusUnprocessableEntity, "ORDER_NOT_APPROVED")
+		return
+	}
+
+	s.seq++
+	o.Status = "COMPLETED"
+	o.Capture = "CAP" + strconv.Itoa(s.seq)
+
+	writeJSON(w, http.StatusCreated, s.render(o))
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TransmissionSig string          `json:"transmission_sig"`
+		WebhookEvent    json.RawMessage `json:"webhook_event"`
+	}
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	status := "FAILURE"
+	if hmac.Equal([]byte(req.TransmissionSig), []byte(s.sign(req.WebhookEvent))) {
+		status = "SUCCESS"
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"verification_status": status})
+}
+
+func (s *Server) render(o *order) map[string]interface{} {
+	unit := map[string]interface{}{
+		"reference_id": o.CustomID,
+		"custom_id":    o.CustomID,
+		"amount":       map[string]string{"currency_code": o.Currency, "value": o.Value},
+	}
+	if len(o.Capture) > 0 {
+		unit["payments"] = map[string]interface{}{
+			"captures": []map[string]interface{}{{
+				"id":        o.Capture,
+				"status":    "COMPLETED",
+				"custom_id": o.CustomID,
+				"amount":    map[string]string{"currency_code": o.Currency, "value": o.Value},
+			}},
+		}
+	}
+
+	return map[string]interface{}{
+		"id":             o.ID,
+		"status":         o.Status,
+		"purchase_units": []interface{}{unit},
+		"links": []map[string]string{
+			{"href": s.URL + "/v2/checkout/orders/" + o.ID, "rel": "self", "method": "GET"},
+			{"href": s.URL + "/checkoutnow?token=" + o.ID, "rel": "approve", "method": "GET"},
+			{"href": s.URL + "/v2/checkout/orders/" + o.ID + "/capture", "rel": "capture", "method": "POST"},
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, issue string) {
+	writeJSON(w, code, map[string]interface{}{
+		"name":    strings.ToUpper(strings.Replace(http.StatusText(code), " ", "_", -1)),
+		"message": http.StatusText(code),
+		"details": []map[string]string{{"issue": issue}},
+	})
+}
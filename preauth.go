@@ -0,0 +1,82 @@
+package pay
+
+import "net/url"
+
+// FreezeStatus 预授权状态
+type FreezeStatus int
+
+const (
+	// FreezeStatusInit 已创建，等待用户授权
+	FreezeStatusInit FreezeStatus = iota
+	// FreezeStatusFrozen 已冻结
+	FreezeStatusFrozen
+	// FreezeStatusFinished 已完结，冻结资金已全部转支付或解冻
+	FreezeStatusFinished
+	// FreezeStatusClosed 已关闭，授权超时或撤销
+	FreezeStatusClosed
+)
+
+// PreAuth 资金预授权，用于押金等先冻结后扣款的场景
+type PreAuth interface {
+	// Freeze 冻结资金，返回调起授权用到的数据
+	// app -> 调起app用到的参数
+	// qrcode -> 二维码地址
+	Freeze(Way, FreezeOrder) (string, error)
+
+	// VerifyFreeze 授权回调验证签名,成功返回授权状态
+	VerifyFreeze(url.Values) (*FreezeResult, error)
+
+	// Capture 冻结资金转支付
+	Capture(CaptureOrder) (*NoticeParams, error)
+
+	// Unfreeze 解冻剩余资金，微信支付分为完结订单不再扣款
+	Unfreeze(UnfreezeOrder) error
+
+	// CancelFreeze 撤销授权，已冻结的资金全额解冻
+	CancelFreeze(outOrderNo, outRequestNo, remark string) error
+
+	// QueryFreeze 查询授权单
+	QueryFreeze(outOrderNo, outRequestNo string) (*FreezeResult, error)
+}
+
+// FreezeOrder 冻结信息
+type FreezeOrder struct {
+	OutOrderNo   string // 商户授权单号
+	OutRequestNo string // 商户本次操作流水号
+	Title        string // 授权详情
+	Amount       int32  // 冻结金额 单位分
+	OpenID       string // 用户openid，仅微信
+}
+
+// CaptureOrder 转支付信息
+type CaptureOrder struct {
+	AuthNo     string // 支付平台授权单号
+	OutOrderNo string // 商户授权单号
+	OutTradeNo string // 转支付的商户订单号
+	Title      string // 订单详情
+	Amount     int32  // 转支付金额 单位分
+	PayerID    string // 付款方用户号，支付宝为冻结时返回的payer_user_id
+	Complete   bool   // 转支付后是否解冻剩余资金，支付宝 auth_confirm_mode=COMPLETE
+	Way        Way    // 冻结时的方式，支付宝按冻结方式使用对应的产品码
+}
+
+// UnfreezeOrder 解冻信息
+type UnfreezeOrder struct {
+	AuthNo       string // 支付平台授权单号
+	OutOrderNo   string // 商户授权单号，仅微信
+	OutRequestNo string // 商户本次操作流水号
+	Amount       int32  // 解冻金额 单位分
+	Remark       string // 解冻描述
+}
+
+// FreezeResult 授权单信息
+type FreezeResult struct {
+	AuthNo            string       // 支付平台授权单号
+	OutOrderNo        string       // 商户授权单号
+	OperationID       string       // 支付平台操作流水号
+	Status            FreezeStatus // 授权状态
+	TotalFreezeAmount int32        // 累计冻结金额
+	RestAmount        int32        // 剩余冻结金额
+	TotalPayAmount    int32        // 累计转支付金额
+	PayerID           string       // 付款方用户号
+}
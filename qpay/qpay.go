@@ -0,0 +1,284 @@
+// Package qpay QQ钱包支付，接口和签名方式与微信支付v2一致
+// https://qpay.qq.com/buss/wiki/38/1203
+package qpay
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+)
+
+var _ pay.Payer = &Qpay{}
+
+const (
+	kAPIDomain = "https://qpay.qq.com"
+
+	kUnifiedOrder = "/cgi-bin/pay/qpay_unified_order.cgi"
+
+	// kWapPayURL 手机浏览器跳转手Q支付
+	kWapPayURL = "https://myun.tenpay.com/mqq/pay/qrcode.html?_wv=1027&_bid=2183&t="
+
+	kTradeTypeNative = "NATIVE"
+	kTradeTypeApp    = "APP"
+	kTradeTypeJSAPI  = "JSAPI"
+
+	kFeeTypeCNY = "CNY"
+
+	kProvider = "qpay" // pay.Error.Provider
+
+	kTimeout = 10 * time.Second
+)
+
+// kErrCodeCategory 常见 err_code 的分类，与微信支付v2一致
+var kErrCodeCategory = map[string]pay.Category{
+	"NOAUTH":          pay.CategoryAuth,
+	"SIGNERROR":       pay.CategoryAuth,
+	"APPID_NOT_EXIST": pay.CategoryAuth,
+	"MCHID_NOT_EXIST": pay.CategoryAuth,
+	"PARAM_ERROR":     pay.CategoryParam,
+	"LACK_PARAMS":     pay.CategoryParam,
+	"ORDERNOTEXIST":   pay.CategoryParam,
+	"NOTENOUGH":       pay.CategoryBalance,
+	"ORDERPAID":       pay.CategoryDuplicate,
+	"ORDERCLOSED":     pay.CategoryDuplicate,
+	"SYSTEMERROR":     pay.CategorySystem,
+}
+
+// Options Options
+type Options struct {
+	APIKey    string // 商户API密钥
+	MchID     string
+	AppID     string // 手Q开放平台appid，APP支付需要
+	AppKey    string // 手Q开放平台appkey，APP支付签名
+	NotifyURL string
+	APIDomain string       // 接口域名，为空时使用 https://qpay.qq.com
+	Client    *http.Client // 为空时使用超时 10s 的 http.Client
+}
+
+// Qpay Qpay
+type Qpay struct {
+	opt Options
+}
+
+// New New
+func New(opt Options) *Qpay {
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	return &Qpay{
+		opt: opt,
+	}
+}
+
+// Verify 支付回调验证签名,成功返回回调参数，in 为 BodyToValues 的返回值
+func (p *Qpay) Verify(in url.Values) (*pay.NoticeParams, error) {
+	ok, err := wxpay.VerifyResponseValues(in, p.opt.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, pay.ErrVerify
+	}
+
+	return NoticeParams(in), nil
+}
+
+// Success 回调成功返回数据
+func (p *Qpay) Success() string {
+	var v = url.Values{}
+	v.Set("return_code", "SUCCESS")
+	return wxpay.URLValueToXML(v)
+}
+
+// Call 调起支付用到的数据
+// qrcode -> 二维码地址
+// app -> 调起手Q支付用到的参数url.Values.Encode()
+// wap -> 跳转手Q支付的地址
+func (p *Qpay) Call(way pay.Way, in pay.Order) (string, error) {
+	switch way {
+	case pay.WayQrcode:
+		rsp, err := p.unifiedOrder(in, kTradeTypeNative)
+		if err != nil {
+			return "", err
+		}
+		return rsp.Get("code_url"), nil
+	case pay.WayApp:
+		rsp, err := p.unifiedOrder(in, kTradeTypeApp)
+		if err != nil {
+			return "", err
+		}
+		return p.appParams(rsp.Get("prepay_id")).Encode(), nil
+	case pay.WayWap:
+		rsp, err := p.unifiedOrder(in, kTradeTypeJSAPI)
+		if err != nil {
+			return "", err
+		}
+		return kWapPayURL + url.QueryEscape(rsp.Get("prepay_id")), nil
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+// unifiedOrder 统一下单
+func (p *Qpay) unifiedOrder(in pay.Order, tradeType string) (url.Values, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.opt.MchID)
+	v.Set("body", in.Title)
+	v.Set("out_trade_no", in.ID)
+	v.Set("fee_type", kFeeTypeCNY)
+	v.Set("total_fee", strconv.Itoa(int(in.Amount)))
+	v.Set("spbill_create_ip", in.IP)
+	v.Set("trade_type", tradeType)
+	v.Set("notify_url", p.opt.NotifyURL)
+	if tradeType == kTradeTypeApp {
+		v.Set("appid", p.opt.AppID)
+	}
+
+	rsp, err := p.post(kUnifiedOrder, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Get("result_code") != wxpay.K_RETURN_CODE_SUCCESS {
+		return nil, newError(rsp)
+	}
+
+	return rsp, nil
+}
+
+// appParams 手Q APP支付参数，sig 为 HMAC-SHA1(appkey&, 按key排序的参数)
+// https://qpay.qq.com/buss/wiki/38/1196
+func (p *Qpay) appParams(tokenID string) url.Values {
+	var v = url.Values{}
+	v.Set("appId", p.opt.AppID)
+	v.Set("bargainorId", p.opt.MchID)
+	v.Set("nonce", wxpay.GetNonceStr())
+	v.Set("pubAcc", "")
+	v.Set("tokenId", tokenID)
+
+	src, _ := url.QueryUnescape(v.Encode())
+	mac := hmac.New(sha1.New, []byte(p.opt.AppKey+"&"))
+	mac.Write([]byte(src))
+
+	v.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	v.Set("sigType", "HMAC-SHA1")
+	v.Set("timeStamp", strconv.FormatInt(time.Now().Unix(), 10))
+
+	return v
+}
+
+// post 签名并请求接口，return_code 为 FAIL 时返回错误，验证返回结果签名，result_code 由调用方处理
+func (p *Qpay) post(api string, vals url.Values) (url.Values, error) {
+	vals.Del("sign")
+	vals.Set("nonce_str", wxpay.GetNonceStr())
+	vals.Set("sign", wxpay.SignMD5(vals, p.opt.APIKey))
+
+	domain := kAPIDomain
+	if len(p.opt.APIDomain) > 0 {
+		domain = strings.TrimSuffix(p.opt.APIDomain, "/")
+	}
+
+	resp, err := p.opt.Client.Post(domain+api, "application/xml;charset=utf-8", strings.NewReader(wxpay.URLValueToXML(vals)))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	rsp, err := BodyToValues(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Get("return_code") != wxpay.K_RETURN_CODE_SUCCESS {
+		return nil, newError(rsp)
+	}
+
+	// result_code 为 FAIL 的结果同样带签名，由调用方转为错误
+	s := rsp.Get("sign")
+	rsp.Del("sign")
+	ok := len(s) > 0 && s == wxpay.SignMD5(rsp, p.opt.APIKey)
+	rsp.Set("sign", s)
+	if !ok {
+		return nil, pay.ErrVerify
+	}
+
+	return rsp, nil
+}
+
+// newError 接口返回的错误，return_code 为 FAIL 时使用 return_msg，否则使用 err_code err_code_des
+func newError(rsp url.Values) *pay.Error {
+	if code := rsp.Get("return_code"); code != wxpay.K_RETURN_CODE_SUCCESS {
+		var category = pay.CategoryParam
+		if strings.Contains(rsp.Get("return_msg"), "签名") {
+			category = pay.CategoryAuth
+		}
+
+		return &pay.Error{
+			Provider: kProvider,
+			Code:     code,
+			Message:  rsp.Get("return_msg"),
+			Category: category,
+		}
+	}
+
+	code := rsp.Get("err_code")
+
+	return &pay.Error{
+		Provider:  kProvider,
+		Code:      rsp.Get("result_code"),
+		SubCode:   code,
+		Message:   rsp.Get("err_code_des"),
+		Category:  kErrCodeCategory[code],
+		Retryable: code == "SYSTEMERROR",
+	}
+}
+
+// NoticeParams 回调参数，trade_state 为 SUCCESS 时支付成功
+func NoticeParams(val url.Values) *pay.NoticeParams {
+	var status = pay.TradeStatusWait
+	switch val.Get("trade_state") {
+	case "SUCCESS":
+		status = pay.TradeStatusSuccess
+	case "CLOSED", "REVOKED", "PAYERROR", "REFUND":
+		status = pay.TradeStatusClosed
+	}
+
+	amount, _ := strconv.Atoi(val.Get("total_fee"))
+
+	return &pay.NoticeParams{
+		OrderID:     val.Get("out_trade_no"),
+		PaymentID:   val.Get("transaction_id"),
+		TradeStatus: status,
+		Amount:      int32(amount),
+	}
+}
+
+// BodyToValues 回调 xml 转为 Verify 的参数
+func BodyToValues(body string) (url.Values, error) {
+	var param = make(wxpay.XMLMap)
+	err := xml.Unmarshal([]byte(body), &param)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Values(param), nil
+}
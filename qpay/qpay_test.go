@@ -0,0 +1,138 @@
+package qpay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+)
+
+func newTestQpay(t *testing.T, domain string) *Qpay {
+	return New(Options{
+		APIKey:    "8934e7d15453e97507ef794cf7b0519d",
+		MchID:     "1900000109",
+		APIDomain: domain,
+	})
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	body, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestVerify(t *testing.T) {
+	p := newTestQpay(t, "")
+	body := readFixture(t, "notify.xml")
+
+	vals, err := BodyToValues(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Verify(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := pay.NoticeParams{
+		OrderID:     "20190701000001",
+		PaymentID:   "1900000109471907011200000001",
+		TradeStatus: pay.TradeStatusSuccess,
+		Amount:      101,
+	}
+	if *r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	vals, _ = BodyToValues(strings.Replace(body, "<total_fee>101", "<total_fee>1", 1))
+	if _, err := p.Verify(vals); err == nil {
+		t.Error("tampered notice verified")
+	}
+}
+
+func TestCallQrcode(t *testing.T) {
+	rsp := readFixture(t, "unifiedorder_response.xml")
+
+	var req url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, _ = BodyToValues(string(body))
+		w.Write([]byte(rsp))
+	}))
+	defer s.Close()
+
+	p := newTestQpay(t, s.URL)
+
+	codeURL, err := p.Call(pay.WayQrcode, pay.Order{ID: "20190701000001", Title: "test", Amount: 101, IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codeURL != "https://qpay.qq.com/qr/5f9a2b7c" {
+		t.Errorf("code_url %s", codeURL)
+	}
+	if req.Get("trade_type") != kTradeTypeNative || req.Get("total_fee") != "101" || len(req.Get("sign")) != 32 {
+		t.Errorf("request %v", req)
+	}
+}
+
+func TestError(t *testing.T) {
+	const key = "8934e7d15453e97507ef794cf7b0519d"
+
+	for _, c := range []struct {
+		name      string
+		rsp       url.Values
+		code      string
+		subCode   string
+		category  pay.Category
+		retryable bool
+	}{
+		{"return fail", url.Values{"return_code": {"FAIL"}, "return_msg": {"签名错误"}}, "FAIL", "", pay.CategoryAuth, false},
+		{"not enough", url.Values{"return_code": {"SUCCESS"}, "result_code": {"FAIL"}, "err_code": {"NOTENOUGH"}, "err_code_des": {"余额不足"}}, "FAIL", "NOTENOUGH", pay.CategoryBalance, false},
+		{"system error", url.Values{"return_code": {"SUCCESS"}, "result_code": {"FAIL"}, "err_code": {"SYSTEMERROR"}, "err_code_des": {"系统错误"}}, "FAIL", "SYSTEMERROR", pay.CategorySystem, true},
+	} {
+		c.rsp.Set("sign", wxpay.SignMD5(c.rsp, key))
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(wxpay.URLValueToXML(c.rsp)))
+		}))
+
+		_, err := newTestQpay(t, s.URL).Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 101})
+		s.Close()
+
+		e, ok := err.(*pay.Error)
+		if !ok {
+			t.Errorf("%s: got %T %v, want *pay.Error", c.name, err, err)
+			continue
+		}
+		if e.Provider != kProvider || e.Code != c.code || e.SubCode != c.subCode || e.Category != c.category || e.Retryable != c.retryable || len(e.Message) == 0 {
+			t.Errorf("%s: got %+v", c.name, e)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	if p := New(Options{}); p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	p := New(Options{APIDomain: s.URL, Client: &http.Client{Timeout: 10 * time.Millisecond}})
+	_, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	if e, ok := err.(*pay.Error); !ok || !e.Retryable || e.Category != pay.CategorySystem {
+		t.Errorf("got %v, want a retryable network error", err)
+	}
+}
@@ -0,0 +1,17 @@
+package pay
+
+import "errors"
+
+var (
+	// ErrOrderNotExist 支付平台没有该订单，用户未扫码或未调起支付
+	ErrOrderNotExist = errors.New("order not exist")
+)
+
+// Querier 订单查询和关闭，用于回调丢失时主动查询
+type Querier interface {
+	// Query 按商户订单号查询订单，支付平台没有该订单时返回 ErrOrderNotExist
+	Query(orderID string) (*NoticeParams, error)
+
+	// Close 关闭未支付的订单，支付平台没有该订单时返回 nil
+	Close(orderID string) error
+}
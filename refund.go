@@ -0,0 +1,37 @@
+package pay
+
+// RefundStatus 退款状态
+type RefundStatus int
+
+const (
+	// RefundStatusProcessing 退款处理中，以退款查询或回调结果为准
+	RefundStatusProcessing RefundStatus = iota
+	// RefundStatusSuccess 退款成功
+	RefundStatusSuccess
+	// RefundStatusFailed 退款失败
+	RefundStatusFailed
+)
+
+// Refunder 退款
+type Refunder interface {
+	// Refund 申请退款，同一 RefundOrder.ID 重复调用不会重复退款
+	Refund(RefundOrder) (*RefundResult, error)
+}
+
+// RefundOrder 退款信息
+type RefundOrder struct {
+	ID          string // 商户退款单号
+	OrderID     string // 商户订单号，与PaymentID二选一
+	PaymentID   string // 支付单号，Stripe 为 PaymentIntent id
+	TotalAmount int32  // 订单金额，仅微信
+	Amount      int32  // 退款金额
+	Reason      string // 退款原因
+}
+
+// RefundResult 退款结果
+type RefundResult struct {
+	ID       string       // 商户退款单号
+	RefundID string       // 支付平台退款单号
+	Status   RefundStatus // 退款状态
+	Amount   int32        // 退款金额
+}
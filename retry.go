@@ -0,0 +1,162 @@
+package pay
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen 熔断中，请求没有发到支付平台
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// DefaultRetryBackoff 默认重试间隔，第n次重试前的等待时间，超过后使用最后一个
+var DefaultRetryBackoff = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second}
+
+// RetryPolicy 重试策略，只重试幂等的接口和支付平台声明可重试的错误
+type RetryPolicy struct {
+	MaxAttempts int             // 最多请求次数，包括第一次，小于2时不重试
+	Backoff     []time.Duration // 重试间隔，默认 DefaultRetryBackoff
+}
+
+// IsRetryable 是否为可重试的错误，err 可以是包装了 *Error 的错误
+func IsRetryable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retryable
+}
+
+// Retry 调用 fn，返回 Retryable 的 *Error 时按 policy 重试，policy 为空时不重试
+// breaker 不为空时每次请求前检查熔断，熔断中返回 ErrCircuitOpen
+func Retry(policy *RetryPolicy, breaker *Breaker, fn func() error) error {
+	var attempts = 1
+	if policy != nil && policy.MaxAttempts > 1 {
+		attempts = policy.MaxAttempts
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(policy.Delay(i - 1))
+		}
+
+		if !breaker.Allow() {
+			return ErrCircuitOpen
+		}
+
+		err = fn()
+		breaker.Done(err)
+
+		if !IsRetryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Delay 第 i 次重试前的等待时间，i 从 0 开始，p 为空时使用 DefaultRetryBackoff
+func (p *RetryPolicy) Delay(i int) time.Duration {
+	var backoff []time.Duration
+	if p != nil {
+		backoff = p.Backoff
+	}
+	if len(backoff) == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	if i >= len(backoff) {
+		i = len(backoff) - 1
+	}
+	return backoff[i]
+}
+
+const (
+	kBreakerFailures = 5
+	kBreakerCooldown = 30 * time.Second
+)
+
+// BreakerOptions BreakerOptions
+type BreakerOptions struct {
+	Failures int           // 连续失败次数，达到后熔断，默认 5
+	Cooldown time.Duration // 熔断时长，之后放行一个请求试探，成功后恢复，默认 30s
+}
+
+// Breaker 熔断器，每个支付平台一个，只有可重试的 *Error 计为失败，业务错误不影响熔断
+// 空的 *Breaker 不熔断
+type Breaker struct {
+	opt BreakerOptions
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker New
+func NewBreaker(opt BreakerOptions) *Breaker {
+	if opt.Failures <= 0 {
+		opt.Failures = kBreakerFailures
+	}
+	if opt.Cooldown <= 0 {
+		opt.Cooldown = kBreakerCooldown
+	}
+
+	return &Breaker{
+		opt: opt,
+	}
+}
+
+// Allow 是否放行请求，熔断时长过后只放行一个试探请求
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.opt.Failures {
+		return true
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// Done 记录请求结果，err 为可重试的错误时计为失败
+func (b *Breaker) Done(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !IsRetryable(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.opt.Failures {
+		b.openUntil = time.Now().Add(b.opt.Cooldown)
+	}
+}
+
+// Open 是否熔断中
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.opt.Failures && time.Now().Before(b.openUntil)
+}
@@ -0,0 +1,25 @@
+package pay
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	var retryable = &Error{Provider: "test", Retryable: true}
+
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{retryable, true},
+		{&Error{Provider: "test"}, false},
+		{fmt.Errorf("query: %w", retryable), true},
+		{ErrCircuitOpen, false},
+	} {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
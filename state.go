@@ -0,0 +1,188 @@
+package pay
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OrderState 订单状态，由支付回调、查询结果和退款结果推进
+type OrderState int
+
+const (
+	// OrderStateCreated 订单创建，未调起支付
+	OrderStateCreated OrderState = iota
+	// OrderStatePending 已调起支付，等待买家付款
+	OrderStatePending
+	// OrderStatePaid 支付成功
+	OrderStatePaid
+	// OrderStatePartiallyRefunded 部分退款，可继续退款
+	OrderStatePartiallyRefunded
+	// OrderStateRefunded 全额退款
+	OrderStateRefunded
+	// OrderStateClosed 未付款关闭
+	OrderStateClosed
+	// OrderStateFinished 交易结束，不可退款
+	OrderStateFinished
+)
+
+var orderStateNames = map[OrderState]string{
+	OrderStateCreated:           "created",
+	OrderStatePending:           "pending",
+	OrderStatePaid:              "paid",
+	OrderStatePartiallyRefunded: "partially_refunded",
+	OrderStateRefunded:          "refunded",
+	OrderStateClosed:            "closed",
+	OrderStateFinished:          "finished",
+}
+
+// String String
+func (s OrderState) String() string {
+	if name, ok := orderStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// orderTransitions 合法的状态变更，Refunded Closed Finished 为终态
+// 漏掉支付成功回调时，未支付的订单可以直接变更为 Finished
+var orderTransitions = map[OrderState][]OrderState{
+	OrderStateCreated:           {OrderStatePending, OrderStatePaid, OrderStateClosed, OrderStateFinished},
+	OrderStatePending:           {OrderStatePaid, OrderStateClosed, OrderStateFinished},
+	OrderStatePaid:              {OrderStatePartiallyRefunded, OrderStateRefunded, OrderStateFinished},
+	OrderStatePartiallyRefunded: {OrderStatePartiallyRefunded, OrderStateRefunded, OrderStateFinished},
+}
+
+var (
+	// ErrInvalidTransition 非法的状态变更，如乱序回调导致的状态回退
+	ErrInvalidTransition = errors.New("invalid order state transition")
+)
+
+// CanTransition 是否可以变更到 to
+func (s OrderState) CanTransition(to OrderState) bool {
+	for _, v := range orderTransitions[s] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal 是否为终态
+func (s OrderState) Terminal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+// NextState 支付回调或查询结果对应的订单状态
+// TradeStatusClosed 在已支付后表示全额退款
+func NextState(cur OrderState, status TradeStatus) OrderState {
+	switch status {
+	case TradeStatusWait:
+		return OrderStatePending
+	case TradeStatusSuccess:
+		return OrderStatePaid
+	case TradeStatusClosed:
+		if cur == OrderStatePaid || cur == OrderStatePartiallyRefunded {
+			return OrderStateRefunded
+		}
+		return OrderStateClosed
+	case TradeStatusFinished:
+		return OrderStateFinished
+	}
+
+	return cur
+}
+
+// RefundState 退款成功后的订单状态，refunded 为累计退款金额
+func RefundState(amount, refunded int32) OrderState {
+	if refunded >= amount {
+		return OrderStateRefunded
+	}
+	return OrderStatePartiallyRefunded
+}
+
+// OrderStateEvent 订单状态变更事件
+type OrderStateEvent struct {
+	OrderID string
+	From    OrderState
+	To      OrderState
+	Time    time.Time
+}
+
+// OrderStateMachine 校验订单状态变更并通知订阅者，并发安全
+// 订单当前状态由调用方保存，状态机不保存订单
+type OrderStateMachine struct {
+	// OnError 订阅者 panic 时调用，为空时忽略，panic 不影响状态变更和其他订阅者
+	OnError func(e OrderStateEvent, err error)
+
+	mu   sync.RWMutex
+	subs []func(OrderStateEvent)
+}
+
+// NewOrderStateMachine New
+func NewOrderStateMachine() *OrderStateMachine {
+	return &OrderStateMachine{}
+}
+
+// Subscribe 订阅状态变更事件，按订阅顺序同步调用，订阅者 panic 时调用 OnError
+func (m *OrderStateMachine) Subscribe(fn func(OrderStateEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subs = append(m.subs, fn)
+}
+
+// Transition 从 from 变更到 to，成功后通知订阅者
+// from 与 to 相同时为重复通知，不变更也不通知，部分退款除外
+// 其他不在 orderTransitions 中的变更返回 ErrInvalidTransition
+func (m *OrderStateMachine) Transition(orderID string, from, to OrderState) error {
+	if from == to && to != OrderStatePartiallyRefunded {
+		return nil
+	}
+
+	if !from.CanTransition(to) {
+		return ErrInvalidTransition
+	}
+
+	m.emit(OrderStateEvent{
+		OrderID: orderID,
+		From:    from,
+		To:      to,
+		Time:    time.Now(),
+	})
+
+	return nil
+}
+
+// Notify 按支付回调或查询结果变更状态，返回变更后的状态
+// 过期的回调返回 ErrInvalidTransition 和当前状态
+func (m *OrderStateMachine) Notify(cur OrderState, in *NoticeParams) (OrderState, error) {
+	next := NextState(cur, in.TradeStatus)
+	if err := m.Transition(in.OrderID, cur, next); err != nil {
+		return cur, err
+	}
+
+	return next, nil
+}
+
+func (m *OrderStateMachine) emit(e OrderStateEvent) {
+	m.mu.RLock()
+	subs := m.subs
+	m.mu.RUnlock()
+
+	for _, fn := range subs {
+		m.call(fn, e)
+	}
+}
+
+// call 调用订阅者，recover 订阅者的 panic
+func (m *OrderStateMachine) call(fn func(OrderStateEvent), e OrderStateEvent) {
+	defer func() {
+		if v := recover(); v != nil && m.OnError != nil {
+			m.OnError(e, fmt.Errorf("order state subscriber panic: %v", v))
+		}
+	}()
+
+	fn(e)
+}
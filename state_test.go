@@ -0,0 +1,170 @@
+package pay
+
+import (
+	"testing"
+)
+
+func TestTransitionSubscriberPanic(t *testing.T) {
+	var (
+		m      = NewOrderStateMachine()
+		called []string
+		errs   int
+	)
+	m.OnError = func(e OrderStateEvent, err error) {
+		errs++
+	}
+	m.Subscribe(func(e OrderStateEvent) {
+		called = append(called, "first")
+		panic("boom")
+	})
+	m.Subscribe(func(e OrderStateEvent) {
+		called = append(called, "second")
+	})
+
+	if err := m.Transition("O1", OrderStatePending, OrderStatePaid); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(called) != 2 || errs != 1 {
+		t.Errorf("called %v, %d errors", called, errs)
+	}
+
+	// OnError 为空时忽略
+	m.OnError = nil
+	if err := m.Transition("O1", OrderStatePaid, OrderStateRefunded); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var allOrderStates = []OrderState{
+	OrderStateCreated,
+	OrderStatePending,
+	OrderStatePaid,
+	OrderStatePartiallyRefunded,
+	OrderStateRefunded,
+	OrderStateClosed,
+	OrderStateFinished,
+}
+
+func TestCanTransition(t *testing.T) {
+	// 合法的状态变更，其余都不合法
+	allowed := map[[2]OrderState]bool{
+		{OrderStateCreated, OrderStatePending}:                     true,
+		{OrderStateCreated, OrderStatePaid}:                        true,
+		{OrderStateCreated, OrderStateClosed}:                      true,
+		{OrderStateCreated, OrderStateFinished}:                    true,
+		{OrderStatePending, OrderStatePaid}:                        true,
+		{OrderStatePending, OrderStateClosed}:                      true,
+		{OrderStatePending, OrderStateFinished}:                    true,
+		{OrderStatePaid, OrderStatePartiallyRefunded}:              true,
+		{OrderStatePaid, OrderStateRefunded}:                       true,
+		{OrderStatePaid, OrderStateFinished}:                       true,
+		{OrderStatePartiallyRefunded, OrderStatePartiallyRefunded}: true,
+		{OrderStatePartiallyRefunded, OrderStateRefunded}:          true,
+		{OrderStatePartiallyRefunded, OrderStateFinished}:          true,
+	}
+
+	for _, from := range allOrderStates {
+		for _, to := range allOrderStates {
+			want := allowed[[2]OrderState{from, to}]
+			if got := from.CanTransition(to); got != want {
+				t.Errorf("%v -> %v = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	for _, s := range allOrderStates {
+		want := s == OrderStateRefunded || s == OrderStateClosed || s == OrderStateFinished
+		if got := s.Terminal(); got != want {
+			t.Errorf("%v terminal = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	for _, c := range []struct {
+		from, to OrderState
+		err      error
+		emit     bool
+	}{
+		{OrderStatePending, OrderStatePaid, nil, true},
+		{OrderStatePaid, OrderStatePaid, nil, false},
+		{OrderStateRefunded, OrderStateRefunded, nil, false},
+		{OrderStatePartiallyRefunded, OrderStatePartiallyRefunded, nil, true},
+		// 乱序回调导致的状态回退
+		{OrderStatePaid, OrderStatePending, ErrInvalidTransition, false},
+		{OrderStateRefunded, OrderStatePaid, ErrInvalidTransition, false},
+		{OrderStateRefunded, OrderStatePartiallyRefunded, ErrInvalidTransition, false},
+		{OrderStateClosed, OrderStatePaid, ErrInvalidTransition, false},
+		{OrderStateFinished, OrderStateRefunded, ErrInvalidTransition, false},
+	} {
+		var (
+			m      = NewOrderStateMachine()
+			events []OrderStateEvent
+		)
+		m.Subscribe(func(e OrderStateEvent) { events = append(events, e) })
+
+		if err := m.Transition("O1", c.from, c.to); err != c.err {
+			t.Errorf("%v -> %v: got %v, want %v", c.from, c.to, err, c.err)
+		}
+		if c.emit != (len(events) == 1) {
+			t.Errorf("%v -> %v: events %+v", c.from, c.to, events)
+		}
+		if len(events) == 1 && (events[0].OrderID != "O1" || events[0].From != c.from || events[0].To != c.to) {
+			t.Errorf("%v -> %v: event %+v", c.from, c.to, events[0])
+		}
+	}
+}
+
+func TestNextState(t *testing.T) {
+	for _, c := range []struct {
+		cur    OrderState
+		status TradeStatus
+		want   OrderState
+	}{
+		{OrderStateCreated, TradeStatusWait, OrderStatePending},
+		{OrderStatePending, TradeStatusSuccess, OrderStatePaid},
+		{OrderStatePending, TradeStatusClosed, OrderStateClosed},
+		{OrderStatePending, TradeStatusFinished, OrderStateFinished},
+		// 已支付后关闭为全额退款
+		{OrderStatePaid, TradeStatusClosed, OrderStateRefunded},
+		{OrderStatePartiallyRefunded, TradeStatusClosed, OrderStateRefunded},
+		{OrderStatePaid, TradeStatusWait, OrderStatePending},
+		{OrderStatePaid, TradeStatus(-1), OrderStatePaid},
+	} {
+		if got := NextState(c.cur, c.status); got != c.want {
+			t.Errorf("NextState(%v, %v) = %v, want %v", c.cur, c.status, got, c.want)
+		}
+	}
+}
+
+func TestRefundState(t *testing.T) {
+	for _, c := range []struct {
+		amount, refunded int32
+		want             OrderState
+	}{
+		{100, 1, OrderStatePartiallyRefunded},
+		{100, 99, OrderStatePartiallyRefunded},
+		{100, 100, OrderStateRefunded},
+		{100, 101, OrderStateRefunded},
+	} {
+		if got := RefundState(c.amount, c.refunded); got != c.want {
+			t.Errorf("RefundState(%d, %d) = %v, want %v", c.amount, c.refunded, got, c.want)
+		}
+	}
+}
+
+func TestNotifyStale(t *testing.T) {
+	m := NewOrderStateMachine()
+
+	next, err := m.Notify(OrderStatePending, &NoticeParams{OrderID: "O1", TradeStatus: TradeStatusSuccess})
+	if err != nil || next != OrderStatePaid {
+		t.Fatalf("got %v %v, want paid", next, err)
+	}
+
+	// 支付成功后收到的待支付回调不回退状态
+	next, err = m.Notify(OrderStatePaid, &NoticeParams{OrderID: "O1", TradeStatus: TradeStatusWait})
+	if err != ErrInvalidTransition || next != OrderStatePaid {
+		t.Errorf("got %v %v, want paid %v", next, err, ErrInvalidTransition)
+	}
+}
@@ -0,0 +1,285 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+// Locker 租约锁，多实例运行 Compensator 时同一订单同时只有一个实例处理
+type Locker interface {
+	// Lock 获取 key 的租约，ttl 后自动过期，返回持有者 token，已被其他实例持有时返回空
+	Lock(key string, ttl time.Duration) (string, error)
+	// Unlock 释放租约，token 与当前持有者不一致时不释放，租约过期后已被其他实例获取时不会误删
+	Unlock(key, token string) error
+}
+
+// NewLockToken 随机的租约持有者 token，Locker 实现可以使用
+func NewLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DefaultBackoff 默认查询间隔，第n次查询距上次查询的间隔，超过后使用最后一个
+var DefaultBackoff = []time.Duration{15 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+const (
+	kCompensateInterval = 10 * time.Second
+	kCompensateBatch    = 100
+	kOrderExpire        = 2 * time.Hour
+	kLeaseTTL           = time.Minute
+	kLockPrefix         = "pay:compensate:"
+
+	// 主动查询和关闭订单记录的 Notice.Raw
+	kRawQuery = "source=query"
+	kRawClose = "source=close"
+)
+
+// CompensatorOptions CompensatorOptions
+type CompensatorOptions struct {
+	Interval  time.Duration                   // 扫描间隔，默认 10s
+	BatchSize int                             // 每次扫描的订单数，默认 100
+	Backoff   []time.Duration                 // 查询间隔，默认 DefaultBackoff
+	Expire    time.Duration                   // 订单有效期，从创建时间算起，过期后关闭订单，默认 2h
+	LeaseTTL  time.Duration                   // 单个订单的租约时长，默认 1m
+	Locker    Locker                          // 为空时只在当前进程内互斥
+	OnError   func(orderID string, err error) // 查询、关闭或保存失败时调用，orderID 为空时为扫描失败
+}
+
+// Compensator 回调丢失补偿，扫描未支付的订单，按退避间隔主动查询支付平台
+// 查询结果经 Recorder.Notify 处理，与回调使用同一流程，过期订单在支付平台关闭
+// 支付平台需要实现 pay.Querier
+type Compensator struct {
+	store     Store
+	opt       CompensatorOptions
+	recorders map[string]*Recorder
+}
+
+// NewCompensator New，recorders 按 Recorder.Provider 匹配订单的支付平台
+func NewCompensator(s Store, opt CompensatorOptions, recorders ...*Recorder) *Compensator {
+	if opt.Interval <= 0 {
+		opt.Interval = kCompensateInterval
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = kCompensateBatch
+	}
+	if len(opt.Backoff) == 0 {
+		opt.Backoff = DefaultBackoff
+	}
+	if opt.Expire <= 0 {
+		opt.Expire = kOrderExpire
+	}
+	if opt.LeaseTTL <= 0 {
+		opt.LeaseTTL = kLeaseTTL
+	}
+	if opt.Locker == nil {
+		opt.Locker = &localLocker{leases: make(map[string]lease)}
+	}
+
+	c := &Compensator{
+		store:     s,
+		opt:       opt,
+		recorders: make(map[string]*Recorder, len(recorders)),
+	}
+	for _, r := range recorders {
+		c.recorders[r.Provider] = r
+	}
+
+	return c
+}
+
+// Run 按 Interval 扫描，直到 ctx 结束
+func (c *Compensator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.RunOnce(time.Now()); err != nil {
+			c.onError("", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 扫描一次到了查询时间的订单，返回处理的订单数
+func (c *Compensator) RunOnce(now time.Time) (int, error) {
+	orders, err := c.store.ListPending(now, c.opt.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, o := range orders {
+		token, err := c.opt.Locker.Lock(kLockPrefix+o.ID, c.opt.LeaseTTL)
+		if err != nil {
+			c.onError(o.ID, err)
+			continue
+		}
+		if len(token) == 0 {
+			continue
+		}
+
+		if err := c.compensate(o.ID, now); err != nil {
+			c.onError(o.ID, err)
+		}
+		n++
+
+		if err := c.opt.Locker.Unlock(kLockPrefix+o.ID, token); err != nil {
+			c.onError(o.ID, err)
+		}
+	}
+
+	return n, nil
+}
+
+// compensate 查询单个订单，有结果时经 Recorder.Notify 处理，未支付时关闭过期订单或安排下次查询
+func (c *Compensator) compensate(id string, now time.Time) error {
+	// 获取租约后重新读取，其他实例可能已经处理
+	o, err := c.store.GetOrder(id)
+	if err != nil {
+		return err
+	}
+
+	if (o.State != pay.OrderStateCreated && o.State != pay.OrderStatePending) || o.NextQueryAt.After(now) {
+		return nil
+	}
+
+	// 刚创建的订单等到第一个查询间隔再查询
+	if first := o.CreatedAt.Add(c.opt.Backoff[0]); o.QueryCount == 0 && now.Before(first) {
+		o.NextQueryAt = first
+		return c.update(o)
+	}
+
+	r, ok := c.recorders[o.Provider]
+	if !ok {
+		return c.schedule(o, now, ErrProviderNotFound)
+	}
+
+	q, ok := r.Payer.(pay.Querier)
+	if !ok || !pay.Supports(r.Payer, pay.OpQuery) {
+		return c.schedule(o, now, ErrQueryNotSupported)
+	}
+
+	params, err := q.Query(o.ID)
+	if errors.Is(err, pay.ErrNotSupported) {
+		return c.schedule(o, now, ErrQueryNotSupported)
+	}
+	if err != nil && err != pay.ErrOrderNotExist {
+		return c.schedule(o, now, err)
+	}
+
+	// 处理失败时同样按退避间隔安排下次查询，避免每次扫描都重复查询
+	if err == nil && params.TradeStatus != pay.TradeStatusWait {
+		if err := r.Notify(params, kRawQuery); err != nil {
+			return c.schedule(o, now, err)
+		}
+		return nil
+	}
+
+	if now.Before(o.CreatedAt.Add(c.opt.Expire)) {
+		return c.schedule(o, now, nil)
+	}
+
+	if err := q.Close(o.ID); err != nil {
+		return c.schedule(o, now, err)
+	}
+
+	err = r.Notify(&pay.NoticeParams{
+		OrderID:     o.ID,
+		PaymentID:   o.PaymentID,
+		TradeStatus: pay.TradeStatusClosed,
+		Amount:      o.Amount,
+	}, kRawClose)
+	if err != nil {
+		return c.schedule(o, now, err)
+	}
+
+	return nil
+}
+
+// schedule 按退避间隔安排下次查询，cause 不为空时返回 cause
+func (c *Compensator) schedule(o *Order, now time.Time, cause error) error {
+	o.QueryCount++
+
+	i := o.QueryCount
+	if i >= len(c.opt.Backoff) {
+		i = len(c.opt.Backoff) - 1
+	}
+	o.NextQueryAt = now.Add(c.opt.Backoff[i])
+
+	if err := c.update(o); err != nil {
+		return err
+	}
+
+	return cause
+}
+
+// update 保存查询计划，版本冲突时订单已被回调或其他实例更新，忽略
+func (c *Compensator) update(o *Order) error {
+	if err := c.store.UpdateOrder(o); err != nil && err != ErrConflict {
+		return err
+	}
+	return nil
+}
+
+func (c *Compensator) onError(orderID string, err error) {
+	if c.opt.OnError != nil {
+		c.opt.OnError(orderID, err)
+	}
+}
+
+// localLocker 进程内租约
+type localLocker struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+type lease struct {
+	token   string
+	expires time.Time
+}
+
+// Lock Lock
+func (l *localLocker) Lock(key string, ttl time.Duration) (string, error) {
+	token, err := NewLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if v, ok := l.leases[key]; ok && now.Before(v.expires) {
+		return "", nil
+	}
+
+	l.leases[key] = lease{token: token, expires: now.Add(ttl)}
+
+	return token, nil
+}
+
+// Unlock Unlock
+func (l *localLocker) Unlock(key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := l.leases[key]; ok && v.token == token {
+		delete(l.leases, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+func TestLocalLockerToken(t *testing.T) {
+	l := &localLocker{leases: make(map[string]lease)}
+
+	a, err := l.Lock("k", time.Millisecond)
+	if err != nil || len(a) == 0 {
+		t.Fatalf("lock: %q %v", a, err)
+	}
+	if b, _ := l.Lock("k", time.Minute); len(b) > 0 {
+		t.Fatal("lock held by a acquired")
+	}
+
+	// a 的租约过期后被 b 获取，a 释放时不能删除 b 的租约
+	time.Sleep(2 * time.Millisecond)
+	b, err := l.Lock("k", time.Minute)
+	if err != nil || len(b) == 0 {
+		t.Fatalf("lock after expiry: %q %v", b, err)
+	}
+
+	if err := l.Unlock("k", a); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := l.Lock("k", time.Minute); len(c) > 0 {
+		t.Fatal("stale owner released the lease")
+	}
+
+	if err := l.Unlock("k", b); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := l.Lock("k", time.Minute); len(c) == 0 {
+		t.Fatal("lease not released by its owner")
+	}
+}
+
+func TestCompensateWrappedNotSupported(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("basic", pay.Wrap("basic", basicPayer{}), s)
+	if _, err := r.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	c := NewCompensator(s, CompensatorOptions{
+		Backoff: []time.Duration{time.Millisecond},
+		OnError: func(orderID string, err error) { errs = append(errs, err) },
+	}, r)
+
+	now := time.Now().Add(time.Second)
+	if _, err := c.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 1 || errs[0] != ErrQueryNotSupported {
+		t.Errorf("errors %v, want %v", errs, ErrQueryNotSupported)
+	}
+	if o, _ := s.GetOrder("O1"); o.QueryCount != 1 {
+		t.Errorf("query count %d, want 1", o.QueryCount)
+	}
+}
+
+// queryPayer 查询返回 status amount 的支付平台
+type queryPayer struct {
+	fakePayer
+	status   pay.TradeStatus
+	amount   int32
+	closeErr error
+
+	queries, closes int
+}
+
+func (p *queryPayer) Query(orderID string) (*pay.NoticeParams, error) {
+	p.queries++
+	return &pay.NoticeParams{OrderID: orderID, PaymentID: "P" + orderID, TradeStatus: p.status, Amount: p.amount}, nil
+}
+
+func (p *queryPayer) Close(orderID string) error {
+	p.closes++
+	return p.closeErr
+}
+
+// newTestCompensator 创建待支付订单 O1，返回订单创建时间
+func newTestCompensator(t *testing.T, p *queryPayer, opt CompensatorOptions) (*Compensator, *memStore, time.Time, *[]error) {
+	t.Helper()
+
+	s := newMemStore()
+	r := NewRecorder("query", p, s)
+	if _, err := r.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+	o, _ := s.GetOrder("O1")
+
+	var errs []error
+	opt.OnError = func(orderID string, err error) { errs = append(errs, err) }
+
+	return NewCompensator(s, opt, r), s, o.CreatedAt, &errs
+}
+
+func TestCompensateBackoff(t *testing.T) {
+	p := &queryPayer{status: pay.TradeStatusWait}
+	c, s, created, errs := newTestCompensator(t, p, CompensatorOptions{
+		Backoff: []time.Duration{time.Second, 2 * time.Second, 5 * time.Second},
+		Expire:  time.Hour,
+	})
+
+	for _, step := range []struct {
+		at      time.Duration // 距创建时间
+		queries int
+		next    time.Duration // 下次查询距创建时间
+	}{
+		{0, 0, time.Second}, // 第一个间隔后再查询
+		{time.Second, 1, 3 * time.Second},
+		{2 * time.Second, 1, 3 * time.Second}, // 未到查询时间
+		{3 * time.Second, 2, 8 * time.Second},
+		{8 * time.Second, 3, 13 * time.Second}, // 超过后使用最后一个间隔
+	} {
+		if _, err := c.RunOnce(created.Add(step.at)); err != nil {
+			t.Fatal(err)
+		}
+
+		o, _ := s.GetOrder("O1")
+		if p.queries != step.queries || !o.NextQueryAt.Equal(created.Add(step.next)) {
+			t.Errorf("at %v: %d queries, next %v, want %d, %v", step.at, p.queries, o.NextQueryAt.Sub(created), step.queries, step.next)
+		}
+	}
+
+	if len(*errs) != 0 {
+		t.Errorf("errors %v", *errs)
+	}
+}
+
+func TestCompensateQueryNotify(t *testing.T) {
+	p := &queryPayer{status: pay.TradeStatusSuccess, amount: 100}
+	c, s, created, errs := newTestCompensator(t, p, CompensatorOptions{Backoff: []time.Duration{time.Second}})
+
+	if n, err := c.RunOnce(created.Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("got %d %v", n, err)
+	}
+
+	o, _ := s.GetOrder("O1")
+	if o.State != pay.OrderStatePaid || o.PaymentID != "PO1" {
+		t.Errorf("order %+v, want paid", o)
+	}
+	if n := s.notices[len(s.notices)-1]; n.Raw != kRawQuery || len(n.Error) > 0 {
+		t.Errorf("notice %+v", n)
+	}
+	if len(*errs) != 0 {
+		t.Errorf("errors %v", *errs)
+	}
+}
+
+func TestCompensateNotifyError(t *testing.T) {
+	p := &queryPayer{status: pay.TradeStatusSuccess, amount: 1}
+	c, s, created, errs := newTestCompensator(t, p, CompensatorOptions{Backoff: []time.Duration{time.Second, time.Minute}})
+
+	now := created.Add(time.Second)
+	if _, err := c.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 金额不一致时订单不变，按退避间隔安排下次查询
+	o, _ := s.GetOrder("O1")
+	if o.State != pay.OrderStatePending || o.QueryCount != 1 || !o.NextQueryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("order %+v", o)
+	}
+	if len(*errs) != 1 || (*errs)[0] != ErrAmountMismatch {
+		t.Errorf("errors %v, want %v", *errs, ErrAmountMismatch)
+	}
+
+	if n, _ := c.RunOnce(now); n != 0 || p.queries != 1 {
+		t.Errorf("requeried %d orders, %d queries", n, p.queries)
+	}
+}
+
+func TestCompensateCloseExpired(t *testing.T) {
+	p := &queryPayer{status: pay.TradeStatusWait, closeErr: pay.ErrVerify}
+	c, s, created, errs := newTestCompensator(t, p, CompensatorOptions{
+		Backoff: []time.Duration{time.Second},
+		Expire:  time.Hour,
+	})
+
+	// 关闭失败时安排下次查询
+	now := created.Add(time.Hour)
+	if _, err := c.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.GetOrder("O1"); o.State != pay.OrderStatePending || o.QueryCount != 1 || p.closes != 1 {
+		t.Errorf("order %+v, %d closes", o, p.closes)
+	}
+	if len(*errs) != 1 || (*errs)[0] != pay.ErrVerify {
+		t.Errorf("errors %v", *errs)
+	}
+
+	p.closeErr = nil
+	if _, err := c.RunOnce(now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.GetOrder("O1"); o.State != pay.OrderStateClosed || p.closes != 2 {
+		t.Errorf("order %+v, %d closes", o, p.closes)
+	}
+	if n := s.notices[len(s.notices)-1]; n.Raw != kRawClose || n.TradeStatus != pay.TradeStatusClosed {
+		t.Errorf("notice %+v", n)
+	}
+}
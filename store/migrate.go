@@ -0,0 +1,158 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect 数据库类型
+type Dialect int
+
+const (
+	// MySQL MySQL 5.7+
+	MySQL Dialect = iota
+	// Postgres PostgreSQL 9.5+
+	Postgres
+	// SQLite SQLite 3.24+
+	SQLite
+)
+
+// Migrations 建表语句，表名前缀为 prefix，语句可重复执行
+func Migrations(dialect Dialect, prefix string) []string {
+	var autoID = "BIGINT AUTO_INCREMENT PRIMARY KEY"
+	switch dialect {
+	case Postgres:
+		autoID = "BIGSERIAL PRIMARY KEY"
+	case SQLite:
+		autoID = "INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+
+	var stmts = []string{
+		`CREATE TABLE IF NOT EXISTS {orders} (
+	id VARCHAR(64) NOT NULL PRIMARY KEY,
+	provider VARCHAR(32) NOT NULL,
+	title VARCHAR(256) NOT NULL,
+	amount INT NOT NULL,
+	currency VARCHAR(8) NOT NULL,
+	state INT NOT NULL,
+	payment_id VARCHAR(128) NOT NULL,
+	refunded INT NOT NULL,
+	version BIGINT NOT NULL,
+	query_count INT NOT NULL,
+	next_query_at BIGINT NOT NULL,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL{orders_index}
+)`,
+		`CREATE TABLE IF NOT EXISTS {attempts} (
+	id ` + autoID + `,
+	order_id VARCHAR(64) NOT NULL,
+	provider VARCHAR(32) NOT NULL,
+	way VARCHAR(16) NOT NULL,
+	amount INT NOT NULL,
+	result TEXT NOT NULL,
+	error TEXT NOT NULL,
+	created_at BIGINT NOT NULL{attempts_index}
+)`,
+		`CREATE TABLE IF NOT EXISTS {notices} (
+	id ` + autoID + `,
+	order_id VARCHAR(64) NOT NULL,
+	provider VARCHAR(32) NOT NULL,
+	payment_id VARCHAR(128) NOT NULL,
+	trade_status INT NOT NULL,
+	amount INT NOT NULL,
+	raw TEXT NOT NULL,
+	error TEXT NOT NULL,
+	created_at BIGINT NOT NULL{notices_index}
+)`,
+		`CREATE TABLE IF NOT EXISTS {refunds} (
+	id VARCHAR(64) NOT NULL PRIMARY KEY,
+	order_id VARCHAR(64) NOT NULL,
+	provider VARCHAR(32) NOT NULL,
+	refund_id VARCHAR(128) NOT NULL,
+	amount INT NOT NULL,
+	status INT NOT NULL,
+	reason VARCHAR(256) NOT NULL,
+	error TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL{refunds_index}
+)`,
+		`CREATE TABLE IF NOT EXISTS {outbox} (
+	id ` + autoID + `,
+	type VARCHAR(64) NOT NULL,
+	order_id VARCHAR(64) NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INT NOT NULL,
+	next_attempt_at BIGINT NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	published_at BIGINT NOT NULL,
+	dead_at BIGINT NOT NULL{outbox_index}
+)`,
+	}
+
+	// MySQL 不支持 CREATE INDEX IF NOT EXISTS，索引在建表时创建
+	var indexes = []struct {
+		table, name, columns string
+	}{
+		{"orders", "pending", "state, next_query_at"},
+		{"attempts", "order_id", "order_id"},
+		{"notices", "order_id", "order_id"},
+		{"refunds", "order_id", "order_id"},
+		{"outbox", "pending", "published_at, next_attempt_at"},
+	}
+	for _, idx := range indexes {
+		var (
+			name   = "idx_{" + idx.table + "}_" + idx.name
+			inline string
+		)
+		if dialect == MySQL {
+			inline = ",\n\tKEY " + name + " (" + idx.columns + ")"
+		} else {
+			stmts = append(stmts, "CREATE INDEX IF NOT EXISTS "+name+" ON {"+idx.table+"} ("+idx.columns+")")
+		}
+
+		for i := range stmts {
+			stmts[i] = strings.Replace(stmts[i], "{"+idx.table+"_index}", inline, 1)
+		}
+	}
+
+	var r = strings.NewReplacer(
+		"idx_{orders}", "idx_"+prefix+"orders",
+		"idx_{attempts}", "idx_"+prefix+"attempts",
+		"idx_{notices}", "idx_"+prefix+"notices",
+		"idx_{refunds}", "idx_"+prefix+"refunds",
+		"idx_{outbox}", "idx_"+prefix+"outbox",
+		"{orders}", prefix+"orders",
+		"{attempts}", prefix+"attempts",
+		"{notices}", prefix+"notices",
+		"{refunds}", prefix+"refunds",
+		"{outbox}", prefix+"outbox",
+	)
+	for i := range stmts {
+		stmts[i] = r.Replace(stmts[i])
+	}
+
+	return stmts
+}
+
+// rebind ? 占位符转为数据库占位符，PostgreSQL 为 $1 $2
+func rebind(dialect Dialect, query string) string {
+	if dialect != Postgres {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// 事件类型
+const (
+	// EventOrderState 订单状态变更，Payload 为 OrderEvent
+	EventOrderState = "order.state_changed"
+	// EventRefund 退款结果，Payload 为 RefundEvent
+	EventRefund = "refund.result"
+	// EventNotice 验签成功的回调或查询结果，Payload 为 NoticeReceivedEvent
+	EventNotice = "notice.received"
+)
+
+// Event outbox 事件，与订单、退款在同一事务中写入，由 Relay 投递
+type Event struct {
+	ID            int64
+	Type          string
+	OrderID       string
+	Payload       []byte // json
+	Attempts      int    // 投递次数
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	PublishedAt   time.Time // 投递成功时间，未投递为空
+	DeadAt        time.Time // 超过最大投递次数的时间，不再投递
+}
+
+// OrderEvent 订单状态变更事件内容
+type OrderEvent struct {
+	OrderID   string       `json:"order_id"`
+	Provider  string       `json:"provider"`
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	PaymentID string       `json:"payment_id,omitempty"`
+	Amount    int32        `json:"amount"`
+	Refunded  int32        `json:"refunded"`
+	Notice    *NoticeEvent `json:"notice,omitempty"`    // 回调或查询结果引起的变更
+	RefundID  string       `json:"refund_id,omitempty"` // 退款引起的变更
+}
+
+// NoticeEvent 引起订单状态变更的回调或查询结果
+type NoticeEvent struct {
+	PaymentID   string `json:"payment_id"`
+	TradeStatus int    `json:"trade_status"` // pay.TradeStatus
+	Amount      int32  `json:"amount"`
+}
+
+// NoticeReceivedEvent 回调或查询结果事件内容，重复、过期、金额不一致或订单不存在的结果也会写入，Error 为原因
+type NoticeReceivedEvent struct {
+	OrderID  string `json:"order_id"`
+	Provider string `json:"provider"`
+	NoticeEvent
+	Error string `json:"error,omitempty"`
+}
+
+// RefundEvent 退款结果事件内容
+type RefundEvent struct {
+	ID       string `json:"id"`
+	OrderID  string `json:"order_id"`
+	Provider string `json:"provider"`
+	RefundID string `json:"refund_id"`
+	Amount   int32  `json:"amount"`
+	Status   int    `json:"status"` // pay.RefundStatus
+}
+
+// NewEvent 事件内容序列化为 json
+func NewEvent(typ, orderID string, payload interface{}) (*Event, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Type:    typ,
+		OrderID: orderID,
+		Payload: b,
+	}, nil
+}
+
+// Outbox 待投递事件
+type Outbox interface {
+	// ClaimEvents 领取未投递且到了投递时间的事件，领取后 lease 内其他 Relay 不会领取
+	// 领取时 Attempts 加1
+	ClaimEvents(now time.Time, lease time.Duration, limit int) ([]*Event, error)
+	// MarkPublished 投递成功
+	MarkPublished(id int64) error
+	// MarkFailed 投递失败，next 后重新投递
+	MarkFailed(id int64, cause string, next time.Time) error
+	// MarkDead 超过最大投递次数，不再投递
+	MarkDead(id int64, cause string) error
+	// DeadEvents 不再投递的事件，按 id 排序
+	DeadEvents(limit int) ([]*Event, error)
+	// Requeue 重新投递不再投递的事件，投递次数清零
+	Requeue(id int64) error
+}
+
+var _ Outbox = &SQLStore{}
+
+// addEvents 在事务中写入事件
+func (s *SQLStore) addEvents(tx *sql.Tx, events []*Event) error {
+	for _, e := range events {
+		now := time.Now()
+		e.CreatedAt = now
+		if e.NextAttemptAt.IsZero() {
+			e.NextAttemptAt = now
+		}
+
+		id, err := s.insert(tx, `INSERT INTO `+s.table("outbox")+` (type, order_id, payload, attempts, next_attempt_at, last_error, created_at, published_at, dead_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.Type, e.OrderID, string(e.Payload), e.Attempts, e.NextAttemptAt.Unix(), e.LastError, now.Unix(), 0, 0)
+		if err != nil {
+			return err
+		}
+
+		e.ID = id
+	}
+
+	return nil
+}
+
+// ClaimEvents 按 next_attempt_at 乐观锁领取事件
+func (s *SQLStore) ClaimEvents(now time.Time, lease time.Duration, limit int) ([]*Event, error) {
+	candidates, err := s.queryEvents(`WHERE published_at = 0 AND dead_at = 0 AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		claimed []*Event
+		until   = now.Add(lease)
+	)
+	for _, e := range candidates {
+		res, err := s.exec(`UPDATE `+s.table("outbox")+` SET attempts = attempts + 1, next_attempt_at = ?
+WHERE id = ? AND published_at = 0 AND next_attempt_at = ?`, until.Unix(), e.ID, e.NextAttemptAt.Unix())
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		e.Attempts++
+		e.NextAttemptAt = until
+		claimed = append(claimed, e)
+	}
+
+	return claimed, nil
+}
+
+// MarkPublished 投递成功
+func (s *SQLStore) MarkPublished(id int64) error {
+	_, err := s.exec(`UPDATE `+s.table("outbox")+` SET published_at = ?, last_error = '' WHERE id = ?`, time.Now().Unix(), id)
+	return err
+}
+
+// MarkFailed 投递失败
+func (s *SQLStore) MarkFailed(id int64, cause string, next time.Time) error {
+	_, err := s.exec(`UPDATE `+s.table("outbox")+` SET last_error = ?, next_attempt_at = ? WHERE id = ? AND published_at = 0`, cause, next.Unix(), id)
+	return err
+}
+
+// MarkDead 不再投递
+func (s *SQLStore) MarkDead(id int64, cause string) error {
+	_, err := s.exec(`UPDATE `+s.table("outbox")+` SET last_error = ?, dead_at = ? WHERE id = ? AND published_at = 0`, cause, time.Now().Unix(), id)
+	return err
+}
+
+// DeadEvents 不再投递的事件
+func (s *SQLStore) DeadEvents(limit int) ([]*Event, error) {
+	return s.queryEvents(`WHERE dead_at > 0 ORDER BY id LIMIT ?`, limit)
+}
+
+// Requeue 重新投递
+func (s *SQLStore) Requeue(id int64) error {
+	res, err := s.exec(`UPDATE `+s.table("outbox")+` SET attempts = 0, dead_at = 0, next_attempt_at = ? WHERE id = ? AND dead_at > 0`, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) queryEvents(where string, args ...interface{}) ([]*Event, error) {
+	rows, err := s.db.Query(rebind(s.opt.Dialect, `SELECT id, type, order_id, payload, attempts, next_attempt_at, last_error, created_at, published_at, dead_at
+FROM `+s.table("outbox")+` `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Event
+	for rows.Next() {
+		var (
+			e                                             Event
+			payload                                       string
+			nextAttemptAt, createdAt, publishedAt, deadAt int64
+		)
+		if err := rows.Scan(&e.ID, &e.Type, &e.OrderID, &payload, &e.Attempts, &nextAttemptAt, &e.LastError, &createdAt, &publishedAt, &deadAt); err != nil {
+			return nil, err
+		}
+
+		e.Payload = []byte(payload)
+		e.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		e.CreatedAt = time.Unix(createdAt, 0)
+		if publishedAt > 0 {
+			e.PublishedAt = time.Unix(publishedAt, 0)
+		}
+		if deadAt > 0 {
+			e.DeadAt = time.Unix(deadAt, 0)
+		}
+		list = append(list, &e)
+	}
+
+	return list, rows.Err()
+}
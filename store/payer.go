@@ -0,0 +1,419 @@
+package store
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	_ pay.Payer    = &Recorder{}
+	_ pay.Refunder = &Recorder{}
+
+	// ErrRefundNotSupported 支付平台未实现 pay.Refunder
+	ErrRefundNotSupported = errors.New("store: refund not supported")
+)
+
+// kConflictRetryTimes 订单版本冲突时重新读取订单的次数
+const kConflictRetryTimes = 3
+
+// Recorder 包装 pay.Payer，Call Verify Refund 的结果自动记录到 Store
+// 订单状态变更经过 States 校验，服务可以通过 States.Subscribe 订阅状态变更
+type Recorder struct {
+	pay.Payer
+	Provider string
+	Store    Store
+	States   *pay.OrderStateMachine
+}
+
+// NewRecorder New
+func NewRecorder(provider string, p pay.Payer, s Store) *Recorder {
+	return &Recorder{
+		Payer:    p,
+		Provider: provider,
+		Store:    s,
+		States:   pay.NewOrderStateMachine(),
+	}
+}
+
+// Call 创建订单并记录调起支付结果，成功后订单变更为 Pending
+func (r *Recorder) Call(way pay.Way, in pay.Order) (string, error) {
+	err := r.Store.CreateOrder(&Order{
+		ID:       in.ID,
+		Provider: r.Provider,
+		Title:    in.Title,
+		Amount:   in.Amount,
+		Currency: in.Currency,
+		State:    pay.OrderStateCreated,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result, callErr := r.Payer.Call(way, in)
+
+	var a = &Attempt{
+		OrderID:  in.ID,
+		Provider: r.Provider,
+		Way:      way,
+		Amount:   in.Amount,
+		Result:   result,
+	}
+	if callErr != nil {
+		a.Error = callErr.Error()
+	}
+	if err := r.Store.AddAttempt(a); err != nil {
+		return "", err
+	}
+
+	if callErr != nil {
+		return "", callErr
+	}
+
+	o, err := r.Store.GetOrder(in.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if o.State == pay.OrderStateCreated {
+		if err := r.transition(o, pay.OrderStatePending, nil, ""); err != nil {
+			return "", err
+		}
+	}
+
+	return result, nil
+}
+
+// Verify 验证回调并记录，验签成功后经 Notify 变更订单
+func (r *Recorder) Verify(in url.Values) (*pay.NoticeParams, error) {
+	params, err := r.Payer.Verify(in)
+	if err != nil {
+		if err := r.Store.AddNotice(&Notice{
+			Provider: r.Provider,
+			Raw:      in.Encode(),
+			Error:    err.Error(),
+		}, nil); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	if err := r.Notify(params, in.Encode()); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// Notify 记录回调或查询结果并按结果变更订单，回调和 Compensator 共用
+// 乱序或重复的结果不会回退订单状态，返回 nil，回调仍应返回 Success
+// 订单不存在或金额不一致时不变更订单，记录回调后返回 ErrNotFound 或 ErrAmountMismatch
+// 回调、订单变更和事件在同一事务中保存，每个回调都写入 EventNotice 事件
+func (r *Recorder) Notify(in *pay.NoticeParams, raw string) error {
+	var (
+		o    *Order
+		from pay.OrderState
+	)
+	err := retryConflict(func() error {
+		var err error
+		o, from, err = r.notify(in, raw)
+		return err
+	})
+	if err != nil || o == nil {
+		return err
+	}
+
+	return r.States.Transition(o.ID, from, o.State)
+}
+
+// notify 保存回调，订单需要变更时返回变更后的订单和变更前的状态
+func (r *Recorder) notify(in *pay.NoticeParams, raw string) (*Order, pay.OrderState, error) {
+	var n = &Notice{
+		OrderID:     in.OrderID,
+		Provider:    r.Provider,
+		PaymentID:   in.PaymentID,
+		TradeStatus: in.TradeStatus,
+		Amount:      in.Amount,
+		Raw:         raw,
+	}
+
+	o, from, e, cause := r.applyNotice(in)
+	switch cause {
+	case nil:
+	case pay.ErrInvalidTransition:
+		n.Error = cause.Error()
+		cause = nil
+	case ErrNotFound, ErrAmountMismatch:
+		n.Error = cause.Error()
+	default:
+		return nil, from, cause
+	}
+
+	received, err := NewEvent(EventNotice, in.OrderID, NoticeReceivedEvent{
+		OrderID:  n.OrderID,
+		Provider: n.Provider,
+		NoticeEvent: NoticeEvent{
+			PaymentID:   n.PaymentID,
+			TradeStatus: int(n.TradeStatus),
+			Amount:      n.Amount,
+		},
+		Error: n.Error,
+	})
+	if err != nil {
+		return nil, from, err
+	}
+
+	var events = []*Event{received}
+	if e != nil {
+		events = append(events, e)
+	}
+
+	if err := r.Store.AddNotice(n, o, events...); err != nil {
+		return nil, from, err
+	}
+
+	return o, from, cause
+}
+
+// applyNotice 按回调计算订单变更，返回变更后的订单、变更前的状态和状态变更事件，订单不变更时订单为空
+// 没有商户订单号的回调只记录，回调金额为0时不校验金额，如 Google Play 的回调没有金额
+func (r *Recorder) applyNotice(in *pay.NoticeParams) (*Order, pay.OrderState, *Event, error) {
+	if len(in.OrderID) == 0 {
+		return nil, 0, nil, nil
+	}
+
+	o, err := r.Store.GetOrder(in.OrderID)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if in.Amount != 0 && in.Amount != o.Amount {
+		return nil, o.State, nil, ErrAmountMismatch
+	}
+
+	if len(in.PaymentID) > 0 {
+		o.PaymentID = in.PaymentID
+	}
+
+	from, to := o.State, pay.NextState(o.State, in.TradeStatus)
+	e, err := r.stateEvent(o, to, in, "")
+	if err != nil || e == nil {
+		return nil, from, nil, err
+	}
+
+	o.State = to
+
+	return o, from, e, nil
+}
+
+// Refund 记录退款，退款成功时更新订单退款金额和状态，RefundOrder.OrderID 为空时不更新订单
+// 平台返回错误时退款记录保持处理中，以退款查询或回调结果为准，结果确认后调用 ApplyRefund
+// 经过 pay.Wrap 的 Payer 按被包装的 Payer 判断是否支持退款
+func (r *Recorder) Refund(in pay.RefundOrder) (*pay.RefundResult, error) {
+	refunder, ok := r.Payer.(pay.Refunder)
+	if !ok || !pay.Supports(r.Payer, pay.OpRefund) {
+		return nil, ErrRefundNotSupported
+	}
+
+	// 重复退款时不重复累加订单退款金额
+	rf, err := r.Store.GetRefund(in.ID)
+	if err == ErrNotFound {
+		rf = &Refund{
+			ID:       in.ID,
+			OrderID:  in.OrderID,
+			Provider: r.Provider,
+			Amount:   in.Amount,
+			Status:   pay.RefundStatusProcessing,
+			Reason:   in.Reason,
+		}
+		err = r.Store.SaveRefund(rf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	applied := rf.Status == pay.RefundStatusSuccess
+
+	result, refundErr := refunder.Refund(in)
+	if errors.Is(refundErr, pay.ErrNotSupported) {
+		refundErr = ErrRefundNotSupported
+	}
+	if refundErr != nil {
+		rf.Error = refundErr.Error()
+		if err := r.Store.SaveRefund(rf); err != nil {
+			return nil, err
+		}
+		return nil, refundErr
+	}
+
+	rf.RefundID = result.RefundID
+	rf.Error = ""
+	if applied {
+		if err := r.Store.SaveRefund(rf); err != nil {
+			return nil, err
+		}
+		return result, r.applyRefund(rf)
+	}
+
+	rf.Status = result.Status
+	e, err := refundEvent(rf)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Store.SaveRefund(rf, e); err != nil {
+		return nil, err
+	}
+
+	if rf.Status == pay.RefundStatusSuccess {
+		if err := r.applyRefund(rf); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// ApplyRefund 退款结果确认后更新退款记录，退款成功时更新订单
+// 退款记录已是成功时重新更新订单，用于上次更新订单失败后重试
+func (r *Recorder) ApplyRefund(id string, status pay.RefundStatus) error {
+	rf, err := r.Store.GetRefund(id)
+	if err != nil {
+		return err
+	}
+
+	if rf.Status == status {
+		if status == pay.RefundStatusSuccess {
+			return r.applyRefund(rf)
+		}
+		return nil
+	}
+
+	if rf.Status != pay.RefundStatusProcessing {
+		return pay.ErrInvalidTransition
+	}
+
+	rf.Status = status
+	e, err := refundEvent(rf)
+	if err != nil {
+		return err
+	}
+	if err := r.Store.SaveRefund(rf, e); err != nil {
+		return err
+	}
+
+	if status != pay.RefundStatusSuccess {
+		return nil
+	}
+
+	return r.applyRefund(rf)
+}
+
+// applyRefund 订单退款金额按订单的成功退款记录重新累计，按累计金额变更为部分退款或全额退款
+// 累计金额不变时已更新过，不重复累加，版本冲突时重新读取订单
+// 只有支付单号的退款不更新订单
+func (r *Recorder) applyRefund(rf *Refund) error {
+	if len(rf.OrderID) == 0 {
+		return nil
+	}
+
+	return retryConflict(func() error {
+		o, err := r.Store.GetOrder(rf.OrderID)
+		if err != nil {
+			return err
+		}
+
+		refunds, err := r.Store.ListRefunds(rf.OrderID)
+		if err != nil {
+			return err
+		}
+
+		var refunded int32
+		for _, v := range refunds {
+			if v.Status == pay.RefundStatusSuccess {
+				refunded += v.Amount
+			}
+		}
+
+		if refunded <= o.Refunded {
+			return nil
+		}
+
+		o.Refunded = refunded
+
+		return r.transition(o, pay.RefundState(o.Amount, o.Refunded), nil, rf.ID)
+	})
+}
+
+// retryConflict 执行 fn，返回 ErrConflict 时重试，最多 kConflictRetryTimes 次
+func retryConflict(fn func() error) error {
+	var err error
+	for i := 0; i < kConflictRetryTimes; i++ {
+		if err = fn(); err != ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// transition 校验状态变更后按版本号保存，状态变更事件与订单在同一事务中写入 outbox
+// notice 和 refundID 为引起变更的回调或退款
+func (r *Recorder) transition(o *Order, to pay.OrderState, notice *pay.NoticeParams, refundID string) error {
+	from := o.State
+	e, err := r.stateEvent(o, to, notice, refundID)
+	if err != nil || e == nil {
+		return err
+	}
+
+	o.State = to
+	if err := r.Store.UpdateOrder(o, e); err != nil {
+		o.State = from
+		return err
+	}
+
+	return r.States.Transition(o.ID, from, to)
+}
+
+// stateEvent 校验订单从当前状态变更到 to，返回状态变更事件，状态不变时返回 nil
+func (r *Recorder) stateEvent(o *Order, to pay.OrderState, notice *pay.NoticeParams, refundID string) (*Event, error) {
+	from := o.State
+	if from == to && to != pay.OrderStatePartiallyRefunded {
+		return nil, nil
+	}
+
+	if !from.CanTransition(to) {
+		return nil, pay.ErrInvalidTransition
+	}
+
+	var ne *NoticeEvent
+	if notice != nil {
+		ne = &NoticeEvent{
+			PaymentID:   notice.PaymentID,
+			TradeStatus: int(notice.TradeStatus),
+			Amount:      notice.Amount,
+		}
+	}
+
+	return NewEvent(EventOrderState, o.ID, OrderEvent{
+		OrderID:   o.ID,
+		Provider:  o.Provider,
+		From:      from.String(),
+		To:        to.String(),
+		PaymentID: o.PaymentID,
+		Amount:    o.Amount,
+		Refunded:  o.Refunded,
+		Notice:    ne,
+		RefundID:  refundID,
+	})
+}
+
+// refundEvent 退款结果事件
+func refundEvent(rf *Refund) (*Event, error) {
+	return NewEvent(EventRefund, rf.OrderID, RefundEvent{
+		ID:       rf.ID,
+		OrderID:  rf.OrderID,
+		Provider: rf.Provider,
+		RefundID: rf.RefundID,
+		Amount:   rf.Amount,
+		Status:   int(rf.Status),
+	})
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/wxpay"
+)
+
+// paidOrder 创建已支付的订单
+func paidOrder(t *testing.T, r *Recorder, id string, amount int32) {
+	t.Helper()
+
+	if _, err := r.Call(pay.WayQrcode, pay.Order{ID: id, Title: "test", Amount: amount}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Notify(&pay.NoticeParams{OrderID: id, PaymentID: "P" + id, TradeStatus: pay.TradeStatusSuccess, Amount: amount}, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotifyAmountMismatch(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{}, s)
+
+	if _, err := r.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var in = url.Values{}
+	in.Set("sign", "ok")
+	in.Set("order_id", "O1")
+	in.Set("amount", "1")
+	if _, err := r.Verify(in); err != ErrAmountMismatch {
+		t.Fatalf("got %v, want %v", err, ErrAmountMismatch)
+	}
+
+	o, _ := s.GetOrder("O1")
+	if o.State != pay.OrderStatePending {
+		t.Errorf("state %v, want pending", o.State)
+	}
+	if n := s.notices[len(s.notices)-1]; n.Error != ErrAmountMismatch.Error() || n.Amount != 1 {
+		t.Errorf("notice %+v", n)
+	}
+
+	// 订单金额一致或回调没有金额时变更订单
+	if err := r.Notify(&pay.NoticeParams{OrderID: "O1", TradeStatus: pay.TradeStatusSuccess}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.GetOrder("O1"); o.State != pay.OrderStatePaid {
+		t.Errorf("state %v, want paid", o.State)
+	}
+}
+
+func TestNotifyUnknownOrder(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{}, s)
+
+	err := r.Notify(&pay.NoticeParams{OrderID: "O1", TradeStatus: pay.TradeStatusSuccess, Amount: 1}, "")
+	if err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+
+	if _, err := s.GetOrder("O1"); err != ErrNotFound {
+		t.Errorf("order created from notice")
+	}
+	if len(s.notices) != 1 || s.notices[0].Error != ErrNotFound.Error() {
+		t.Errorf("notices %+v", s.notices)
+	}
+}
+
+func TestApplyRefundConflict(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{refundStatus: pay.RefundStatusProcessing}, s)
+	paidOrder(t, r, "O1", 100)
+
+	for _, id := range []string{"R1", "R2"} {
+		if _, err := r.Refund(pay.RefundOrder{ID: id, OrderID: "O1", Amount: 50}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每次更新订单前其他请求先修改了订单
+	var conflicts int
+	s.beforeUpdate = func(o *Order) {
+		if conflicts < kConflictRetryTimes-1 {
+			conflicts++
+			s.bump(o.ID)
+		}
+	}
+	if err := r.ApplyRefund("R1", pay.RefundStatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	s.beforeUpdate = nil
+
+	o, _ := s.GetOrder("O1")
+	if o.Refunded != 50 || o.State != pay.OrderStatePartiallyRefunded {
+		t.Errorf("order %+v", o)
+	}
+
+	if err := r.ApplyRefund("R2", pay.RefundStatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	// 重复确认不重复累加
+	if err := r.ApplyRefund("R2", pay.RefundStatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ = s.GetOrder("O1")
+	if o.Refunded != 100 || o.State != pay.OrderStateRefunded {
+		t.Errorf("order %+v", o)
+	}
+}
+
+func TestApplyRefundRepair(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{refundStatus: pay.RefundStatusSuccess}, s)
+	paidOrder(t, r, "O1", 100)
+
+	// 退款记录已保存为成功，更新订单一直冲突
+	s.beforeUpdate = func(o *Order) { s.bump(o.ID) }
+	if _, err := r.Refund(pay.RefundOrder{ID: "R1", OrderID: "O1", Amount: 30}); err != ErrConflict {
+		t.Fatalf("got %v, want %v", err, ErrConflict)
+	}
+	s.beforeUpdate = nil
+
+	if err := r.ApplyRefund("R1", pay.RefundStatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := s.GetOrder("O1")
+	if o.Refunded != 30 || o.State != pay.OrderStatePartiallyRefunded {
+		t.Errorf("order %+v", o)
+	}
+}
+
+func TestNotifyOutbox(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{}, s)
+	paidOrder(t, r, "O1", 100)
+
+	// 重复和过期的回调也写入 outbox
+	for _, status := range []pay.TradeStatus{pay.TradeStatusSuccess, pay.TradeStatusWait} {
+		if err := r.Notify(&pay.NoticeParams{OrderID: "O1", TradeStatus: status, Amount: 100}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{EventOrderState, EventNotice, EventOrderState, EventNotice, EventNotice}
+	if got := s.eventTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+	if n := s.notices[len(s.notices)-1]; n.Error != pay.ErrInvalidTransition.Error() {
+		t.Errorf("stale notice %+v", n)
+	}
+}
+
+func TestNotifyConflict(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("fake", &fakePayer{}, s)
+	if _, err := r.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		events = len(s.eventTypes())
+		calls  int
+	)
+	s.beforeUpdate = func(o *Order) {
+		calls++
+		s.bump(o.ID)
+	}
+
+	err := r.Notify(&pay.NoticeParams{OrderID: "O1", TradeStatus: pay.TradeStatusSuccess, Amount: 100}, "")
+	if err != ErrConflict {
+		t.Fatalf("got %v, want %v", err, ErrConflict)
+	}
+
+	// 订单更新失败时回调和事件都不保存
+	if calls != kConflictRetryTimes || len(s.notices) != 0 || len(s.eventTypes()) != events {
+		t.Errorf("%d updates, %d notices, %d events", calls, len(s.notices), len(s.eventTypes())-events)
+	}
+
+	s.beforeUpdate = nil
+	if err := r.Notify(&pay.NoticeParams{OrderID: "O1", TradeStatus: pay.TradeStatusSuccess, Amount: 100}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.GetOrder("O1"); o.State != pay.OrderStatePaid || len(s.notices) != 1 {
+		t.Errorf("state %v, %d notices", o.State, len(s.notices))
+	}
+}
+
+// basicPayer 只实现 pay.Payer
+type basicPayer struct{}
+
+func (basicPayer) Verify(url.Values) (*pay.NoticeParams, error) { return nil, pay.ErrVerify }
+func (basicPayer) Success() string                              { return "success" }
+func (basicPayer) Call(pay.Way, pay.Order) (string, error)      { return "", nil }
+
+func TestRefundWrappedNotSupported(t *testing.T) {
+	s := newMemStore()
+	r := NewRecorder("basic", pay.Wrap("basic", basicPayer{}), s)
+
+	if _, err := r.Refund(pay.RefundOrder{ID: "R1", OrderID: "O1", Amount: 1}); err != ErrRefundNotSupported {
+		t.Errorf("got %v, want %v", err, ErrRefundNotSupported)
+	}
+	if _, err := s.GetRefund("R1"); err != ErrNotFound {
+		t.Errorf("refund saved for unsupported payer")
+	}
+}
+
+// wxpayNotice 读取 wxpay/testdata 中签名的支付结果通知
+func wxpayNotice(t *testing.T) (*wxpay.Wxpay, url.Values) {
+	t.Helper()
+
+	body, err := ioutil.ReadFile("../wxpay/testdata/notify.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := wxpay.BodyToValues(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wxpay.New(wxpay.Options{
+		APIKey:       "192006250b4c09247ec02edce69f6a2d",
+		MchID:        "10000100",
+		IsProduction: true,
+	}), in
+}
+
+func TestVerifyWxpayNotice(t *testing.T) {
+	p, in := wxpayNotice(t)
+
+	s := newMemStore()
+	s.CreateOrder(&Order{ID: "20190701000001", Provider: "wxpay", Amount: 101, State: pay.OrderStatePending})
+
+	r := NewRecorder("wxpay", p, s)
+	if _, err := r.Verify(in); err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := s.GetOrder("20190701000001")
+	if o.State != pay.OrderStatePaid || o.PaymentID != "4200000109201907011200000001" {
+		t.Errorf("order %+v, want paid", o)
+	}
+	if n := s.notices[0]; n.Amount != 101 || n.TradeStatus != pay.TradeStatusSuccess || len(n.Error) > 0 {
+		t.Errorf("notice %+v", n)
+	}
+}
+
+func TestVerifyWxpayNoticeAmountMismatch(t *testing.T) {
+	p, in := wxpayNotice(t)
+
+	s := newMemStore()
+	s.CreateOrder(&Order{ID: "20190701000001", Provider: "wxpay", Amount: 100, State: pay.OrderStatePending})
+
+	r := NewRecorder("wxpay", p, s)
+	if _, err := r.Verify(in); err != ErrAmountMismatch {
+		t.Fatalf("got %v, want %v", err, ErrAmountMismatch)
+	}
+
+	if o, _ := s.GetOrder("20190701000001"); o.State != pay.OrderStatePending {
+		t.Errorf("state %v, want pending", o.State)
+	}
+}
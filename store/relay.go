@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Publisher 事件投递，返回 nil 表示投递成功，失败的事件会重新投递
+// 同一事件可能投递多次，订阅方按 Event.ID 去重，http 投递使用 webhook.Dispatcher
+type Publisher interface {
+	Publish(*Event) error
+}
+
+// DefaultRelayBackoff 默认重新投递间隔，第n次失败后的间隔，超过后使用最后一个
+var DefaultRelayBackoff = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 5 * time.Minute}
+
+const (
+	kRelayInterval = time.Second
+	kRelayBatch    = 100
+	kRelayLease    = time.Minute
+)
+
+// RelayOptions RelayOptions
+type RelayOptions struct {
+	Interval  time.Duration             // 扫描间隔，默认 1s
+	BatchSize int                       // 每次领取的事件数，默认 100
+	Lease     time.Duration             // 领取后未确认的事件 Lease 后重新投递，默认 1m
+	Backoff   []time.Duration           // 投递失败后的重试间隔，默认 DefaultRelayBackoff
+	OnError   func(e *Event, err error) // 投递或保存失败时调用，e 为空时为领取失败
+
+	MaxAttempts int                       // 最大投递次数，超过后不再投递，为0时不限制
+	DeadLetter  func(e *Event, err error) // 不再投递时调用，事件可以用 Outbox.Requeue 重新投递
+}
+
+// Relay 从 Outbox 领取事件投递到 Publisher，至少投递一次，可以多实例运行
+type Relay struct {
+	outbox    Outbox
+	publisher Publisher
+	opt       RelayOptions
+}
+
+// NewRelay New
+func NewRelay(o Outbox, p Publisher, opt RelayOptions) *Relay {
+	if opt.Interval <= 0 {
+		opt.Interval = kRelayInterval
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = kRelayBatch
+	}
+	if opt.Lease <= 0 {
+		opt.Lease = kRelayLease
+	}
+	if len(opt.Backoff) == 0 {
+		opt.Backoff = DefaultRelayBackoff
+	}
+
+	return &Relay{
+		outbox:    o,
+		publisher: p,
+		opt:       opt,
+	}
+}
+
+// Run 按 Interval 投递，直到 ctx 结束
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		// 一批投递完后立即领取下一批
+		for {
+			n, err := r.RunOnce(time.Now())
+			if err != nil {
+				r.onError(nil, err)
+			}
+			if err != nil || n < r.opt.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 领取一批事件并投递，返回领取的事件数
+func (r *Relay) RunOnce(now time.Time) (int, error) {
+	events, err := r.outbox.ClaimEvents(now, r.opt.Lease, r.opt.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		if cause := r.publisher.Publish(e); cause != nil {
+			r.onError(e, cause)
+
+			if r.opt.MaxAttempts > 0 && e.Attempts >= r.opt.MaxAttempts {
+				if err := r.outbox.MarkDead(e.ID, cause.Error()); err != nil {
+					r.onError(e, err)
+				} else if r.opt.DeadLetter != nil {
+					r.opt.DeadLetter(e, cause)
+				}
+				continue
+			}
+
+			i := e.Attempts - 1
+			if i >= len(r.opt.Backoff) {
+				i = len(r.opt.Backoff) - 1
+			}
+			if err := r.outbox.MarkFailed(e.ID, cause.Error(), now.Add(r.opt.Backoff[i])); err != nil {
+				r.onError(e, err)
+			}
+			continue
+		}
+
+		if err := r.outbox.MarkPublished(e.ID); err != nil {
+			r.onError(e, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+func (r *Relay) onError(e *Event, err error) {
+	if r.opt.OnError != nil {
+		r.opt.OnError(e, err)
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// publisherFunc 函数实现 Publisher
+type publisherFunc func(e *Event) error
+
+func (f publisherFunc) Publish(e *Event) error {
+	return f(e)
+}
+
+var errPublish = errors.New("publish failed")
+
+// outboxEvents 写入 n 个事件
+func outboxEvents(t *testing.T, s *memStore, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		e, err := NewEvent(EventOrderState, "O1", OrderEvent{OrderID: "O1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.addEvents([]*Event{e})
+	}
+}
+
+func TestRelayRunOnce(t *testing.T) {
+	s := newMemStore()
+	outboxEvents(t, s, 3)
+
+	var published []int64
+	r := NewRelay(s, publisherFunc(func(e *Event) error {
+		published = append(published, e.ID)
+		return nil
+	}), RelayOptions{BatchSize: 2})
+
+	now := time.Now()
+	for _, want := range []int{2, 1, 0} {
+		n, err := r.RunOnce(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("claimed %d, want %d", n, want)
+		}
+	}
+
+	if len(published) != 3 {
+		t.Errorf("published %v, want 3 events", published)
+	}
+	for _, e := range s.events {
+		if e.PublishedAt.IsZero() || e.Attempts != 1 {
+			t.Errorf("event %d published %v attempts %d", e.ID, e.PublishedAt, e.Attempts)
+		}
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	s := newMemStore()
+	outboxEvents(t, s, 1)
+
+	var errs int
+	r := NewRelay(s, publisherFunc(func(e *Event) error { return errPublish }), RelayOptions{
+		Backoff: []time.Duration{time.Second, time.Minute},
+		OnError: func(e *Event, err error) { errs++ },
+	})
+
+	now := time.Now()
+	for i, next := range []time.Duration{time.Second, time.Minute, time.Minute} {
+		// 重试时间之前不会重新领取
+		if i > 0 {
+			if n, _ := r.RunOnce(now.Add(-time.Millisecond)); n != 0 {
+				t.Fatalf("attempt %d: claimed before backoff", i+1)
+			}
+		}
+
+		if n, err := r.RunOnce(now); err != nil || n != 1 {
+			t.Fatalf("attempt %d: claimed %d, %v", i+1, n, err)
+		}
+
+		e := s.events[0]
+		if !e.NextAttemptAt.Equal(now.Add(next)) || e.LastError != errPublish.Error() {
+			t.Errorf("attempt %d: next %v error %q, want +%v", i+1, e.NextAttemptAt.Sub(now), e.LastError, next)
+		}
+		now = e.NextAttemptAt
+	}
+
+	if errs != 3 {
+		t.Errorf("OnError called %d times, want 3", errs)
+	}
+	if s.events[0].Attempts != 3 || !s.events[0].DeadAt.IsZero() {
+		t.Errorf("event %+v", s.events[0])
+	}
+}
+
+func TestRelayDeadLetter(t *testing.T) {
+	s := newMemStore()
+	outboxEvents(t, s, 1)
+
+	var (
+		fail = true
+		dead []*Event
+	)
+	r := NewRelay(s, publisherFunc(func(e *Event) error {
+		if fail {
+			return errPublish
+		}
+		return nil
+	}), RelayOptions{
+		Backoff:     []time.Duration{time.Second},
+		MaxAttempts: 2,
+		DeadLetter:  func(e *Event, err error) { dead = append(dead, e) },
+	})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		r.RunOnce(now)
+		now = now.Add(time.Second)
+	}
+
+	if len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("dead letters %+v, want 1 after 2 attempts", dead)
+	}
+	if list, _ := s.DeadEvents(10); len(list) != 1 || list[0].LastError != errPublish.Error() {
+		t.Fatalf("dead events %+v", list)
+	}
+
+	// 重新投递
+	fail = false
+	if err := s.Requeue(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.RunOnce(time.Now()); err != nil || n != 1 {
+		t.Fatalf("claimed %d, %v after requeue", n, err)
+	}
+	if e := s.events[0]; e.PublishedAt.IsZero() || e.Attempts != 1 {
+		t.Errorf("event %+v, want published after requeue", e)
+	}
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ Store = &SQLStore{}
+
+// kTablePrefix 默认表名前缀
+const kTablePrefix = "pay_"
+
+// SQLOptions SQLOptions
+type SQLOptions struct {
+	Dialect     Dialect
+	TablePrefix string // 表名前缀，为空时使用 pay_
+}
+
+// SQLStore database/sql 实现，需要调用方导入对应的数据库驱动
+type SQLStore struct {
+	db  *sql.DB
+	opt SQLOptions
+}
+
+// NewSQL New
+func NewSQL(db *sql.DB, opt SQLOptions) *SQLStore {
+	if len(opt.TablePrefix) == 0 {
+		opt.TablePrefix = kTablePrefix
+	}
+
+	return &SQLStore{
+		db:  db,
+		opt: opt,
+	}
+}
+
+// Migrate 创建表和索引，可重复执行
+func (s *SQLStore) Migrate() error {
+	for _, stmt := range Migrations(s.opt.Dialect, s.opt.TablePrefix) {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateOrder 创建订单，订单已存在时不覆盖
+func (s *SQLStore) CreateOrder(o *Order) error {
+	var ignore, conflict string
+	if s.opt.Dialect == MySQL {
+		ignore = " IGNORE"
+	} else {
+		conflict = " ON CONFLICT (id) DO NOTHING"
+	}
+
+	now := time.Now()
+	o.CreatedAt, o.UpdatedAt = now, now
+	if o.NextQueryAt.IsZero() {
+		o.NextQueryAt = now
+	}
+
+	_, err := s.exec(`INSERT`+ignore+` INTO `+s.table("orders")+` (id, provider, title, amount, currency, state, payment_id, refunded, version, query_count, next_query_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`+conflict,
+		o.ID, o.Provider, o.Title, o.Amount, o.Currency, int(o.State), o.PaymentID, o.Refunded, o.Version, o.QueryCount, o.NextQueryAt.Unix(), now.Unix(), now.Unix())
+
+	return err
+}
+
+// GetOrder 查询订单
+func (s *SQLStore) GetOrder(id string) (*Order, error) {
+	orders, err := s.queryOrders(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return orders[0], nil
+}
+
+// ListPending 未支付且到了查询时间的订单
+func (s *SQLStore) ListPending(now time.Time, limit int) ([]*Order, error) {
+	return s.queryOrders(`WHERE state IN (?, ?) AND next_query_at <= ? ORDER BY next_query_at LIMIT ?`,
+		int(pay.OrderStateCreated), int(pay.OrderStatePending), now.Unix(), limit)
+}
+
+func (s *SQLStore) queryOrders(where string, args ...interface{}) ([]*Order, error) {
+	rows, err := s.db.Query(rebind(s.opt.Dialect, `SELECT id, provider, title, amount, currency, state, payment_id, refunded, version, query_count, next_query_at, created_at, updated_at
+FROM `+s.table("orders")+` `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Order
+	for rows.Next() {
+		var (
+			o                                 Order
+			state                             int
+			nextQueryAt, createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&o.ID, &o.Provider, &o.Title, &o.Amount, &o.Currency, &state, &o.PaymentID, &o.Refunded, &o.Version,
+			&o.QueryCount, &nextQueryAt, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+
+		o.State = pay.OrderState(state)
+		o.NextQueryAt = time.Unix(nextQueryAt, 0)
+		o.CreatedAt = time.Unix(createdAt, 0)
+		o.UpdatedAt = time.Unix(updatedAt, 0)
+		list = append(list, &o)
+	}
+
+	return list, rows.Err()
+}
+
+// UpdateOrder 按版本号更新订单，events 在同一事务中写入 outbox
+func (s *SQLStore) UpdateOrder(o *Order, events ...*Event) error {
+	now := time.Now()
+
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := s.updateOrder(tx, o, now); err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, events)
+	})
+	if err != nil {
+		return err
+	}
+
+	o.Version++
+	o.UpdatedAt = now
+
+	return nil
+}
+
+// updateOrder 在事务中按版本号更新订单，不修改 o
+func (s *SQLStore) updateOrder(tx *sql.Tx, o *Order, now time.Time) error {
+	res, err := tx.Exec(rebind(s.opt.Dialect, `UPDATE `+s.table("orders")+` SET state = ?, payment_id = ?, refunded = ?, query_count = ?, next_query_at = ?, version = version + 1, updated_at = ?
+WHERE id = ? AND version = ?`),
+		int(o.State), o.PaymentID, o.Refunded, o.QueryCount, o.NextQueryAt.Unix(), now.Unix(), o.ID, o.Version)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// AddAttempt 记录调起支付
+func (s *SQLStore) AddAttempt(a *Attempt) error {
+	a.CreatedAt = time.Now()
+
+	id, err := s.insert(s.db, `INSERT INTO `+s.table("attempts")+` (order_id, provider, way, amount, result, error, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.OrderID, a.Provider, string(a.Way), a.Amount, a.Result, a.Error, a.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	a.ID = id
+
+	return nil
+}
+
+// AddNotice 记录支付回调，o 不为空时在同一事务中更新订单，events 在同一事务中写入 outbox
+func (s *SQLStore) AddNotice(n *Notice, o *Order, events ...*Event) error {
+	var (
+		now = time.Now()
+		id  int64
+	)
+
+	err := s.withTx(func(tx *sql.Tx) error {
+		if o != nil {
+			if err := s.updateOrder(tx, o, now); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		id, err = s.insert(tx, `INSERT INTO `+s.table("notices")+` (order_id, provider, payment_id, trade_status, amount, raw, error, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			n.OrderID, n.Provider, n.PaymentID, int(n.TradeStatus), n.Amount, n.Raw, n.Error, now.Unix())
+		if err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, events)
+	})
+	if err != nil {
+		return err
+	}
+
+	n.ID = id
+	n.CreatedAt = now
+	if o != nil {
+		o.Version++
+		o.UpdatedAt = now
+	}
+
+	return nil
+}
+
+// SaveRefund 保存退款，已存在时更新退款单号、状态和错误，events 在同一事务中写入 outbox
+func (s *SQLStore) SaveRefund(r *Refund, events ...*Event) error {
+	var upsert = ` ON CONFLICT (id) DO UPDATE SET refund_id = excluded.refund_id, status = excluded.status, error = excluded.error, updated_at = excluded.updated_at`
+	if s.opt.Dialect == MySQL {
+		upsert = ` ON DUPLICATE KEY UPDATE refund_id = VALUES(refund_id), status = VALUES(status), error = VALUES(error), updated_at = VALUES(updated_at)`
+	}
+
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(rebind(s.opt.Dialect, `INSERT INTO `+s.table("refunds")+` (id, order_id, provider, refund_id, amount, status, reason, error, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`+upsert),
+			r.ID, r.OrderID, r.Provider, r.RefundID, r.Amount, int(r.Status), r.Reason, r.Error, r.CreatedAt.Unix(), now.Unix())
+		if err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, events)
+	})
+}
+
+// GetRefund 查询退款
+func (s *SQLStore) GetRefund(id string) (*Refund, error) {
+	rows, err := s.queryRefunds(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+// ListRefunds 订单的退款记录，按创建时间排序
+func (s *SQLStore) ListRefunds(orderID string) ([]*Refund, error) {
+	return s.queryRefunds(`WHERE order_id = ? ORDER BY created_at, id`, orderID)
+}
+
+func (s *SQLStore) queryRefunds(where string, args ...interface{}) ([]*Refund, error) {
+	rows, err := s.db.Query(rebind(s.opt.Dialect, `SELECT id, order_id, provider, refund_id, amount, status, reason, error, created_at, updated_at
+FROM `+s.table("refunds")+` `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Refund
+	for rows.Next() {
+		var (
+			r                    Refund
+			status               int
+			createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Provider, &r.RefundID, &r.Amount, &status, &r.Reason, &r.Error, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+
+		r.Status = pay.RefundStatus(status)
+		r.CreatedAt = time.Unix(createdAt, 0)
+		r.UpdatedAt = time.Unix(updatedAt, 0)
+		list = append(list, &r)
+	}
+
+	return list, rows.Err()
+}
+
+// execer *sql.DB 和 *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insert 插入自增id的记录，PostgreSQL 不支持 LastInsertId，使用 RETURNING id
+func (s *SQLStore) insert(e execer, query string, args ...interface{}) (int64, error) {
+	if s.opt.Dialect == Postgres {
+		var id int64
+		err := e.QueryRow(rebind(s.opt.Dialect, query+` RETURNING id`), args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := e.Exec(rebind(s.opt.Dialect, query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// withTx 在事务中执行 fn，fn 返回错误时回滚
+func (s *SQLStore) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(rebind(s.opt.Dialect, query), args...)
+}
+
+func (s *SQLStore) table(name string) string {
+	return s.opt.TablePrefix + name
+}
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQL SQLite 文件数据库，已建表
+func newTestSQL(t *testing.T) (*SQLStore, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "pay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewSQL(db, SQLOptions{Dialect: SQLite, TablePrefix: "t_"})
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, db
+}
+
+// count 表中的记录数
+func count(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM t_` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMigrations(t *testing.T) {
+	s, _ := newTestSQL(t)
+
+	// 可重复执行
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mysql := strings.Join(Migrations(MySQL, "p_"), ";")
+	if strings.Contains(mysql, "CREATE INDEX") || !strings.Contains(mysql, "KEY idx_p_outbox_pending (published_at, next_attempt_at)") {
+		t.Errorf("mysql indexes not inline:\n%s", mysql)
+	}
+	if !strings.Contains(mysql, "AUTO_INCREMENT") || strings.Contains(mysql, "{") {
+		t.Errorf("mysql statements:\n%s", mysql)
+	}
+
+	postgres := strings.Join(Migrations(Postgres, "p_"), ";")
+	if !strings.Contains(postgres, "BIGSERIAL") || !strings.Contains(postgres, "CREATE INDEX IF NOT EXISTS idx_p_orders_pending ON p_orders") {
+		t.Errorf("postgres statements:\n%s", postgres)
+	}
+
+	if got := rebind(Postgres, "a = ? AND b = ?"); got != "a = $1 AND b = $2" {
+		t.Errorf("rebind %q", got)
+	}
+}
+
+func TestSQLOrder(t *testing.T) {
+	s, db := newTestSQL(t)
+
+	o := &Order{ID: "O1", Provider: "fake", Title: "test", Amount: 100, State: pay.OrderStateCreated}
+	if err := s.CreateOrder(o); err != nil {
+		t.Fatal(err)
+	}
+	// 已存在时不覆盖
+	if err := s.CreateOrder(&Order{ID: "O1", Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetOrder("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != 100 || got.Version != 0 {
+		t.Errorf("order %+v", got)
+	}
+
+	e, _ := NewEvent(EventOrderState, "O1", OrderEvent{OrderID: "O1"})
+	got.State = pay.OrderStatePending
+	if err := s.UpdateOrder(got, e); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 1 || e.ID == 0 {
+		t.Errorf("version %d event id %d", got.Version, e.ID)
+	}
+
+	// 版本号过期时订单和事件都不写入
+	stale := *got
+	stale.Version = 0
+	stale.State = pay.OrderStatePaid
+	e, _ = NewEvent(EventOrderState, "O1", OrderEvent{OrderID: "O1"})
+	if err := s.UpdateOrder(&stale, e); err != ErrConflict {
+		t.Fatalf("got %v, want %v", err, ErrConflict)
+	}
+	if o, _ := s.GetOrder("O1"); o.State != pay.OrderStatePending {
+		t.Errorf("state %v, want pending", o.State)
+	}
+	if n := count(t, db, "outbox"); n != 1 {
+		t.Errorf("%d events, want 1", n)
+	}
+
+	if _, err := s.GetOrder("O2"); err != ErrNotFound {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+
+	list, err := s.ListPending(time.Now().Add(time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "O1" {
+		t.Errorf("pending %+v", list)
+	}
+	if list, _ := s.ListPending(time.Now().Add(-time.Hour), 10); len(list) != 0 {
+		t.Errorf("pending before next_query_at %+v", list)
+	}
+}
+
+func TestSQLAddNotice(t *testing.T) {
+	s, db := newTestSQL(t)
+
+	s.CreateOrder(&Order{ID: "O1", Provider: "fake", Amount: 100, State: pay.OrderStatePending})
+	o, _ := s.GetOrder("O1")
+
+	// 订单版本冲突时回调和事件都不写入
+	stale := *o
+	stale.Version = 5
+	e, _ := NewEvent(EventNotice, "O1", NoticeReceivedEvent{OrderID: "O1"})
+	if err := s.AddNotice(&Notice{OrderID: "O1", Provider: "fake"}, &stale, e); err != ErrConflict {
+		t.Fatalf("got %v, want %v", err, ErrConflict)
+	}
+	if count(t, db, "notices") != 0 || count(t, db, "outbox") != 0 {
+		t.Fatal("notice or event written on conflict")
+	}
+
+	o.State = pay.OrderStatePaid
+	n := &Notice{OrderID: "O1", Provider: "fake", TradeStatus: pay.TradeStatusSuccess, Amount: 100}
+	if err := s.AddNotice(n, o, e); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID == 0 || o.Version != 1 {
+		t.Errorf("notice id %d order version %d", n.ID, o.Version)
+	}
+	if got, _ := s.GetOrder("O1"); got.State != pay.OrderStatePaid {
+		t.Errorf("state %v, want paid", got.State)
+	}
+
+	// 没有订单的回调只记录
+	if err := s.AddNotice(&Notice{Provider: "fake", Error: "verify"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if count(t, db, "notices") != 2 || count(t, db, "outbox") != 1 {
+		t.Error("notices or events")
+	}
+}
+
+func TestSQLRefund(t *testing.T) {
+	s, _ := newTestSQL(t)
+
+	r := &Refund{ID: "R1", OrderID: "O1", Provider: "fake", Amount: 50, Status: pay.RefundStatusProcessing}
+	if err := s.SaveRefund(r); err != nil {
+		t.Fatal(err)
+	}
+
+	r.RefundID, r.Status = "RR1", pay.RefundStatusSuccess
+	if err := s.SaveRefund(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRefund(&Refund{ID: "R2", OrderID: "O1", Provider: "fake", Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetRefund("R1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RefundID != "RR1" || got.Status != pay.RefundStatusSuccess || got.Amount != 50 {
+		t.Errorf("refund %+v", got)
+	}
+
+	list, err := s.ListRefunds("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("%d refunds, want 2", len(list))
+	}
+
+	if _, err := s.GetRefund("R3"); err != ErrNotFound {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSQLOutbox(t *testing.T) {
+	s, _ := newTestSQL(t)
+
+	s.CreateOrder(&Order{ID: "O1", Provider: "fake", Amount: 100, State: pay.OrderStateCreated})
+	o, _ := s.GetOrder("O1")
+	var events []*Event
+	for i := 0; i < 2; i++ {
+		e, _ := NewEvent(EventOrderState, "O1", OrderEvent{OrderID: "O1"})
+		events = append(events, e)
+	}
+	if err := s.UpdateOrder(o, events...); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claimed, err := s.ClaimEvents(now, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].Attempts != 1 || claimed[0].Type != EventOrderState {
+		t.Fatalf("claimed %+v", claimed)
+	}
+
+	// lease 内不会被其他 Relay 领取
+	if again, _ := s.ClaimEvents(now, time.Minute, 10); len(again) != 0 {
+		t.Fatalf("claimed %d events within lease", len(again))
+	}
+
+	if err := s.MarkPublished(claimed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkFailed(claimed[1].ID, "failed", now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 投递成功的事件不再领取，失败的事件到重试时间后领取
+	retry, _ := s.ClaimEvents(now.Add(2*time.Second), time.Minute, 10)
+	if len(retry) != 1 || retry[0].ID != claimed[1].ID || retry[0].Attempts != 2 || retry[0].LastError != "failed" {
+		t.Fatalf("retry %+v", retry)
+	}
+
+	if err := s.MarkDead(retry[0].ID, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := s.DeadEvents(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].DeadAt.IsZero() || dead[0].LastError != "dead" {
+		t.Fatalf("dead %+v", dead)
+	}
+	if list, _ := s.ClaimEvents(now.Add(time.Hour), time.Minute, 10); len(list) != 0 {
+		t.Fatalf("dead event claimed")
+	}
+
+	if err := s.Requeue(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Requeue(claimed[0].ID); err != ErrNotFound {
+		t.Errorf("requeue published event: got %v, want %v", err, ErrNotFound)
+	}
+
+	requeued, _ := s.ClaimEvents(time.Now().Add(time.Second), time.Minute, 10)
+	if len(requeued) != 1 || requeued[0].Attempts != 1 {
+		t.Fatalf("requeued %+v", requeued)
+	}
+}
+
+// TestSQLRecorderRelay 订单变更和事件在同一事务中写入，经 Relay 投递
+func TestSQLRecorderRelay(t *testing.T) {
+	s, _ := newTestSQL(t)
+	r := NewRecorder("fake", &fakePayer{refundStatus: pay.RefundStatusSuccess}, s)
+
+	paidOrder(t, r, "O1", 100)
+	if _, err := r.Refund(pay.RefundOrder{ID: "R1", OrderID: "O1", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	relay := NewRelay(s, publisherFunc(func(e *Event) error {
+		types = append(types, e.Type)
+		return nil
+	}), RelayOptions{})
+	if _, err := relay.RunOnce(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{EventOrderState, EventNotice, EventOrderState, EventRefund, EventOrderState}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("published %v, want %v", types, want)
+	}
+
+	o, _ := s.GetOrder("O1")
+	if o.State != pay.OrderStateRefunded || o.Refunded != 100 {
+		t.Errorf("order %+v", o)
+	}
+}
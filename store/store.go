@@ -0,0 +1,105 @@
+// Package store 支付订单持久化，记录订单、调起支付、回调和退款
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	// ErrNotFound 记录不存在
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict 订单已被其他请求修改，Version 不一致
+	ErrConflict = errors.New("store: version conflict")
+	// ErrProviderNotFound 订单的支付平台没有对应的 Recorder
+	ErrProviderNotFound = errors.New("store: provider not found")
+	// ErrQueryNotSupported 支付平台未实现 pay.Querier
+	ErrQueryNotSupported = errors.New("store: query not supported")
+	// ErrAmountMismatch 回调金额与订单金额不一致
+	ErrAmountMismatch = errors.New("store: amount mismatch")
+)
+
+// Store 支付订单存储
+type Store interface {
+	// CreateOrder 创建订单，订单已存在时不覆盖，返回 nil
+	CreateOrder(*Order) error
+	// GetOrder 查询订单，不存在时返回 ErrNotFound
+	GetOrder(id string) (*Order, error)
+	// UpdateOrder 按 Order.Version 更新订单状态、支付单号、退款金额和查询计划，成功后 Version 加1
+	// Version 不一致时返回 ErrConflict，events 与订单在同一事务中写入 outbox
+	UpdateOrder(o *Order, events ...*Event) error
+	// ListPending 未支付且 NextQueryAt 不晚于 now 的订单，按 NextQueryAt 排序
+	ListPending(now time.Time, limit int) ([]*Order, error)
+
+	// AddAttempt 记录一次调起支付
+	AddAttempt(*Attempt) error
+	// AddNotice 记录一次支付回调，o 不为空时在同一事务中按 Order.Version 更新订单
+	// Version 不一致时返回 ErrConflict，回调不记录，events 与回调在同一事务中写入 outbox
+	AddNotice(n *Notice, o *Order, events ...*Event) error
+
+	// SaveRefund 保存退款，已存在时更新，events 与退款在同一事务中写入 outbox
+	SaveRefund(r *Refund, events ...*Event) error
+	// GetRefund 查询退款，不存在时返回 ErrNotFound
+	GetRefund(id string) (*Refund, error)
+	// ListRefunds 订单的退款记录
+	ListRefunds(orderID string) ([]*Refund, error)
+}
+
+// Order 支付订单
+type Order struct {
+	ID        string         // 商户订单号
+	Provider  string         // 支付平台 alipay wxpay 等
+	Title     string         // 订单详情
+	Amount    int32          // 订单金额
+	Currency  string         // 币种
+	State     pay.OrderState // 订单状态
+	PaymentID string         // 支付单号
+	Refunded  int32          // 累计退款金额
+	Version   int64          // 乐观锁版本号
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	QueryCount  int       // 主动查询次数
+	NextQueryAt time.Time // 下次主动查询时间，创建时为空则为创建时间
+}
+
+// Attempt 调起支付记录
+type Attempt struct {
+	ID        int64
+	OrderID   string
+	Provider  string
+	Way       pay.Way
+	Amount    int32
+	Result    string // Call 返回的数据
+	Error     string // Call 返回的错误
+	CreatedAt time.Time
+}
+
+// Notice 支付回调记录，验签失败的回调也会记录
+type Notice struct {
+	ID          int64
+	OrderID     string
+	Provider    string
+	PaymentID   string
+	TradeStatus pay.TradeStatus
+	Amount      int32
+	Raw         string // 回调参数 url.Values.Encode()
+	Error       string // 验签或状态变更错误
+	CreatedAt   time.Time
+}
+
+// Refund 退款记录
+type Refund struct {
+	ID        string // 商户退款单号
+	OrderID   string
+	Provider  string
+	RefundID  string // 支付平台退款单号
+	Amount    int32
+	Status    pay.RefundStatus
+	Reason    string
+	Error     string // Refund 返回的错误
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	_ Store  = &memStore{}
+	_ Outbox = &memStore{}
+)
+
+// memStore 内存 Store，beforeUpdate 在 UpdateOrder 比较版本号前调用，用于模拟并发修改
+type memStore struct {
+	mu       sync.Mutex
+	orders   map[string]Order
+	refunds  map[string]Refund
+	notices  []Notice
+	attempts []Attempt
+	events   []Event
+
+	beforeUpdate func(o *Order)
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		orders:  make(map[string]Order),
+		refunds: make(map[string]Refund),
+	}
+}
+
+func (s *memStore) CreateOrder(o *Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[o.ID]; !ok {
+		s.orders[o.ID] = *o
+	}
+	return nil
+}
+
+func (s *memStore) GetOrder(id string) (*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &o, nil
+}
+
+func (s *memStore) UpdateOrder(o *Order, events ...*Event) error {
+	if s.beforeUpdate != nil {
+		s.beforeUpdate(o)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.updateOrder(o); err != nil {
+		return err
+	}
+	s.addEvents(events)
+
+	return nil
+}
+
+func (s *memStore) updateOrder(o *Order) error {
+	cur, ok := s.orders[o.ID]
+	if !ok || cur.Version != o.Version {
+		return ErrConflict
+	}
+
+	o.Version++
+	s.orders[o.ID] = *o
+
+	return nil
+}
+
+// bump 模拟其他请求修改订单
+func (s *memStore) bump(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.orders[id]
+	o.Version++
+	s.orders[id] = o
+}
+
+func (s *memStore) ListPending(now time.Time, limit int) ([]*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*Order
+	for _, o := range s.orders {
+		o := o
+		if (o.State == pay.OrderStateCreated || o.State == pay.OrderStatePending) && !o.NextQueryAt.After(now) {
+			list = append(list, &o)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NextQueryAt.Before(list[j].NextQueryAt) })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	return list, nil
+}
+
+func (s *memStore) AddAttempt(a *Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts = append(s.attempts, *a)
+	return nil
+}
+
+func (s *memStore) AddNotice(n *Notice, o *Order, events ...*Event) error {
+	if o != nil && s.beforeUpdate != nil {
+		s.beforeUpdate(o)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o != nil {
+		if err := s.updateOrder(o); err != nil {
+			return err
+		}
+	}
+
+	s.notices = append(s.notices, *n)
+	s.addEvents(events)
+
+	return nil
+}
+
+func (s *memStore) SaveRefund(r *Refund, events ...*Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refunds[r.ID] = *r
+	s.addEvents(events)
+
+	return nil
+}
+
+func (s *memStore) GetRefund(id string) (*Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.refunds[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+func (s *memStore) ListRefunds(orderID string) ([]*Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*Refund
+	for _, r := range s.refunds {
+		r := r
+		if r.OrderID == orderID {
+			list = append(list, &r)
+		}
+	}
+	return list, nil
+}
+
+func (s *memStore) addEvents(events []*Event) {
+	for _, e := range events {
+		e.ID = int64(len(s.events) + 1)
+		s.events = append(s.events, *e)
+	}
+}
+
+func (s *memStore) ClaimEvents(now time.Time, lease time.Duration, limit int) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*Event
+	for i := range s.events {
+		e := &s.events[i]
+		if len(list) >= limit || !e.PublishedAt.IsZero() || !e.DeadAt.IsZero() || e.NextAttemptAt.After(now) {
+			continue
+		}
+
+		e.Attempts++
+		e.NextAttemptAt = now.Add(lease)
+		c := *e
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+func (s *memStore) MarkPublished(id int64) error {
+	return s.event(id, func(e *Event) { e.PublishedAt, e.LastError = time.Now(), "" })
+}
+
+func (s *memStore) MarkFailed(id int64, cause string, next time.Time) error {
+	return s.event(id, func(e *Event) { e.LastError, e.NextAttemptAt = cause, next })
+}
+
+func (s *memStore) MarkDead(id int64, cause string) error {
+	return s.event(id, func(e *Event) { e.LastError, e.DeadAt = cause, time.Now() })
+}
+
+func (s *memStore) DeadEvents(limit int) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*Event
+	for _, e := range s.events {
+		e := e
+		if !e.DeadAt.IsZero() && len(list) < limit {
+			list = append(list, &e)
+		}
+	}
+	return list, nil
+}
+
+func (s *memStore) Requeue(id int64) error {
+	return s.event(id, func(e *Event) { e.Attempts, e.DeadAt, e.NextAttemptAt = 0, time.Time{}, time.Now() })
+}
+
+func (s *memStore) event(id int64, fn func(e *Event)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.events) {
+		return ErrNotFound
+	}
+	fn(&s.events[id-1])
+	return nil
+}
+
+// eventTypes 写入 outbox 的事件类型
+func (s *memStore) eventTypes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []string
+	for _, e := range s.events {
+		list = append(list, e.Type)
+	}
+	return list
+}
+
+// fakePayer 支付平台桩，Refund 返回 refundStatus
+type fakePayer struct {
+	refundStatus pay.RefundStatus
+	refundErr    error
+}
+
+func (p *fakePayer) Verify(in url.Values) (*pay.NoticeParams, error) {
+	if in.Get("sign") != "ok" {
+		return nil, pay.ErrVerify
+	}
+
+	amount, _ := strconv.Atoi(in.Get("amount"))
+
+	return &pay.NoticeParams{
+		OrderID:     in.Get("order_id"),
+		PaymentID:   in.Get("payment_id"),
+		TradeStatus: pay.TradeStatusSuccess,
+		Amount:      int32(amount),
+	}, nil
+}
+
+func (p *fakePayer) Success() string {
+	return "success"
+}
+
+func (p *fakePayer) Call(way pay.Way, in pay.Order) (string, error) {
+	return "code_url", nil
+}
+
+func (p *fakePayer) Refund(in pay.RefundOrder) (*pay.RefundResult, error) {
+	if p.refundErr != nil {
+		return nil, p.refundErr
+	}
+
+	return &pay.RefundResult{
+		ID:       in.ID,
+		RefundID: "R" + in.ID,
+		Status:   p.refundStatus,
+		Amount:   in.Amount,
+	}, nil
+}
@@ -0,0 +1,390 @@
+// Package stripe Stripe Checkout Session 和 PaymentIntent
+// https://stripe.com/docs/api/payment_intents
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	_ pay.Payer    = &Stripe{}
+	_ pay.Refunder = &Stripe{}
+)
+
+const (
+	kAPIDomain = "https://api.stripe.com"
+
+	kCheckoutSessions = "/v1/checkout/sessions"
+	kPaymentIntents   = "/v1/payment_intents"
+	kRefunds          = "/v1/refunds"
+
+	kDefaultCurrency  = "usd"
+	kDefaultTolerance = 5 * time.Minute
+	kTimeout          = 10 * time.Second
+
+	// kMetadataOrderID metadata 中保存商户订单号的key
+	kMetadataOrderID = "order_id"
+)
+
+// PaymentIntent 状态
+const (
+	IntentStatusRequiresPaymentMethod = "requires_payment_method"
+	IntentStatusRequiresConfirmation  = "requires_confirmation"
+	IntentStatusRequiresAction        = "requires_action"
+	IntentStatusProcessing            = "processing"
+	IntentStatusRequiresCapture       = "requires_capture"
+	IntentStatusCanceled              = "canceled"
+	IntentStatusSucceeded             = "succeeded"
+)
+
+var (
+	// ErrInvalidSignature Stripe-Signature 格式错误或签名不匹配
+	ErrInvalidSignature = errors.New("stripe: invalid signature")
+	// ErrTimestampExpired Stripe-Signature 时间戳超出允许范围
+	ErrTimestampExpired = errors.New("stripe: timestamp outside the tolerance zone")
+	// ErrEventNotSupport 不是 payment_intent.* 事件
+	ErrEventNotSupport = errors.New("stripe: event not support")
+	// ErrPaymentIDRequired 退款需要 PaymentIntent id
+	ErrPaymentIDRequired = errors.New("stripe: payment id required")
+)
+
+// Options Options
+type Options struct {
+	SecretKey     string        // sk_live_ sk_test_
+	WebhookSecret string        // webhook 签名密钥 whsec_
+	SuccessURL    string        // Checkout 支付成功跳转地址
+	CancelURL     string        // Checkout 取消支付跳转地址
+	Tolerance     time.Duration // webhook 时间戳允许误差，为空时为5分钟
+	APIDomain     string        // 接口域名，为空时使用 https://api.stripe.com
+	Client        *http.Client  // 为空时使用超时 10s 的 http.Client
+}
+
+// Stripe Stripe
+type Stripe struct {
+	opt Options
+}
+
+// New New
+func New(opt Options) *Stripe {
+	if opt.Tolerance == 0 {
+		opt.Tolerance = kDefaultTolerance
+	}
+
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	return &Stripe{
+		opt: opt,
+	}
+}
+
+// Verify webhook 验证签名,成功返回回调参数，in 为 WebhookValues 的返回值
+func (p *Stripe) Verify(in url.Values) (*pay.NoticeParams, error) {
+	body := []byte(in.Get("body"))
+	if err := p.verify(in.Get("Stripe-Signature"), body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return NoticeParams(body)
+}
+
+// Success 回调成功返回数据
+func (p *Stripe) Success() string {
+	return `{"received":true}`
+}
+
+// Call 调起支付用到的数据
+// form -> Checkout 支付页面地址
+// wap -> Checkout 支付页面地址
+// app -> PaymentIntent client_secret，由客户端 SDK 确认支付
+func (p *Stripe) Call(way pay.Way, in pay.Order) (string, error) {
+	switch way {
+	case pay.WayForm, pay.WayWap:
+		return p.checkoutCall(in)
+	case pay.WayApp:
+		return p.appCall(in)
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+func (p *Stripe) checkoutCall(in pay.Order) (string, error) {
+	var v = url.Values{}
+	v.Set("mode", "payment")
+	v.Set("success_url", p.opt.SuccessURL)
+	v.Set("cancel_url", p.opt.CancelURL)
+	v.Set("client_reference_id", in.ID)
+	v.Set("line_items[0][quantity]", "1")
+	v.Set("line_items[0][price_data][currency]", currency(in.Currency))
+	v.Set("line_items[0][price_data][unit_amount]", strconv.Itoa(int(in.Amount)))
+	v.Set("line_items[0][price_data][product_data][name]", in.Title)
+	v.Set("metadata["+kMetadataOrderID+"]", in.ID)
+	v.Set("payment_intent_data[metadata]["+kMetadataOrderID+"]", in.ID)
+
+	var rsp struct {
+		URL string `json:"url"`
+	}
+	if err := p.do("POST", kCheckoutSessions, "checkout-"+in.ID, v, &rsp); err != nil {
+		return "", err
+	}
+
+	return rsp.URL, nil
+}
+
+func (p *Stripe) appCall(in pay.Order) (string, error) {
+	var v = url.Values{}
+	v.Set("amount", strconv.Itoa(int(in.Amount)))
+	v.Set("currency", currency(in.Currency))
+	v.Set("description", in.Title)
+	v.Set("automatic_payment_methods[enabled]", "true")
+	v.Set("metadata["+kMetadataOrderID+"]", in.ID)
+
+	var rsp PaymentIntent
+	if err := p.do("POST", kPaymentIntents, "intent-"+in.ID, v, &rsp); err != nil {
+		return "", err
+	}
+
+	return rsp.ClientSecret, nil
+}
+
+// QueryPaymentIntent 查询 PaymentIntent
+func (p *Stripe) QueryPaymentIntent(id string) (*PaymentIntent, error) {
+	var rsp PaymentIntent
+	if err := p.do("GET", kPaymentIntents+"/"+url.PathEscape(id), "", nil, &rsp); err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// Refund 申请退款，PaymentID 为 PaymentIntent id，RefundOrder.ID 作为幂等键
+func (p *Stripe) Refund(in pay.RefundOrder) (*pay.RefundResult, error) {
+	if len(in.PaymentID) == 0 {
+		return nil, ErrPaymentIDRequired
+	}
+
+	var v = url.Values{}
+	v.Set("payment_intent", in.PaymentID)
+	v.Set("amount", strconv.Itoa(int(in.Amount)))
+	v.Set("metadata[refund_id]", in.ID)
+	v.Set("metadata[reason]", in.Reason)
+
+	var rsp struct {
+		ID     string `json:"id"`
+		Amount int32  `json:"amount"`
+		Status string `json:"status"`
+	}
+	if err := p.do("POST", kRefunds, "refund-"+in.ID, v, &rsp); err != nil {
+		return nil, err
+	}
+
+	var status = pay.RefundStatusProcessing
+	switch rsp.Status {
+	case "succeeded":
+		status = pay.RefundStatusSuccess
+	case "failed", "canceled":
+		status = pay.RefundStatusFailed
+	}
+
+	return &pay.RefundResult{
+		ID:       in.ID,
+		RefundID: rsp.ID,
+		Status:   status,
+		Amount:   rsp.Amount,
+	}, nil
+}
+
+// do 请求接口，idempotencyKey 不为空时作为 Idempotency-Key 保证幂等
+func (p *Stripe) do(method, path, idempotencyKey string, vals url.Values, result interface{}) error {
+	domain := kAPIDomain
+	if len(p.opt.APIDomain) > 0 {
+		domain = strings.TrimSuffix(p.opt.APIDomain, "/")
+	}
+
+	req, err := http.NewRequest(method, domain+path, strings.NewReader(vals.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.opt.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(idempotencyKey) > 0 {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.opt.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.Unmarshal(data, &e); err != nil || e.Error == nil {
+			return fmt.Errorf("stripe: http status %d", resp.StatusCode)
+		}
+		e.Error.StatusCode = resp.StatusCode
+		return e.Error
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// verify 验证 Stripe-Signature https://stripe.com/docs/webhooks/signatures
+// 格式 t=时间戳,v1=签名，签名为 HMAC-SHA256(时间戳.body)，可能有多个 v1
+func (p *Stripe) verify(header string, body []byte, now time.Time) error {
+	var (
+		timestamp string
+		sigs      []string
+	)
+
+	for _, item := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+
+	if d := now.Sub(time.Unix(t, 0)); d > p.opt.Tolerance || d < -p.opt.Tolerance {
+		return ErrTimestampExpired
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.opt.WebhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, s := range sigs {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+
+	return pay.ErrVerify
+}
+
+// Error 接口错误
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code"`
+	Message     string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stripe: %d %s %s", e.StatusCode, e.Code, e.Message)
+}
+
+// PaymentIntent PaymentIntent
+type PaymentIntent struct {
+	ID             string            `json:"id"`
+	Status         string            `json:"status"`
+	Amount         int32             `json:"amount"`
+	AmountReceived int32             `json:"amount_received"`
+	Currency       string            `json:"currency"`
+	ClientSecret   string            `json:"client_secret"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+// NoticeParams 支付结果，成功时金额为实收金额
+func (pi *PaymentIntent) NoticeParams() *pay.NoticeParams {
+	var (
+		status = IntentTradeStatus(pi.Status)
+		amount = pi.Amount
+	)
+
+	if status == pay.TradeStatusSuccess {
+		amount = pi.AmountReceived
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     pi.Metadata[kMetadataOrderID],
+		PaymentID:   pi.ID,
+		TradeStatus: status,
+		Amount:      amount,
+	}
+}
+
+// IntentTradeStatus PaymentIntent 状态转支付状态，支付失败可以重新支付，仍为等待付款
+func IntentTradeStatus(status string) pay.TradeStatus {
+	switch status {
+	case IntentStatusSucceeded:
+		return pay.TradeStatusSuccess
+	case IntentStatusCanceled:
+		return pay.TradeStatusClosed
+	}
+
+	return pay.TradeStatusWait
+}
+
+// WebhookValues webhook 请求头和body转为 Verify 的参数
+func WebhookValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	v.Set("Stripe-Signature", header.Get("Stripe-Signature"))
+	v.Set("body", string(body))
+	return v
+}
+
+// NoticeParams payment_intent.* 事件转回调参数
+func NoticeParams(body []byte) (*pay.NoticeParams, error) {
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object PaymentIntent `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(event.Type, "payment_intent.") {
+		return nil, ErrEventNotSupport
+	}
+
+	return event.Data.Object.NoticeParams(), nil
+}
+
+// currency 币种小写，为空时为 usd
+func currency(c string) string {
+	if len(c) == 0 {
+		return kDefaultCurrency
+	}
+
+	return strings.ToLower(c)
+}
@@ -0,0 +1,201 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// signature 按 ts 签名的 Stripe-Signature
+func signature(ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return "t=" + strconv.FormatInt(ts, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyFixture(t *testing.T) {
+	var (
+		p      = New(Options{WebhookSecret: testWebhookSecret})
+		body   = readFixture(t, "payment_intent_succeeded.json")
+		header = strings.TrimSpace(string(readFixture(t, "payment_intent_succeeded.sig")))
+		signed = time.Unix(1560000000, 0)
+	)
+
+	if err := p.verify(header, body, signed.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出 Tolerance
+	if err := p.verify(header, body, signed.Add(kDefaultTolerance+time.Second)); err != ErrTimestampExpired {
+		t.Errorf("expired: got %v, want %v", err, ErrTimestampExpired)
+	}
+	if err := p.verify(header, body, signed.Add(-kDefaultTolerance-time.Second)); err != ErrTimestampExpired {
+		t.Errorf("future: got %v, want %v", err, ErrTimestampExpired)
+	}
+
+	// 修改 body
+	tampered := []byte(strings.Replace(string(body), `"amount_received": 2000`, `"amount_received": 20`, 1))
+	if err := p.verify(header, tampered, signed); err != pay.ErrVerify {
+		t.Errorf("tampered: got %v, want %v", err, pay.ErrVerify)
+	}
+
+	// 其他密钥
+	other := New(Options{WebhookSecret: "whsec_other"})
+	if err := other.verify(header, body, signed); err != pay.ErrVerify {
+		t.Errorf("wrong secret: got %v, want %v", err, pay.ErrVerify)
+	}
+
+	for _, h := range []string{"", "t=1560000000", "v1=abc", "t=abc,v1=abc"} {
+		if err := p.verify(h, body, signed); err != ErrInvalidSignature {
+			t.Errorf("header %q: got %v, want %v", h, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p := New(Options{WebhookSecret: testWebhookSecret})
+	body := readFixture(t, "payment_intent_succeeded.json")
+
+	header := http.Header{}
+	// 多个 v1 时任意一个匹配即可，如轮换密钥期间
+	header.Set("Stripe-Signature", signature(time.Now().Unix(), body)+",v1=0000")
+
+	r, err := p.Verify(WebhookValues(header, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := pay.NoticeParams{
+		OrderID:     "20190701000001",
+		PaymentID:   "pi_3PSucceeded0001",
+		TradeStatus: pay.TradeStatusSuccess,
+		Amount:      2000,
+	}
+	if *r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	header.Set("Stripe-Signature", signature(time.Now().Add(-time.Hour).Unix(), body))
+	if _, err := p.Verify(WebhookValues(header, body)); err != ErrTimestampExpired {
+		t.Errorf("got %v, want %v", err, ErrTimestampExpired)
+	}
+}
+
+func TestNoticeParams(t *testing.T) {
+	var cases = []struct {
+		body   string
+		status pay.TradeStatus
+		amount int32
+	}{
+		{`{"type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","status":"succeeded","amount":100,"amount_received":90,"metadata":{"order_id":"O1"}}}}`, pay.TradeStatusSuccess, 90},
+		{`{"type":"payment_intent.canceled","data":{"object":{"id":"pi_1","status":"canceled","amount":100,"metadata":{"order_id":"O1"}}}}`, pay.TradeStatusClosed, 100},
+		{`{"type":"payment_intent.payment_failed","data":{"object":{"id":"pi_1","status":"requires_payment_method","amount":100,"metadata":{"order_id":"O1"}}}}`, pay.TradeStatusWait, 100},
+		{`{"type":"payment_intent.processing","data":{"object":{"id":"pi_1","status":"processing","amount":100,"metadata":{"order_id":"O1"}}}}`, pay.TradeStatusWait, 100},
+	}
+
+	for _, c := range cases {
+		r, err := NoticeParams([]byte(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.OrderID != "O1" || r.PaymentID != "pi_1" || r.TradeStatus != c.status || r.Amount != c.amount {
+			t.Errorf("%s: got %+v", c.body, r)
+		}
+	}
+
+	if _, err := NoticeParams([]byte(`{"type":"charge.succeeded","data":{"object":{}}}`)); err != ErrEventNotSupport {
+		t.Errorf("got %v, want %v", err, ErrEventNotSupport)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	var (
+		req    url.Values
+		header http.Header
+		status = "succeeded"
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != kRefunds {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, _ = url.ParseQuery(string(body))
+		header = r.Header
+		w.Write([]byte(`{"id":"re_1","amount":` + req.Get("amount") + `,"status":"` + status + `"}`))
+	}))
+	defer s.Close()
+
+	p := New(Options{SecretKey: "sk_test", APIDomain: s.URL})
+
+	r, err := p.Refund(pay.RefundOrder{ID: "R1", PaymentID: "pi_1", Amount: 50, Reason: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RefundID != "re_1" || r.Status != pay.RefundStatusSuccess || r.Amount != 50 {
+		t.Errorf("result %+v", r)
+	}
+	if req.Get("payment_intent") != "pi_1" || req.Get("metadata[refund_id]") != "R1" {
+		t.Errorf("request %v", req)
+	}
+	if header.Get("Idempotency-Key") != "refund-R1" {
+		t.Errorf("Idempotency-Key %q", header.Get("Idempotency-Key"))
+	}
+	if user, _, _ := (&http.Request{Header: header}).BasicAuth(); user != "sk_test" {
+		t.Errorf("basic auth user %q", user)
+	}
+
+	status = "pending"
+	if r, _ := p.Refund(pay.RefundOrder{ID: "R2", PaymentID: "pi_1", Amount: 50}); r.Status != pay.RefundStatusProcessing {
+		t.Errorf("status %v, want processing", r.Status)
+	}
+
+	if _, err := p.Refund(pay.RefundOrder{ID: "R3", Amount: 50}); err != ErrPaymentIDRequired {
+		t.Errorf("got %v, want %v", err, ErrPaymentIDRequired)
+	}
+}
+
+func TestRefundError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"type":"invalid_request_error","code":"charge_already_refunded","message":"already refunded"}}`))
+	}))
+	defer s.Close()
+
+	p := New(Options{SecretKey: "sk_test", APIDomain: s.URL})
+
+	_, err := p.Refund(pay.RefundOrder{ID: "R1", PaymentID: "pi_1", Amount: 50})
+	e, ok := err.(*Error)
+	if !ok || e.StatusCode != http.StatusBadRequest || e.Code != "charge_already_refunded" {
+		t.Errorf("got %v, want *Error", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	if p := New(Options{}); p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+}
@@ -0,0 +1,144 @@
+// Package tracing 支付操作和支付平台接口请求的 OpenTelemetry 链路追踪
+//
+// Middleware 为经过 pay.Wrap 的每次操作创建 span，并把 span 的 context 传给实现了 pay.ContextPayer 的支付平台，
+// Transport 为每次接口请求创建子 span，通过 alipay.Options.WrapTransport wxpay.Options.WrapTransport payscore.Options.WrapTransport 使用。
+// span 只记录商户单号、接口名和返回码，不记录密钥、签名、用户标识、银行卡等请求内容
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/gocommon/pay"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const kInstrumentation = "github.com/gocommon/pay/tracing"
+
+// 属性名
+const (
+	AttrProvider      = attribute.Key("pay.provider")
+	AttrOperation     = attribute.Key("pay.operation")
+	AttrWay           = attribute.Key("pay.way")
+	AttrOutTradeNo    = attribute.Key("pay.out_trade_no") // 商户单号：订单号、退款单号、签约号、授权单号或付款单号
+	AttrTradeStatus   = attribute.Key("pay.trade_status")
+	AttrAPI           = attribute.Key("pay.api")         // 接口名，支付宝为 method，其他为接口路径
+	AttrResultCode    = attribute.Key("pay.result_code") // 支付宝 code，微信 result_code
+	AttrSubCode       = attribute.Key("pay.sub_code")    // 支付宝 sub_code，微信 err_code
+	AttrErrorCategory = attribute.Key("pay.error.category")
+)
+
+// Options Options
+type Options struct {
+	TracerProvider trace.TracerProvider // 默认 otel.GetTracerProvider()
+}
+
+// Tracer 支付链路追踪
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New New
+func New(opt Options) *Tracer {
+	if opt.TracerProvider == nil {
+		opt.TracerProvider = otel.GetTracerProvider()
+	}
+
+	return &Tracer{
+		tracer: opt.TracerProvider.Tracer(kInstrumentation),
+	}
+}
+
+// Wrap 用 Middleware 和 mws 包装 Payer，Middleware 在最外层
+// 需要在请求的 context 下调用时使用 Wrapped.BindContext
+func (t *Tracer) Wrap(provider string, p pay.Payer, mws ...pay.Middleware) *pay.Wrapped {
+	return pay.Wrap(provider, p, append([]pay.Middleware{t.Middleware()}, mws...)...)
+}
+
+// Middleware 每次操作一个 span，父 span 来自 Invocation.Context，下一层使用带有该 span 的 context
+func (t *Tracer) Middleware() pay.Middleware {
+	return func(next pay.Handler) pay.Handler {
+		return func(inv *pay.Invocation) (interface{}, error) {
+			attrs := []attribute.KeyValue{
+				AttrProvider.String(inv.Provider),
+				AttrOperation.String(string(inv.Operation)),
+			}
+			if len(inv.Way) > 0 {
+				attrs = append(attrs, AttrWay.String(string(inv.Way)))
+			}
+			if len(inv.OrderID) > 0 {
+				attrs = append(attrs, AttrOutTradeNo.String(inv.OrderID))
+			}
+
+			ctx, span := t.tracer.Start(inv.Context, "pay."+string(inv.Operation), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			inv.Context = ctx
+			res, err := next(inv)
+
+			if params, ok := res.(*pay.NoticeParams); ok && params != nil {
+				setNotice(span, params)
+			}
+			setError(span, err)
+
+			return res, err
+		}
+	}
+}
+
+// Notify 处理支付回调，ctx 为回调 handler 的请求 context
+// 验证回调和业务处理 fn 在同一个 span 下，fn 的 ctx 带有该 span，业务处理中的数据库、消息等操作可以继续追踪
+// 验证失败时不调用 fn，返回验证或 fn 的错误，成功时 handler 应返回 p.Success()
+func (t *Tracer) Notify(ctx context.Context, p *pay.Wrapped, in url.Values, fn func(ctx context.Context, params *pay.NoticeParams) error) error {
+	ctx, span := t.tracer.Start(ctx, "pay.notify",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(AttrProvider.String(p.Provider)),
+	)
+	defer span.End()
+
+	params, err := p.BindContext(ctx).Verify(in)
+	if err != nil {
+		setError(span, err)
+		return err
+	}
+
+	setNotice(span, params)
+
+	err = fn(ctx, params)
+	setError(span, err)
+
+	return err
+}
+
+// setNotice 回调或查询结果
+func setNotice(span trace.Span, params *pay.NoticeParams) {
+	if len(params.OrderID) > 0 {
+		span.SetAttributes(AttrOutTradeNo.String(params.OrderID))
+	}
+	span.SetAttributes(AttrTradeStatus.String(params.TradeStatus.String()))
+}
+
+// setError *pay.Error 记录返回码和错误分类，包装过的 *pay.Error 按 errors.As 取出
+func setError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	var e *pay.Error
+	if errors.As(err, &e) {
+		span.SetAttributes(AttrErrorCategory.String(e.Category.String()))
+		if len(e.Code) > 0 {
+			span.SetAttributes(AttrResultCode.String(e.Code))
+		}
+		if len(e.SubCode) > 0 {
+			span.SetAttributes(AttrSubCode.String(e.SubCode))
+		}
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
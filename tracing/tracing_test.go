@@ -0,0 +1,202 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpan 记录 SetAttributes 的属性
+type recordSpan struct {
+	trace.Span
+	attrs map[attribute.Key]string
+}
+
+func (s *recordSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, v := range kv {
+		s.attrs[v.Key] = v.Value.Emit()
+	}
+}
+
+func TestSetErrorWrapped(t *testing.T) {
+	span := &recordSpan{
+		Span:  trace.SpanFromContext(context.Background()),
+		attrs: make(map[attribute.Key]string),
+	}
+
+	err := fmt.Errorf("refund: %w", &pay.Error{Category: pay.CategoryBalance, Code: "FAIL", SubCode: "NOTENOUGH"})
+	setError(span, err)
+
+	want := map[attribute.Key]string{
+		AttrErrorCategory: pay.CategoryBalance.String(),
+		AttrResultCode:    "FAIL",
+		AttrSubCode:       "NOTENOUGH",
+	}
+	for k, v := range want {
+		if span.attrs[k] != v {
+			t.Errorf("%s = %q, want %q", k, span.attrs[k], v)
+		}
+	}
+}
+
+// newTestTracer 使用 SpanRecorder 记录结束的 span
+func newTestTracer() (*Tracer, *tracetest.SpanRecorder, trace.Tracer) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	return New(Options{TracerProvider: tp}), rec, tp.Tracer("test")
+}
+
+// endedSpan 名为 name 的 span
+func endedSpan(t *testing.T, rec *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+
+	t.Fatalf("span %s not found", name)
+	return nil
+}
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	var m = make(map[attribute.Key]string)
+	for _, kv := range s.Attributes() {
+		m[kv.Key] = kv.Value.Emit()
+	}
+	return m
+}
+
+// ctxPayer 记录 WithContext 传入的 context
+type ctxPayer struct {
+	ctx  context.Context
+	seen *[]context.Context
+}
+
+func (p *ctxPayer) WithContext(ctx context.Context) pay.Payer {
+	return &ctxPayer{ctx: ctx, seen: p.seen}
+}
+
+func (p *ctxPayer) Verify(in url.Values) (*pay.NoticeParams, error) {
+	*p.seen = append(*p.seen, p.ctx)
+	if in.Get("sign") != "ok" {
+		return nil, pay.ErrVerify
+	}
+	return &pay.NoticeParams{OrderID: in.Get("out_trade_no"), TradeStatus: pay.TradeStatusSuccess}, nil
+}
+
+func (p *ctxPayer) Success() string { return "success" }
+
+func (p *ctxPayer) Call(way pay.Way, in pay.Order) (string, error) {
+	*p.seen = append(*p.seen, p.ctx)
+	if in.ID == "FAIL" {
+		return "", &pay.Error{Code: "40004", SubCode: "ACQ.TRADE_HAS_SUCCESS", Category: pay.CategoryDuplicate}
+	}
+	return "code_url", nil
+}
+
+func TestMiddlewareContext(t *testing.T) {
+	tr, rec, test := newTestTracer()
+
+	var seen []context.Context
+	w := tr.Wrap("fake", &ctxPayer{seen: &seen})
+
+	ctx, parent := test.Start(context.Background(), "handler")
+	if _, err := w.BindContext(ctx).Call(pay.WayQrcode, pay.Order{ID: "O1"}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	span := endedSpan(t, rec, "pay.call")
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("parent %v, want the handler span", span.Parent().SpanID())
+	}
+	// 支付平台使用 Middleware 创建的 span 的 context 发出请求
+	if len(seen) != 1 || trace.SpanContextFromContext(seen[0]).SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("payer context does not carry the operation span")
+	}
+
+	want := map[attribute.Key]string{
+		AttrProvider:   "fake",
+		AttrOperation:  string(pay.OpCall),
+		AttrWay:        string(pay.WayQrcode),
+		AttrOutTradeNo: "O1",
+	}
+	attrs := spanAttrs(span)
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("%s = %q, want %q", k, attrs[k], v)
+		}
+	}
+	if span.Status().Code != codes.Unset {
+		t.Errorf("status %v", span.Status())
+	}
+
+	if _, err := w.Call(pay.WayQrcode, pay.Order{ID: "FAIL"}); err == nil {
+		t.Fatal("want error")
+	}
+	span = rec.Ended()[len(rec.Ended())-1]
+	attrs = spanAttrs(span)
+	if span.Status().Code != codes.Error || attrs[AttrResultCode] != "40004" || attrs[AttrSubCode] != "ACQ.TRADE_HAS_SUCCESS" {
+		t.Errorf("status %v, attributes %v", span.Status(), attrs)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tr, rec, _ := newTestTracer()
+
+	var seen []context.Context
+	w := tr.Wrap("fake", &ctxPayer{seen: &seen})
+
+	var fnCtx context.Context
+	err := tr.Notify(context.Background(), w, url.Values{"out_trade_no": {"O1"}, "sign": {"ok"}}, func(ctx context.Context, params *pay.NoticeParams) error {
+		fnCtx = ctx
+		if params.OrderID != "O1" {
+			t.Errorf("params %+v", params)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notify := endedSpan(t, rec, "pay.notify")
+	verify := endedSpan(t, rec, "pay.verify")
+
+	// 验证和业务处理都在 pay.notify 下
+	if fnCtx == nil || trace.SpanContextFromContext(fnCtx).SpanID() != notify.SpanContext().SpanID() {
+		t.Errorf("fn context does not carry the notify span")
+	}
+	if verify.Parent().SpanID() != notify.SpanContext().SpanID() {
+		t.Errorf("verify span parent %v, want notify", verify.Parent().SpanID())
+	}
+	if len(seen) != 1 || trace.SpanContextFromContext(seen[0]).SpanID() != verify.SpanContext().SpanID() {
+		t.Errorf("payer context does not carry the verify span")
+	}
+	if attrs := spanAttrs(notify); attrs[AttrOutTradeNo] != "O1" || attrs[AttrTradeStatus] != pay.TradeStatusSuccess.String() {
+		t.Errorf("attributes %v", attrs)
+	}
+
+	// 验证失败时不调用 fn
+	var called bool
+	err = tr.Notify(context.Background(), w, url.Values{"sign": {"bad"}}, func(ctx context.Context, params *pay.NoticeParams) error {
+		called = true
+		return nil
+	})
+	if err != pay.ErrVerify || called {
+		t.Errorf("got %v, fn called %v", err, called)
+	}
+	if s := rec.Ended()[len(rec.Ended())-1]; s.Name() != "pay.notify" || s.Status().Code != codes.Error {
+		t.Errorf("span %s status %v", s.Name(), s.Status())
+	}
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// kMaxBody 读取请求和响应内容的上限，超过时不解析
+const kMaxBody = 1 << 20
+
+// kOutTradeNoFields 记录为 AttrOutTradeNo 的字段，按顺序取第一个不为空的值
+var kOutTradeNoFields = []string{
+	"out_trade_no",
+	"out_refund_no",
+	"out_request_no",
+	"out_order_no",
+	"out_biz_no",
+	"partner_trade_no",
+	"external_agreement_no",
+}
+
+// Transport 返回包装 Transport 的函数，用于 alipay.Options.WrapTransport wxpay.Options.WrapTransport payscore.Options.WrapTransport
+// 每次请求一个 client span，父 span 为请求的 context，记录接口名、商户单号、http 状态码和支付平台返回码
+// http.url 不含查询参数，请求和响应内容不记录
+func (t *Tracer) Transport(provider string) func(http.RoundTripper) http.RoundTripper {
+	return func(base http.RoundTripper) http.RoundTripper {
+		if base == nil {
+			base = http.DefaultTransport
+		}
+
+		return &transport{
+			base:     base,
+			tracer:   t.tracer,
+			provider: provider,
+		}
+	}
+}
+
+type transport struct {
+	base     http.RoundTripper
+	tracer   trace.Tracer
+	provider string
+}
+
+// RoundTrip RoundTrip
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	api, fields := requestFields(req)
+
+	attrs := []attribute.KeyValue{
+		AttrProvider.String(t.provider),
+		AttrAPI.String(api),
+		semconv.HTTPMethodKey.String(req.Method),
+		semconv.HTTPURLKey.String(req.URL.Scheme + "://" + req.URL.Host + req.URL.Path),
+		semconv.NetPeerNameKey.String(req.URL.Hostname()),
+	}
+	if no := outTradeNo(fields); len(no) > 0 {
+		attrs = append(attrs, AttrOutTradeNo.String(no))
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), t.provider+" "+api,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+		return resp, nil
+	}
+
+	code, subCode := responseCodes(resp)
+	if len(code) > 0 {
+		span.SetAttributes(AttrResultCode.String(code))
+	}
+	if len(subCode) > 0 {
+		span.SetAttributes(AttrSubCode.String(subCode))
+	}
+
+	return resp, nil
+}
+
+// requestFields 接口名和请求参数，只在 req.GetBody 可用时读取请求内容
+// 支付宝为表单，接口名为 method，业务参数在 biz_content 中；微信为 xml，接口名为路径
+func requestFields(req *http.Request) (api string, fields map[string]string) {
+	api = req.URL.Path
+
+	if req.GetBody == nil || req.ContentLength > kMaxBody {
+		return api, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return api, nil
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, kMaxBody))
+	if err != nil {
+		return api, nil
+	}
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		return api, xmlFields(data)
+	}
+
+	form, err := url.ParseQuery(string(data))
+	if err != nil {
+		return api, nil
+	}
+
+	if method := form.Get("method"); len(method) > 0 {
+		api = method
+	}
+
+	fields = make(map[string]string)
+	json.Unmarshal([]byte(form.Get("biz_content")), &fields)
+
+	return api, fields
+}
+
+// responseCodes 支付平台返回码，读取后恢复 resp.Body
+// 支付宝为响应节点的 code sub_code，微信 return_code 为 FAIL 时为 return_code，否则为 result_code err_code
+func responseCodes(resp *http.Response) (code, subCode string) {
+	if resp.ContentLength > kMaxBody {
+		return "", ""
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, kMaxBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	if err != nil || len(data) > kMaxBody {
+		return "", ""
+	}
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		fields := xmlFields(data)
+		if fields["return_code"] == "FAIL" {
+			return "FAIL", ""
+		}
+		return fields["result_code"], fields["err_code"]
+	}
+
+	var rsp map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rsp); err != nil {
+		return "", ""
+	}
+
+	for k, v := range rsp {
+		if !strings.HasSuffix(k, "_response") {
+			continue
+		}
+
+		var r struct {
+			Code    string `json:"code"`
+			SubCode string `json:"sub_code"`
+		}
+		json.Unmarshal(v, &r)
+
+		return r.Code, r.SubCode
+	}
+
+	return "", ""
+}
+
+// xmlFields 根节点下的一级字段
+func xmlFields(data []byte) map[string]string {
+	var (
+		fields = make(map[string]string)
+		dec    = xml.NewDecoder(bytes.NewReader(data))
+		depth  int
+		name   string
+	)
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return fields
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			name = t.Name.Local
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 2 {
+				fields[name] += string(t)
+			}
+		}
+	}
+}
+
+// outTradeNo 第一个不为空的商户单号
+func outTradeNo(fields map[string]string) string {
+	for _, k := range kOutTradeNoFields {
+		if v := fields[k]; len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+func TestTransport(t *testing.T) {
+	var cases = []struct {
+		name     string
+		provider string
+		status   int
+		req      string
+		rsp      string
+		want     map[attribute.Key]string
+		secrets  []string
+	}{
+		{
+			name:     "alipay",
+			provider: "alipay",
+			status:   http.StatusOK,
+			req: url.Values{
+				"method":      {"alipay.trade.refund"},
+				"biz_content": {`{"out_trade_no":"O1","refund_amount":"1.00","buyer_logon_id":"buyer@example.com"}`},
+				"sign":        {"SIGNSECRET"},
+			}.Encode(),
+			rsp: `{"alipay_trade_refund_response":{"code":"40004","msg":"Business Failed","sub_code":"ACQ.TRADE_NOT_EXIST","buyer_user_id":"2088000000000001"},"sign":"RSPSIGN"}`,
+			want: map[attribute.Key]string{
+				AttrProvider:   "alipay",
+				AttrAPI:        "alipay.trade.refund",
+				AttrOutTradeNo: "O1",
+				AttrResultCode: "40004",
+				AttrSubCode:    "ACQ.TRADE_NOT_EXIST",
+			},
+			secrets: []string{"SIGNSECRET", "buyer@example.com", "1.00", "2088000000000001", "RSPSIGN", "token"},
+		},
+		{
+			name:     "wxpay",
+			provider: "wxpay",
+			status:   http.StatusOK,
+			req:      `<xml><partner_trade_no>P1</partner_trade_no><enc_bank_no>Ym5r</enc_bank_no><sign>SIGNSECRET</sign></xml>`,
+			rsp:      `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>NOTENOUGH</err_code><sign>RSPSIGN</sign></xml>`,
+			want: map[attribute.Key]string{
+				AttrAPI:        "/mmpaysptrans/pay_bank",
+				AttrOutTradeNo: "P1",
+				AttrResultCode: "FAIL",
+				AttrSubCode:    "NOTENOUGH",
+			},
+			secrets: []string{"SIGNSECRET", "Ym5r", "RSPSIGN", "token"},
+		},
+		{
+			name:     "server error",
+			provider: "wxpay",
+			status:   http.StatusInternalServerError,
+			req:      `<xml><out_trade_no>O2</out_trade_no></xml>`,
+			rsp:      `<xml><return_code>FAIL</return_code></xml>`,
+			want: map[attribute.Key]string{
+				AttrOutTradeNo: "O2",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		tr, rec, _ := newTestTracer()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			w.Write([]byte(c.rsp))
+		}))
+
+		path := "/gateway.do"
+		if c.provider == "wxpay" {
+			path = "/mmpaysptrans/pay_bank"
+		}
+
+		cli := &http.Client{Transport: tr.Transport(c.provider)(nil)}
+		resp, err := cli.Post(srv.URL+path+"?app_auth_token=token", "application/x-www-form-urlencoded", strings.NewReader(c.req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+
+		// 读取返回码后调用方仍能读取完整的响应
+		if string(data) != c.rsp {
+			t.Errorf("%s: response body %s", c.name, data)
+		}
+
+		spans := rec.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("%s: %d spans", c.name, len(spans))
+		}
+		attrs := spanAttrs(spans[0])
+
+		for k, v := range c.want {
+			if attrs[k] != v {
+				t.Errorf("%s: %s = %q, want %q", c.name, k, attrs[k], v)
+			}
+		}
+		if attrs[semconv.HTTPMethodKey] != http.MethodPost || attrs[semconv.HTTPURLKey] != srv.URL+path {
+			t.Errorf("%s: http attributes %v", c.name, attrs)
+		}
+
+		// 请求和响应内容不记录
+		for k, v := range attrs {
+			for _, s := range c.secrets {
+				if strings.Contains(v, s) {
+					t.Errorf("%s: %s = %q contains %q", c.name, k, v, s)
+				}
+			}
+		}
+
+		if c.status >= http.StatusBadRequest {
+			if spans[0].Status().Code != codes.Error || len(attrs[AttrResultCode]) > 0 {
+				t.Errorf("%s: status %v, attributes %v", c.name, spans[0].Status(), attrs)
+			}
+		}
+	}
+}
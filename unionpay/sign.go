@@ -0,0 +1,139 @@
+package unionpay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gocommon/pay"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// kUnionpayCN 银联签名证书 CN 中的机构名称
+const kUnionpayCN = "中国银联股份有限公司"
+
+var (
+	// ErrInvalidSignCert 商户签名证书无效
+	ErrInvalidSignCert = errors.New("unionpay: invalid sign cert")
+	// ErrInvalidVerifyCert 银联签名证书无效
+	ErrInvalidVerifyCert = errors.New("unionpay: invalid verify cert")
+)
+
+// loadSignCert 加载商户签名证书，返回 certId 和私钥
+func loadSignCert(path, password string) (string, *rsa.PrivateKey, error) {
+	pfx, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, cert, err := pkcs12.Decode(pfx, password)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", nil, ErrInvalidSignCert
+	}
+
+	return cert.SerialNumber.String(), pk, nil
+}
+
+// loadCertPool 加载银联根证书、中级证书
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrInvalidVerifyCert
+	}
+
+	return pool, nil
+}
+
+// signValues 设置 certId signMethod 并签名
+func (p *Unionpay) signValues(vals url.Values) (url.Values, error) {
+	vals.Del("signature")
+	vals.Set("certId", p.certID)
+	vals.Set("signMethod", kSignMethod)
+
+	hashed := sha256.Sum256([]byte(signDigest(vals)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	vals.Set("signature", base64.StdEncoding.EncodeToString(sig))
+	return vals, nil
+}
+
+// verify 验证银联签名证书链和签名
+func (p *Unionpay) verify(vals url.Values) error {
+	block, _ := pem.Decode([]byte(vals.Get("signPubKeyCert")))
+	if block == nil {
+		return ErrInvalidVerifyCert
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: p.middles,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+
+	if cn := strings.Split(cert.Subject.CommonName, "@"); len(cn) < 2 || cn[1] != kUnionpayCN {
+		return ErrInvalidVerifyCert
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidVerifyCert
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(vals.Get("signature"))
+	if err != nil {
+		return pay.ErrVerify
+	}
+
+	hashed := sha256.Sum256([]byte(signDigest(vals)))
+	if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig) != nil {
+		return pay.ErrVerify
+	}
+
+	return nil
+}
+
+// signDigest 除 signature 外的参数按 key 排序拼接后 sha256，返回16进制小写
+func signDigest(vals url.Values) string {
+	var pList = make([]string, 0, len(vals))
+	for k := range vals {
+		if k == "signature" {
+			continue
+		}
+		pList = append(pList, k+"="+vals.Get(k))
+	}
+	sort.Strings(pList)
+
+	h := sha256.Sum256([]byte(strings.Join(pList, "&")))
+	return hex.EncodeToString(h[:])
+}
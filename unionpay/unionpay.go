@@ -0,0 +1,328 @@
+package unionpay
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payer = &Unionpay{}
+
+// 银联全渠道 https://open.unionpay.com/tjweb/acproduct/list?apiSvcId=448
+const (
+	kSandboxGateway    = "https://gateway.test.95516.com"
+	kProductionGateway = "https://gateway.95516.com"
+
+	kFrontTransReq = "/gateway/api/frontTransReq.do"
+	kAppTransReq   = "/gateway/api/appTransReq.do"
+
+	kVersion      = "5.1.0"
+	kSignMethod   = "01" // RSA-SHA256
+	kCurrencyCode = "156"
+
+	kChannelTypePC     = "07"
+	kChannelTypeMobile = "08"
+
+	kProvider = "unionpay" // pay.Error.Provider
+
+	kTimeout = 10 * time.Second
+)
+
+// kRespCodeCategory 应答码的分类
+var kRespCodeCategory = map[string]pay.Category{
+	"03": pay.CategorySystem,    // 交易通讯超时
+	"04": pay.CategorySystem,    // 交易状态未明
+	"05": pay.CategorySystem,    // 交易已受理
+	"06": pay.CategorySystem,    // 系统繁忙
+	"11": pay.CategoryAuth,      // 验证签名失败
+	"12": pay.CategoryDuplicate, // 重复交易
+	"13": pay.CategoryParam,     // 报文交易要素缺失
+	"30": pay.CategoryParam,     // 报文格式错误
+	"31": pay.CategoryAuth,      // 商户状态不正确
+	"32": pay.CategoryAuth,      // 无此交易权限
+	"34": pay.CategoryParam,     // 查无此交易
+	"51": pay.CategoryBalance,   // 余额不足
+}
+
+var (
+	// ErrRespCode 银联返回应答码不成功
+	ErrRespCode = errors.New("unionpay: resp code not success")
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+// Options Options
+type Options struct {
+	MerID            string
+	SignCertFile     string // 商户签名证书 pfx 路径
+	SignCertPassword string // 商户签名证书密码
+	RootCertFile     string // 银联根证书 acp_prod_root.cer 路径
+	MiddleCertFile   string // 银联中级证书 acp_prod_middle.cer 路径
+	IsProduction     bool
+	NotifyURL        string       // 异步回调地址 backUrl
+	ReturnURL        string       // 同步回调地址 frontUrl
+	GatewayURL       string       // 网关域名，为空时按 IsProduction 选择
+	Client           *http.Client // 为空时使用超时 10s 的 http.Client
+}
+
+// Unionpay Unionpay
+type Unionpay struct {
+	opt Options
+
+	certID     string
+	privateKey *rsa.PrivateKey
+	roots      *x509.CertPool
+	middles    *x509.CertPool
+}
+
+// New New
+func New(opt Options) (*Unionpay, error) {
+	certID, key, err := loadSignCert(opt.SignCertFile, opt.SignCertPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := loadCertPool(opt.RootCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	middles, err := loadCertPool(opt.MiddleCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	p := &Unionpay{
+		opt:        opt,
+		certID:     certID,
+		privateKey: key,
+		roots:      roots,
+		middles:    middles,
+	}
+
+	return p, nil
+}
+
+// Verify 支付回调验证签名,成功返回回调参数
+func (p *Unionpay) Verify(in url.Values) (*pay.NoticeParams, error) {
+	if err := p.verify(in); err != nil {
+		return nil, err
+	}
+
+	return NoticeParams(in), nil
+}
+
+// Success 回调成功返回数据
+func (p *Unionpay) Success() string {
+	return "ok"
+}
+
+// Call 调起支付用到的数据
+// form -> 自动提交form表单 html
+// wap -> 自动提交form表单 html
+// app -> 调起银联控件用到的tn
+func (p *Unionpay) Call(way pay.Way, in pay.Order) (string, error) {
+	switch way {
+	case pay.WayForm:
+		return p.formCall(in, kChannelTypePC)
+	case pay.WayWap:
+		return p.formCall(in, kChannelTypeMobile)
+	case pay.WayApp:
+		return p.appCall(in)
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+func (p *Unionpay) formCall(in pay.Order, channelType string) (string, error) {
+	v, err := p.signValues(p.consumeValues(in, channelType))
+	if err != nil {
+		return "", err
+	}
+
+	var keys = make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(`<form id="unionpaysubmit" name="unionpaysubmit" action="`)
+	b.WriteString(html.EscapeString(p.api(kFrontTransReq)))
+	b.WriteString(`" method="post">`)
+	for _, k := range keys {
+		fmt.Fprintf(&b, `<input type="hidden" name="%s" value="%s"/>`, html.EscapeString(k), html.EscapeString(v.Get(k)))
+	}
+	b.WriteString(`</form><script>document.forms['unionpaysubmit'].submit();</script>`)
+
+	return b.String(), nil
+}
+
+func (p *Unionpay) appCall(in pay.Order) (string, error) {
+	rsp, err := p.post(kAppTransReq, p.consumeValues(in, kChannelTypeMobile))
+	if err != nil {
+		return "", err
+	}
+
+	if code := rsp.Get("respCode"); code != "00" {
+		return "", newError(code, rsp.Get("respMsg"))
+	}
+
+	return rsp.Get("tn"), nil
+}
+
+// newError 应答码不成功的错误，Err 为 ErrRespCode
+func newError(respCode, respMsg string) *pay.Error {
+	return &pay.Error{
+		Provider: kProvider,
+		Code:     respCode,
+		Message:  respMsg,
+		Category: kRespCodeCategory[respCode],
+		Err:      ErrRespCode,
+	}
+}
+
+// consumeValues 消费交易参数
+func (p *Unionpay) consumeValues(in pay.Order, channelType string) url.Values {
+	var v = url.Values{}
+	v.Set("version", kVersion)
+	v.Set("encoding", "UTF-8")
+	v.Set("txnType", "01")
+	v.Set("txnSubType", "01")
+	v.Set("bizType", "000201")
+	v.Set("channelType", channelType)
+	v.Set("accessType", "0")
+	v.Set("merId", p.opt.MerID)
+	v.Set("orderId", in.ID)
+	v.Set("txnTime", time.Now().In(cst).Format("20060102150405"))
+	v.Set("txnAmt", strconv.Itoa(int(in.Amount)))
+	v.Set("currencyCode", kCurrencyCode)
+	v.Set("orderDesc", in.Title)
+	v.Set("backUrl", p.opt.NotifyURL)
+	if len(p.opt.ReturnURL) > 0 {
+		v.Set("frontUrl", p.opt.ReturnURL)
+	}
+	if len(in.IP) > 0 {
+		v.Set("customerIp", in.IP)
+	}
+
+	return v
+}
+
+// post 签名并请求后台接口，返回结果验证签名
+func (p *Unionpay) post(api string, vals url.Values) (url.Values, error) {
+	vals, err := p.signValues(vals)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.opt.Client.PostForm(p.api(api), vals)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	rsp := parseResponse(string(data))
+	if err := p.verify(rsp); err != nil {
+		return nil, err
+	}
+
+	return rsp, nil
+}
+
+// api 接口地址，Options.GatewayURL 不为空时替换默认域名
+func (p *Unionpay) api(path string) string {
+	if len(p.opt.GatewayURL) > 0 {
+		return strings.TrimSuffix(p.opt.GatewayURL, "/") + path
+	}
+
+	if p.opt.IsProduction {
+		return kProductionGateway + path
+	}
+
+	return kSandboxGateway + path
+}
+
+// NoticeParams 回调参数
+func NoticeParams(val url.Values) *pay.NoticeParams {
+	amount, _ := strconv.Atoi(val.Get("txnAmt"))
+
+	return &pay.NoticeParams{
+		OrderID:     val.Get("orderId"),
+		PaymentID:   val.Get("queryId"),
+		TradeStatus: TradeStatus(val.Get("respCode")),
+		Amount:      int32(amount),
+	}
+}
+
+// TradeStatus 应答码转支付状态，00 A6 成功，03 04 05 处理中，其他为失败
+func TradeStatus(respCode string) pay.TradeStatus {
+	switch respCode {
+	case "00", "A6":
+		return pay.TradeStatusSuccess
+	case "03", "04", "05":
+		return pay.TradeStatusWait
+	}
+
+	return pay.TradeStatusClosed
+}
+
+// parseResponse 解析后台接口返回，值未做url编码，可能包含 {} [] 包裹的 &
+func parseResponse(body string) url.Values {
+	var (
+		v     = url.Values{}
+		depth int
+		start int
+	)
+
+	body = strings.TrimSpace(body)
+	for i := 0; i <= len(body); i++ {
+		if i < len(body) {
+			switch body[i] {
+			case '{', '[':
+				depth++
+				continue
+			case '}', ']':
+				depth--
+				continue
+			case '&':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		if kv := body[start:i]; len(kv) > 0 {
+			if n := strings.IndexByte(kv, '='); n > 0 {
+				v.Set(kv[:n], kv[n+1:])
+			}
+		}
+		start = i + 1
+	}
+
+	return v
+}
@@ -0,0 +1,169 @@
+package unionpay
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/unionpay/unionpaytest"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	kTestCertFile     = "testdata/merchant.pfx"
+	kTestCertPassword = "000000"
+)
+
+func newTestUnionpay(t *testing.T) (*Unionpay, *unionpaytest.Server) {
+	s, err := unionpaytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	p, err := New(Options{
+		MerID:            "777290058110048",
+		SignCertFile:     kTestCertFile,
+		SignCertPassword: kTestCertPassword,
+		RootCertFile:     s.RootCertFile,
+		MiddleCertFile:   s.MiddleCertFile,
+		NotifyURL:        "https://example.com/notify",
+		GatewayURL:       s.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, s
+}
+
+// merchantKey 商户签名证书的公钥和 certId
+func merchantKey(t *testing.T) (*rsa.PublicKey, string) {
+	pfx, err := ioutil.ReadFile(kTestCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, cert, err := pkcs12.Decode(pfx, kTestCertPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.PublicKey.(*rsa.PublicKey), cert.SerialNumber.String()
+}
+
+func TestAppCallSign(t *testing.T) {
+	p, s := newTestUnionpay(t)
+
+	tn, err := p.Call(pay.WayApp, pay.Order{ID: "20190701000001", Title: "test", Amount: 101})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn) == 0 {
+		t.Error("empty tn")
+	}
+
+	reqs := s.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("%d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+
+	pub, certID := merchantKey(t)
+	if req.Get("certId") != certID || req.Get("signMethod") != kSignMethod {
+		t.Errorf("certId %s signMethod %s", req.Get("certId"), req.Get("signMethod"))
+	}
+	if req.Get("txnAmt") != "101" || req.Get("orderId") != "20190701000001" {
+		t.Errorf("request %v", req)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(req.Get("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashed := sha256.Sum256([]byte(signDigest(req)))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil {
+		t.Errorf("request signature: %v", err)
+	}
+}
+
+func TestAppCallError(t *testing.T) {
+	p, s := newTestUnionpay(t)
+	s.RespCode = "12"
+	s.RespMsg = "重复交易"
+
+	_, err := p.Call(pay.WayApp, pay.Order{ID: "20190701000001", Title: "test", Amount: 101})
+
+	var e *pay.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %T %v, want *pay.Error", err, err)
+	}
+	if e.Code != "12" || e.Message != "重复交易" || e.Category != pay.CategoryDuplicate {
+		t.Errorf("got %+v", e)
+	}
+	if !errors.Is(err, ErrRespCode) {
+		t.Error("error does not wrap ErrRespCode")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, s := newTestUnionpay(t)
+
+	r, err := p.Verify(s.Notify("20190701000001", 101, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "20190701000001" || r.Amount != 101 || r.TradeStatus != pay.TradeStatusSuccess {
+		t.Errorf("notice %+v", r)
+	}
+
+	v := s.Notify("20190701000001", 101, "00")
+	v.Set("txnAmt", "1")
+	if _, err := p.Verify(v); err != pay.ErrVerify {
+		t.Errorf("tampered notice: got %v, want ErrVerify", err)
+	}
+}
+
+func TestVerifyUntrustedCert(t *testing.T) {
+	p, _ := newTestUnionpay(t)
+
+	// 另一个桩网关的证书不在根证书链中
+	other, err := unionpaytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	if _, err := p.Verify(other.Notify("20190701000001", 101, "00")); err == nil {
+		t.Error("notice signed by an untrusted cert verified")
+	}
+}
+
+func TestClient(t *testing.T) {
+	p, _ := newTestUnionpay(t)
+	if p.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", p.opt.Client.Timeout, kTimeout)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	p.opt.GatewayURL = s.URL
+	p.opt.Client = &http.Client{Timeout: 10 * time.Millisecond}
+
+	_, err := p.Call(pay.WayApp, pay.Order{ID: "O1", Title: "test", Amount: 101})
+	if e, ok := err.(*pay.Error); !ok || !e.Retryable || e.Category != pay.CategorySystem {
+		t.Errorf("got %v, want a retryable network error", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Package unionpaytest 本地银联网关桩，用于不连银联测试环境的联调测试
+//
+// 桩网关自签根证书、中级证书和银联签名证书，unionpay.Options 的 RootCertFile MiddleCertFile
+// 使用 Server 生成的证书文件，GatewayURL 使用 Server.URL。桩网关不验证商户签名，Requests 返回收到的请求用于验证
+package unionpaytest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 本地银联网关桩
+type Server struct {
+	*httptest.Server
+
+	RootCertFile   string // 根证书路径
+	MiddleCertFile string // 中级证书路径
+	RespCode       string // appTransReq 返回的应答码，默认 00
+	RespMsg        string // RespCode 不为 00 时返回的应答信息
+
+	dir     string
+	key     *rsa.PrivateKey
+	certPEM string
+
+	mu       sync.Mutex
+	requests []url.Values
+}
+
+// NewServer 启动桩网关
+func NewServer() (*Server, error) {
+	dir, err := ioutil.TempDir("", "unionpaytest")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{dir: dir, RespCode: "00"}
+	if err := s.issueCerts(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gateway/api/frontTransReq.do", s.handleFront)
+	mux.HandleFunc("/gateway/api/appTransReq.do", s.handleApp)
+	s.Server = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// Close 关闭桩网关并删除证书文件
+func (s *Server) Close() {
+	s.Server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// Requests 收到的请求参数
+func (s *Server) Requests() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]url.Values(nil), s.requests...)
+}
+
+// Notify 生成银联签名的异步回调参数
+func (s *Server) Notify(orderID string, amount int32, respCode string) url.Values {
+	var v = url.Values{}
+	v.Set("version", "5.1.0")
+	v.Set("encoding", "UTF-8")
+	v.Set("txnType", "01")
+	v.Set("txnSubType", "01")
+	v.Set("bizType", "000201")
+	v.Set("accessType", "0")
+	v.Set("orderId", orderID)
+	v.Set("queryId", strconv.FormatInt(time.Now().UnixNano(), 10))
+	v.Set("txnAmt", strconv.Itoa(int(amount)))
+	v.Set("currencyCode", "156")
+	v.Set("respCode", respCode)
+	v.Set("respMsg", "success")
+
+	return s.sign(v)
+}
+
+func (s *Server) handleFront(w http.ResponseWriter, r *http.Request) {
+	if s.record(r) == nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) handleApp(w http.ResponseWriter, r *http.Request) {
+	req := s.record(r)
+	if req == nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var v = url.Values{}
+	for _, k := range []string{"version", "encoding", "txnType", "txnSubType", "bizType", "accessType", "merId", "orderId", "txnTime"} {
+		v.Set(k, req.Get(k))
+	}
+
+	s.mu.Lock()
+	code, msg := s.RespCode, s.RespMsg
+	s.mu.Unlock()
+
+	if code == "00" {
+		v.Set("tn", strconv.FormatInt(time.Now().UnixNano(), 10))
+		msg = "success"
+	}
+	v.Set("respCode", code)
+	v.Set("respMsg", msg)
+	v = s.sign(v)
+
+	var pList = make([]string, 0, len(v))
+	for k := range v {
+		pList = append(pList, k+"="+v.Get(k))
+	}
+	sort.Strings(pList)
+
+	w.Write([]byte(strings.Join(pList, "&")))
+}
+
+func (s *Server) record(r *http.Request) url.Values {
+	if err := r.ParseForm(); err != nil || len(r.PostForm.Get("signature")) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.PostForm)
+	s.mu.Unlock()
+
+	return r.PostForm
+}
+
+// sign 使用银联签名证书签名
+func (s *Server) sign(v url.Values) url.Values {
+	v.Set("signMethod", "01")
+	v.Set("signPubKeyCert", s.certPEM)
+
+	var pList = make([]string, 0, len(v))
+	for k := range v {
+		pList = append(pList, k+"="+v.Get(k))
+	}
+	sort.Strings(pList)
+
+	digest := sha256.Sum256([]byte(strings.Join(pList, "&")))
+	hashed := sha256.Sum256([]byte(hex.EncodeToString(digest[:])))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+
+	v.Set("signature", base64.StdEncoding.EncodeToString(sig))
+	return v
+}
+
+// issueCerts 签发根证书 -> 中级证书 -> 银联签名证书
+func (s *Server) issueCerts() error {
+	rootKey, root, err := issue(nil, nil, "unionpaytest root", true)
+	if err != nil {
+		return err
+	}
+
+	middleKey, middle, err := issue(root, rootKey, "unionpaytest middle", true)
+	if err != nil {
+		return err
+	}
+
+	key, cert, err := issue(middle, middleKey, "CFCA@中国银联股份有限公司@00040000:SIGN@1", false)
+	if err != nil {
+		return err
+	}
+
+	s.RootCertFile = filepath.Join(s.dir, "root.cer")
+	if err := ioutil.WriteFile(s.RootCertFile, encode(root), 0600); err != nil {
+		return err
+	}
+
+	s.MiddleCertFile = filepath.Join(s.dir, "middle.cer")
+	if err := ioutil.WriteFile(s.MiddleCertFile, encode(middle), 0600); err != nil {
+		return err
+	}
+
+	s.key = key
+	s.certPEM = string(encode(cert))
+
+	return nil
+}
+
+// issue 签发证书，parent 为空时自签
+func issue(parent *x509.Certificate, parentKey *rsa.PrivateKey, cn string, isCA bool) (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	if parent == nil {
+		parent, parentKey = tpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+func encode(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
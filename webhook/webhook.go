@@ -0,0 +1,297 @@
+// Package webhook 以统一格式通知下游服务支付结果，与支付平台无关
+// 事件 json 使用 HMAC-SHA256 签名，签名内容为 timestamp + "." + body
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/store"
+)
+
+// 请求头
+const (
+	HeaderEventID   = "X-Pay-Event-Id"
+	HeaderEventType = "X-Pay-Event-Type"
+	HeaderTimestamp = "X-Pay-Timestamp"
+	HeaderSignature = "X-Pay-Signature" // v1=hex(HMAC-SHA256(secret, timestamp + "." + body))
+
+	kSignatureVersion = "v1="
+	kTolerance        = 5 * time.Minute
+	kTimeout          = 10 * time.Second
+)
+
+// 事件类型
+const (
+	PaymentPending           = "payment.pending"
+	PaymentSucceeded         = "payment.succeeded"
+	PaymentClosed            = "payment.closed"
+	PaymentFinished          = "payment.finished"
+	PaymentPartiallyRefunded = "payment.partially_refunded"
+	PaymentRefunded          = "payment.refunded"
+	RefundProcessing         = "refund.processing"
+	RefundSucceeded          = "refund.succeeded"
+	RefundFailed             = "refund.failed"
+)
+
+var (
+	// ErrSignature 签名错误
+	ErrSignature = errors.New("webhook: invalid signature")
+	// ErrTimestamp 时间戳超出允许范围，可能是重放的请求
+	ErrTimestamp = errors.New("webhook: timestamp out of tolerance")
+)
+
+// Event 下游服务收到的事件
+type Event struct {
+	ID      string `json:"id"` // 同一事件重复投递时 ID 不变，接收方按 ID 去重
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    Data   `json:"data"`
+}
+
+// Data 事件内容
+type Data struct {
+	OrderID   string `json:"order_id"`
+	Provider  string `json:"provider"`
+	PaymentID string `json:"payment_id,omitempty"`
+	Amount    int32  `json:"amount"`             // 订单金额或退款金额
+	Refunded  int32  `json:"refunded,omitempty"` // 订单累计退款金额
+	RefundID  string `json:"refund_id,omitempty"`
+	State     string `json:"state,omitempty"` // 订单状态 pay.OrderState.String()
+}
+
+// Options Options
+type Options struct {
+	URL    string
+	Secret string
+	Types  []string     // 投递的事件类型，为空时投递所有事件
+	Client *http.Client // 为空时使用超时 10s 的 http.Client
+
+	Backoff     []time.Duration                 // 重试间隔，默认 store.DefaultRelayBackoff
+	MaxAttempts int                             // 最大投递次数，超过后进入死信，默认 10
+	DeadLetter  func(e *store.Event, err error) // 进入死信时调用
+}
+
+// Dispatcher 将 store 的 outbox 事件转为统一格式签名后投递，实现 store.Publisher
+type Dispatcher struct {
+	opt Options
+}
+
+var _ store.Publisher = &Dispatcher{}
+
+const kMaxAttempts = 10
+
+// New New
+func New(opt Options) *Dispatcher {
+	if opt.MaxAttempts <= 0 {
+		opt.MaxAttempts = kMaxAttempts
+	}
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: kTimeout}
+	}
+
+	return &Dispatcher{
+		opt: opt,
+	}
+}
+
+// Relay 投递 outbox 事件的 store.Relay，失败按 Backoff 重试，超过 MaxAttempts 进入死信
+func (d *Dispatcher) Relay(o store.Outbox, opt store.RelayOptions) *store.Relay {
+	opt.Backoff = d.opt.Backoff
+	opt.MaxAttempts = d.opt.MaxAttempts
+	opt.DeadLetter = d.opt.DeadLetter
+
+	return store.NewRelay(o, d, opt)
+}
+
+// Publish 转为统一格式后投递，不需要投递的事件返回 nil
+func (d *Dispatcher) Publish(e *store.Event) error {
+	evt, err := FromStoreEvent(e)
+	if err != nil {
+		return err
+	}
+
+	if evt == nil || !d.accept(evt.Type) {
+		return nil
+	}
+
+	return d.Send(evt)
+}
+
+// Send 签名并投递事件，2xx 为投递成功
+func (d *Dispatcher) Send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now().Unix()
+
+	req, err := http.NewRequest("POST", d.opt.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, e.ID)
+	req.Header.Set(HeaderEventType, e.Type)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, kSignatureVersion+Sign(d.opt.Secret, ts, body))
+
+	resp, err := d.opt.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("webhook: %s: %s", resp.Status, b)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) accept(typ string) bool {
+	if len(d.opt.Types) == 0 {
+		return true
+	}
+
+	for _, t := range d.opt.Types {
+		if t == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromStoreEvent outbox 事件转为统一格式，没有对应类型时返回 nil
+func FromStoreEvent(e *store.Event) (*Event, error) {
+	var evt = &Event{
+		ID:      strconv.FormatInt(e.ID, 10),
+		Created: e.CreatedAt.Unix(),
+	}
+
+	switch e.Type {
+	case store.EventOrderState:
+		var oe store.OrderEvent
+		if err := json.Unmarshal(e.Payload, &oe); err != nil {
+			return nil, err
+		}
+
+		evt.Type = orderEventTypes[oe.To]
+		evt.Data = Data{
+			OrderID:   oe.OrderID,
+			Provider:  oe.Provider,
+			PaymentID: oe.PaymentID,
+			Amount:    oe.Amount,
+			Refunded:  oe.Refunded,
+			RefundID:  oe.RefundID,
+			State:     oe.To,
+		}
+	case store.EventRefund:
+		var re store.RefundEvent
+		if err := json.Unmarshal(e.Payload, &re); err != nil {
+			return nil, err
+		}
+
+		evt.Type = refundEventTypes[pay.RefundStatus(re.Status)]
+		evt.Data = Data{
+			OrderID:  re.OrderID,
+			Provider: re.Provider,
+			Amount:   re.Amount,
+			RefundID: re.ID,
+		}
+	}
+
+	if len(evt.Type) == 0 {
+		return nil, nil
+	}
+
+	return evt, nil
+}
+
+var orderEventTypes = map[string]string{
+	pay.OrderStatePending.String():           PaymentPending,
+	pay.OrderStatePaid.String():              PaymentSucceeded,
+	pay.OrderStateClosed.String():            PaymentClosed,
+	pay.OrderStateFinished.String():          PaymentFinished,
+	pay.OrderStatePartiallyRefunded.String(): PaymentPartiallyRefunded,
+	pay.OrderStateRefunded.String():          PaymentRefunded,
+}
+
+var refundEventTypes = map[pay.RefundStatus]string{
+	pay.RefundStatusProcessing: RefundProcessing,
+	pay.RefundStatusSuccess:    RefundSucceeded,
+	pay.RefundStatusFailed:     RefundFailed,
+}
+
+// Sign hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 接收方验证签名和时间戳，tolerance 为0时使用 5m
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	if tolerance <= 0 {
+		tolerance = kTolerance
+	}
+
+	ts, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrTimestamp
+	}
+
+	if d := time.Since(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrTimestamp
+	}
+
+	sig := header.Get(HeaderSignature)
+	if !strings.HasPrefix(sig, kSignatureVersion) {
+		return ErrSignature
+	}
+
+	if !hmac.Equal([]byte(strings.TrimPrefix(sig, kSignatureVersion)), []byte(Sign(secret, ts, body))) {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// ParseRequest 接收方读取请求、验证签名并解析事件
+func ParseRequest(r *http.Request, secret string, tolerance time.Duration) (*Event, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Verify(secret, r.Header, body, tolerance); err != nil {
+		return nil, err
+	}
+
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
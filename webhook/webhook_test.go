@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/store"
+)
+
+const testSecret = "whsec_test"
+
+// signedHeader 按 ts 签名的请求头
+func signedHeader(ts int64, body []byte) http.Header {
+	h := http.Header{}
+	h.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	h.Set(HeaderSignature, kSignatureVersion+Sign(testSecret, ts, body))
+	return h
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		body = []byte(`{"id":"1","type":"payment.succeeded"}`)
+		now  = time.Now().Unix()
+	)
+
+	if err := Verify(testSecret, signedHeader(now, body), body, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	noPrefix := signedHeader(now, body)
+	noPrefix.Set(HeaderSignature, Sign(testSecret, now, body))
+
+	var cases = []struct {
+		name   string
+		header http.Header
+		body   []byte
+		want   error
+	}{
+		{"missing v1= prefix", noPrefix, body, ErrSignature},
+		{"tampered body", signedHeader(now, body), []byte(`{"id":"1","type":"payment.refunded"}`), ErrSignature},
+		{"wrong secret", func() http.Header {
+			h := signedHeader(now, body)
+			h.Set(HeaderSignature, kSignatureVersion+Sign("other", now, body))
+			return h
+		}(), body, ErrSignature},
+		{"expired", signedHeader(now-int64(kTolerance/time.Second)-10, body), body, ErrTimestamp},
+		{"future", signedHeader(now+int64(kTolerance/time.Second)+10, body), body, ErrTimestamp},
+		{"missing timestamp", http.Header{}, body, ErrTimestamp},
+	}
+
+	for _, c := range cases {
+		if err := Verify(testSecret, c.header, c.body, 0); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// 时间戳在 tolerance 内
+	old := now - 50
+	if err := Verify(testSecret, signedHeader(old, body), body, time.Minute); err != nil {
+		t.Errorf("within tolerance: %v", err)
+	}
+	if err := Verify(testSecret, signedHeader(old, body), body, 10*time.Second); err != ErrTimestamp {
+		t.Errorf("outside tolerance: got %v, want %v", err, ErrTimestamp)
+	}
+}
+
+// storeEvent outbox 事件
+func storeEvent(t *testing.T, id int64, typ string, payload interface{}) *store.Event {
+	t.Helper()
+
+	e, err := store.NewEvent(typ, "O1", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ID = id
+	e.CreatedAt = time.Unix(1560000000, 0)
+	return e
+}
+
+func TestFromStoreEvent(t *testing.T) {
+	var cases = []struct {
+		event *store.Event
+		want  string
+	}{
+		{storeEvent(t, 1, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStatePending.String()}), PaymentPending},
+		{storeEvent(t, 2, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStatePaid.String(), Amount: 100}), PaymentSucceeded},
+		{storeEvent(t, 3, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStateClosed.String()}), PaymentClosed},
+		{storeEvent(t, 4, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStatePartiallyRefunded.String()}), PaymentPartiallyRefunded},
+		{storeEvent(t, 5, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStateRefunded.String()}), PaymentRefunded},
+		{storeEvent(t, 6, store.EventRefund, store.RefundEvent{ID: "R1", OrderID: "O1", Status: int(pay.RefundStatusProcessing)}), RefundProcessing},
+		{storeEvent(t, 7, store.EventRefund, store.RefundEvent{ID: "R1", OrderID: "O1", Status: int(pay.RefundStatusSuccess)}), RefundSucceeded},
+		{storeEvent(t, 8, store.EventRefund, store.RefundEvent{ID: "R1", OrderID: "O1", Status: int(pay.RefundStatusFailed)}), RefundFailed},
+	}
+
+	for _, c := range cases {
+		evt, err := FromStoreEvent(c.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if evt == nil || evt.Type != c.want {
+			t.Errorf("event %d: got %+v, want %s", c.event.ID, evt, c.want)
+			continue
+		}
+		if evt.ID != strconv.FormatInt(c.event.ID, 10) || evt.Created != 1560000000 || evt.Data.OrderID != "O1" {
+			t.Errorf("event %d: %+v", c.event.ID, evt)
+		}
+	}
+
+	// 没有对应类型的事件
+	for _, e := range []*store.Event{
+		storeEvent(t, 9, store.EventNotice, store.NoticeReceivedEvent{OrderID: "O1"}),
+		storeEvent(t, 10, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStateCreated.String()}),
+		storeEvent(t, 11, "unknown", struct{}{}),
+	} {
+		if evt, err := FromStoreEvent(e); err != nil || evt != nil {
+			t.Errorf("event %s: got %+v %v, want nil", e.Type, evt, err)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var (
+		received []*Event
+		status   = http.StatusOK
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := ParseRequest(r, testSecret, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(HeaderEventID) != e.ID || r.Header.Get(HeaderEventType) != e.Type {
+			http.Error(w, "header mismatch", http.StatusBadRequest)
+			return
+		}
+		received = append(received, e)
+		w.WriteHeader(status)
+	}))
+	defer s.Close()
+
+	d := New(Options{URL: s.URL, Secret: testSecret, Types: []string{PaymentSucceeded}})
+
+	paid := storeEvent(t, 1, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStatePaid.String(), Amount: 100})
+	if err := d.Publish(paid); err != nil {
+		t.Fatal(err)
+	}
+
+	// Types 之外的事件不投递
+	closed := storeEvent(t, 2, store.EventOrderState, store.OrderEvent{OrderID: "O1", To: pay.OrderStateClosed.String()})
+	if err := d.Publish(closed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 1 || received[0].Type != PaymentSucceeded || received[0].Data.Amount != 100 {
+		t.Fatalf("received %+v", received)
+	}
+
+	// 非 2xx 为投递失败
+	status = http.StatusInternalServerError
+	if err := d.Publish(paid); err == nil {
+		t.Error("5xx treated as delivered")
+	}
+
+	// 签名错误时接收方返回 401
+	d = New(Options{URL: s.URL, Secret: "other"})
+	if err := d.Publish(paid); err == nil {
+		t.Error("401 treated as delivered")
+	}
+}
+
+func TestDefaultClientTimeout(t *testing.T) {
+	if d := New(Options{}); d.opt.Client.Timeout != kTimeout {
+		t.Errorf("timeout %v, want %v", d.opt.Client.Timeout, kTimeout)
+	}
+}
@@ -0,0 +1,149 @@
+package wxpay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var (
+	// ErrNotSandbox 验收用例只能在仿真测试系统执行
+	ErrNotSandbox = errors.New("wxpay: acceptance must run in sandbox")
+)
+
+// AcceptanceCase 仿真测试验收用例，沙箱按订单金额返回对应用例的结果
+// https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=23_13
+type AcceptanceCase struct {
+	Name   string
+	Amount int32 // 订单金额，分
+	Refund int32 // 退款金额，分，为0时不申请退款
+}
+
+// AcceptanceCases 普通支付验收用例，按顺序执行
+var AcceptanceCases = []AcceptanceCase{
+	{Name: "1.01 订单支付", Amount: 101},
+	{Name: "1.02 订单退款", Amount: 102, Refund: 102},
+}
+
+// AcceptanceError 失败的验收用例
+type AcceptanceError struct {
+	Failed []AcceptanceResult
+}
+
+// Error Error
+func (e *AcceptanceError) Error() string {
+	var list = make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		list = append(list, fmt.Sprintf("%s(%s): %v", r.Case.Name, r.OrderID, r.Err))
+	}
+	return "wxpay: acceptance failed: " + strings.Join(list, "; ")
+}
+
+// AcceptanceResult 验收用例执行结果
+type AcceptanceResult struct {
+	Case     AcceptanceCase
+	OrderID  string
+	RefundID string
+	Err      error
+}
+
+// Acceptance 在 Options.APIDomain 配置的仿真测试系统按顺序执行验收用例
+// 每个用例: 统一下单(NATIVE) -> 查询订单 -> 申请退款 -> 查询退款
+// cases 为空时使用 AcceptanceCases，某个用例失败时继续执行后续用例，
+// 执行完后有失败的用例时返回全部结果和 *AcceptanceError
+// 命令行执行见 cmd/wxpayacceptance
+func (p *Wxpay) Acceptance(cases ...AcceptanceCase) ([]AcceptanceResult, error) {
+	if p.Opt.IsProduction {
+		return nil, ErrNotSandbox
+	}
+
+	if len(cases) == 0 {
+		cases = AcceptanceCases
+	}
+
+	var (
+		prefix  = time.Now().Format("20060102150405")
+		results = make([]AcceptanceResult, 0, len(cases))
+	)
+	for i, c := range cases {
+		var r = AcceptanceResult{
+			Case:    c,
+			OrderID: fmt.Sprintf("%s%02d", prefix, i+1),
+		}
+		if c.Refund > 0 {
+			r.RefundID = "R" + r.OrderID
+		}
+
+		r.Err = p.runCase(r)
+		results = append(results, r)
+	}
+
+	var failed []AcceptanceResult
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 {
+		return results, &AcceptanceError{Failed: failed}
+	}
+
+	return results, nil
+}
+
+// runCase 执行单个验收用例
+func (p *Wxpay) runCase(r AcceptanceResult) error {
+	_, err := p.qrcodeCall(pay.Order{
+		ID:     r.OrderID,
+		Title:  r.Case.Name,
+		Amount: r.Case.Amount,
+		IP:     "127.0.0.1",
+	})
+	if err != nil {
+		return fmt.Errorf("unifiedorder: %v", err)
+	}
+
+	rsp, err := p.orderQuery(r.OrderID)
+	if err != nil {
+		return fmt.Errorf("orderquery: %v", err)
+	}
+
+	if s := rsp.Get("trade_state"); s != "SUCCESS" {
+		return fmt.Errorf("orderquery: trade_state %s", s)
+	}
+
+	if r.Case.Refund == 0 {
+		return nil
+	}
+
+	_, err = p.Refund(pay.RefundOrder{
+		ID:          r.RefundID,
+		OrderID:     r.OrderID,
+		PaymentID:   rsp.Get("transaction_id"),
+		TotalAmount: r.Case.Amount,
+		Amount:      r.Case.Refund,
+		Reason:      r.Case.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("refund: %v", err)
+	}
+
+	rsp, err = p.refundQuery(r.RefundID)
+	if err != nil {
+		return fmt.Errorf("refundquery: %v", err)
+	}
+
+	if s := rsp.Get("refund_status_0"); s != "SUCCESS" && s != "PROCESSING" {
+		return fmt.Errorf("refundquery: refund_status %s", s)
+	}
+
+	if fee := rsp.Get("refund_fee"); fee != strconv.Itoa(int(r.Case.Refund)) {
+		return fmt.Errorf("refundquery: refund_fee %s", fee)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package wxpay
+
+import (
+	"testing"
+)
+
+func TestAcceptance(t *testing.T) {
+	p, s := newTestWxpay(t)
+	s.AutoPay = true
+
+	results, err := p.Acceptance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(AcceptanceCases) {
+		t.Fatalf("%d results, want %d", len(results), len(AcceptanceCases))
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Case.Name, r.Err)
+		}
+	}
+	if n := s.Refunds(results[1].OrderID); n != 1 {
+		t.Errorf("%d refunds, want 1", n)
+	}
+}
+
+func TestAcceptanceFailed(t *testing.T) {
+	p, _ := newTestWxpay(t)
+
+	// 订单未支付，查询订单失败
+	results, err := p.Acceptance()
+
+	e, ok := err.(*AcceptanceError)
+	if !ok {
+		t.Fatalf("got %v, want *AcceptanceError", err)
+	}
+	if len(results) != len(AcceptanceCases) || len(e.Failed) != len(AcceptanceCases) {
+		t.Errorf("%d results %d failed, want %d", len(results), len(e.Failed), len(AcceptanceCases))
+	}
+}
+
+func TestAcceptanceProduction(t *testing.T) {
+	p, _ := newTestWxpay(t)
+	p.Opt.IsProduction = true
+
+	if _, err := p.Acceptance(); err != ErrNotSandbox {
+		t.Errorf("got %v, want %v", err, ErrNotSandbox)
+	}
+}
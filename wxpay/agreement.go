@@ -0,0 +1,169 @@
+package wxpay
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+)
+
+var _ pay.Agreement = &Wxpay{}
+
+// 委托代扣 https://pay.weixin.qq.com/wiki/doc/api/pap.php?chapter=18_1&index=1
+// 签约需要 Options.PlanID
+const (
+	kEntrustWeb     = "/papay/entrustweb"
+	kPreEntrustWeb  = "/papay/preentrustweb"
+	kDeleteContract = "/papay/deletecontract"
+	kPapPayApply    = "/pay/pappayapply"
+
+	kTradeTypePAP = "PAP"
+)
+
+// SignAgreement 委托代扣签约
+// jsapi -> 公众号签约跳转地址
+// app -> APP签约的 pre_entrustweb_id
+// wxxcx -> 小程序跳转签约小程序的 extraData
+func (p *Wxpay) SignAgreement(way pay.Way, in pay.AgreementOrder) (string, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("plan_id", p.Opt.PlanID)
+	v.Set("contract_code", in.ExternalID)
+	v.Set("request_serial", strconv.FormatInt(time.Now().UnixNano(), 10))
+	v.Set("contract_display_account", in.Title)
+	v.Set("notify_url", p.Opt.NotifyURL)
+
+	key, err := p.signKey()
+	if err != nil {
+		return "", err
+	}
+
+	switch way {
+	case pay.WayJSAPI:
+		v.Set("appid", p.Opt.PublicID)
+		v.Set("version", "1.0")
+		v.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		v.Set("sign", wxpay.SignMD5(v, key))
+		return p.api(kEntrustWeb) + "?" + v.Encode(), nil
+	case pay.WayApp:
+		v.Set("appid", p.Opt.APPID)
+		v.Set("version", "1.0")
+		v.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		rsp, err := p.post(kPreEntrustWeb, v, false)
+		if err != nil {
+			return "", err
+		}
+
+		if !isSuccess(rsp) {
+			return "", newError(rsp)
+		}
+
+		return rsp.Get("pre_entrustweb_id"), nil
+	case pay.WayWXXCX:
+		v.Set("appid", p.Opt.MiniAPPID)
+		v.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		v.Set("sign", wxpay.SignMD5(v, key))
+
+		var m = make(map[string]string, len(v))
+		for k := range v {
+			m[k] = v.Get(k)
+		}
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			return "", err
+		}
+
+		return string(b), nil
+	}
+
+	return "", pay.ErrWayNotDefine
+}
+
+// VerifyAgreement 签约、解约回调验证签名，change_type 为 ADD DELETE
+func (p *Wxpay) VerifyAgreement(in url.Values) (*pay.AgreementNotice, error) {
+	key, err := p.signKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := wxpay.VerifyResponseValues(in, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, pay.ErrVerify
+	}
+
+	return AgreementNoticeParams(in), nil
+}
+
+// Unsign 解约
+func (p *Wxpay) Unsign(agreementID string) error {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("contract_id", agreementID)
+	v.Set("contract_termination_remark", "unsign")
+	v.Set("version", "1.0")
+
+	rsp, err := p.post(kDeleteContract, v, false)
+	if err != nil {
+		return err
+	}
+
+	if !isSuccess(rsp) {
+		return newError(rsp)
+	}
+
+	return nil
+}
+
+// Charge 申请扣款，受理成功返回 TradeStatusWait，扣款结果以支付回调为准
+func (p *Wxpay) Charge(agreementID string, in pay.Order) (*pay.NoticeParams, error) {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("body", in.Title)
+	v.Set("out_trade_no", in.ID)
+	v.Set("total_fee", strconv.Itoa(int(in.Amount)))
+	v.Set("spbill_create_ip", in.IP)
+	v.Set("notify_url", p.Opt.NotifyURL)
+	v.Set("trade_type", kTradeTypePAP)
+	v.Set("contract_id", agreementID)
+
+	rsp, err := p.post(kPapPayApply, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     in.ID,
+		TradeStatus: pay.TradeStatusWait,
+		Amount:      in.Amount,
+	}, nil
+}
+
+// AgreementNoticeParams 签约、解约回调参数
+func AgreementNoticeParams(val url.Values) *pay.AgreementNotice {
+	var status = pay.AgreementStatusSigned
+	if val.Get("change_type") == "DELETE" {
+		status = pay.AgreementStatusUnsigned
+	}
+
+	return &pay.AgreementNotice{
+		AgreementID: val.Get("contract_id"),
+		ExternalID:  val.Get("contract_code"),
+		Status:      status,
+		UserID:      val.Get("openid"),
+		Time:        val.Get("operate_time"),
+	}
+}
@@ -0,0 +1,157 @@
+package wxpay
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/wxpay/wxpaytest"
+	"github.com/smartwalle/wxpay"
+)
+
+// newTestPapay 委托代扣使用正式环境，签约回调由桩接口使用 APIKey 签名
+func newTestPapay(t *testing.T) (*Wxpay, *wxpaytest.Server) {
+	s := wxpaytest.NewServer()
+	t.Cleanup(s.Close)
+
+	p := New(Options{
+		APIKey:       s.APIKey,
+		MchID:        s.MchID,
+		PublicID:     "wx2421b1c4370ec43b",
+		APPID:        "wx8888888888888888",
+		MiniAPPID:    "wx6666666666666666",
+		PlanID:       "12535",
+		APIDomain:    s.URL,
+		IsProduction: true,
+	})
+
+	return p, s
+}
+
+func TestSignAgreement(t *testing.T) {
+	p, s := newTestPapay(t)
+	in := pay.AgreementOrder{ExternalID: "C1", Title: "会员自动续费"}
+
+	u, err := p.SignAgreement(pay.WayJSAPI, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, s.URL+kEntrustWeb+"?") {
+		t.Fatalf("url %s", u)
+	}
+	v, err := url.ParseQuery(u[strings.Index(u, "?")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := wxpay.VerifyResponseValues(v, s.APIKey); !ok {
+		t.Errorf("jsapi: verify sign %v", err)
+	}
+	if v.Get("appid") != "wx2421b1c4370ec43b" || v.Get("plan_id") != "12535" || v.Get("contract_code") != "C1" {
+		t.Errorf("jsapi: params %v", v)
+	}
+
+	id, err := p.SignAgreement(pay.WayApp, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := s.LastRequest(wxpaytest.PreEntrustWeb)
+	if len(id) == 0 || req.Get("appid") != "wx8888888888888888" || req.Get("contract_code") != "C1" {
+		t.Errorf("app: pre_entrustweb_id %q, request %v", id, req)
+	}
+
+	extra, err := p.SignAgreement(pay.WayWXXCX, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(extra), &m); err != nil {
+		t.Fatal(err)
+	}
+	v = url.Values{}
+	for k, val := range m {
+		v.Set(k, val)
+	}
+	if ok, err := wxpay.VerifyResponseValues(v, s.APIKey); !ok {
+		t.Errorf("wxxcx: verify sign %v", err)
+	}
+	if v.Get("appid") != "wx6666666666666666" {
+		t.Errorf("wxxcx: params %v", v)
+	}
+
+	if _, err := p.SignAgreement(pay.WayQrcode, in); err != pay.ErrWayNotDefine {
+		t.Errorf("got %v, want %v", err, pay.ErrWayNotDefine)
+	}
+}
+
+func TestVerifyAgreement(t *testing.T) {
+	p, s := newTestPapay(t)
+
+	in := s.SignContract("12535", "C1", "openid")
+	id := in.Get("contract_id")
+	n, err := p.VerifyAgreement(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.AgreementID != id || n.ExternalID != "C1" || n.UserID != "openid" || n.Status != pay.AgreementStatusSigned {
+		t.Errorf("notice %+v", n)
+	}
+
+	in = s.SignContract("12535", "C2", "openid")
+	in.Set("contract_code", "C3")
+	if _, err := p.VerifyAgreement(in); err == nil {
+		t.Error("tampered notice verified")
+	}
+}
+
+func TestUnsign(t *testing.T) {
+	p, s := newTestPapay(t)
+	id := s.SignContract("12535", "C1", "openid").Get("contract_id")
+
+	if err := p.Unsign(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Contract(id); got != "DELETE" {
+		t.Errorf("contract %s, want DELETE", got)
+	}
+
+	err := p.Unsign(id)
+	if e, ok := err.(*pay.Error); !ok || e.SubCode != "CONTRACT_NOT_EXIST" {
+		t.Errorf("got %v, want CONTRACT_NOT_EXIST", err)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	p, s := newTestPapay(t)
+	id := s.SignContract("12535", "C1", "openid").Get("contract_id")
+
+	r, err := p.Charge(id, pay.Order{ID: "O1", Title: "test", Amount: 1999, IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" || r.TradeStatus != pay.TradeStatusWait || r.Amount != 1999 {
+		t.Errorf("result %+v", r)
+	}
+	if req := s.LastRequest(wxpaytest.PapPayApply); req.Get("trade_type") != kTradeTypePAP || req.Get("total_fee") != "1999" {
+		t.Errorf("request %v", req)
+	}
+
+	// 扣款结果以支付回调为准
+	s.Pay("O1")
+	q, err := p.Query("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.TradeStatus != pay.TradeStatusSuccess || q.Amount != 1999 {
+		t.Errorf("query %+v", q)
+	}
+
+	if err := p.Unsign(id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Charge(id, pay.Order{ID: "O2", Title: "test", Amount: 1999})
+	if e, ok := err.(*pay.Error); !ok || e.SubCode != "CONTRACT_NOT_EXIST" {
+		t.Errorf("got %v, want CONTRACT_NOT_EXIST", err)
+	}
+}
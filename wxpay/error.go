@@ -0,0 +1,79 @@
+package wxpay
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+)
+
+// kProvider pay.Error.Provider
+const kProvider = "wxpay"
+
+// kErrCodeCategory 常见 err_code 的分类
+var kErrCodeCategory = map[string]pay.Category{
+	"NOAUTH":                pay.CategoryAuth,
+	"NO_AUTH":               pay.CategoryAuth,
+	"SIGNERROR":             pay.CategoryAuth,
+	"SIGN_ERROR":            pay.CategoryAuth,
+	"APPID_NOT_EXIST":       pay.CategoryAuth,
+	"MCHID_NOT_EXIST":       pay.CategoryAuth,
+	"APPID_MCHID_NOT_MATCH": pay.CategoryAuth,
+	"CA_ERROR":              pay.CategoryAuth,
+	"CERT_ERROR":            pay.CategoryAuth,
+
+	"PARAM_ERROR":           pay.CategoryParam,
+	"LACK_PARAMS":           pay.CategoryParam,
+	"XML_FORMAT_ERROR":      pay.CategoryParam,
+	"REQUIRE_POST_METHOD":   pay.CategoryParam,
+	"POST_DATA_EMPTY":       pay.CategoryParam,
+	"NOT_UTF8":              pay.CategoryParam,
+	"INVALID_TRANSACTIONID": pay.CategoryParam,
+	"ORDERNOTEXIST":         pay.CategoryParam,
+	"REFUNDNOTEXIST":        pay.CategoryParam,
+	"AMOUNT_LIMIT":          pay.CategoryParam,
+
+	"NOTENOUGH":  pay.CategoryBalance,
+	"NOT_ENOUGH": pay.CategoryBalance,
+
+	"ORDERPAID":         pay.CategoryDuplicate,
+	"ORDERCLOSED":       pay.CategoryDuplicate,
+	"OUT_TRADE_NO_USED": pay.CategoryDuplicate,
+	"INVALID_REQUEST":   pay.CategoryDuplicate, // 201 商户订单号重复
+
+	"SYSTEMERROR":       pay.CategorySystem,
+	"BIZERR_NEED_RETRY": pay.CategorySystem,
+	"FREQUENCY_LIMITED": pay.CategorySystem,
+}
+
+// newError 接口返回的错误，return_code 为 FAIL 时使用 return_msg，否则使用 err_code err_code_des
+func newError(rsp url.Values) *pay.Error {
+	if code := rsp.Get("return_code"); code == wxpay.K_RETURN_CODE_FAIL {
+		var (
+			msg      = rsp.Get("return_msg")
+			category = pay.CategoryParam
+		)
+		if strings.Contains(msg, "签名") {
+			category = pay.CategoryAuth
+		}
+
+		return &pay.Error{
+			Provider: kProvider,
+			Code:     code,
+			Message:  msg,
+			Category: category,
+		}
+	}
+
+	code := rsp.Get("err_code")
+
+	return &pay.Error{
+		Provider:  kProvider,
+		Code:      rsp.Get("result_code"),
+		SubCode:   code,
+		Message:   rsp.Get("err_code_des"),
+		Category:  kErrCodeCategory[code],
+		Retryable: kRetryErrCode[code],
+	}
+}
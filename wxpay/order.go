@@ -0,0 +1,176 @@
+package wxpay
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+)
+
+var _ pay.Querier = &Wxpay{}
+
+const (
+	kUnifiedOrder = "/pay/unifiedorder"
+	kOrderQuery   = "/pay/orderquery"
+	kCloseOrder   = "/pay/closeorder"
+)
+
+// unifiedOrderParam 统一下单参数，增加分账标识
+type unifiedOrderParam struct {
+	wxpay.UnifiedOrderParam
+	ProfitSharing bool // 是否需要分账
+}
+
+// Params Params
+func (p unifiedOrderParam) Params() url.Values {
+	var m = p.UnifiedOrderParam.Params()
+	if p.ProfitSharing {
+		m.Set("profit_sharing", "Y")
+	}
+	return m
+}
+
+// unifiedOrder 统一下单，按交易类型生成Payinfo
+// native:二维码地址，mweb:支付跳转连接，app,jsapi:调起支付需要的参数url.Values.Encode()
+func (p *Wxpay) unifiedOrder(param wxpay.UnifiedOrderParam, profitSharing bool) (*wxpay.UnifiedOrderRsp, error) {
+	var v = unifiedOrderParam{
+		UnifiedOrderParam: param,
+		ProfitSharing:     profitSharing,
+	}.Params()
+	if len(v.Get("appid")) == 0 {
+		v.Set("appid", p.Opt.PublicID)
+	}
+	v.Set("mch_id", p.Opt.MchID)
+
+	vals, err := p.post(kUnifiedOrder, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(vals) {
+		return nil, newError(vals)
+	}
+
+	key, err := p.signKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var rsp = &wxpay.UnifiedOrderRsp{
+		ReturnCode: vals.Get("return_code"),
+		ReturnMsg:  vals.Get("return_msg"),
+		AppID:      vals.Get("appid"),
+		MCHID:      vals.Get("mch_id"),
+		NonceStr:   vals.Get("nonce_str"),
+		Sign:       vals.Get("sign"),
+		ResultCode: vals.Get("result_code"),
+		PrepayID:   vals.Get("prepay_id"),
+		TradeType:  vals.Get("trade_type"),
+		CodeURL:    vals.Get("code_url"),
+		MWebURL:    vals.Get("mweb_url"),
+	}
+
+	switch param.TradeType {
+	case wxpay.K_TRADE_TYPE_NATIVE:
+		rsp.Payinfo = rsp.CodeURL
+	case wxpay.K_TRADE_TYPE_MWEB:
+		rsp.Payinfo = rsp.MWebURL
+	case wxpay.K_TRADE_TYPE_APP:
+		var u = url.Values{}
+		u.Set("appid", param.AppID)
+		u.Set("noncestr", wxpay.GetNonceStr())
+		u.Set("partnerid", p.Opt.MchID)
+		u.Set("prepayid", rsp.PrepayID)
+		u.Set("package", "Sign=WXPay")
+		u.Set("timestamp", fmt.Sprintf("%d", time.Now().Unix()))
+		u.Set("sign", wxpay.SignMD5(u, key))
+		rsp.Payinfo = u.Encode()
+	case wxpay.K_TRADE_TYPE_JSAPI:
+		var u = url.Values{}
+		u.Set("appId", param.AppID)
+		u.Set("nonceStr", wxpay.GetNonceStr())
+		u.Set("package", fmt.Sprintf("prepay_id=%s", rsp.PrepayID))
+		u.Set("signType", kSignTypeMD5)
+		u.Set("timeStamp", fmt.Sprintf("%d", time.Now().Unix()))
+		u.Set("paySign", wxpay.SignMD5(u, key))
+		rsp.Payinfo = u.Encode()
+	}
+
+	return rsp, nil
+}
+
+// orderQuery 查询订单 https://pay.weixin.qq.com/wiki/doc/api/native.php?chapter=9_2
+// 订单不存在时返回 pay.ErrOrderNotExist
+func (p *Wxpay) orderQuery(orderID string) (url.Values, error) {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("out_trade_no", orderID)
+
+	rsp, err := p.post(kOrderQuery, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		if rsp.Get("err_code") == "ORDERNOTEXIST" {
+			return nil, pay.ErrOrderNotExist
+		}
+		return nil, newError(rsp)
+	}
+
+	return rsp, nil
+}
+
+// Query 查询订单，trade_state 转为支付状态
+func (p *Wxpay) Query(orderID string) (*pay.NoticeParams, error) {
+	rsp, err := p.orderQuery(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var status = pay.TradeStatusWait
+	switch rsp.Get("trade_state") {
+	case "SUCCESS", "REFUND":
+		status = pay.TradeStatusSuccess
+	case "CLOSED", "REVOKED", "PAYERROR":
+		status = pay.TradeStatusClosed
+	}
+
+	amount, _ := strconv.Atoi(rsp.Get("total_fee"))
+
+	return &pay.NoticeParams{
+		OrderID:     rsp.Get("out_trade_no"),
+		PaymentID:   rsp.Get("transaction_id"),
+		TradeStatus: status,
+		Amount:      int32(amount),
+	}, nil
+}
+
+// Close 关闭订单 https://pay.weixin.qq.com/wiki/doc/api/native.php?chapter=9_3
+// 订单已关闭或不存在时返回 nil
+func (p *Wxpay) Close(orderID string) error {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("out_trade_no", orderID)
+
+	rsp, err := p.post(kCloseOrder, v, false)
+	if err != nil {
+		return err
+	}
+
+	if isSuccess(rsp) {
+		return nil
+	}
+
+	switch rsp.Get("err_code") {
+	case "ORDERCLOSED", "ORDERNOTEXIST":
+		return nil
+	}
+
+	return newError(rsp)
+}
@@ -0,0 +1,290 @@
+package wxpay
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Payout = &Wxpay{}
+
+const (
+	kTransfers       = "/mmpaymkttransfers/promotion/transfers"
+	kGetTransferInfo = "/mmpaymkttransfers/gettransferinfo"
+	kPayBank         = "/mmpaysptrans/pay_bank"
+	kQueryBank       = "/mmpaysptrans/query_bank"
+	kGetPublicKey    = "https://fraud.mch.weixin.qq.com/risk/getpublickey"
+)
+
+// payoutRetryTimes 付款结果不确定时的最大请求次数
+const payoutRetryTimes = 3
+
+var (
+	// ErrInvalidPublicKey getpublickey 返回的公钥无法解析
+	ErrInvalidPublicKey = errors.New("wxpay: invalid rsa public key")
+	// ErrBankPayeeRequired 付款到银行卡缺少卡号、姓名或开户行编号
+	ErrBankPayeeRequired = errors.New("wxpay: payee, payee name and bank code required")
+)
+
+// Transfer 企业付款到零钱或银行卡，结果不确定时按 Options.Retry 的间隔使用相同的partner_trade_no重试
+// 多次请求结果仍不确定时以查询结果为准，查询失败时返回最后一次请求的错误，不可重试的错误直接返回
+func (p *Wxpay) Transfer(in pay.PayoutOrder) (*pay.PayoutResult, error) {
+	switch in.PayeeType {
+	case pay.PayeeAccount, "":
+		return p.transferToBalance(in)
+	case pay.PayeeBankCard:
+		return p.transferToBank(in)
+	}
+
+	return nil, pay.ErrPayeeTypeNotDefine
+}
+
+// transferToBalance 企业付款到零钱 https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay.php?chapter=14_2
+func (p *Wxpay) transferToBalance(in pay.PayoutOrder) (*pay.PayoutResult, error) {
+	appID := p.Opt.PayoutAPPID
+	if len(appID) == 0 {
+		appID = p.Opt.PublicID
+	}
+
+	var v = url.Values{}
+	v.Set("mch_appid", appID)
+	v.Set("mchid", p.Opt.MchID)
+	v.Set("partner_trade_no", in.OutBizNo)
+	v.Set("openid", in.Payee)
+	if len(in.PayeeName) > 0 {
+		v.Set("check_name", "FORCE_CHECK")
+		v.Set("re_user_name", in.PayeeName)
+	} else {
+		v.Set("check_name", "NO_CHECK")
+	}
+	v.Set("amount", strconv.Itoa(int(in.Amount)))
+	v.Set("desc", in.Remark)
+	v.Set("spbill_create_ip", in.IP)
+
+	return p.payout(kTransfers, v, func(rsp url.Values) *pay.PayoutResult {
+		return &pay.PayoutResult{
+			OutBizNo:  in.OutBizNo,
+			PaymentID: rsp.Get("payment_no"),
+			Status:    pay.PayoutStatusSuccess,
+			PayDate:   rsp.Get("payment_time"),
+		}
+	})
+}
+
+// transferToBank 企业付款到银行卡 https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay.php?chapter=24_2
+// 受理成功后银行处理需要时间，返回处理中状态
+func (p *Wxpay) transferToBank(in pay.PayoutOrder) (*pay.PayoutResult, error) {
+	if len(in.Payee) == 0 || len(in.PayeeName) == 0 || len(in.BankCode) == 0 {
+		return nil, ErrBankPayeeRequired
+	}
+
+	bankNo, err := p.encrypt(in.Payee)
+	if err != nil {
+		return nil, err
+	}
+
+	trueName, err := p.encrypt(in.PayeeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("partner_trade_no", in.OutBizNo)
+	v.Set("enc_bank_no", bankNo)
+	v.Set("enc_true_name", trueName)
+	v.Set("bank_code", in.BankCode)
+	v.Set("amount", strconv.Itoa(int(in.Amount)))
+	v.Set("desc", in.Remark)
+
+	return p.payout(kPayBank, v, func(rsp url.Values) *pay.PayoutResult {
+		return &pay.PayoutResult{
+			OutBizNo:  in.OutBizNo,
+			PaymentID: rsp.Get("payment_no"),
+			Status:    pay.PayoutStatusProcessing,
+		}
+	})
+}
+
+// payout 请求付款接口，成功时返回 success(rsp)，业务失败时返回失败状态
+// 网络错误、5xx 和 SYSTEMERROR 时按 Options.Retry 的间隔重试，仍不确定时查询，查询失败返回最后一次请求的错误
+func (p *Wxpay) payout(api string, v url.Values, success func(rsp url.Values) *pay.PayoutResult) (*pay.PayoutResult, error) {
+	var (
+		outBizNo = v.Get("partner_trade_no")
+		lastErr  error
+	)
+
+	for i := 0; i < payoutRetryTimes; i++ {
+		if i > 0 {
+			time.Sleep(p.Opt.Retry.Delay(i - 1))
+		}
+
+		rsp, err := p.post(api, v, true)
+		if err != nil {
+			if !pay.IsRetryable(err) {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+
+		if isPayoutUnknown(rsp) {
+			lastErr = newError(rsp)
+			continue
+		}
+
+		if !isSuccess(rsp) {
+			return &pay.PayoutResult{
+				OutBizNo:   outBizNo,
+				Status:     pay.PayoutStatusFailed,
+				FailReason: rsp.Get("err_code_des"),
+			}, nil
+		}
+
+		return success(rsp), nil
+	}
+
+	r, err := p.QueryTransfer(outBizNo)
+	if err != nil {
+		return nil, lastErr
+	}
+
+	return r, nil
+}
+
+// QueryTransfer 查询企业付款，零钱付款查不到时再查询银行卡付款
+func (p *Wxpay) QueryTransfer(outBizNo string) (*pay.PayoutResult, error) {
+	appID := p.Opt.PayoutAPPID
+	if len(appID) == 0 {
+		appID = p.Opt.PublicID
+	}
+
+	var v = url.Values{}
+	v.Set("appid", appID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("partner_trade_no", outBizNo)
+
+	rsp, err := p.post(kGetTransferInfo, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		if rsp.Get("err_code") == "NOT_FOUND" {
+			return p.queryBank(outBizNo)
+		}
+		return nil, newError(rsp)
+	}
+
+	return &pay.PayoutResult{
+		OutBizNo:   outBizNo,
+		PaymentID:  rsp.Get("detail_id"),
+		Status:     payoutStatus(rsp.Get("status")),
+		PayDate:    rsp.Get("payment_time"),
+		FailReason: rsp.Get("reason"),
+	}, nil
+}
+
+// queryBank 查询企业付款到银行卡
+func (p *Wxpay) queryBank(outBizNo string) (*pay.PayoutResult, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("partner_trade_no", outBizNo)
+
+	rsp, err := p.post(kQueryBank, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	return &pay.PayoutResult{
+		OutBizNo:   outBizNo,
+		PaymentID:  rsp.Get("payment_no"),
+		Status:     payoutStatus(rsp.Get("status")),
+		PayDate:    rsp.Get("pay_succ_time"),
+		FailReason: rsp.Get("reason"),
+	}, nil
+}
+
+// encrypt 使用getpublickey获取的公钥加密银行卡号和姓名 RSA_PKCS1_OAEP_PADDING
+func (p *Wxpay) encrypt(s string) (string, error) {
+	pub, err := p.publicKey()
+	if err != nil {
+		return "", err
+	}
+
+	d, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, []byte(s), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(d), nil
+}
+
+// publicKey 获取RSA加密公钥，获取成功后缓存
+func (p *Wxpay) publicKey() (*rsa.PublicKey, error) {
+	p.pubKeyMu.Lock()
+	defer p.pubKeyMu.Unlock()
+
+	if p.pubKey != nil {
+		return p.pubKey, nil
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("sign_type", kSignTypeMD5)
+
+	rsp, err := p.post(kGetPublicKey, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	block, _ := pem.Decode([]byte(rsp.Get("pub_key")))
+	if block == nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	p.pubKey = pub
+
+	return pub, nil
+}
+
+// payoutStatus 付款状态
+// 零钱 SUCCESS FAILED PROCESSING
+// 银行卡 SUCCESS FAILED PROCESSING BANK_FAIL
+func payoutStatus(status string) pay.PayoutStatus {
+	switch status {
+	case "SUCCESS":
+		return pay.PayoutStatusSuccess
+	case "FAILED", "BANK_FAIL":
+		return pay.PayoutStatusFailed
+	}
+
+	return pay.PayoutStatusProcessing
+}
+
+// isPayoutUnknown 付款结果是否不确定，需要使用原partner_trade_no重试
+func isPayoutUnknown(rsp url.Values) bool {
+	return !isSuccess(rsp) && rsp.Get("err_code") == "SYSTEMERROR"
+}
@@ -0,0 +1,147 @@
+package wxpay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/wxpay/wxpaytest"
+)
+
+func newTestWxpay(t *testing.T) (*Wxpay, *wxpaytest.Server) {
+	s := wxpaytest.NewServer()
+	t.Cleanup(s.Close)
+
+	p := New(Options{
+		APIKey:    s.APIKey,
+		MchID:     s.MchID,
+		PublicID:  "wx2421b1c4370ec43b",
+		APIDomain: s.URL,
+		Retry:     &pay.RetryPolicy{Backoff: []time.Duration{time.Millisecond}},
+	})
+
+	return p, s
+}
+
+func TestTransferRetrySystemError(t *testing.T) {
+	p, s := newTestWxpay(t)
+	s.Inject(wxpaytest.Transfers, wxpaytest.FaultSystemErrorAfter, 1)
+	s.Inject(wxpaytest.Transfers, wxpaytest.FaultServerError, 1)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T1", Payee: "openid", Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusSuccess || len(r.PaymentID) == 0 {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(wxpaytest.Transfers); n != 3 {
+		t.Errorf("%d transfer requests, want 3", n)
+	}
+	if n := s.Requests(wxpaytest.TransferInfo); n != 0 {
+		t.Errorf("%d query requests, want 0", n)
+	}
+	if a := s.Payout("T1"); a != "100" {
+		t.Errorf("amount %s", a)
+	}
+}
+
+func TestTransferQueryAfterUnknown(t *testing.T) {
+	p, s := newTestWxpay(t)
+	s.Inject(wxpaytest.Transfers, wxpaytest.FaultSystemErrorAfter, payoutRetryTimes)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T2", Payee: "openid", Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusSuccess || r.OutBizNo != "T2" {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(wxpaytest.TransferInfo); n != 1 {
+		t.Errorf("%d query requests, want 1", n)
+	}
+}
+
+func TestTransferRetryNotNested(t *testing.T) {
+	p, s := newTestWxpay(t)
+	p.Opt.Retry.MaxAttempts = 3
+	s.Inject(wxpaytest.Transfers, wxpaytest.FaultServerError, 10)
+
+	if _, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T6", Payee: "openid", Amount: 100}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// 每次付款请求只发出一次，不与 Options.Retry 嵌套
+	if n := s.Requests(wxpaytest.Transfers); n != payoutRetryTimes {
+		t.Errorf("%d transfer requests, want %d", n, payoutRetryTimes)
+	}
+	if n := s.Requests(wxpaytest.TransferInfo); n != 1 {
+		t.Errorf("%d query requests, want 1", n)
+	}
+}
+
+func TestTransferUnknownNotFound(t *testing.T) {
+	p, s := newTestWxpay(t)
+	s.Inject(wxpaytest.Transfers, wxpaytest.FaultSystemError, payoutRetryTimes)
+
+	_, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T3", Payee: "openid", Amount: 100})
+
+	e, ok := err.(*pay.Error)
+	if !ok || e.SubCode != "SYSTEMERROR" {
+		t.Errorf("got %v, want the last SYSTEMERROR", err)
+	}
+}
+
+func TestTransferNotRetryable(t *testing.T) {
+	p, s := newTestWxpay(t)
+	p.Opt.MchID = "1900000000"
+
+	_, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T4", Payee: "openid", Amount: 100})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if n := s.Requests(wxpaytest.Transfers); n != 1 {
+		t.Errorf("%d transfer requests, want 1", n)
+	}
+	if n := s.Requests(wxpaytest.TransferInfo); n != 0 {
+		t.Errorf("%d query requests, want 0", n)
+	}
+}
+
+func TestTransferFailed(t *testing.T) {
+	p, s := newTestWxpay(t)
+
+	r, err := p.Transfer(pay.PayoutOrder{OutBizNo: "T5", Payee: wxpaytest.OpenIDNotExist, Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != pay.PayoutStatusFailed || len(r.FailReason) == 0 {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(wxpaytest.Transfers); n != 1 {
+		t.Errorf("%d transfer requests, want 1", n)
+	}
+}
+
+func TestTransferToBankRequired(t *testing.T) {
+	p, _ := newTestWxpay(t)
+
+	var full = pay.PayoutOrder{OutBizNo: "B1", PayeeType: pay.PayeeBankCard, Payee: "6225000000000000", PayeeName: "张三", BankCode: "1002", Amount: 100}
+	for _, clear := range []func(o *pay.PayoutOrder){
+		func(o *pay.PayoutOrder) { o.Payee = "" },
+		func(o *pay.PayoutOrder) { o.PayeeName = "" },
+		func(o *pay.PayoutOrder) { o.BankCode = "" },
+	} {
+		in := full
+		clear(&in)
+
+		// 校验在获取加密公钥和请求付款之前
+		if _, err := p.Transfer(in); err != ErrBankPayeeRequired {
+			t.Errorf("%+v: got %v, want %v", in, err, ErrBankPayeeRequired)
+		}
+	}
+}
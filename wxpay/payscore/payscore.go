@@ -0,0 +1,597 @@
+// Package payscore 微信支付分 需确认模式服务订单，用于免押租借等先享后付场景
+// https://pay.weixin.qq.com/wiki/doc/apiv3/wxpay/payscore/chapter3_1.shtml
+package payscore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.PreAuth = &Payscore{}
+
+const (
+	kAPIDomain = "https://api.mch.weixin.qq.com"
+
+	kServiceOrder = "/v3/payscore/serviceorder"
+
+	kRiskFundName = "DEPOSIT" // 风险金名称 押金
+
+	kProvider = "wxpay" // pay.Error.Provider
+
+	kTimestampTolerance = 5 * time.Minute // 应答和回调时间戳允许的偏差
+)
+
+// kCodeCategory APIv3 错误码的分类 https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay2_0.shtml
+var kCodeCategory = map[string]pay.Category{
+	"PARAM_ERROR":           pay.CategoryParam,
+	"INVALID_REQUEST":       pay.CategoryParam,
+	"ORDER_NOT_EXIST":       pay.CategoryParam,
+	"SIGN_ERROR":            pay.CategoryAuth,
+	"NO_AUTH":               pay.CategoryAuth,
+	"APPID_MCHID_NOT_MATCH": pay.CategoryAuth,
+	"NOT_ENOUGH":            pay.CategoryBalance,
+	"ORDER_CLOSED":          pay.CategoryDuplicate,
+	"ORDER_DONE":            pay.CategoryDuplicate,
+	"SYSTEM_ERROR":          pay.CategorySystem,
+	"FREQUENCY_LIMITED":     pay.CategorySystem,
+}
+
+// 回调通知类型
+const (
+	// EventUserConfirm 用户确认订单
+	EventUserConfirm = "PAYSCORE.USER_CONFIRM"
+	// EventUserPaid 用户支付成功
+	EventUserPaid = "PAYSCORE.USER_PAID"
+)
+
+var (
+	// ErrInvalidPrivateKey 商户私钥无法解析
+	ErrInvalidPrivateKey = errors.New("payscore: invalid private key")
+	// ErrInvalidPlatformCert 平台证书无法解析
+	ErrInvalidPlatformCert = errors.New("payscore: invalid platform certificate")
+	// ErrPlatformSerial 应答或回调的 Wechatpay-Serial 与平台证书不一致，平台证书可能已更换
+	ErrPlatformSerial = errors.New("payscore: platform certificate serial mismatch")
+)
+
+// Options Options
+type Options struct {
+	AppID        string // 服务订单使用的appid
+	MchID        string
+	ServiceID    string       // 支付分服务id
+	APIKey       string       // APIv2密钥，用于调起确认订单页面的签名
+	APIv3Key     string       // APIv3密钥，用于解密回调通知
+	PrivateKey   string       // 商户API私钥 apiclient_key.pem
+	SerialNo     string       // 商户API证书序列号
+	PlatformCert string       // 微信支付平台证书，用于验证应答和回调签名
+	NotifyURL    string       // 异步回调地址
+	APIDomain    string       // 接口域名，为空时使用 https://api.mch.weixin.qq.com
+	Client       *http.Client // 为空时使用 http.DefaultClient
+
+	// WrapTransport 包装请求支付平台的 Transport，用于链路追踪和审计
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
+// Payscore Payscore
+type Payscore struct {
+	opt        Options
+	privateKey *rsa.PrivateKey
+	platform   *x509.Certificate
+	client     *http.Client
+}
+
+// New New
+func New(opt Options) (*Payscore, error) {
+	block, _ := pem.Decode([]byte(opt.PrivateKey))
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pri, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	block, _ = pem.Decode([]byte(opt.PlatformCert))
+	if block == nil {
+		return nil, ErrInvalidPlatformCert
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var client = http.DefaultClient
+	if opt.Client != nil {
+		client = opt.Client
+	}
+
+	if opt.WrapTransport != nil {
+		c := *client
+		if c.Transport == nil {
+			c.Transport = http.DefaultTransport
+		}
+		c.Transport = opt.WrapTransport(c.Transport)
+		client = &c
+	}
+
+	p := &Payscore{
+		opt:        opt,
+		privateKey: pri,
+		platform:   cert,
+		client:     client,
+	}
+
+	return p, nil
+}
+
+// Freeze 创建需确认模式的支付分订单，押金为风险金额
+// wxxcx, app -> 调起支付分确认订单页面的参数 url.Values.Encode()
+func (p *Payscore) Freeze(way pay.Way, in pay.FreezeOrder) (string, error) {
+	if way != pay.WayWXXCX && way != pay.WayApp && way != pay.WayJSAPI {
+		return "", pay.ErrWayNotDefine
+	}
+
+	var rsp ServiceOrder
+	err := p.do("POST", kServiceOrder, map[string]interface{}{
+		"out_order_no":         in.OutOrderNo,
+		"appid":                p.opt.AppID,
+		"service_id":           p.opt.ServiceID,
+		"service_introduction": in.Title,
+		"time_range": map[string]string{
+			"start_time": "OnAccept",
+		},
+		"risk_fund": map[string]interface{}{
+			"name":   kRiskFundName,
+			"amount": in.Amount,
+		},
+		"notify_url":        p.opt.NotifyURL,
+		"openid":            in.OpenID,
+		"need_user_confirm": true,
+	}, &rsp)
+	if err != nil {
+		return "", err
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.opt.MchID)
+	v.Set("package", rsp.Package)
+	v.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	v.Set("nonce_str", nonceStr())
+	v.Set("sign_type", "HMAC-SHA256")
+	v.Set("sign", signHMAC(v, p.opt.APIKey))
+
+	return v.Encode(), nil
+}
+
+// VerifyFreeze 回调验证签名并解密，values 由 NotifyValues 生成
+// 用户确认订单 -> FreezeStatusFrozen 用户支付成功 -> FreezeStatusFinished
+func (p *Payscore) VerifyFreeze(in url.Values) (*pay.FreezeResult, error) {
+	order, err := p.Notice(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return order.FreezeResult(), nil
+}
+
+// Notice 回调验证签名并解密，返回服务订单
+func (p *Payscore) Notice(in url.Values) (*ServiceOrder, error) {
+	var body = in.Get("body")
+
+	err := p.verify(in.Get("Wechatpay-Serial"), in.Get("Wechatpay-Timestamp"), in.Get("Wechatpay-Nonce"), body, in.Get("Wechatpay-Signature"))
+	if err != nil {
+		return nil, err
+	}
+
+	var notice struct {
+		EventType string `json:"event_type"`
+		Resource  struct {
+			Algorithm      string `json:"algorithm"`
+			Ciphertext     string `json:"ciphertext"`
+			AssociatedData string `json:"associated_data"`
+			Nonce          string `json:"nonce"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal([]byte(body), &notice); err != nil {
+		return nil, err
+	}
+
+	data, err := p.decrypt(notice.Resource.Ciphertext, notice.Resource.Nonce, notice.Resource.AssociatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var order ServiceOrder
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, err
+	}
+	order.EventType = notice.EventType
+
+	return &order, nil
+}
+
+// Success 回调成功返回数据
+func (p *Payscore) Success() string {
+	return `{"code":"SUCCESS","message":"成功"}`
+}
+
+// Capture 完结支付分订单，按实际金额扣款，扣款结果以 USER_PAID 回调或查询结果为准
+func (p *Payscore) Capture(in pay.CaptureOrder) (*pay.NoticeParams, error) {
+	order, err := p.complete(in.OutOrderNo, in.Title, in.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pay.NoticeParams{
+		OrderID:     order.OutOrderNo,
+		PaymentID:   order.OrderID,
+		TradeStatus: pay.TradeStatusWait,
+		Amount:      order.TotalAmount,
+	}, nil
+}
+
+// Unfreeze 完结支付分订单且不扣款
+func (p *Payscore) Unfreeze(in pay.UnfreezeOrder) error {
+	_, err := p.complete(in.OutOrderNo, in.Remark, 0)
+	return err
+}
+
+// CancelFreeze 取消支付分订单，用户确认后未完结的订单均可取消
+func (p *Payscore) CancelFreeze(outOrderNo, outRequestNo, remark string) error {
+	return p.do("POST", kServiceOrder+"/"+url.PathEscape(outOrderNo)+"/cancel", map[string]interface{}{
+		"appid":      p.opt.AppID,
+		"service_id": p.opt.ServiceID,
+		"reason":     remark,
+	}, nil)
+}
+
+// ModifyAmount 修改订单金额，订单完结后用户支付失败时使用
+func (p *Payscore) ModifyAmount(outOrderNo, title string, amount int32, reason string) error {
+	return p.do("POST", kServiceOrder+"/"+url.PathEscape(outOrderNo)+"/modify", map[string]interface{}{
+		"appid":         p.opt.AppID,
+		"service_id":    p.opt.ServiceID,
+		"post_payments": postPayments(title, amount),
+		"total_amount":  amount,
+		"reason":        reason,
+	}, nil)
+}
+
+// QueryFreeze 查询支付分订单
+func (p *Payscore) QueryFreeze(outOrderNo, outRequestNo string) (*pay.FreezeResult, error) {
+	order, err := p.Query(outOrderNo)
+	if err != nil {
+		return nil, err
+	}
+
+	return order.FreezeResult(), nil
+}
+
+// Query 查询支付分订单
+func (p *Payscore) Query(outOrderNo string) (*ServiceOrder, error) {
+	var q = url.Values{}
+	q.Set("service_id", p.opt.ServiceID)
+	q.Set("appid", p.opt.AppID)
+	q.Set("out_order_no", outOrderNo)
+
+	var order ServiceOrder
+	if err := p.do("GET", kServiceOrder+"?"+q.Encode(), nil, &order); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// complete 完结订单
+func (p *Payscore) complete(outOrderNo, title string, amount int32) (*ServiceOrder, error) {
+	var order ServiceOrder
+	err := p.do("POST", kServiceOrder+"/"+url.PathEscape(outOrderNo)+"/complete", map[string]interface{}{
+		"appid":         p.opt.AppID,
+		"service_id":    p.opt.ServiceID,
+		"post_payments": postPayments(title, amount),
+		"total_amount":  amount,
+		"time_range": map[string]string{
+			"end_time": time.Now().Format("20060102150405"),
+		},
+	}, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// do 签名请求APIv3接口并验证应答签名
+func (p *Payscore) do(method, path string, body interface{}, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	domain := kAPIDomain
+	if len(p.opt.APIDomain) > 0 {
+		domain = strings.TrimSuffix(p.opt.APIDomain, "/")
+	}
+
+	req, err := http.NewRequest(method, domain+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	auth, err := p.authorization(method, path, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return pay.NetError(kProvider, err)
+	}
+
+	rspBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return pay.NetError(kProvider, err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return newError(resp, rspBody)
+	}
+
+	err = p.verify(resp.Header.Get("Wechatpay-Serial"), resp.Header.Get("Wechatpay-Timestamp"), resp.Header.Get("Wechatpay-Nonce"), string(rspBody), resp.Header.Get("Wechatpay-Signature"))
+	if err != nil {
+		return err
+	}
+
+	if result == nil || len(rspBody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(rspBody, result)
+}
+
+// newError 非 2xx 应答转为 *pay.Error，Code 为 http 状态码，SubCode 为应答中的 code
+// 5xx 和 SYSTEM_ERROR 可以用原参数重试
+func newError(resp *http.Response, body []byte) *pay.Error {
+	var e struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &e)
+
+	var msg = e.Message
+	if len(msg) == 0 {
+		msg = resp.Status
+	}
+
+	category, ok := kCodeCategory[e.Code]
+	if !ok && resp.StatusCode >= http.StatusInternalServerError {
+		category = pay.CategorySystem
+	}
+
+	return &pay.Error{
+		Provider:  kProvider,
+		Code:      strconv.Itoa(resp.StatusCode),
+		SubCode:   e.Code,
+		Message:   msg,
+		Category:  category,
+		Retryable: resp.StatusCode >= http.StatusInternalServerError || e.Code == "SYSTEM_ERROR",
+	}
+}
+
+// authorization 请求签名 https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_0.shtml
+func (p *Payscore) authorization(method, path string, body []byte) (string, error) {
+	var (
+		nonce     = nonceStr()
+		timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		message   = method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + string(body) + "\n"
+	)
+
+	h := sha256.Sum256([]byte(message))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.privateKey, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		p.opt.MchID, nonce, base64.StdEncoding.EncodeToString(sig), timestamp, p.opt.SerialNo), nil
+}
+
+// verify 验证应答或回调签名，时间戳偏差超过5分钟的视为重放
+func (p *Payscore) verify(serial, timestamp, nonce, body, signature string) error {
+	if !strings.EqualFold(serial, fmt.Sprintf("%X", p.platform.SerialNumber)) {
+		return ErrPlatformSerial
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return pay.ErrVerify
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > kTimestampTolerance || d < -kTimestampTolerance {
+		return pay.ErrVerify
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return pay.ErrVerify
+	}
+
+	pub, ok := p.platform.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidPlatformCert
+	}
+
+	h := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + body + "\n"))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+		return pay.ErrVerify
+	}
+
+	return nil
+}
+
+// decrypt 使用APIv3密钥解密回调资源 AEAD_AES_256_GCM
+func (p *Payscore) decrypt(ciphertext, nonce, associatedData string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher([]byte(p.opt.APIv3Key))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, []byte(nonce), data, []byte(associatedData))
+}
+
+// NotifyValues 回调请求转为url.Values，用于VerifyFreeze
+func NotifyValues(header http.Header, body []byte) url.Values {
+	var v = url.Values{}
+	v.Set("Wechatpay-Timestamp", header.Get("Wechatpay-Timestamp"))
+	v.Set("Wechatpay-Nonce", header.Get("Wechatpay-Nonce"))
+	v.Set("Wechatpay-Signature", header.Get("Wechatpay-Signature"))
+	v.Set("Wechatpay-Serial", header.Get("Wechatpay-Serial"))
+	v.Set("body", string(body))
+	return v
+}
+
+// ServiceOrder 支付分服务订单
+type ServiceOrder struct {
+	EventType        string `json:"-"` // 回调通知类型
+	OutOrderNo       string `json:"out_order_no"`
+	ServiceID        string `json:"service_id"`
+	AppID            string `json:"appid"`
+	MchID            string `json:"mchid"`
+	OrderID          string `json:"order_id"`          // 微信支付服务订单号
+	State            string `json:"state"`             // CREATED DOING DONE REVOKED EXPIRED
+	StateDescription string `json:"state_description"` // USER_CONFIRM MCH_COMPLETE USER_PAID
+	TotalAmount      int32  `json:"total_amount"`
+	NeedCollection   bool   `json:"need_collection"`
+	Package          string `json:"package"`
+	OpenID           string `json:"openid"`
+	RiskFund         struct {
+		Name   string `json:"name"`
+		Amount int32  `json:"amount"`
+	} `json:"risk_fund"`
+	Collection struct {
+		State        string `json:"state"` // USER_PAYING USER_PAID
+		TotalAmount  int32  `json:"total_amount"`
+		PayingAmount int32  `json:"paying_amount"`
+		PaidAmount   int32  `json:"paid_amount"`
+		Details      []struct {
+			Seq           int    `json:"seq"`
+			Amount        int32  `json:"amount"`
+			PaidType      string `json:"paid_type"`
+			PaidTime      string `json:"paid_time"`
+			TransactionID string `json:"transaction_id"`
+		} `json:"details"`
+	} `json:"collection"`
+}
+
+// FreezeResult 服务订单转授权单信息
+func (o *ServiceOrder) FreezeResult() *pay.FreezeResult {
+	var status pay.FreezeStatus
+	switch o.State {
+	case "CREATED":
+		status = pay.FreezeStatusInit
+	case "DOING":
+		status = pay.FreezeStatusFrozen
+	case "DONE":
+		status = pay.FreezeStatusFinished
+	case "REVOKED", "EXPIRED":
+		status = pay.FreezeStatusClosed
+	}
+
+	if o.EventType == EventUserConfirm {
+		status = pay.FreezeStatusFrozen
+	} else if o.EventType == EventUserPaid {
+		status = pay.FreezeStatusFinished
+	}
+
+	var rest int32
+	if status == pay.FreezeStatusFrozen {
+		rest = o.RiskFund.Amount
+	}
+
+	return &pay.FreezeResult{
+		AuthNo:            o.OrderID,
+		OutOrderNo:        o.OutOrderNo,
+		Status:            status,
+		TotalFreezeAmount: o.RiskFund.Amount,
+		RestAmount:        rest,
+		TotalPayAmount:    o.Collection.PaidAmount,
+		PayerID:           o.OpenID,
+	}
+}
+
+func postPayments(title string, amount int32) []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"name":   title,
+			"amount": amount,
+		},
+	}
+}
+
+// signHMAC 调起确认订单页面的签名 HMAC-SHA256
+func signHMAC(v url.Values, key string) string {
+	var pList = make([]string, 0, len(v))
+	for k := range v {
+		if len(v.Get(k)) > 0 {
+			pList = append(pList, k+"="+v.Get(k))
+		}
+	}
+	sort.Strings(pList)
+	pList = append(pList, "key="+key)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join(pList, "&")))
+
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+func nonceStr() string {
+	var b = make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
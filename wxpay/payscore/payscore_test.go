@@ -0,0 +1,116 @@
+package payscore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestPayscore 生成商户私钥和平台证书，请求发到 handler
+func newTestPayscore(t *testing.T, handler http.HandlerFunc, wrap func(http.RoundTripper) http.RoundTripper) *Payscore {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wxpay"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+
+	p, err := New(Options{
+		AppID:         "wx2421b1c4370ec43b",
+		MchID:         "1900000109",
+		ServiceID:     "500001",
+		PrivateKey:    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		PlatformCert:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+		APIDomain:     s.URL,
+		WrapTransport: wrap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestError(t *testing.T) {
+	for _, c := range []struct {
+		status    int
+		body      string
+		subCode   string
+		category  pay.Category
+		retryable bool
+	}{
+		{http.StatusBadRequest, `{"code":"PARAM_ERROR","message":"参数错误"}`, "PARAM_ERROR", pay.CategoryParam, false},
+		{http.StatusInternalServerError, `{"code":"SYSTEM_ERROR","message":"系统错误"}`, "SYSTEM_ERROR", pay.CategorySystem, true},
+		{http.StatusBadGateway, `bad gateway`, "", pay.CategorySystem, true},
+	} {
+		p := newTestPayscore(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			io.WriteString(w, c.body)
+		}, nil)
+
+		_, err := p.Query("O1")
+
+		e, ok := err.(*pay.Error)
+		if !ok {
+			t.Errorf("%d: got %T %v, want *pay.Error", c.status, err, err)
+			continue
+		}
+		if e.SubCode != c.subCode || e.Category != c.category || e.Retryable != c.retryable || len(e.Message) == 0 {
+			t.Errorf("%d: got %+v", c.status, e)
+		}
+	}
+}
+
+func TestWrapTransport(t *testing.T) {
+	var n int
+	p := newTestPayscore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}, func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			n++
+			return base.RoundTrip(req)
+		})
+	})
+
+	p.Query("O1")
+
+	if n != 1 {
+		t.Errorf("%d requests through WrapTransport, want 1", n)
+	}
+	if p.client == http.DefaultClient {
+		t.Error("WrapTransport should wrap a copy of http.DefaultClient")
+	}
+}
@@ -0,0 +1,313 @@
+package wxpay
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// 分账 https://pay.weixin.qq.com/wiki/doc/api/allocation.php?chapter=27_1&index=1
+// 下单时 pay.Order.ProfitSharing 为 true 的订单才能分账，分账接口只支持 HMAC-SHA256 签名
+const (
+	kProfitSharingAddReceiver    = "/pay/profitsharingaddreceiver"
+	kProfitSharingRemoveReceiver = "/pay/profitsharingremovereceiver"
+	kProfitSharing               = "/secapi/pay/profitsharing"
+	kMultiProfitSharing          = "/secapi/pay/multiprofitsharing"
+	kProfitSharingFinish         = "/secapi/pay/profitsharingfinish"
+	kProfitSharingQuery          = "/pay/profitsharingquery"
+	kProfitSharingReturn         = "/secapi/pay/profitsharingreturn"
+	kProfitSharingReturnQuery    = "/pay/profitsharingreturnquery"
+)
+
+const (
+	// ReceiverTypeMerchant 分账接收方类型 商户号
+	ReceiverTypeMerchant = "MERCHANT_ID"
+	// ReceiverTypeOpenID 分账接收方类型 个人openid
+	ReceiverTypeOpenID = "PERSONAL_OPENID"
+)
+
+// ProfitSharingReceiver 分账接收方
+type ProfitSharingReceiver struct {
+	Type           string `json:"type"`                      // 分账接收方类型 MERCHANT_ID PERSONAL_OPENID
+	Account        string `json:"account"`                   // 分账接收方帐号，商户号或openid
+	Name           string `json:"name,omitempty"`            // 分账接收方全称，类型是MERCHANT_ID时必填
+	RelationType   string `json:"relation_type,omitempty"`   // 与分账方的关系类型 SERVICE_PROVIDER STORE STAFF STORE_OWNER PARTNER HEADQUARTER BRAND DISTRIBUTOR USER SUPPLIER CUSTOM
+	CustomRelation string `json:"custom_relation,omitempty"` // 自定义的分账关系，relation_type为CUSTOM时必填
+}
+
+// ProfitSharingItem 分账明细
+type ProfitSharingItem struct {
+	Type        string `json:"type"`                  // 分账接收方类型 MERCHANT_ID PERSONAL_OPENID
+	Account     string `json:"account"`               // 分账接收方帐号
+	Amount      int32  `json:"amount"`                // 分账金额 单位分
+	Description string `json:"description"`           // 分账描述
+	Result      string `json:"result,omitempty"`      // 查询返回 分账结果 PENDING SUCCESS CLOSED
+	FinishTime  string `json:"finish_time,omitempty"` // 查询返回 分账完成时间
+	FailReason  string `json:"fail_reason,omitempty"` // 查询返回 分账失败原因
+}
+
+// ProfitSharingOrder 分账请求
+type ProfitSharingOrder struct {
+	TransactionID string              // 微信支付订单号
+	OutOrderNo    string              // 商户分账单号，同一分账单号多次请求等同一次
+	Receivers     []ProfitSharingItem // 分账接收方列表
+}
+
+// ProfitSharingResult 分账结果
+type ProfitSharingResult struct {
+	TransactionID string              // 微信支付订单号
+	OutOrderNo    string              // 商户分账单号
+	OrderID       string              // 微信分账单号
+	Status        string              // 查询返回 分账单状态 ACCEPTED PROCESSING FINISHED CLOSED
+	CloseReason   string              // 查询返回 关单原因
+	Receivers     []ProfitSharingItem // 查询返回 分账接收方列表
+	Amount        int32               // 完结分账返回 分账完结金额
+	Description   string              // 分账完结描述
+}
+
+// ProfitSharingReturnOrder 分账回退请求
+type ProfitSharingReturnOrder struct {
+	OrderID           string // 微信分账单号，与OutOrderNo二选一
+	OutOrderNo        string // 商户分账单号，与OrderID二选一
+	OutReturnNo       string // 商户回退单号
+	ReturnAccountType string // 回退方类型，暂时只支持 MERCHANT_ID
+	ReturnAccount     string // 回退方账号
+	ReturnAmount      int32  // 回退金额 单位分
+	Description       string // 回退描述
+}
+
+// ProfitSharingReturnResult 分账回退结果
+type ProfitSharingReturnResult struct {
+	OrderID           string // 微信分账单号
+	OutOrderNo        string // 商户分账单号
+	OutReturnNo       string // 商户回退单号
+	ReturnNo          string // 微信回退单号
+	ReturnAccountType string // 回退方类型
+	ReturnAccount     string // 回退方账号
+	ReturnAmount      int32  // 回退金额
+	Description       string // 回退描述
+	Result            string // 回退结果 PROCESSING SUCCESS FAILED
+	FailReason        string // 失败原因
+	FinishTime        string // 完成时间
+}
+
+// AddReceiver 添加分账接收方
+func (p *Wxpay) AddReceiver(r ProfitSharingReceiver) error {
+	return p.receiverRequest(kProfitSharingAddReceiver, r)
+}
+
+// RemoveReceiver 删除分账接收方，只需要Type和Account
+func (p *Wxpay) RemoveReceiver(r ProfitSharingReceiver) error {
+	return p.receiverRequest(kProfitSharingRemoveReceiver, ProfitSharingReceiver{
+		Type:    r.Type,
+		Account: r.Account,
+	})
+}
+
+func (p *Wxpay) receiverRequest(api string, r ProfitSharingReceiver) error {
+	d, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	v.Set("receiver", string(d))
+
+	rsp, err := p.post(api, v, false)
+	if err != nil {
+		return err
+	}
+
+	if !isSuccess(rsp) {
+		return newError(rsp)
+	}
+
+	return nil
+}
+
+// ProfitSharing 单次分账，请求后剩余待分账金额自动解冻给本商户
+func (p *Wxpay) ProfitSharing(in ProfitSharingOrder) (*ProfitSharingResult, error) {
+	return p.profitSharing(kProfitSharing, in)
+}
+
+// MultiProfitSharing 多次分账，分账完成后需要调用FinishProfitSharing解冻剩余资金
+func (p *Wxpay) MultiProfitSharing(in ProfitSharingOrder) (*ProfitSharingResult, error) {
+	return p.profitSharing(kMultiProfitSharing, in)
+}
+
+func (p *Wxpay) profitSharing(api string, in ProfitSharingOrder) (*ProfitSharingResult, error) {
+	d, err := json.Marshal(in.Receivers)
+	if err != nil {
+		return nil, err
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	v.Set("transaction_id", in.TransactionID)
+	v.Set("out_order_no", in.OutOrderNo)
+	v.Set("receivers", string(d))
+
+	rsp, err := p.post(api, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	return &ProfitSharingResult{
+		TransactionID: rsp.Get("transaction_id"),
+		OutOrderNo:    rsp.Get("out_order_no"),
+		OrderID:       rsp.Get("order_id"),
+	}, nil
+}
+
+// FinishProfitSharing 完结分账，剩余待分账金额解冻给本商户
+func (p *Wxpay) FinishProfitSharing(transactionID, outOrderNo, description string) (*ProfitSharingResult, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	v.Set("transaction_id", transactionID)
+	v.Set("out_order_no", outOrderNo)
+	v.Set("amount", "0")
+	v.Set("description", description)
+
+	rsp, err := p.post(kProfitSharingFinish, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	amount, _ := strconv.Atoi(rsp.Get("amount"))
+
+	return &ProfitSharingResult{
+		TransactionID: rsp.Get("transaction_id"),
+		OutOrderNo:    rsp.Get("out_order_no"),
+		OrderID:       rsp.Get("order_id"),
+		Amount:        int32(amount),
+		Description:   description,
+	}, nil
+}
+
+// QueryProfitSharing 查询分账结果
+func (p *Wxpay) QueryProfitSharing(transactionID, outOrderNo string) (*ProfitSharingResult, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	v.Set("transaction_id", transactionID)
+	v.Set("out_order_no", outOrderNo)
+
+	rsp, err := p.post(kProfitSharingQuery, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	var receivers []ProfitSharingItem
+	if s := rsp.Get("receivers"); len(s) > 0 {
+		if err := json.Unmarshal([]byte(s), &receivers); err != nil {
+			return nil, err
+		}
+	}
+
+	amount, _ := strconv.Atoi(rsp.Get("amount"))
+
+	return &ProfitSharingResult{
+		TransactionID: rsp.Get("transaction_id"),
+		OutOrderNo:    rsp.Get("out_order_no"),
+		OrderID:       rsp.Get("order_id"),
+		Status:        rsp.Get("status"),
+		CloseReason:   rsp.Get("close_reason"),
+		Receivers:     receivers,
+		Amount:        int32(amount),
+		Description:   rsp.Get("description"),
+	}, nil
+}
+
+// ProfitSharingReturn 分账回退，从分账接收方回退资金到本商户
+func (p *Wxpay) ProfitSharingReturn(in ProfitSharingReturnOrder) (*ProfitSharingReturnResult, error) {
+	returnAccountType := in.ReturnAccountType
+	if len(returnAccountType) == 0 {
+		returnAccountType = ReceiverTypeMerchant
+	}
+
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	setOrderNo(v, in.OrderID, in.OutOrderNo)
+	v.Set("out_return_no", in.OutReturnNo)
+	v.Set("return_account_type", returnAccountType)
+	v.Set("return_account", in.ReturnAccount)
+	v.Set("return_amount", strconv.Itoa(int(in.ReturnAmount)))
+	v.Set("description", in.Description)
+
+	rsp, err := p.post(kProfitSharingReturn, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return returnResult(rsp)
+}
+
+// QueryProfitSharingReturn 查询分账回退结果，orderID与outOrderNo二选一
+func (p *Wxpay) QueryProfitSharingReturn(orderID, outOrderNo, outReturnNo string) (*ProfitSharingReturnResult, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("sign_type", kSignTypeHMACSHA256)
+	setOrderNo(v, orderID, outOrderNo)
+	v.Set("out_return_no", outReturnNo)
+
+	rsp, err := p.post(kProfitSharingReturnQuery, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return returnResult(rsp)
+}
+
+// setOrderNo order_id 与 out_order_no 二选一，只设置不为空的参数，都不为空时使用 order_id
+func setOrderNo(v url.Values, orderID, outOrderNo string) {
+	if len(orderID) > 0 {
+		v.Set("order_id", orderID)
+		return
+	}
+	v.Set("out_order_no", outOrderNo)
+}
+
+// returnResult 回退接口业务失败时 result_code 为 FAIL，回退本身失败时 result 为 FAILED
+func returnResult(rsp url.Values) (*ProfitSharingReturnResult, error) {
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	amount, _ := strconv.Atoi(rsp.Get("return_amount"))
+
+	return &ProfitSharingReturnResult{
+		OrderID:           rsp.Get("order_id"),
+		OutOrderNo:        rsp.Get("out_order_no"),
+		OutReturnNo:       rsp.Get("out_return_no"),
+		ReturnNo:          rsp.Get("return_no"),
+		ReturnAccountType: rsp.Get("return_account_type"),
+		ReturnAccount:     rsp.Get("return_account"),
+		ReturnAmount:      int32(amount),
+		Description:       rsp.Get("description"),
+		Result:            rsp.Get("result"),
+		FailReason:        rsp.Get("fail_reason"),
+		FinishTime:        rsp.Get("finish_time"),
+	}, nil
+}
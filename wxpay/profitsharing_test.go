@@ -0,0 +1,202 @@
+package wxpay
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/wxpay/wxpaytest"
+)
+
+// lastRequest 接口最后一次收到的请求，桩已验证签名，分账接口应使用 HMAC-SHA256
+func lastRequest(t *testing.T, s *wxpaytest.Server, path string) url.Values {
+	t.Helper()
+
+	req := s.LastRequest(path)
+	if req == nil {
+		t.Fatalf("%s not requested", path)
+	}
+
+	if req.Get("sign_type") != kSignTypeHMACSHA256 || len(req.Get("sign")) != 64 {
+		t.Errorf("%s sign_type %q sign %q", path, req.Get("sign_type"), req.Get("sign"))
+	}
+
+	return req
+}
+
+// paidOrder 下单并模拟支付，返回微信支付订单号
+func paidOrder(t *testing.T, p *Wxpay, s *wxpaytest.Server, id string) string {
+	t.Helper()
+
+	_, err := p.Call(pay.WayQrcode, pay.Order{ID: id, Title: "test", Amount: 100, IP: "127.0.0.1", ProfitSharing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Pay(id) {
+		t.Fatalf("pay %s failed", id)
+	}
+
+	r, err := p.Query(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r.PaymentID
+}
+
+func TestUnifiedOrderProfitSharing(t *testing.T) {
+	p, s := newTestWxpay(t)
+
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100, IP: "127.0.0.1", ProfitSharing: true}); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.LastRequest(wxpaytest.UnifiedOrder).Get("profit_sharing"); v != "Y" {
+		t.Errorf("profit_sharing %q, want Y", v)
+	}
+
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O2", Title: "test", Amount: 100, IP: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.LastRequest(wxpaytest.UnifiedOrder)["profit_sharing"]; ok {
+		t.Error("profit_sharing sent for order without profit sharing")
+	}
+}
+
+func TestProfitSharing(t *testing.T) {
+	p, s := newTestWxpay(t)
+	transactionID := paidOrder(t, p, s, "O1")
+
+	err := p.AddReceiver(ProfitSharingReceiver{
+		Type:         ReceiverTypeOpenID,
+		Account:      "openid",
+		RelationType: "STAFF",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := lastRequest(t, s, wxpaytest.ProfitSharingAddReceiver)
+	if req.Get("receiver") != `{"type":"PERSONAL_OPENID","account":"openid","relation_type":"STAFF"}` {
+		t.Errorf("receiver %s", req.Get("receiver"))
+	}
+	if !s.Receiver("openid") {
+		t.Error("receiver not added")
+	}
+
+	var receivers = []ProfitSharingItem{{Type: ReceiverTypeOpenID, Account: "openid", Amount: 10, Description: "分给员工"}}
+
+	r, err := p.ProfitSharing(ProfitSharingOrder{
+		TransactionID: transactionID,
+		OutOrderNo:    "P1",
+		Receivers:     receivers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.OrderID) == 0 || r.OutOrderNo != "P1" || r.TransactionID != transactionID {
+		t.Errorf("result %+v", r)
+	}
+
+	req = lastRequest(t, s, wxpaytest.ProfitSharing)
+	if req.Get("transaction_id") != transactionID || req.Get("out_order_no") != "P1" {
+		t.Errorf("request %v", req)
+	}
+
+	var sent []ProfitSharingItem
+	if err := json.Unmarshal([]byte(req.Get("receivers")), &sent); err != nil || !reflect.DeepEqual(sent, receivers) {
+		t.Errorf("receivers %s", req.Get("receivers"))
+	}
+
+	q, err := p.QueryProfitSharing(transactionID, "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Status != "FINISHED" || q.OrderID != r.OrderID || !reflect.DeepEqual(q.Receivers, receivers) {
+		t.Errorf("query %+v", q)
+	}
+
+	f, err := p.FinishProfitSharing(transactionID, "P2", "完结")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Amount != 0 || f.OutOrderNo != "P2" {
+		t.Errorf("finish %+v", f)
+	}
+
+	req = lastRequest(t, s, wxpaytest.ProfitSharingFinish)
+	if req.Get("amount") != "0" || req.Get("description") != "完结" {
+		t.Errorf("request %v", req)
+	}
+
+	if err := p.RemoveReceiver(ProfitSharingReceiver{Type: ReceiverTypeOpenID, Account: "openid", RelationType: "STAFF"}); err != nil {
+		t.Fatal(err)
+	}
+	req = lastRequest(t, s, wxpaytest.ProfitSharingRemoveReceiver)
+	if req.Get("receiver") != `{"type":"PERSONAL_OPENID","account":"openid"}` {
+		t.Errorf("receiver %s", req.Get("receiver"))
+	}
+}
+
+func TestProfitSharingReturn(t *testing.T) {
+	p, s := newTestWxpay(t)
+	transactionID := paidOrder(t, p, s, "O1")
+
+	r, err := p.ProfitSharing(ProfitSharingOrder{
+		TransactionID: transactionID,
+		OutOrderNo:    "P1",
+		Receivers:     []ProfitSharingItem{{Type: ReceiverTypeMerchant, Account: "1900000110", Amount: 10, Description: "分给商户"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range []ProfitSharingReturnOrder{
+		{OutOrderNo: "P1", OutReturnNo: "R1"},
+		{OrderID: r.OrderID, OutReturnNo: "R2"},
+	} {
+		in.ReturnAccount = "1900000110"
+		in.ReturnAmount = 10
+		in.Description = "回退"
+
+		rr, err := p.ProfitSharingReturn(in)
+		if err != nil {
+			t.Fatalf("%s: %v", in.OutReturnNo, err)
+		}
+		if rr.Result != "SUCCESS" || rr.OrderID != r.OrderID || rr.ReturnAmount != 10 {
+			t.Errorf("%s: result %+v", in.OutReturnNo, rr)
+		}
+
+		req := lastRequest(t, s, wxpaytest.ProfitSharingReturn)
+		checkOrderNo(t, req, in.OrderID, in.OutOrderNo)
+		if req.Get("return_account_type") != ReceiverTypeMerchant || req.Get("return_amount") != "10" {
+			t.Errorf("%s: request %v", in.OutReturnNo, req)
+		}
+
+		q, err := p.QueryProfitSharingReturn(in.OrderID, in.OutOrderNo, in.OutReturnNo)
+		if err != nil {
+			t.Fatalf("%s: %v", in.OutReturnNo, err)
+		}
+		if q.ReturnNo != rr.ReturnNo {
+			t.Errorf("%s: query %+v", in.OutReturnNo, q)
+		}
+
+		checkOrderNo(t, lastRequest(t, s, wxpaytest.ProfitSharingReturnQuery), in.OrderID, in.OutOrderNo)
+	}
+}
+
+// checkOrderNo order_id 与 out_order_no 只发送不为空的一个
+func checkOrderNo(t *testing.T, req url.Values, orderID, outOrderNo string) {
+	t.Helper()
+
+	_, hasID := req["order_id"]
+	_, hasNo := req["out_order_no"]
+	if hasID != (len(orderID) > 0) || hasNo != (len(outOrderNo) > 0) {
+		t.Errorf("order_id %v out_order_no %v, want only the one provided", hasID, hasNo)
+	}
+	if req.Get("order_id") != orderID || req.Get("out_order_no") != outOrderNo {
+		t.Errorf("order_id %q out_order_no %q", req.Get("order_id"), req.Get("out_order_no"))
+	}
+}
@@ -0,0 +1,67 @@
+package wxpay
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gocommon/pay"
+)
+
+var _ pay.Refunder = &Wxpay{}
+
+// 申请退款 https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_4
+const kRefund = "/secapi/pay/refund"
+
+// Refund 申请退款，需要商户证书，受理成功返回处理中，退款结果以退款查询为准
+// appid 使用 PublicID，需要与下单时的 appid 一致
+func (p *Wxpay) Refund(in pay.RefundOrder) (*pay.RefundResult, error) {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("transaction_id", in.PaymentID)
+	v.Set("out_trade_no", in.OrderID)
+	v.Set("out_refund_no", in.ID)
+	v.Set("total_fee", strconv.Itoa(int(in.TotalAmount)))
+	v.Set("refund_fee", strconv.Itoa(int(in.Amount)))
+	v.Set("refund_desc", in.Reason)
+
+	rsp, err := p.post(kRefund, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	amount, _ := strconv.Atoi(rsp.Get("refund_fee"))
+
+	return &pay.RefundResult{
+		ID:       in.ID,
+		RefundID: rsp.Get("refund_id"),
+		Status:   pay.RefundStatusProcessing,
+		Amount:   int32(amount),
+	}, nil
+}
+
+// 查询退款 https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=9_5
+const kRefundQuery = "/pay/refundquery"
+
+// refundQuery 按商户退款单号查询退款
+func (p *Wxpay) refundQuery(refundID string) (url.Values, error) {
+	var v = url.Values{}
+	v.Set("appid", p.Opt.PublicID)
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("out_refund_no", refundID)
+
+	rsp, err := p.post(kRefundQuery, v, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(rsp) {
+		return nil, newError(rsp)
+	}
+
+	return rsp, nil
+}
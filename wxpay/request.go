@@ -0,0 +1,256 @@
+package wxpay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gocommon/pay"
+	"github.com/smartwalle/wxpay"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	kAPIDomain = "https://api.mch.weixin.qq.com"
+
+	kSignTypeMD5        = "MD5"
+	kSignTypeHMACSHA256 = "HMAC-SHA256"
+)
+
+var (
+	// ErrNotFoundCertFile 未配置商户证书
+	ErrNotFoundCertFile = errors.New("wxpay: not found cert file")
+	// ErrSandboxSignKey 未获取到沙箱密钥
+	ErrSandboxSignKey = errors.New("wxpay: sandbox sign key not found")
+)
+
+// kRetryAPI 可以用原参数重试的接口，按商户单号幂等
+// 付款接口 kTransfers kPayBank 由 payout 重试，结果仍不确定时查询，不在这里重试
+var kRetryAPI = map[string]bool{
+	kUnifiedOrder:    true,
+	kOrderQuery:      true,
+	kCloseOrder:      true,
+	kRefund:          true,
+	kRefundQuery:     true,
+	kGetTransferInfo: true,
+	kQueryBank:       true,
+}
+
+// kRetryErrCode 需要用原参数重试的 err_code
+var kRetryErrCode = map[string]bool{
+	"SYSTEMERROR":       true,
+	"BIZERR_NEED_RETRY": true,
+}
+
+// post 签名并请求接口，返回结果参数，withCert 为 true 时使用商户证书
+// return_code 为 FAIL 时返回错误，result_code 由调用方处理
+// kRetryAPI 中的接口按 Options.Retry 重试网络错误、5xx 和 kRetryErrCode，所有接口经过熔断器
+func (p *Wxpay) post(api string, vals url.Values, withCert bool) (rsp url.Values, err error) {
+	var policy *pay.RetryPolicy
+	if kRetryAPI[api] {
+		policy = p.Opt.Retry
+	}
+
+	err = pay.Retry(policy, p.breaker, func() error {
+		var err error
+		if rsp, err = p.do(api, vals, withCert); err != nil {
+			return err
+		}
+
+		if !isSuccess(rsp) && kRetryErrCode[rsp.Get("err_code")] {
+			return newError(rsp)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rsp, nil
+}
+
+// do 请求一次接口，按请求的 sign_type 签名，返回结果带签名时验证签名
+func (p *Wxpay) do(api string, vals url.Values, withCert bool) (url.Values, error) {
+	key, err := p.signKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// 沙箱环境不校验商户证书，配置了证书时才使用
+	var cli = p.client.Client
+	if withCert && (p.Opt.IsProduction || len(p.Opt.CertFile) > 0) {
+		if cli, err = p.tlsClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	var signType = vals.Get("sign_type")
+
+	// 重试时重新签名
+	vals.Del("sign")
+	vals.Set("nonce_str", wxpay.GetNonceStr())
+	vals.Set("sign", sign(vals, key, signType))
+
+	resp, err := p.postXML(cli, api, vals)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &pay.Error{
+			Provider:  kProvider,
+			Code:      strconv.Itoa(resp.StatusCode),
+			Message:   resp.Status,
+			Category:  pay.CategorySystem,
+			Retryable: true,
+		}
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, pay.NetError(kProvider, err)
+	}
+
+	rsp, err := BodyToValues(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Get("return_code") == wxpay.K_RETURN_CODE_FAIL {
+		return nil, newError(rsp)
+	}
+
+	if s := rsp.Get("sign"); len(s) > 0 {
+		rsp.Del("sign")
+		ok := s == sign(rsp, key, signType)
+		rsp.Set("sign", s)
+		if !ok {
+			return nil, pay.ErrVerify
+		}
+	}
+
+	return rsp, nil
+}
+
+// postXML 使用 p.context() 以 xml 发送 vals
+func (p *Wxpay) postXML(cli *http.Client, api string, vals url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", p.api(api), strings.NewReader(wxpay.URLValueToXML(vals)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml;charset=utf-8")
+
+	return cli.Do(req.WithContext(p.context()))
+}
+
+// api 接口地址，Options.APIDomain 不为空时替换默认域名，沙箱环境使用沙箱接口路径
+func (p *Wxpay) api(path string) string {
+	if strings.HasPrefix(path, "https://") {
+		return path
+	}
+
+	if !p.Opt.IsProduction {
+		path = sandboxAPI(path)
+	}
+
+	if len(p.Opt.APIDomain) > 0 {
+		return strings.TrimSuffix(p.Opt.APIDomain, "/") + path
+	}
+
+	return kAPIDomain + path
+}
+
+// tlsClient 加载商户证书，只在第一次使用时加载
+func (p *Wxpay) tlsClient() (*http.Client, error) {
+	p.certOnce.Do(func() {
+		p.certClient, p.certErr = newTLSClient(p.Opt.CertFile, p.Opt.MchID)
+		if p.certErr == nil && p.Opt.WrapTransport != nil {
+			p.certClient.Transport = wrapTransport(p.Opt.WrapTransport, p.certClient.Transport)
+		}
+	})
+
+	return p.certClient, p.certErr
+}
+
+// newTLSClient 证书密码默认为商户号
+func newTLSClient(path, password string) (*http.Client, error) {
+	if len(path) == 0 {
+		return nil, ErrNotFoundCertFile
+	}
+
+	p12, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := pkcs12.ToPEM(p12, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var pemData []byte
+	for _, b := range blocks {
+		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	}
+
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+			},
+			DisableCompression: true,
+		},
+	}, nil
+}
+
+// wrapTransport base 为空时包装 http.DefaultTransport
+func wrapTransport(wrap func(http.RoundTripper) http.RoundTripper, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return wrap(base)
+}
+
+// sign 签名，signType 为 HMAC-SHA256 时使用 HMAC-SHA256，否则使用 MD5
+func sign(vals url.Values, key, signType string) string {
+	if signType != kSignTypeHMACSHA256 {
+		return wxpay.SignMD5(vals, key)
+	}
+
+	var pList = make([]string, 0, len(vals))
+	for k := range vals {
+		if v := vals.Get(k); len(v) > 0 {
+			pList = append(pList, k+"="+v)
+		}
+	}
+	sort.Strings(pList)
+	pList = append(pList, "key="+key)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join(pList, "&")))
+
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// isSuccess result_code 是否为 SUCCESS
+func isSuccess(rsp url.Values) bool {
+	return rsp.Get("result_code") == wxpay.K_RETURN_CODE_SUCCESS
+}
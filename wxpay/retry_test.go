@@ -0,0 +1,72 @@
+package wxpay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocommon/pay"
+	"github.com/gocommon/pay/wxpay/wxpaytest"
+)
+
+func TestRetryIdempotent(t *testing.T) {
+	p, s := newTestWxpay(t)
+	p.Opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100, IP: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Inject(wxpaytest.OrderQuery, wxpaytest.FaultServerError, 1)
+	s.Inject(wxpaytest.OrderQuery, wxpaytest.FaultSystemError, 1)
+
+	r, err := p.Query("O1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OrderID != "O1" {
+		t.Errorf("result %+v", r)
+	}
+	if n := s.Requests(wxpaytest.OrderQuery); n != 3 {
+		t.Errorf("%d query requests, want 3", n)
+	}
+}
+
+func TestNoRetryNotIdempotent(t *testing.T) {
+	p, s := newTestWxpay(t)
+	p.Opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+	s.Inject(wxpaytest.ProfitSharingAddReceiver, wxpaytest.FaultSystemError, 1)
+
+	err := p.AddReceiver(ProfitSharingReceiver{Type: ReceiverTypeOpenID, Account: "openid", RelationType: "STAFF"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n := s.Requests(wxpaytest.ProfitSharingAddReceiver); n != 1 {
+		t.Errorf("%d add receiver requests, want 1", n)
+	}
+}
+
+func TestBreakerOpen(t *testing.T) {
+	p, s := newTestWxpay(t)
+	p.Opt.Retry = &pay.RetryPolicy{MaxAttempts: 3, Backoff: []time.Duration{time.Millisecond}}
+	p.breaker = pay.NewBreaker(pay.BreakerOptions{Failures: 2, Cooldown: time.Minute})
+	s.Inject(wxpaytest.OrderQuery, wxpaytest.FaultServerError, 10)
+
+	// 第二次失败后熔断，第三次请求没有发出
+	if _, err := p.Query("O1"); err != pay.ErrCircuitOpen {
+		t.Errorf("got %v, want %v", err, pay.ErrCircuitOpen)
+	}
+	if !p.breaker.Open() {
+		t.Error("breaker not open")
+	}
+
+	// 熔断中其他接口也不发出请求
+	if _, err := p.Call(pay.WayQrcode, pay.Order{ID: "O1", Title: "test", Amount: 100, IP: "127.0.0.1"}); err != pay.ErrCircuitOpen {
+		t.Errorf("got %v, want %v", err, pay.ErrCircuitOpen)
+	}
+	if n := s.Requests(wxpaytest.OrderQuery); n != 2 {
+		t.Errorf("%d query requests, want 2", n)
+	}
+	if n := s.Requests(wxpaytest.UnifiedOrder); n != 0 {
+		t.Errorf("%d unifiedorder requests, want 0", n)
+	}
+}
@@ -0,0 +1,90 @@
+package wxpay
+
+import (
+	"io/ioutil"
+	"net/url"
+
+	"github.com/smartwalle/wxpay"
+)
+
+// 仿真测试系统 https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=23_1&index=2
+// Options.IsProduction 为 false 时接口地址加 /sandboxnew 前缀，签名和验签使用沙箱密钥
+const (
+	kSandboxPath = "/sandboxnew"
+	kGetSignKey  = "/pay/getsignkey"
+)
+
+// kSandboxAPI 沙箱环境接口路径与正式环境不一致的接口
+var kSandboxAPI = map[string]string{
+	kRefund: "/pay/refund",
+}
+
+// signKey 签名密钥，正式环境为 Options.APIKey，沙箱环境为获取到的沙箱密钥
+// 沙箱密钥获取成功后缓存，失败时下次重新获取
+func (p *Wxpay) signKey() (string, error) {
+	if p.Opt.IsProduction {
+		return p.Opt.APIKey, nil
+	}
+
+	p.sandboxMu.Lock()
+	defer p.sandboxMu.Unlock()
+
+	if len(p.sandboxKey) > 0 {
+		return p.sandboxKey, nil
+	}
+
+	key, err := p.getSignKey()
+	if err != nil {
+		return "", err
+	}
+
+	p.sandboxKey = key
+
+	return key, nil
+}
+
+// getSignKey 获取沙箱密钥，请求使用 Options.APIKey 签名
+func (p *Wxpay) getSignKey() (string, error) {
+	var v = url.Values{}
+	v.Set("mch_id", p.Opt.MchID)
+	v.Set("nonce_str", wxpay.GetNonceStr())
+	v.Set("sign", wxpay.SignMD5(v, p.Opt.APIKey))
+
+	resp, err := p.postXML(p.client.Client, kGetSignKey, v)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	rsp, err := BodyToValues(string(data))
+	if err != nil {
+		return "", err
+	}
+
+	if rsp.Get("return_code") != wxpay.K_RETURN_CODE_SUCCESS {
+		return "", newError(rsp)
+	}
+
+	key := rsp.Get("sandbox_signkey")
+	if len(key) == 0 {
+		return "", ErrSandboxSignKey
+	}
+
+	return key, nil
+}
+
+// sandboxAPI 沙箱环境接口路径
+func sandboxAPI(path string) string {
+	if s, ok := kSandboxAPI[path]; ok {
+		path = s
+	}
+
+	return kSandboxPath + path
+}
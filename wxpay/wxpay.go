@@ -1,50 +1,123 @@
 package wxpay
 
 import (
+	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"encoding/xml"
+	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 
 	"github.com/gocommon/pay"
 	"github.com/smartwalle/wxpay"
 )
 
-var _ pay.Payer = &Wxpay{}
+var (
+	_ pay.Payer        = &Wxpay{}
+	_ pay.ContextPayer = &Wxpay{}
+)
 
 // Options Options
 type Options struct {
 	APIKey       string
 	MchID        string
 	NotifyURL    string
-	IsProduction bool
-	PublicID     string // 公从号appid
-	APPID        string // APP支付appid
-	MiniAPPID    string // 小程序支付
-
+	IsProduction bool         // 为 false 时使用仿真测试系统，自动获取沙箱密钥
+	PublicID     string       // 公从号appid
+	APPID        string       // APP支付appid
+	MiniAPPID    string       // 小程序支付
+	PayoutAPPID  string       // 企业付款到零钱appid，为空时使用PublicID
+	CertFile     string       // 商户证书apiclient_cert.p12路径，企业付款、分账需要
+	APIDomain    string       // 接口域名，为空时使用 https://api.mch.weixin.qq.com
+	PlanID       string       // 委托代扣模板id，代扣签约需要
+	Client       *http.Client // 不需要商户证书的请求使用，为空时使用 http.DefaultClient
+
+	// WrapTransport 包装请求支付平台的 Transport，包括使用商户证书的请求，用于链路追踪
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+
+	Retry   *pay.RetryPolicy    // 幂等接口的重试策略，为空时不重试
+	Breaker *pay.BreakerOptions // 熔断，为空时不熔断
 }
 
 // Wxpay Wxpay
 type Wxpay struct {
 	Opt    Options
 	client *wxpay.Client
+	ctx    context.Context
+
+	*cache
+
+	breaker *pay.Breaker
+}
+
+// cache 第一次使用时加载的证书和密钥，WithContext 的副本共用
+type cache struct {
+	certOnce   sync.Once
+	certClient *http.Client
+	certErr    error
+
+	pubKeyMu sync.Mutex
+	pubKey   *rsa.PublicKey
+
+	sandboxMu  sync.Mutex
+	sandboxKey string
 }
 
 // New New
 func New(opt Options) *Wxpay {
 	cli := wxpay.New(opt.PublicID, opt.APIKey, opt.MchID, opt.IsProduction)
+	if opt.Client != nil {
+		cli.Client = opt.Client
+	}
+
+	if opt.WrapTransport != nil {
+		c := *cli.Client
+		c.Transport = wrapTransport(opt.WrapTransport, c.Transport)
+		cli.Client = &c
+	}
 
 	p := &Wxpay{
 		client: cli,
 		Opt:    opt,
+		cache:  &cache{},
+	}
+
+	if opt.Breaker != nil {
+		p.breaker = pay.NewBreaker(*opt.Breaker)
 	}
 
 	return p
 }
 
+// WithContext 返回使用 ctx 请求接口的副本，共用证书、密钥和熔断器
+func (p *Wxpay) WithContext(ctx context.Context) pay.Payer {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	c := *p
+	c.ctx = ctx
+	return &c
+}
+
+// context 请求接口使用的 context
+func (p *Wxpay) context() context.Context {
+	if p.ctx != nil {
+		return p.ctx
+	}
+	return context.Background()
+}
+
 // Verify 支付回调验证签名,成功返回回调参数
 func (p *Wxpay) Verify(in url.Values) (*pay.NoticeParams, error) {
-	ok, err := wxpay.VerifyResponseValues(in, p.Opt.APIKey)
+	key, err := p.signKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := wxpay.VerifyResponseValues(in, key)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +170,7 @@ func (p *Wxpay) Call(way pay.Way, in pay.Order) (string, error) {
 
 // qrcodeCall 返回二维码地址 ip 传服务器端ip
 func (p *Wxpay) qrcodeCall(in pay.Order) (string, error) {
-	info, err := p.client.NativePay(wxpay.UnifiedOrderParam{
+	info, err := p.unifiedOrder(wxpay.UnifiedOrderParam{
 		NotifyURL:      p.Opt.NotifyURL,           // 是 异步接收微信支付结果通知的回调地址，通知url必须为外网可访问的url，不能携带参数。
 		Body:           in.Title,                  // 是 商品简单描述，该字段请按照规范传递，具体请见参数规定
 		OutTradeNo:     in.ID,                     // 是 商户系统内部订单号，要求32个字符内，只能是数字、大小写字母_-|*@ ，且在同一个商户号下唯一。详见商户订单号
@@ -106,17 +179,17 @@ func (p *Wxpay) qrcodeCall(in pay.Order) (string, error) {
 		TradeType:      wxpay.K_TRADE_TYPE_NATIVE, // 是 取值如下：JSAPI，NATIVE，APP等，说明详见参数规定
 		ProductID:      in.ID,                     // 否 trade_type=NATIVE时（即扫码支付），此参数必传。此参数为二维码中包含的商品ID，商户自行定义。
 		AppID:          p.Opt.PublicID,
-	})
+	}, in.ProfitSharing)
 	if err != nil {
 		return "", err
 	}
 
-	return info.CodeURL, nil
+	return info.Payinfo, nil
 }
 
 // appCall 返回app调起支付的参数
 func (p *Wxpay) appCall(in pay.Order) (string, error) {
-	rsp, err := p.client.AppPay(wxpay.UnifiedOrderParam{
+	rsp, err := p.unifiedOrder(wxpay.UnifiedOrderParam{
 		NotifyURL:      p.Opt.NotifyURL,        // 是 异步接收微信支付结果通知的回调地址，通知url必须为外网可访问的url，不能携带参数。
 		Body:           in.Title,               // 是 商品简单描述，该字段请按照规范传递，具体请见参数规定
 		OutTradeNo:     in.ID,                  // 是 商户系统内部订单号，要求32个字符内，只能是数字、大小写字母_-|*@ ，且在同一个商户号下唯一。详见商户订单号
@@ -125,7 +198,7 @@ func (p *Wxpay) appCall(in pay.Order) (string, error) {
 		TradeType:      wxpay.K_TRADE_TYPE_APP, // 是 取值如下：JSAPI，NATIVE，APP等，说明详见参数规定
 		ProductID:      in.ID,                  // 否 trade_type=NATIVE时（即扫码支付），此参数必传。此参数为二维码中包含的商品ID，商户自行定义。
 		AppID:          p.Opt.APPID,
-	})
+	}, in.ProfitSharing)
 	if err != nil {
 		return "", err
 	}
@@ -135,7 +208,7 @@ func (p *Wxpay) appCall(in pay.Order) (string, error) {
 
 // jsAPICall 返回跳转的url地址
 func (p *Wxpay) jsAPICall(in pay.Order) (string, error) {
-	resp, err := p.client.JSAPIPay(wxpay.UnifiedOrderParam{
+	resp, err := p.unifiedOrder(wxpay.UnifiedOrderParam{
 		NotifyURL:      p.Opt.NotifyURL,          // 是 异步接收微信支付结果通知的回调地址，通知url必须为外网可访问的url，不能携带参数。
 		Body:           in.Title,                 // 是 商品简单描述，该字段请按照规范传递，具体请见参数规定
 		OutTradeNo:     in.ID,                    // 是 商户系统内部订单号，要求32个字符内，只能是数字、大小写字母_-|*@ ，且在同一个商户号下唯一。详见商户订单号
@@ -144,7 +217,7 @@ func (p *Wxpay) jsAPICall(in pay.Order) (string, error) {
 		TradeType:      wxpay.K_TRADE_TYPE_JSAPI, // 是 取值如下：JSAPI，NATIVE，APP等，说明详见参数规定
 		OpenID:         in.OpenID,
 		AppID:          p.Opt.PublicID,
-	})
+	}, in.ProfitSharing)
 	if err != nil {
 		return "", err
 	}
@@ -164,7 +237,7 @@ func (p *Wxpay) wapCall(in pay.Order) (string, error) {
 
 	d, _ := json.Marshal(sInfo)
 
-	resp, err := p.client.WebPay(wxpay.UnifiedOrderParam{
+	resp, err := p.unifiedOrder(wxpay.UnifiedOrderParam{
 		Body:           in.Title,                // 是 商品简单描述，该字段请按照规范传递，具体请见参数规定
 		OutTradeNo:     in.ID,                   // 是 商户系统内部订单号，要求32个字符内，只能是数字、大小写字母_-|*@ ，且在同一个商户号下唯一。详见商户订单号
 		TotalFee:       int(in.Amount),          // 是 订单总金额，单位为分，详见支付金额
@@ -173,7 +246,7 @@ func (p *Wxpay) wapCall(in pay.Order) (string, error) {
 		NotifyURL:      p.Opt.NotifyURL,         // 是 异步接收微信支付结果通知的回调地址，通知url必须为外网可访问的url，不能携带参数。
 		SceneInfo:      string(d),
 		AppID:          p.Opt.PublicID,
-	})
+	}, in.ProfitSharing)
 	if err != nil {
 		return "", err
 	}
@@ -183,7 +256,7 @@ func (p *Wxpay) wapCall(in pay.Order) (string, error) {
 
 // wxxcxCall 返回跳转的url地址
 func (p *Wxpay) wxxcxCall(in pay.Order) (string, error) {
-	resp, err := p.client.MiniAppPay(wxpay.UnifiedOrderParam{
+	resp, err := p.unifiedOrder(wxpay.UnifiedOrderParam{
 		NotifyURL:      p.Opt.NotifyURL,          // 是 异步接收微信支付结果通知的回调地址，通知url必须为外网可访问的url，不能携带参数。
 		Body:           in.Title,                 // 是 商品简单描述，该字段请按照规范传递，具体请见参数规定
 		OutTradeNo:     in.ID,                    // 是 商户系统内部订单号，要求32个字符内，只能是数字、大小写字母_-|*@ ，且在同一个商户号下唯一。详见商户订单号
@@ -192,7 +265,7 @@ func (p *Wxpay) wxxcxCall(in pay.Order) (string, error) {
 		TradeType:      wxpay.K_TRADE_TYPE_JSAPI, // 是 取值如下：JSAPI，NATIVE，APP等，说明详见参数规定
 		OpenID:         in.OpenID,
 		AppID:          p.Opt.MiniAPPID,
-	})
+	}, in.ProfitSharing)
 	if err != nil {
 		return "", err
 	}
@@ -212,36 +285,26 @@ type H5Info struct {
 	WapName string `json:"wap_name"`
 }
 
-// NoticeParams NoticeParams
+// NoticeParams 支付结果通知的参数 https://pay.weixin.qq.com/wiki/doc/api/native.php?chapter=9_7
+// return_code 和 result_code 都为 SUCCESS 时支付成功，result_code 为 FAIL 时支付失败，金额为 total_fee
 func NoticeParams(val url.Values) *pay.NoticeParams {
-	var (
-		amount = val.Get("total_amount")
-		status = val.Get("trade_status")
-
-		payStatus pay.TradeStatus
-	)
-
-	amountf, _ := strconv.ParseFloat(amount, 10)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	switch status {
-	case "WAIT_BUYER_PAY":
-		payStatus = pay.TradeStatusWait
-	case "TRADE_CLOSED":
-		payStatus = pay.TradeStatusClosed
-	case "TRADE_SUCCESS":
-		payStatus = pay.TradeStatusSuccess
-	case "TRADE_FINISHED":
-		payStatus = pay.TradeStatusFinished
+	var status = pay.TradeStatusWait
+	if val.Get("return_code") == "SUCCESS" {
+		switch val.Get("result_code") {
+		case "SUCCESS":
+			status = pay.TradeStatusSuccess
+		case "FAIL":
+			status = pay.TradeStatusClosed
+		}
 	}
 
+	amount, _ := strconv.Atoi(val.Get("total_fee"))
+
 	return &pay.NoticeParams{
 		OrderID:     val.Get("out_trade_no"),
-		PaymentID:   val.Get("trade_no"), // 支付单号
-		TradeStatus: payStatus,           //支付状态
-		Amount:      int32(amountf * 100),
+		PaymentID:   val.Get("transaction_id"),
+		TradeStatus: status,
+		Amount:      int32(amount),
 	}
 }
 
@@ -0,0 +1,58 @@
+package wxpay
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gocommon/pay"
+)
+
+func TestVerifyNotice(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/notify.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(Options{
+		APIKey:       "192006250b4c09247ec02edce69f6a2d",
+		MchID:        "10000100",
+		IsProduction: true,
+	})
+
+	vals, err := BodyToValues(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Verify(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := pay.NoticeParams{
+		OrderID:     "20190701000001",
+		PaymentID:   "4200000109201907011200000001",
+		TradeStatus: pay.TradeStatusSuccess,
+		Amount:      101,
+	}
+	if *r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	vals, _ = BodyToValues(strings.Replace(string(body), "<total_fee>101", "<total_fee>1", 1))
+	if _, err := p.Verify(vals); err == nil {
+		t.Error("tampered notice verified")
+	}
+}
+
+func TestNoticeParamsFail(t *testing.T) {
+	r := NoticeParams(map[string][]string{
+		"return_code":  {"SUCCESS"},
+		"result_code":  {"FAIL"},
+		"out_trade_no": {"O1"},
+	})
+	if r.TradeStatus != pay.TradeStatusClosed {
+		t.Errorf("status %v, want closed", r.TradeStatus)
+	}
+}
@@ -0,0 +1,977 @@
+// Package wxpaytest 本地微信支付接口桩，可以注入故障，用于测试重试和熔断
+//
+// wxpay.Options.APIDomain 使用 Server.URL，APIKey MchID 使用 Server 的配置。
+// IsProduction 为 false 时请求走 /sandboxnew 前缀，签名使用 Server.SandboxKey，退款不需要商户证书。
+// 桩接口支持统一下单、查询订单、关闭订单、申请退款、查询退款、企业付款到零钱和查询、分账、委托代扣的预签约、解约和申请扣款，
+// 订单需要调用 Pay 模拟用户支付，协议需要调用 SignContract 模拟用户签约。
+// LastRequest 返回接口最后一次收到的验签通过的请求，用于检查请求参数。
+// AutoPay 为 true 时下单即支付成功，与仿真测试系统一致，用于执行 wxpay 验收用例
+package wxpaytest
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 接口路径，Inject Requests 使用正式环境的路径
+const (
+	UnifiedOrder = "/pay/unifiedorder"
+	OrderQuery   = "/pay/orderquery"
+	CloseOrder   = "/pay/closeorder"
+	Refund       = "/secapi/pay/refund"
+	RefundQuery  = "/pay/refundquery"
+	Transfers    = "/mmpaymkttransfers/promotion/transfers"
+	TransferInfo = "/mmpaymkttransfers/gettransferinfo"
+
+	PreEntrustWeb  = "/papay/preentrustweb"
+	DeleteContract = "/papay/deletecontract"
+	PapPayApply    = "/pay/pappayapply"
+
+	ProfitSharingAddReceiver    = "/pay/profitsharingaddreceiver"
+	ProfitSharingRemoveReceiver = "/pay/profitsharingremovereceiver"
+	ProfitSharing               = "/secapi/pay/profitsharing"
+	MultiProfitSharing          = "/secapi/pay/multiprofitsharing"
+	ProfitSharingFinish         = "/secapi/pay/profitsharingfinish"
+	ProfitSharingQuery          = "/pay/profitsharingquery"
+	ProfitSharingReturn         = "/secapi/pay/profitsharingreturn"
+	ProfitSharingReturnQuery    = "/pay/profitsharingreturnquery"
+
+	kSandboxPath    = "/sandboxnew"
+	kSandboxRefund  = "/pay/refund"
+	kGetSignKey     = "/pay/getsignkey"
+	kSignTypeSHA256 = "HMAC-SHA256"
+)
+
+// OpenIDNotExist 付款到该 openid 时返回 OPENID_ERROR
+const OpenIDNotExist = "openid-not-exist"
+
+// Fault 注入的故障
+type Fault int
+
+const (
+	// FaultTimeout 等待 Server.Delay 后正常处理，客户端超时时间小于 Delay 时为超时
+	FaultTimeout Fault = iota + 1
+	// FaultServerError 返回 500
+	FaultServerError
+	// FaultSystemError 返回 result_code FAIL，err_code SYSTEMERROR，请求不处理
+	FaultSystemError
+	// FaultSystemErrorAfter 正常处理请求后返回 SYSTEMERROR，模拟处理成功但结果未知
+	FaultSystemErrorAfter
+)
+
+// Server 本地微信支付接口桩
+type Server struct {
+	*httptest.Server
+
+	APIKey     string
+	MchID      string
+	SandboxKey string        // 沙箱密钥
+	Delay      time.Duration // FaultTimeout 的等待时间，默认 1s
+	AutoPay    bool          // 统一下单后订单直接支付成功
+
+	mu        sync.Mutex
+	seq       int
+	faults    map[string][]Fault
+	requests  map[string]int
+	orders    map[string]*order
+	refunds   map[string]*refund
+	payouts   map[string]*payout
+	shares    map[string]*share       // out_order_no 对应的分账单
+	returns   map[string]*shareReturn // out_return_no 对应的回退单
+	received  map[string]url.Values   // 接口最后一次收到的请求
+	accounts  map[string]bool         // 分账接收方
+	contracts map[string]*contract    // contract_id 对应的委托代扣协议
+}
+
+type contract struct {
+	ID     string
+	Code   string
+	PlanID string
+	OpenID string
+	State  string // ADD DELETE
+}
+
+type share struct {
+	OrderID       string
+	OutOrderNo    string
+	TransactionID string
+	Receivers     string
+	Status        string // FINISHED
+}
+
+type shareReturn struct {
+	ReturnNo    string
+	OrderID     string
+	OutOrderNo  string
+	OutReturnNo string
+	Account     string
+	Amount      string
+}
+
+type payout struct {
+	ID        string
+	PaymentNo string
+	Amount    string
+}
+
+type order struct {
+	ID            string
+	TotalFee      string
+	PrepayID      string
+	TransactionID string
+	State         string // NOTPAY SUCCESS CLOSED REFUND
+	ProfitSharing bool
+}
+
+type refund struct {
+	ID        string
+	OrderID   string
+	RefundID  string
+	RefundFee string
+}
+
+// NewServer 启动接口桩
+func NewServer() *Server {
+	s := &Server{
+		APIKey:     "wxpaytestwxpaytestwxpaytestwxpay",
+		MchID:      "1900000109",
+		SandboxKey: "wxpaytestsandboxwxpaytestsandbox",
+		Delay:      time.Second,
+		faults:     make(map[string][]Fault),
+		requests:   make(map[string]int),
+		orders:     make(map[string]*order),
+		refunds:    make(map[string]*refund),
+		payouts:    make(map[string]*payout),
+		shares:     make(map[string]*share),
+		returns:    make(map[string]*shareReturn),
+		received:   make(map[string]url.Values),
+		accounts:   make(map[string]bool),
+		contracts:  make(map[string]*contract),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// Inject 接口 path 接下来的 n 次请求返回 fault，多次调用按顺序排队
+func (s *Server) Inject(path string, fault Fault, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.faults[path] = append(s.faults[path], fault)
+	}
+}
+
+// Requests 接口 path 收到的请求数，包括注入故障的请求
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// Pay 模拟用户支付
+func (s *Server) Pay(orderID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok || o.State != "NOTPAY" {
+		return false
+	}
+
+	s.seq++
+	o.State = "SUCCESS"
+	o.TransactionID = "4200000000" + strconv.Itoa(s.seq)
+
+	return true
+}
+
+// Refunds 订单的退款单数，重复的退款请求不重复退款
+func (s *Server) Refunds(orderID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, r := range s.refunds {
+		if r.OrderID == orderID {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Payout 付款单号对应的付款金额，没有付款时返回空，重复的付款请求只付款一次
+func (s *Server) Payout(partnerTradeNo string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.payouts[partnerTradeNo]; ok {
+		return p.Amount
+	}
+	return ""
+}
+
+// LastRequest 接口 path 最后一次收到的验签通过的请求，包括 sign，没有请求时返回 nil
+func (s *Server) LastRequest(path string) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.received[path]
+}
+
+// Receiver 是否已添加分账接收方
+func (s *Server) Receiver(account string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.accounts[account]
+}
+
+// Contract 委托代扣协议状态，ADD 或 DELETE，协议不存在时返回空
+func (s *Server) Contract(contractID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.contracts[contractID]; ok {
+		return c.State
+	}
+	return ""
+}
+
+// SignContract 模拟用户签约，返回使用 APIKey 签名的签约回调参数，用于正式环境
+func (s *Server) SignContract(planID, contractCode, openID string) url.Values {
+	s.mu.Lock()
+	s.seq++
+	c := &contract{
+		ID:     "2020" + strconv.Itoa(s.seq),
+		Code:   contractCode,
+		PlanID: planID,
+		OpenID: openID,
+		State:  "ADD",
+	}
+	s.contracts[c.ID] = c
+	s.mu.Unlock()
+
+	var v = url.Values{}
+	v.Set("return_code", "SUCCESS")
+	v.Set("result_code", "SUCCESS")
+	v.Set("mch_id", s.MchID)
+	v.Set("contract_code", c.Code)
+	v.Set("plan_id", c.PlanID)
+	v.Set("openid", c.OpenID)
+	v.Set("change_type", c.State)
+	v.Set("operate_time", "2020-01-01 00:00:00")
+	v.Set("contract_id", c.ID)
+	v.Set("sign", sign(v, s.APIKey, ""))
+
+	return v
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	key := s.APIKey
+	if strings.HasPrefix(path, kSandboxPath) {
+		path = strings.TrimPrefix(path, kSandboxPath)
+		key = s.SandboxKey
+		if path == kSandboxRefund {
+			path = Refund
+		}
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	req, err := parseXML(body)
+	if err != nil {
+		writeFail(w, "XML格式错误")
+		return
+	}
+
+	if path == kGetSignKey {
+		if req.Get("sign") != sign(req, s.APIKey, "") {
+			writeFail(w, "签名错误")
+			return
+		}
+
+		var rsp = url.Values{}
+		rsp.Set("return_code", "SUCCESS")
+		rsp.Set("mch_id", s.MchID)
+		rsp.Set("sandbox_signkey", s.SandboxKey)
+		writeXML(w, rsp)
+		return
+	}
+
+	fault := s.next(path)
+
+	switch fault {
+	case FaultTimeout:
+		time.Sleep(s.Delay)
+	case FaultServerError:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	signType := req.Get("sign_type")
+	if req.Get("sign") != sign(req, key, signType) {
+		writeFail(w, "签名错误")
+		return
+	}
+
+	// 企业付款到零钱的商户号参数为 mchid
+	mchID := req.Get("mch_id")
+	if path == Transfers {
+		mchID = req.Get("mchid")
+	}
+	if mchID != s.MchID {
+		writeFail(w, "mch_id参数格式错误")
+		return
+	}
+
+	s.mu.Lock()
+	s.received[path] = req
+	s.mu.Unlock()
+
+	// 分账接口只支持 HMAC-SHA256
+	if strings.Contains(path, "profitsharing") && signType != kSignTypeSHA256 {
+		writeFail(w, "sign_type参数错误")
+		return
+	}
+
+	if fault == FaultSystemError {
+		s.writeResult(w, key, signType, systemError())
+		return
+	}
+
+	var rsp url.Values
+	switch path {
+	case UnifiedOrder:
+		rsp = s.unifiedOrder(req)
+	case OrderQuery:
+		rsp = s.orderQuery(req)
+	case CloseOrder:
+		rsp = s.closeOrder(req)
+	case Refund:
+		rsp = s.refund(req)
+	case RefundQuery:
+		rsp = s.refundQuery(req)
+	case Transfers:
+		rsp = s.transfers(req)
+	case TransferInfo:
+		rsp = s.transferInfo(req)
+	case PreEntrustWeb:
+		rsp = s.preEntrustWeb(req)
+	case DeleteContract:
+		rsp = s.deleteContract(req)
+	case PapPayApply:
+		rsp = s.papPayApply(req)
+	case ProfitSharingAddReceiver:
+		rsp = s.receiver(req, true)
+	case ProfitSharingRemoveReceiver:
+		rsp = s.receiver(req, false)
+	case ProfitSharing, MultiProfitSharing:
+		rsp = s.profitSharing(req)
+	case ProfitSharingFinish:
+		rsp = s.profitSharingFinish(req)
+	case ProfitSharingQuery:
+		rsp = s.profitSharingQuery(req)
+	case ProfitSharingReturn:
+		rsp = s.profitSharingReturn(req)
+	case ProfitSharingReturnQuery:
+		rsp = s.profitSharingReturnQuery(req)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if fault == FaultSystemErrorAfter {
+		rsp = systemError()
+	}
+
+	s.writeResult(w, key, signType, rsp)
+}
+
+// next 记录请求数并取出注入的故障
+func (s *Server) next(path string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[path]++
+
+	faults := s.faults[path]
+	if len(faults) == 0 {
+		return 0
+	}
+
+	s.faults[path] = faults[1:]
+
+	return faults[0]
+}
+
+func (s *Server) unifiedOrder(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := req.Get("out_trade_no")
+	o, ok := s.orders[id]
+	if ok && o.TotalFee != req.Get("total_fee") {
+		return bizError("INVALID_REQUEST", "201 商户订单号重复")
+	}
+	if ok && o.State == "SUCCESS" {
+		return bizError("ORDERPAID", "该订单已支付")
+	}
+	if ok && o.State == "CLOSED" {
+		return bizError("ORDERCLOSED", "该订单已关")
+	}
+
+	// 同一订单号重复下单返回同一 prepay_id
+	if !ok {
+		s.seq++
+		o = &order{
+			ID:       id,
+			TotalFee: req.Get("total_fee"),
+			PrepayID: "wx" + strconv.Itoa(s.seq),
+			State:    "NOTPAY",
+
+			ProfitSharing: req.Get("profit_sharing") == "Y",
+		}
+		s.orders[id] = o
+
+		if s.AutoPay {
+			s.seq++
+			o.State = "SUCCESS"
+			o.TransactionID = "4200000000" + strconv.Itoa(s.seq)
+		}
+	}
+
+	rsp := success()
+	rsp.Set("appid", req.Get("appid"))
+	rsp.Set("trade_type", req.Get("trade_type"))
+	rsp.Set("prepay_id", o.PrepayID)
+	switch req.Get("trade_type") {
+	case "NATIVE":
+		rsp.Set("code_url", "weixin://wxpay/bizpayurl?pr="+o.PrepayID)
+	case "MWEB":
+		rsp.Set("mweb_url", "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id="+o.PrepayID)
+	}
+
+	return rsp
+}
+
+func (s *Server) orderQuery(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.Get("out_trade_no")]
+	if !ok {
+		return bizError("ORDERNOTEXIST", "订单不存在")
+	}
+
+	rsp := success()
+	rsp.Set("out_trade_no", o.ID)
+	rsp.Set("trade_state", o.State)
+	rsp.Set("total_fee", o.TotalFee)
+	if len(o.TransactionID) > 0 {
+		rsp.Set("transaction_id", o.TransactionID)
+	}
+
+	return rsp
+}
+
+func (s *Server) closeOrder(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.Get("out_trade_no")]
+	if !ok {
+		return bizError("ORDERNOTEXIST", "订单不存在")
+	}
+
+	switch o.State {
+	case "SUCCESS", "REFUND":
+		return bizError("ORDERPAID", "订单已支付")
+	case "CLOSED":
+		return bizError("ORDERCLOSED", "订单已关闭")
+	}
+
+	o.State = "CLOSED"
+
+	return success()
+}
+
+func (s *Server) refund(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.Get("out_trade_no")]
+	if !ok || (o.State != "SUCCESS" && o.State != "REFUND") {
+		return bizError("TRADE_STATE_ERROR", "订单状态错误")
+	}
+
+	// 同一退款单号重复请求只退款一次
+	id := req.Get("out_refund_no")
+	rf, ok := s.refunds[id]
+	if !ok {
+		s.seq++
+		rf = &refund{
+			ID:        id,
+			OrderID:   o.ID,
+			RefundID:  "5030000000" + strconv.Itoa(s.seq),
+			RefundFee: req.Get("refund_fee"),
+		}
+		s.refunds[id] = rf
+		o.State = "REFUND"
+	}
+
+	rsp := success()
+	rsp.Set("transaction_id", o.TransactionID)
+	rsp.Set("out_trade_no", o.ID)
+	rsp.Set("out_refund_no", rf.ID)
+	rsp.Set("refund_id", rf.RefundID)
+	rsp.Set("refund_fee", rf.RefundFee)
+	rsp.Set("total_fee", o.TotalFee)
+
+	return rsp
+}
+
+func (s *Server) refundQuery(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rf, ok := s.refunds[req.Get("out_refund_no")]
+	if !ok {
+		return bizError("REFUNDNOTEXIST", "退款订单查询失败")
+	}
+
+	rsp := success()
+	rsp.Set("out_trade_no", rf.OrderID)
+	rsp.Set("refund_fee", rf.RefundFee)
+	rsp.Set("refund_count", "1")
+	rsp.Set("out_refund_no_0", rf.ID)
+	rsp.Set("refund_id_0", rf.RefundID)
+	rsp.Set("refund_fee_0", rf.RefundFee)
+	rsp.Set("refund_status_0", "SUCCESS")
+
+	return rsp
+}
+
+func (s *Server) transfers(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Get("openid") == OpenIDNotExist {
+		return bizError("OPENID_ERROR", "Openid错误")
+	}
+
+	// 同一付款单号重复请求只付款一次
+	id := req.Get("partner_trade_no")
+	p, ok := s.payouts[id]
+	if !ok {
+		s.seq++
+		p = &payout{
+			ID:        id,
+			PaymentNo: "1000018301" + strconv.Itoa(s.seq),
+			Amount:    req.Get("amount"),
+		}
+		s.payouts[id] = p
+	}
+
+	rsp := success()
+	rsp.Set("partner_trade_no", p.ID)
+	rsp.Set("payment_no", p.PaymentNo)
+	rsp.Set("payment_time", "2020-01-01 00:00:00")
+
+	return rsp
+}
+
+func (s *Server) transferInfo(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payouts[req.Get("partner_trade_no")]
+	if !ok {
+		return bizError("NOT_FOUND", "指定单号数据不存在")
+	}
+
+	rsp := success()
+	rsp.Set("partner_trade_no", p.ID)
+	rsp.Set("detail_id", p.PaymentNo)
+	rsp.Set("status", "SUCCESS")
+	rsp.Set("payment_amount", p.Amount)
+	rsp.Set("payment_time", "2020-01-01 00:00:00")
+
+	return rsp
+}
+
+func (s *Server) preEntrustWeb(req url.Values) url.Values {
+	if len(req.Get("plan_id")) == 0 || len(req.Get("contract_code")) == 0 {
+		return bizError("PARAM_ERROR", "参数错误")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+
+	rsp := success()
+	rsp.Set("pre_entrustweb_id", "5778aadY9nltAsZzXixCkFIGYnV2V"+strconv.Itoa(s.seq))
+
+	return rsp
+}
+
+func (s *Server) deleteContract(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.contracts[req.Get("contract_id")]
+	if !ok || c.State != "ADD" {
+		return bizError("CONTRACT_NOT_EXIST", "签约协议不存在")
+	}
+
+	c.State = "DELETE"
+
+	rsp := success()
+	rsp.Set("contract_id", c.ID)
+	rsp.Set("plan_id", c.PlanID)
+	rsp.Set("contract_code", c.Code)
+
+	return rsp
+}
+
+// papPayApply 申请扣款，受理后订单为待支付，扣款结果需要调用 Pay
+func (s *Server) papPayApply(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.contracts[req.Get("contract_id")]
+	if !ok || c.State != "ADD" {
+		return bizError("CONTRACT_NOT_EXIST", "签约协议不存在")
+	}
+
+	id := req.Get("out_trade_no")
+	if _, ok := s.orders[id]; ok {
+		return bizError("INVALID_REQUEST", "201 商户订单号重复")
+	}
+
+	s.orders[id] = &order{
+		ID:       id,
+		TotalFee: req.Get("total_fee"),
+		State:    "NOTPAY",
+	}
+
+	return success()
+}
+
+func (s *Server) receiver(req url.Values, add bool) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var r struct {
+		Type    string `json:"type"`
+		Account string `json:"account"`
+	}
+	if err := json.Unmarshal([]byte(req.Get("receiver")), &r); err != nil || len(r.Account) == 0 {
+		return bizError("PARAM_ERROR", "receiver参数错误")
+	}
+
+	if add {
+		s.accounts[r.Account] = true
+	} else {
+		delete(s.accounts, r.Account)
+	}
+
+	rsp := success()
+	rsp.Set("receiver", req.Get("receiver"))
+
+	return rsp
+}
+
+func (s *Server) profitSharing(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var o *order
+	for _, v := range s.orders {
+		if len(v.TransactionID) > 0 && v.TransactionID == req.Get("transaction_id") {
+			o = v
+		}
+	}
+	if o == nil || o.State != "SUCCESS" {
+		return bizError("ORDER_NOT_READY", "订单处理中，暂时无法分账")
+	}
+	if !o.ProfitSharing {
+		return bizError("NO_AUTH", "订单不需要分账")
+	}
+
+	var receivers []map[string]interface{}
+	if err := json.Unmarshal([]byte(req.Get("receivers")), &receivers); err != nil || len(receivers) == 0 {
+		return bizError("PARAM_ERROR", "receivers参数错误")
+	}
+
+	// 同一分账单号重复请求只分账一次
+	id := req.Get("out_order_no")
+	sh, ok := s.shares[id]
+	if !ok {
+		s.seq++
+		sh = &share{
+			OrderID:       "3008450740201411110007820472" + strconv.Itoa(s.seq),
+			OutOrderNo:    id,
+			TransactionID: o.TransactionID,
+			Receivers:     req.Get("receivers"),
+			Status:        "FINISHED",
+		}
+		s.shares[id] = sh
+	}
+
+	rsp := success()
+	rsp.Set("transaction_id", sh.TransactionID)
+	rsp.Set("out_order_no", sh.OutOrderNo)
+	rsp.Set("order_id", sh.OrderID)
+
+	return rsp
+}
+
+func (s *Server) profitSharingFinish(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found bool
+	for _, o := range s.orders {
+		if len(o.TransactionID) > 0 && o.TransactionID == req.Get("transaction_id") {
+			found = o.ProfitSharing
+		}
+	}
+	if !found {
+		return bizError("ORDER_NOT_READY", "订单处理中，暂时无法分账")
+	}
+
+	s.seq++
+
+	rsp := success()
+	rsp.Set("transaction_id", req.Get("transaction_id"))
+	rsp.Set("out_order_no", req.Get("out_order_no"))
+	rsp.Set("order_id", "3008450740201411110007820472"+strconv.Itoa(s.seq))
+	rsp.Set("amount", req.Get("amount"))
+
+	return rsp
+}
+
+func (s *Server) profitSharingQuery(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, ok := s.shares[req.Get("out_order_no")]
+	if !ok || sh.TransactionID != req.Get("transaction_id") {
+		return bizError("ORDER_NOT_EXIST", "分账单不存在")
+	}
+
+	rsp := success()
+	rsp.Set("transaction_id", sh.TransactionID)
+	rsp.Set("out_order_no", sh.OutOrderNo)
+	rsp.Set("order_id", sh.OrderID)
+	rsp.Set("status", sh.Status)
+	rsp.Set("receivers", sh.Receivers)
+
+	return rsp
+}
+
+// shareOf order_id 与 out_order_no 必须只传一个
+func (s *Server) shareOf(req url.Values) (*share, url.Values) {
+	_, byID := req["order_id"]
+	_, byNo := req["out_order_no"]
+	if byID == byNo {
+		return nil, bizError("PARAM_ERROR", "order_id和out_order_no必须二选一")
+	}
+
+	for _, sh := range s.shares {
+		if (byID && sh.OrderID == req.Get("order_id")) || (byNo && sh.OutOrderNo == req.Get("out_order_no")) {
+			return sh, nil
+		}
+	}
+
+	return nil, bizError("ORDER_NOT_EXIST", "分账单不存在")
+}
+
+func (s *Server) profitSharingReturn(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, fail := s.shareOf(req)
+	if fail != nil {
+		return fail
+	}
+
+	// 同一回退单号重复请求只回退一次
+	id := req.Get("out_return_no")
+	r, ok := s.returns[id]
+	if !ok {
+		s.seq++
+		r = &shareReturn{
+			ReturnNo:    "3008450740201411110007820472" + strconv.Itoa(s.seq),
+			OrderID:     sh.OrderID,
+			OutOrderNo:  sh.OutOrderNo,
+			OutReturnNo: id,
+			Account:     req.Get("return_account"),
+			Amount:      req.Get("return_amount"),
+		}
+		s.returns[id] = r
+	}
+
+	return r.values()
+}
+
+func (s *Server) profitSharingReturnQuery(req url.Values) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, fail := s.shareOf(req); fail != nil {
+		return fail
+	}
+
+	r, ok := s.returns[req.Get("out_return_no")]
+	if !ok {
+		return bizError("ORDER_NOT_EXIST", "回退单不存在")
+	}
+
+	return r.values()
+}
+
+func (r *shareReturn) values() url.Values {
+	rsp := success()
+	rsp.Set("order_id", r.OrderID)
+	rsp.Set("out_order_no", r.OutOrderNo)
+	rsp.Set("out_return_no", r.OutReturnNo)
+	rsp.Set("return_no", r.ReturnNo)
+	rsp.Set("return_account_type", "MERCHANT_ID")
+	rsp.Set("return_account", r.Account)
+	rsp.Set("return_amount", r.Amount)
+	rsp.Set("result", "SUCCESS")
+	rsp.Set("finish_time", "20200101000000")
+
+	return rsp
+}
+
+// writeResult 带上公共参数签名后返回
+func (s *Server) writeResult(w http.ResponseWriter, key, signType string, rsp url.Values) {
+	rsp.Set("return_code", "SUCCESS")
+	rsp.Set("return_msg", "OK")
+	rsp.Set("mch_id", s.MchID)
+	rsp.Set("nonce_str", strconv.FormatInt(time.Now().UnixNano(), 36))
+	rsp.Set("sign", sign(rsp, key, signType))
+
+	writeXML(w, rsp)
+}
+
+func success() url.Values {
+	var v = url.Values{}
+	v.Set("result_code", "SUCCESS")
+	return v
+}
+
+func bizError(code, des string) url.Values {
+	var v = url.Values{}
+	v.Set("result_code", "FAIL")
+	v.Set("err_code", code)
+	v.Set("err_code_des", des)
+	return v
+}
+
+func systemError() url.Values {
+	return bizError("SYSTEMERROR", "系统超时")
+}
+
+func writeFail(w http.ResponseWriter, msg string) {
+	var v = url.Values{}
+	v.Set("return_code", "FAIL")
+	v.Set("return_msg", msg)
+	writeXML(w, v)
+}
+
+func writeXML(w http.ResponseWriter, v url.Values) {
+	var keys = make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("<xml>")
+	for _, k := range keys {
+		b.WriteString("<" + k + "><![CDATA[" + v.Get(k) + "]]></" + k + ">")
+	}
+	b.WriteString("</xml>")
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, b.String())
+}
+
+func parseXML(body []byte) (url.Values, error) {
+	var (
+		v   = url.Values{}
+		dec = xml.NewDecoder(strings.NewReader(string(body)))
+		key string
+	)
+
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return v, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			key = t.Name.Local
+			// 空值的参数也记录，用于检查不应传的参数
+			if key != "xml" {
+				v.Set(key, "")
+			}
+		case xml.CharData:
+			if len(key) > 0 && key != "xml" {
+				v.Set(key, string(t))
+			}
+		case xml.EndElement:
+			key = ""
+		}
+	}
+}
+
+// sign 签名，signType 为 HMAC-SHA256 时使用 HMAC-SHA256，否则使用 MD5
+func sign(vals url.Values, key, signType string) string {
+	var pList = make([]string, 0, len(vals))
+	for k := range vals {
+		if v := vals.Get(k); k != "sign" && len(v) > 0 {
+			pList = append(pList, k+"="+v)
+		}
+	}
+	sort.Strings(pList)
+	pList = append(pList, "key="+key)
+
+	var src = strings.Join(pList, "&")
+
+	if signType == kSignTypeSHA256 {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(src))
+		return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	sum := md5.Sum([]byte(src))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}